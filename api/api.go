@@ -0,0 +1,18 @@
+package api
+
+import (
+	_ "embed"
+
+	"portfolio-rebalancer/pkg/openapi"
+)
+
+// Spec is the OpenAPI 3 document describing every route of the HTTP API. It is served at
+// /openapi.json and requests are validated against it, so it must change with the handlers.
+//
+//go:embed openapi.json
+var Spec []byte
+
+// Load parses Spec
+func Load() (*openapi.Document, error) {
+	return openapi.Load(Spec)
+}
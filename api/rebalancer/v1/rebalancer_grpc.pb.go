@@ -0,0 +1,326 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: rebalancer/v1/rebalancer.proto
+
+package rebalancerv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	PortfolioRebalancer_CreatePortfolio_FullMethodName  = "/rebalancer.v1.PortfolioRebalancer/CreatePortfolio"
+	PortfolioRebalancer_GetPortfolio_FullMethodName     = "/rebalancer.v1.PortfolioRebalancer/GetPortfolio"
+	PortfolioRebalancer_UpdatePortfolio_FullMethodName  = "/rebalancer.v1.PortfolioRebalancer/UpdatePortfolio"
+	PortfolioRebalancer_Rebalance_FullMethodName        = "/rebalancer.v1.PortfolioRebalancer/Rebalance"
+	PortfolioRebalancer_PreviewRebalance_FullMethodName = "/rebalancer.v1.PortfolioRebalancer/PreviewRebalance"
+	PortfolioRebalancer_ListTransactions_FullMethodName = "/rebalancer.v1.PortfolioRebalancer/ListTransactions"
+)
+
+// PortfolioRebalancerClient is the client API for PortfolioRebalancer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PortfolioRebalancer is the gRPC counterpart of the /v1 HTTP API for internal services.
+// Errors carry the status code matching the HTTP problem type: INVALID_ARGUMENT with a
+// google.rpc.BadRequest naming each offending field, NOT_FOUND, FAILED_PRECONDITION for
+// conflicts and UNAVAILABLE while Elasticsearch or Kafka are down.
+type PortfolioRebalancerClient interface {
+	// CreatePortfolio stores a user's portfolio with its target allocation
+	CreatePortfolio(ctx context.Context, in *CreatePortfolioRequest, opts ...grpc.CallOption) (*Portfolio, error)
+	// GetPortfolio returns a user's portfolio
+	GetPortfolio(ctx context.Context, in *GetPortfolioRequest, opts ...grpc.CallOption) (*Portfolio, error)
+	// UpdatePortfolio changes a portfolio's target allocation, schedule or both
+	UpdatePortfolio(ctx context.Context, in *UpdatePortfolioRequest, opts ...grpc.CallOption) (*Portfolio, error)
+	// Rebalance takes the allocation a provider reports, submits the transactions taking the
+	// portfolio back to its target and records the reported allocation
+	Rebalance(ctx context.Context, in *RebalanceRequest, opts ...grpc.CallOption) (*RebalanceResponse, error)
+	// PreviewRebalance calculates the transactions Rebalance would submit without submitting
+	// them or changing the portfolio
+	PreviewRebalance(ctx context.Context, in *PreviewRebalanceRequest, opts ...grpc.CallOption) (*PreviewRebalanceResponse, error)
+	// ListTransactions lists the transactions of a user's rebalances, newest first
+	ListTransactions(ctx context.Context, in *ListTransactionsRequest, opts ...grpc.CallOption) (*ListTransactionsResponse, error)
+}
+
+type portfolioRebalancerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPortfolioRebalancerClient(cc grpc.ClientConnInterface) PortfolioRebalancerClient {
+	return &portfolioRebalancerClient{cc}
+}
+
+func (c *portfolioRebalancerClient) CreatePortfolio(ctx context.Context, in *CreatePortfolioRequest, opts ...grpc.CallOption) (*Portfolio, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Portfolio)
+	err := c.cc.Invoke(ctx, PortfolioRebalancer_CreatePortfolio_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *portfolioRebalancerClient) GetPortfolio(ctx context.Context, in *GetPortfolioRequest, opts ...grpc.CallOption) (*Portfolio, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Portfolio)
+	err := c.cc.Invoke(ctx, PortfolioRebalancer_GetPortfolio_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *portfolioRebalancerClient) UpdatePortfolio(ctx context.Context, in *UpdatePortfolioRequest, opts ...grpc.CallOption) (*Portfolio, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Portfolio)
+	err := c.cc.Invoke(ctx, PortfolioRebalancer_UpdatePortfolio_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *portfolioRebalancerClient) Rebalance(ctx context.Context, in *RebalanceRequest, opts ...grpc.CallOption) (*RebalanceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RebalanceResponse)
+	err := c.cc.Invoke(ctx, PortfolioRebalancer_Rebalance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *portfolioRebalancerClient) PreviewRebalance(ctx context.Context, in *PreviewRebalanceRequest, opts ...grpc.CallOption) (*PreviewRebalanceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PreviewRebalanceResponse)
+	err := c.cc.Invoke(ctx, PortfolioRebalancer_PreviewRebalance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *portfolioRebalancerClient) ListTransactions(ctx context.Context, in *ListTransactionsRequest, opts ...grpc.CallOption) (*ListTransactionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListTransactionsResponse)
+	err := c.cc.Invoke(ctx, PortfolioRebalancer_ListTransactions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PortfolioRebalancerServer is the server API for PortfolioRebalancer service.
+// All implementations must embed UnimplementedPortfolioRebalancerServer
+// for forward compatibility
+//
+// PortfolioRebalancer is the gRPC counterpart of the /v1 HTTP API for internal services.
+// Errors carry the status code matching the HTTP problem type: INVALID_ARGUMENT with a
+// google.rpc.BadRequest naming each offending field, NOT_FOUND, FAILED_PRECONDITION for
+// conflicts and UNAVAILABLE while Elasticsearch or Kafka are down.
+type PortfolioRebalancerServer interface {
+	// CreatePortfolio stores a user's portfolio with its target allocation
+	CreatePortfolio(context.Context, *CreatePortfolioRequest) (*Portfolio, error)
+	// GetPortfolio returns a user's portfolio
+	GetPortfolio(context.Context, *GetPortfolioRequest) (*Portfolio, error)
+	// UpdatePortfolio changes a portfolio's target allocation, schedule or both
+	UpdatePortfolio(context.Context, *UpdatePortfolioRequest) (*Portfolio, error)
+	// Rebalance takes the allocation a provider reports, submits the transactions taking the
+	// portfolio back to its target and records the reported allocation
+	Rebalance(context.Context, *RebalanceRequest) (*RebalanceResponse, error)
+	// PreviewRebalance calculates the transactions Rebalance would submit without submitting
+	// them or changing the portfolio
+	PreviewRebalance(context.Context, *PreviewRebalanceRequest) (*PreviewRebalanceResponse, error)
+	// ListTransactions lists the transactions of a user's rebalances, newest first
+	ListTransactions(context.Context, *ListTransactionsRequest) (*ListTransactionsResponse, error)
+	mustEmbedUnimplementedPortfolioRebalancerServer()
+}
+
+// UnimplementedPortfolioRebalancerServer must be embedded to have forward compatible implementations.
+type UnimplementedPortfolioRebalancerServer struct {
+}
+
+func (UnimplementedPortfolioRebalancerServer) CreatePortfolio(context.Context, *CreatePortfolioRequest) (*Portfolio, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreatePortfolio not implemented")
+}
+func (UnimplementedPortfolioRebalancerServer) GetPortfolio(context.Context, *GetPortfolioRequest) (*Portfolio, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPortfolio not implemented")
+}
+func (UnimplementedPortfolioRebalancerServer) UpdatePortfolio(context.Context, *UpdatePortfolioRequest) (*Portfolio, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePortfolio not implemented")
+}
+func (UnimplementedPortfolioRebalancerServer) Rebalance(context.Context, *RebalanceRequest) (*RebalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rebalance not implemented")
+}
+func (UnimplementedPortfolioRebalancerServer) PreviewRebalance(context.Context, *PreviewRebalanceRequest) (*PreviewRebalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PreviewRebalance not implemented")
+}
+func (UnimplementedPortfolioRebalancerServer) ListTransactions(context.Context, *ListTransactionsRequest) (*ListTransactionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTransactions not implemented")
+}
+func (UnimplementedPortfolioRebalancerServer) mustEmbedUnimplementedPortfolioRebalancerServer() {}
+
+// UnsafePortfolioRebalancerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PortfolioRebalancerServer will
+// result in compilation errors.
+type UnsafePortfolioRebalancerServer interface {
+	mustEmbedUnimplementedPortfolioRebalancerServer()
+}
+
+func RegisterPortfolioRebalancerServer(s grpc.ServiceRegistrar, srv PortfolioRebalancerServer) {
+	s.RegisterService(&PortfolioRebalancer_ServiceDesc, srv)
+}
+
+func _PortfolioRebalancer_CreatePortfolio_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreatePortfolioRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PortfolioRebalancerServer).CreatePortfolio(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PortfolioRebalancer_CreatePortfolio_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PortfolioRebalancerServer).CreatePortfolio(ctx, req.(*CreatePortfolioRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PortfolioRebalancer_GetPortfolio_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPortfolioRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PortfolioRebalancerServer).GetPortfolio(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PortfolioRebalancer_GetPortfolio_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PortfolioRebalancerServer).GetPortfolio(ctx, req.(*GetPortfolioRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PortfolioRebalancer_UpdatePortfolio_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdatePortfolioRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PortfolioRebalancerServer).UpdatePortfolio(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PortfolioRebalancer_UpdatePortfolio_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PortfolioRebalancerServer).UpdatePortfolio(ctx, req.(*UpdatePortfolioRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PortfolioRebalancer_Rebalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RebalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PortfolioRebalancerServer).Rebalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PortfolioRebalancer_Rebalance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PortfolioRebalancerServer).Rebalance(ctx, req.(*RebalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PortfolioRebalancer_PreviewRebalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PreviewRebalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PortfolioRebalancerServer).PreviewRebalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PortfolioRebalancer_PreviewRebalance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PortfolioRebalancerServer).PreviewRebalance(ctx, req.(*PreviewRebalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PortfolioRebalancer_ListTransactions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTransactionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PortfolioRebalancerServer).ListTransactions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PortfolioRebalancer_ListTransactions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PortfolioRebalancerServer).ListTransactions(ctx, req.(*ListTransactionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PortfolioRebalancer_ServiceDesc is the grpc.ServiceDesc for PortfolioRebalancer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PortfolioRebalancer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rebalancer.v1.PortfolioRebalancer",
+	HandlerType: (*PortfolioRebalancerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreatePortfolio",
+			Handler:    _PortfolioRebalancer_CreatePortfolio_Handler,
+		},
+		{
+			MethodName: "GetPortfolio",
+			Handler:    _PortfolioRebalancer_GetPortfolio_Handler,
+		},
+		{
+			MethodName: "UpdatePortfolio",
+			Handler:    _PortfolioRebalancer_UpdatePortfolio_Handler,
+		},
+		{
+			MethodName: "Rebalance",
+			Handler:    _PortfolioRebalancer_Rebalance_Handler,
+		},
+		{
+			MethodName: "PreviewRebalance",
+			Handler:    _PortfolioRebalancer_PreviewRebalance_Handler,
+		},
+		{
+			MethodName: "ListTransactions",
+			Handler:    _PortfolioRebalancer_ListTransactions_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "rebalancer/v1/rebalancer.proto",
+}
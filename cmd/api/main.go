@@ -3,21 +3,31 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"portfolio-rebalancer/api"
+	rebalancerv1 "portfolio-rebalancer/api/rebalancer/v1"
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/execution"
+	"portfolio-rebalancer/internal/grpcserver"
 	"portfolio-rebalancer/internal/handlers"
 	"portfolio-rebalancer/internal/messaging"
+	"portfolio-rebalancer/internal/middleware"
 	"portfolio-rebalancer/internal/repository"
 	"portfolio-rebalancer/internal/services"
 	"portfolio-rebalancer/pkg/elasticsearch"
+	"portfolio-rebalancer/pkg/fx"
+	"portfolio-rebalancer/pkg/idgen"
 	"portfolio-rebalancer/pkg/kafka"
 
 	"github.com/joho/godotenv"
 	kafkago "github.com/segmentio/kafka-go"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -35,9 +45,32 @@ func main() {
 	// Get Elasticsearch client
 	esClient := elasticsearch.GetClient()
 
+	// The lock index has a single version, so every replica can create it at once
+	if err := elasticsearch.Migrate(context.Background(), esClient, repository.LockIndexSpec()); err != nil {
+		log.Fatalf("Failed to create lock index: %v", err)
+	}
+	lockRepo := repository.NewLockRepository(esClient)
+
+	// Install index templates and move aliases to the latest mapping version
+	// Reindexes existing data, including indices created by dynamic mapping
+	// One replica migrates under a lock while the others wait for it to finish
+	hostname, _ := os.Hostname()
+	migrationOwner := hostname + "-" + idgen.New()
+	if err := elasticsearch.MigrateLocked(context.Background(), esClient, lockRepo, migrationOwner, repository.IndexSpecs()...); err != nil {
+		log.Fatalf("Failed to migrate Elasticsearch indices: %v", err)
+	}
+
 	// Initialize repositories
 	portfolioRepo := repository.NewPortfolioRepository(esClient)
 	transactionRepo := repository.NewTransactionRepository(esClient)
+	historyRepo := repository.NewAllocationHistoryRepository(esClient)
+	modelRepo := repository.NewModelPortfolioRepository(esClient)
+	assetRepo := repository.NewAssetRepository(esClient)
+	rebalanceRepo := repository.NewRebalanceRepository(esClient)
+	executionRepo := repository.NewExecutionRepository(esClient)
+	reconciliationRepo := repository.NewReconciliationRepository(esClient)
+	apiKeyRepo := repository.NewAPIKeyRepository(esClient)
+	auditRepo := repository.NewAuditRepository(esClient)
 
 	// Initialize Kafka producer for async transaction processing
 	// Non-fatal if Kafka is unavailable (graceful degradation)
@@ -48,9 +81,75 @@ func main() {
 		publisher = kafka.NewPublisher(kafka.GetWriter())
 	}
 
+	// FX rates for converting cash flows and trade amounts between currencies
+	rates, err := fx.NewProviderFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load FX rates: %v", err)
+	}
+
+	// Broker the consumer hands approved transactions to
+	brokerConfig, err := execution.SimulatedConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid broker configuration: %v", err)
+	}
+	broker := execution.NewSimulatedBroker(brokerConfig)
+
 	// Services
-	portfolioService := services.NewPortfolioService(portfolioRepo)
-	rebalanceService := services.NewRebalanceService(transactionRepo, publisher)
+	// Portfolio changes and rebalance submissions are recorded in an append-only audit trail
+	auditService := services.NewAuditService(auditRepo)
+	assetService := services.NewAssetService(assetRepo)
+	portfolioService := services.NewPortfolioService(portfolioRepo, historyRepo, modelRepo, assetService, auditService)
+	executionService := services.NewExecutionService(broker, executionRepo, portfolioService)
+	rebalanceService := services.NewRebalanceService(transactionRepo, rebalanceRepo, publisher, assetService, rates, executionService, auditService)
+	modelService := services.NewModelPortfolioService(modelRepo, portfolioService, rebalanceService)
+
+	assetConfig, err := services.AssetConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid asset configuration: %v", err)
+	}
+	assetValidator := services.NewAssetValidator(assetConfig, assetService)
+	rebalancePlanner := services.NewRebalancePlanner(portfolioService, rebalanceService, assetValidator)
+
+	schedulerConfig, err := services.SchedulerConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid scheduler configuration: %v", err)
+	}
+	schedulerService := services.NewSchedulerService(portfolioRepo, lockRepo, rebalanceService, schedulerConfig)
+
+	reconciliationConfig, err := services.ReconciliationConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid reconciliation configuration: %v", err)
+	}
+	authConfig, err := middleware.AuthConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid auth configuration: %v", err)
+	}
+	tokenVerifier, err := middleware.NewTokenVerifier(authConfig)
+	if err != nil {
+		log.Fatalf("Invalid end-user token configuration: %v", err)
+	}
+	rateLimitConfig, err := middleware.RateLimitConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid rate limit configuration: %v", err)
+	}
+	signatureConfig, err := middleware.SignatureConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid signing configuration: %v", err)
+	}
+	apiKeyService := services.NewAPIKeyService(apiKeyRepo)
+	if authConfig.AdminKey != "" {
+		if err := apiKeyService.EnsureKey(context.Background(), "bootstrap-admin", "bootstrap admin", authConfig.AdminKey, []string{auth.ScopeAdmin}); err != nil {
+			log.Fatalf("Failed to install AUTH_ADMIN_KEY: %v", err)
+		}
+	}
+
+	// The API contract, served to clients and enforced on incoming requests
+	spec, err := api.Load()
+	if err != nil {
+		log.Fatalf("Invalid OpenAPI specification: %v", err)
+	}
+
+	reconciliationService := services.NewReconciliationService(portfolioRepo, historyRepo, executionRepo, rebalanceRepo, reconciliationRepo, lockRepo, reconciliationConfig)
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -64,12 +163,50 @@ func main() {
 		log.Printf("Warning: Failed to start Kafka consumer: %v", err)
 	}
 
-	// Create HTTP router
-	mux := http.NewServeMux()
+	// Rebalance portfolios whose schedule is due; a shared lock keeps replicas from
+	// running the same schedule twice
+	schedulerService.Start(ctx)
+
+	// Check that executed rebalances took each portfolio to its target
+	reconciliationService.Start(ctx)
+
+	// Create HTTP router: /v1 routes by method and path, plus the deprecated unversioned routes
+	mux := handlers.NewRouter()
 
 	// Register handlers - each with single responsibility
 	handlers.NewPortfolioHandler(mux, portfolioService)
-	handlers.NewRebalanceHandler(mux, rebalanceService, portfolioService)
+	handlers.NewRebalanceHandler(mux, rebalanceService, portfolioService, rebalancePlanner)
+	handlers.NewModelPortfolioHandler(mux, modelService)
+	handlers.NewAssetHandler(mux, assetService)
+	handlers.NewExecutionHandler(mux, executionService)
+	handlers.NewReconciliationHandler(mux, reconciliationService)
+	handlers.NewAPIKeyHandler(mux, apiKeyService)
+	handlers.NewAuditHandler(mux, auditService)
+	handlers.NewOpenAPIHandler(mux, api.Spec)
+
+	// Every request carries an ID, echoed in X-Request-ID, that ties it to its audit entries
+	mux.Use(middleware.RequestID)
+
+	// Every route requires an API key with the scope the policy assigns it, or an end user's
+	// token for reading their own portfolio
+	if authConfig.Enabled {
+		mux.Use(middleware.Authenticate(apiKeyService, tokenVerifier, middleware.DefaultPolicy()))
+	} else {
+		log.Println("Warning: AUTH_ENABLED=false, the API is open to anyone who can reach it")
+	}
+
+	// Clients and users are rate limited, and busy routes are capped and shed while Kafka or
+	// Elasticsearch are slow. RPCs draw from the same budgets.
+	limiter := middleware.NewLimiter(rateLimitConfig, kafka.Latency(), elasticsearch.Latency())
+	mux.Use(limiter.Handler)
+
+	// Allocations reported by providers must be signed with a provider secret when any is configured
+	if signatureConfig.Enabled() {
+		mux.Use(middleware.VerifySignature(signatureConfig, "/v1/portfolios/{user_id}/rebalances", "/rebalance"))
+	}
+
+	// Requests must match the OpenAPI document: known fields, the right types and every required field
+	mux.Use(middleware.ValidateRequests(spec))
 
 	server := &http.Server{
 		Addr:         ":8080",
@@ -79,6 +216,31 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// gRPC API for internal services on its own port, sharing the services, credentials, rate
+	// limits and provider signing of the HTTP API. Every RPC is logged with its request ID.
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "9090"
+	}
+	unary := []grpc.UnaryServerInterceptor{middleware.UnaryLog}
+	stream := []grpc.StreamServerInterceptor{middleware.StreamLog}
+	if authConfig.Enabled {
+		unary = append(unary, middleware.UnaryAuthenticate(apiKeyService, tokenVerifier, middleware.DefaultRPCPolicy()))
+		stream = append(stream, middleware.StreamAuthenticate(apiKeyService, tokenVerifier, middleware.DefaultRPCPolicy()))
+	}
+	unary = append(unary, limiter.Unary)
+	stream = append(stream, limiter.Stream)
+	if signatureConfig.Enabled() {
+		unary = append(unary, middleware.UnaryVerifySignature(signatureConfig, rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName))
+	}
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	healthServer := grpcserver.Register(grpcServer, grpcserver.NewServer(portfolioService, rebalanceService, rebalancePlanner))
+
+	grpcListener, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC on :%s: %v", grpcPort, err)
+	}
+
 	// Channel to listen for interrupt signals
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -90,6 +252,12 @@ func main() {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
+	go func() {
+		log.Printf("gRPC server started at :%s", grpcPort)
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			log.Fatalf("Failed to start gRPC server: %v", err)
+		}
+	}()
 
 	// Wait for interrupt signal
 	<-quit
@@ -107,6 +275,21 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// Report NOT_SERVING to health checks and let RPCs in flight finish, cutting off any
+	// still running when the shutdown timeout is up
+	healthServer.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		log.Println("gRPC server forced to shutdown")
+		grpcServer.Stop()
+	}
+
 	// Close Kafka writer if it exists
 	if writer := kafka.GetWriter(); writer != nil {
 		if err := writer.Close(); err != nil {
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/pkg/idgen"
+	"strconv"
+)
+
+// Scopes an API key can carry
+const (
+	ScopePortfolioRead   = "portfolio:read"   // read portfolios, rebalances and reports
+	ScopePortfolioWrite  = "portfolio:write"  // create portfolios and change their targets
+	ScopeRebalanceSubmit = "rebalance:submit" // report drifted allocations through /rebalance
+	ScopeAdmin           = "admin"            // everything, including managing API keys
+)
+
+// keyPrefix marks API keys so they are recognisable in logs and secret scanners
+const keyPrefix = "prk_"
+
+// ValidScope reports whether scope is one of the known scopes
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopePortfolioRead, ScopePortfolioWrite, ScopeRebalanceSubmit, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// HasScope reports whether scopes grant required. The admin scope grants every scope.
+func HasScope(scopes []string, required string) bool {
+	for _, scope := range scopes {
+		if scope == required || scope == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateKey returns a new random API key. Only its hash is ever stored.
+func GenerateKey() string {
+	return keyPrefix + idgen.New() + idgen.New()
+}
+
+// HashKey returns the hex SHA-256 of an API key. Keys are long random strings, so an
+// unsalted fast hash is enough to keep a leaked index from revealing usable keys.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type contextKey struct{}
+
+// WithAPIKey returns a copy of ctx carrying the key that authenticated the request
+func WithAPIKey(ctx context.Context, key *models.APIKey) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// APIKeyFromContext returns the key that authenticated the request, if any
+func APIKeyFromContext(ctx context.Context) (*models.APIKey, bool) {
+	key, ok := ctx.Value(contextKey{}).(*models.APIKey)
+	return key, ok
+}
+
+// Sign returns the hex HMAC-SHA256 of a signed request: its unix timestamp, a dot and its body.
+// Covering the timestamp stops a captured request from being replayed later under a new one.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignPath is Sign for routes that take the user from the path, e.g. /v1/portfolios/1/rebalances:
+// the escaped path is covered too, between the timestamp and the body and separated by dots,
+// so a signed body cannot be replayed against another user.
+func SignPath(secret string, timestamp int64, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write([]byte(path))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
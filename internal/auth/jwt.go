@@ -0,0 +1,380 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is how far the issuer's clock may be from ours when checking exp and nbf
+const clockSkew = time.Minute
+
+// ErrInvalidToken is returned when a bearer token is malformed, badly signed or expired
+var ErrInvalidToken = errors.New("invalid token")
+
+// User is the end user a bearer token was issued to
+type User struct {
+	ID    string // the token subject, matched against user_id
+	Admin bool   // may read every user's data
+}
+
+// KeySet finds the public key that signed a token by its key ID
+type KeySet interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// StaticKeySet is a fixed JSON Web Key Set, e.g. loaded from a file for local development and tests
+type StaticKeySet map[string]crypto.PublicKey
+
+// NewStaticKeySet parses a JSON Web Key Set document
+func NewStaticKeySet(data []byte) (StaticKeySet, error) {
+	return parseJWKS(data)
+}
+
+// Key returns the key with ID kid. A set with a single key also serves tokens without a kid.
+func (s StaticKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := s[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(s) == 1 {
+		for _, key := range s {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// RemoteKeySet fetches the issuer's JSON Web Key Set and caches it. The set is fetched again
+// once it is older than refresh, or when a token names a key it does not have, so keys
+// rotated by the issuer are picked up without a restart.
+type RemoteKeySet struct {
+	url       string
+	refresh   time.Duration
+	client    *http.Client
+	mu        sync.Mutex
+	keys      StaticKeySet
+	fetchedAt time.Time
+}
+
+// NewRemoteKeySet creates a key set served from url
+func NewRemoteKeySet(url string, refresh time.Duration) *RemoteKeySet {
+	return &RemoteKeySet{
+		url:     url,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Key returns the key with ID kid, fetching the key set when it is stale or lacks the key.
+// Unknown keys refetch at most once a minute so bad tokens cannot hammer the issuer.
+func (s *RemoteKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	age := time.Since(s.fetchedAt)
+	if s.keys == nil || age > s.refresh {
+		if err := s.fetch(ctx); err != nil && s.keys == nil {
+			return nil, err
+		}
+	} else if _, ok := s.keys[kid]; !ok && age > time.Minute {
+		if err := s.fetch(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.keys.Key(ctx, kid)
+}
+
+// fetch downloads and parses the key set. Callers hold s.mu.
+func (s *RemoteKeySet) fetch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build JWKS request: %w", err)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: %s", res.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	return nil
+}
+
+// jsonWebKey holds the JWK fields of the RSA and EC P-256 keys tokens can be signed with
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses the signing keys of a key set, skipping encryption and unsupported keys
+func parseJWKS(data []byte) (StaticKeySet, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(StaticKeySet)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWKS key %q: %w", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no RSA or P-256 signing keys")
+	}
+
+	return keys, nil
+}
+
+// publicKey decodes the key, or returns nil for key types tokens are not verified with
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on P-256")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// TokenVerifier checks bearer tokens issued by the app's identity provider
+type TokenVerifier struct {
+	keys      KeySet
+	issuer    string
+	audience  string
+	adminRole string
+	now       func() time.Time
+}
+
+// NewTokenVerifier creates a verifier for RS256 and ES256 tokens signed by keys. Empty issuer
+// or audience skip that check; tokens whose roles claim contains adminRole may read every user's data.
+func NewTokenVerifier(keys KeySet, issuer, audience, adminRole string) *TokenVerifier {
+	return &TokenVerifier{
+		keys:      keys,
+		issuer:    issuer,
+		audience:  audience,
+		adminRole: adminRole,
+		now:       time.Now,
+	}
+}
+
+// claims are the registered and role claims of a token
+type claims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Roles     json.RawMessage `json:"roles"`
+}
+
+// Verify checks the token's signature, expiry, issuer and audience and returns its user
+func (v *TokenVerifier) Verify(ctx context.Context, token string) (*User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected three segments", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: bad header", ErrInvalidToken)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", ErrInvalidToken)
+	}
+
+	key, err := v.keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("%w: bad claims", ErrInvalidToken)
+	}
+	if err := v.validate(c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	return &User{ID: c.Subject, Admin: v.adminRole != "" && contains(stringList(c.Roles), v.adminRole)}, nil
+}
+
+// validate checks the claims of a correctly signed token
+func (v *TokenVerifier) validate(c claims) error {
+	now := v.now()
+	if c.Subject == "" {
+		return fmt.Errorf("missing subject")
+	}
+	if c.ExpiresAt == nil {
+		return fmt.Errorf("missing expiry")
+	}
+	if now.After(time.Unix(int64(*c.ExpiresAt), 0).Add(clockSkew)) {
+		return fmt.Errorf("token expired")
+	}
+	if c.NotBefore != nil && now.Add(clockSkew).Before(time.Unix(int64(*c.NotBefore), 0)) {
+		return fmt.Errorf("token not valid yet")
+	}
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return fmt.Errorf("unexpected issuer %q", c.Issuer)
+	}
+	if v.audience != "" && !contains(stringList(c.Audience), v.audience) {
+		return fmt.Errorf("token not issued for %q", v.audience)
+	}
+	return nil
+}
+
+// verifySignature checks signature over signed with key under alg. Only asymmetric
+// algorithms are accepted, so a token cannot pick "none" or an HMAC keyed with a public key.
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("RS256 token signed with a non-RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("bad signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return fmt.Errorf("ES256 token signed with a non-P-256 key")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return fmt.Errorf("bad signature")
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a token into v
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// stringList reads a claim that is either a string or an array of strings
+func stringList(raw json.RawMessage) []string {
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil && single != "" {
+		return []string{single}
+	}
+	return nil
+}
+
+// contains reports whether list holds value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+type userContextKey struct{}
+
+// WithUser returns a copy of ctx carrying the end user whose token authenticated the request
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// UserFromContext returns the end user whose token authenticated the request, if any
+func UserFromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userContextKey{}).(*User)
+	return user, ok
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signToken builds a token with the given header and claims, signed with key
+func signToken(t *testing.T, header, claims map[string]interface{}, key crypto.Signer) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to encode token segment: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	signed := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestTokenVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kid": "rsa-1", "kty": "RSA", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kid": "ec-1", "kty": "EC", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+			{"kid": "enc-1", "kty": "RSA", "use": "enc", "n": b64(otherKey.N.Bytes()), "e": "AQAB"},
+		},
+	})
+	keys, err := NewStaticKeySet(jwks)
+	if err != nil {
+		t.Fatalf("failed to parse JWKS: %v", err)
+	}
+	if _, ok := keys["enc-1"]; ok {
+		t.Errorf("expected encryption keys to be skipped")
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	verifier := NewTokenVerifier(keys, "https://id.example.com", "portfolio-app", "admin")
+	verifier.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "user1",
+			"iss": "https://id.example.com",
+			"aud": []string{"portfolio-app", "other-app"},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+	rs256 := map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}
+
+	tests := []struct {
+		name          string
+		token         string
+		expectedUser  *User
+		expectedError bool
+	}{
+		{
+			name:         "RS256 token",
+			token:        signToken(t, rs256, claims(nil), rsaKey),
+			expectedUser: &User{ID: "user1"},
+		},
+		{
+			name:         "ES256 token",
+			token:        signToken(t, map[string]interface{}{"alg": "ES256", "kid": "ec-1"}, claims(nil), ecKey),
+			expectedUser: &User{ID: "user1"},
+		},
+		{
+			name:         "admin role",
+			token:        signToken(t, rs256, claims(map[string]interface{}{"roles": []string{"viewer", "admin"}, "aud": "portfolio-app"}), rsaKey),
+			expectedUser: &User{ID: "user1", Admin: true},
+		},
+		{
+			name:         "expired within the clock skew",
+			token:        signToken(t, rs256, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}), rsaKey),
+			expectedUser: &User{ID: "user1"},
+		},
+		{
+			name:          "expired",
+			token:         signToken(t, rs256, claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}), rsaKey),
+			expectedError: true,
+		},
+		{
+			name:          "without expiry",
+			token:         signToken(t, rs256, claims(map[string]interface{}{"exp": nil}), rsaKey),
+			expectedError: true,
+		},
+		{
+			name:          "not valid yet",
+			token:         signToken(t, rs256, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}), rsaKey),
+			expectedError: true,
+		},
+		{
+			name:          "wrong issuer",
+			token:         signToken(t, rs256, claims(map[string]interface{}{"iss": "https://evil.example.com"}), rsaKey),
+			expectedError: true,
+		},
+		{
+			name:          "wrong audience",
+			token:         signToken(t, rs256, claims(map[string]interface{}{"aud": "other-app"}), rsaKey),
+			expectedError: true,
+		},
+		{
+			name:          "signed by an unknown key",
+			token:         signToken(t, rs256, claims(nil), otherKey),
+			expectedError: true,
+		},
+		{
+			name:          "unknown kid",
+			token:         signToken(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-2"}, claims(nil), rsaKey),
+			expectedError: true,
+		},
+		{
+			name:          "algorithm none",
+			token:         signToken(t, map[string]interface{}{"alg": "none", "kid": "rsa-1"}, claims(nil), rsaKey),
+			expectedError: true,
+		},
+		{
+			name:          "malformed",
+			token:         "not-a-token",
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := verifier.Verify(context.Background(), tt.token)
+			if tt.expectedError {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("expected ErrInvalidToken, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *user != *tt.expectedUser {
+				t.Errorf("expected user %+v, got %+v", tt.expectedUser, user)
+			}
+		})
+	}
+
+	t.Run("tampered claims", func(t *testing.T) {
+		token := signToken(t, rs256, claims(nil), rsaKey)
+		parts := strings.Split(token, ".")
+		forged, _ := json.Marshal(claims(map[string]interface{}{"sub": "user2"}))
+		parts[1] = base64.RawURLEncoding.EncodeToString(forged)
+		if _, err := verifier.Verify(context.Background(), strings.Join(parts, ".")); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected a token with altered claims to be rejected, got %v", err)
+		}
+	})
+}
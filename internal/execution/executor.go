@@ -0,0 +1,13 @@
+package execution
+
+import (
+	"context"
+	"portfolio-rebalancer/internal/models"
+)
+
+// Executor sends approved transactions to a broker.
+// A rejected order is reported as an execution with status REJECTED; the error is
+// reserved for failures to reach the broker at all.
+type Executor interface {
+	Execute(ctx context.Context, tx models.RebalanceTransaction) (models.Execution, error)
+}
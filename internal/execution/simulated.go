@@ -0,0 +1,170 @@
+package execution
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"portfolio-rebalancer/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// simulatedBroker is the broker name recorded on simulated executions
+const simulatedBroker = "simulated"
+
+// defaultPrice is the fill price of assets without a configured price
+const defaultPrice = 100.0
+
+// SimulatedConfig controls how the simulated broker fills orders
+type SimulatedConfig struct {
+	RejectRate       float64            // share of orders rejected, 0-1
+	PartialFillRate  float64            // share of orders only partially filled, 0-1
+	PartialFillRatio float64            // fraction of a partially filled order that fills
+	Latency          time.Duration      // delay before each order is answered
+	Prices           map[string]float64 // fill price per asset, defaultPrice when missing
+	Seed             int64              // makes the random outcomes repeatable when non-zero
+}
+
+// SimulatedConfigFromEnv reads BROKER_REJECT_RATE, BROKER_PARTIAL_FILL_RATE,
+// BROKER_PARTIAL_FILL_RATIO (default 0.5), BROKER_LATENCY as a Go duration, BROKER_PRICES
+// as comma separated asset=price pairs and BROKER_SEED. Unset, every order fills at once.
+func SimulatedConfigFromEnv() (SimulatedConfig, error) {
+	config := SimulatedConfig{
+		PartialFillRatio: 0.5,
+		Prices:           make(map[string]float64),
+	}
+
+	for key, target := range map[string]*float64{
+		"BROKER_REJECT_RATE":        &config.RejectRate,
+		"BROKER_PARTIAL_FILL_RATE":  &config.PartialFillRate,
+		"BROKER_PARTIAL_FILL_RATIO": &config.PartialFillRatio,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || f > 1 {
+			return config, fmt.Errorf("invalid %s %q, expected a number between 0 and 1", key, value)
+		}
+		*target = f
+	}
+
+	if value := os.Getenv("BROKER_LATENCY"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return config, fmt.Errorf("invalid BROKER_LATENCY %q, expected a duration such as 200ms", value)
+		}
+		config.Latency = d
+	}
+
+	for _, pair := range strings.Split(os.Getenv("BROKER_PRICES"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		asset, price, ok := strings.Cut(pair, "=")
+		p, err := strconv.ParseFloat(strings.TrimSpace(price), 64)
+		if !ok || strings.TrimSpace(asset) == "" || err != nil || p <= 0 {
+			return config, fmt.Errorf("invalid BROKER_PRICES entry %q, expected asset=price", pair)
+		}
+		config.Prices[strings.TrimSpace(asset)] = p
+	}
+
+	if value := os.Getenv("BROKER_SEED"); value != "" {
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid BROKER_SEED %q", value)
+		}
+		config.Seed = seed
+	}
+
+	if config.RejectRate+config.PartialFillRate > 1 {
+		return config, fmt.Errorf("BROKER_REJECT_RATE and BROKER_PARTIAL_FILL_RATE cannot add up to more than 1")
+	}
+
+	return config, nil
+}
+
+// SimulatedBroker fills orders locally at configured prices, rejecting or partially
+// filling a configurable share of them. It stands in for a real broker in development and tests.
+type SimulatedBroker struct {
+	config SimulatedConfig
+	mu     sync.Mutex // guards random, which is not safe for concurrent use
+	random *rand.Rand
+}
+
+// NewSimulatedBroker creates a simulated broker
+func NewSimulatedBroker(config SimulatedConfig) Executor {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &SimulatedBroker{
+		config: config,
+		random: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Execute fills, partially fills or rejects the transaction after the configured latency
+func (b *SimulatedBroker) Execute(ctx context.Context, tx models.RebalanceTransaction) (models.Execution, error) {
+	if b.config.Latency > 0 {
+		timer := time.NewTimer(b.config.Latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return models.Execution{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	b.mu.Lock()
+	roll := b.random.Float64()
+	b.mu.Unlock()
+
+	execution := models.Execution{
+		RebalanceID:      tx.RebalanceID,
+		UserID:           tx.UserID,
+		Action:           tx.Action,
+		Asset:            tx.Asset,
+		RequestedPercent: tx.RebalancePercent,
+		Broker:           simulatedBroker,
+		ExecutedAt:       time.Now().UTC().Format(time.RFC3339),
+	}
+
+	ratio := 1.0
+	switch {
+	case roll < b.config.RejectRate:
+		execution.Status = models.ExecutionRejected
+		execution.Reason = "rejected by simulated broker"
+		return execution, nil
+	case roll < b.config.RejectRate+b.config.PartialFillRate:
+		ratio = b.config.PartialFillRatio
+		execution.Status = models.ExecutionPartiallyFilled
+		execution.Reason = fmt.Sprintf("only %.0f%% of the order filled", ratio*100)
+	default:
+		execution.Status = models.ExecutionFilled
+	}
+
+	execution.FilledPercent = tx.RebalancePercent * ratio
+	execution.FillPrice = b.price(tx.Asset)
+
+	// Amounts are in the asset's currency when it differs from the portfolio's
+	notional := tx.AssetAmount
+	if notional == 0 {
+		notional = tx.Amount
+	}
+	execution.Quantity = notional * ratio / execution.FillPrice
+
+	return execution, nil
+}
+
+func (b *SimulatedBroker) price(asset string) float64 {
+	if price, ok := b.config.Prices[asset]; ok {
+		return price
+	}
+	return defaultPrice
+}
@@ -0,0 +1,159 @@
+package grpcserver
+
+import (
+	rebalancerv1 "portfolio-rebalancer/api/rebalancer/v1"
+	"portfolio-rebalancer/internal/models"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// toPortfolio converts a portfolio to its message
+func toPortfolio(p *models.Portfolio) *rebalancerv1.Portfolio {
+	return &rebalancerv1.Portfolio{
+		UserId:             p.UserID,
+		Allocation:         p.Allocation,
+		OriginalAllocation: p.OriginalAllocation,
+		ModelId:            p.ModelID,
+		AllocationTree:     toNodes(p.AllocationTree),
+		Constraints:        toConstraints(p.Constraints),
+		TotalValue:         p.TotalValue,
+		Currency:           p.Currency,
+		Schedule:           p.Schedule,
+		NextRebalanceAt:    toTimestamp(p.NextRebalanceAt),
+		RequireApproval:    p.RequireApproval,
+	}
+}
+
+// fromPortfolio converts a portfolio message to the model. next_rebalance_at is set by the
+// service from the schedule, so it is not read.
+func fromPortfolio(p *rebalancerv1.Portfolio) models.Portfolio {
+	return models.Portfolio{
+		UserID:             p.GetUserId(),
+		Allocation:         p.GetAllocation(),
+		OriginalAllocation: p.GetOriginalAllocation(),
+		ModelID:            p.GetModelId(),
+		AllocationTree:     fromNodes(p.GetAllocationTree()),
+		Constraints:        fromConstraints(p.GetConstraints()),
+		TotalValue:         p.GetTotalValue(),
+		Currency:           p.GetCurrency(),
+		Schedule:           p.GetSchedule(),
+		RequireApproval:    p.GetRequireApproval(),
+	}
+}
+
+func toNodes(nodes []models.AllocationNode) []*rebalancerv1.AllocationNode {
+	if len(nodes) == 0 {
+		return nil
+	}
+	converted := make([]*rebalancerv1.AllocationNode, 0, len(nodes))
+	for _, node := range nodes {
+		converted = append(converted, &rebalancerv1.AllocationNode{Asset: node.Asset, Percent: node.Percent, Children: toNodes(node.Children)})
+	}
+	return converted
+}
+
+func fromNodes(nodes []*rebalancerv1.AllocationNode) []models.AllocationNode {
+	if len(nodes) == 0 {
+		return nil
+	}
+	converted := make([]models.AllocationNode, 0, len(nodes))
+	for _, node := range nodes {
+		converted = append(converted, models.AllocationNode{Asset: node.GetAsset(), Percent: node.GetPercent(), Children: fromNodes(node.GetChildren())})
+	}
+	return converted
+}
+
+func toConstraints(constraints map[string]models.AssetConstraint) map[string]*rebalancerv1.AssetConstraint {
+	if len(constraints) == 0 {
+		return nil
+	}
+	converted := make(map[string]*rebalancerv1.AssetConstraint, len(constraints))
+	for asset, c := range constraints {
+		converted[asset] = &rebalancerv1.AssetConstraint{Min: c.Min, Max: c.Max, NoBuy: c.NoBuy, NoSell: c.NoSell, Locked: c.Locked}
+	}
+	return converted
+}
+
+func fromConstraints(constraints map[string]*rebalancerv1.AssetConstraint) map[string]models.AssetConstraint {
+	if len(constraints) == 0 {
+		return nil
+	}
+	converted := make(map[string]models.AssetConstraint, len(constraints))
+	for asset, c := range constraints {
+		converted[asset] = models.AssetConstraint{Min: c.Min, Max: c.Max, NoBuy: c.GetNoBuy(), NoSell: c.GetNoSell(), Locked: c.GetLocked()}
+	}
+	return converted
+}
+
+func toTransactions(transactions []models.RebalanceTransaction) []*rebalancerv1.Transaction {
+	converted := make([]*rebalancerv1.Transaction, 0, len(transactions))
+	for _, t := range transactions {
+		converted = append(converted, &rebalancerv1.Transaction{
+			RebalanceId:      t.RebalanceID,
+			UserId:           t.UserID,
+			Action:           t.Action,
+			Asset:            t.Asset,
+			AssetName:        t.AssetName,
+			AssetClass:       t.AssetClass,
+			RebalancePercent: t.RebalancePercent,
+			Amount:           t.Amount,
+			Currency:         t.Currency,
+			Status:           t.Status,
+			Timestamp:        toTimestamp(t.Timestamp),
+		})
+	}
+	return converted
+}
+
+func toSwitches(switches []models.RebalanceSwitch) []*rebalancerv1.Switch {
+	converted := make([]*rebalancerv1.Switch, 0, len(switches))
+	for _, s := range switches {
+		converted = append(converted, &rebalancerv1.Switch{RebalanceId: s.RebalanceID, From: s.From, To: s.To, RebalancePercent: s.RebalancePercent})
+	}
+	return converted
+}
+
+func toConstraintResult(result *models.ConstraintResult) *rebalancerv1.ConstraintResult {
+	if result == nil {
+		return nil
+	}
+	return &rebalancerv1.ConstraintResult{Target: result.Target, Adjusted: result.Adjusted, Violations: result.Violations}
+}
+
+func toDrift(drift []models.AllocationDrift) []*rebalancerv1.AllocationDrift {
+	if len(drift) == 0 {
+		return nil
+	}
+	converted := make([]*rebalancerv1.AllocationDrift, 0, len(drift))
+	for _, d := range drift {
+		converted = append(converted, &rebalancerv1.AllocationDrift{Asset: d.Asset, Current: d.Current, Target: d.Target, Drift: d.Drift, Children: toDrift(d.Children)})
+	}
+	return converted
+}
+
+// toAssetValidation converts the outcome of asset validation, reported only when it found
+// something to report
+func toAssetValidation(validation *models.AssetValidation) *rebalancerv1.AssetValidation {
+	if validation == nil || (!validation.HasIssues() && len(validation.Aliased) == 0) {
+		return nil
+	}
+	return &rebalancerv1.AssetValidation{Mode: validation.Mode, Unknown: validation.Unknown, Missing: validation.Missing, Aliased: validation.Aliased}
+}
+
+// toTimestamp converts a stored RFC 3339 time, leaving it unset when empty or malformed
+func toTimestamp(value string) *timestamppb.Timestamp {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// fromTimestamp converts an optional time of a request, unset being the zero time
+func fromTimestamp(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
@@ -0,0 +1,63 @@
+package grpcserver
+
+import (
+	"errors"
+	"portfolio-rebalancer/internal/services"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serviceError returns the status matching a service error, as respondWithServiceError does
+// for HTTP. Errors outside the services' taxonomy are internal, and reported with message
+// rather than their own text.
+func serviceError(err error, message string) error {
+	var validationErr *services.ValidationError
+	var notFoundErr *services.NotFoundError
+	var conflictErr *services.ConflictError
+	var constraintErr *services.ConstraintError
+	var fxErr *services.FXError
+	var unavailableErr *services.DependencyUnavailableError
+
+	switch {
+	case errors.As(err, &validationErr):
+		return invalidFields(validationErr.Message, validationErr.Fields)
+	case errors.As(err, &notFoundErr):
+		return status.Error(codes.NotFound, notFoundErr.Error())
+	case errors.As(err, &conflictErr):
+		return status.Error(codes.FailedPrecondition, conflictErr.Error())
+	case errors.As(err, &constraintErr):
+		return status.Error(codes.FailedPrecondition, constraintErr.Error())
+	case errors.As(err, &fxErr):
+		return status.Error(codes.FailedPrecondition, fxErr.Error())
+	case errors.As(err, &unavailableErr):
+		return status.Error(codes.Unavailable, unavailableErr.Dependency+" is unavailable. Please try again")
+	default:
+		return status.Error(codes.Internal, message)
+	}
+}
+
+// invalidField returns an InvalidArgument status about one field of the request
+func invalidField(field, message string) error {
+	return invalidFields(message, []services.FieldError{{Field: field, Message: message}})
+}
+
+// invalidFields returns an InvalidArgument status whose google.rpc.BadRequest details name
+// each offending field
+func invalidFields(message string, fields []services.FieldError) error {
+	st := status.New(codes.InvalidArgument, message)
+	if len(fields) == 0 {
+		return st.Err()
+	}
+
+	violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(fields))
+	for _, field := range fields {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Message})
+	}
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
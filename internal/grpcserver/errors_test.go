@@ -0,0 +1,94 @@
+package grpcserver
+
+import (
+	"errors"
+	"testing"
+
+	"portfolio-rebalancer/internal/services"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestServiceError(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		expectedCode    codes.Code
+		expectedMessage string
+	}{
+		{
+			name:            "validation error",
+			err:             &services.ValidationError{Message: "allocation must add up to 100", Fields: []services.FieldError{{Field: "allocation", Message: "must add up to 100"}}},
+			expectedCode:    codes.InvalidArgument,
+			expectedMessage: "allocation must add up to 100",
+		},
+		{
+			name:            "not found",
+			err:             &services.NotFoundError{Resource: "portfolio", ID: "1"},
+			expectedCode:    codes.NotFound,
+			expectedMessage: "portfolio not found: 1",
+		},
+		{
+			name:            "conflict",
+			err:             &services.ConflictError{Err: services.ErrInvalidTransition},
+			expectedCode:    codes.FailedPrecondition,
+			expectedMessage: services.ErrInvalidTransition.Error(),
+		},
+		{
+			name:            "infeasible constraints",
+			err:             &services.ConstraintError{Err: services.ErrInfeasibleConstraints},
+			expectedCode:    codes.FailedPrecondition,
+			expectedMessage: services.ErrInfeasibleConstraints.Error(),
+		},
+		{
+			name:            "missing FX rate",
+			err:             &services.FXError{From: "JPY", To: "USD", Err: errors.New("no FX rate from JPY to USD")},
+			expectedCode:    codes.FailedPrecondition,
+			expectedMessage: "no FX rate from JPY to USD",
+		},
+		{
+			name:            "dependency unavailable",
+			err:             &services.DependencyUnavailableError{Dependency: services.DependencyKafka, Err: errors.New("broker down")},
+			expectedCode:    codes.Unavailable,
+			expectedMessage: "Kafka is unavailable. Please try again",
+		},
+		{
+			name:            "unexpected error",
+			err:             errors.New("connection reset"),
+			expectedCode:    codes.Internal,
+			expectedMessage: "Failed to retrieve portfolio",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(serviceError(tt.err, "Failed to retrieve portfolio"))
+
+			if st.Code() != tt.expectedCode || st.Message() != tt.expectedMessage {
+				t.Errorf("expected %s %q, got %s %q", tt.expectedCode, tt.expectedMessage, st.Code(), st.Message())
+			}
+		})
+	}
+}
+
+func TestInvalidFieldsDetails(t *testing.T) {
+	err := invalidFields("new_allocation does not match the portfolio's assets", []services.FieldError{
+		{Field: "new_allocation.stonks", Message: "unknown asset"},
+		{Field: "new_allocation.bonds", Message: "missing asset held or targeted by the portfolio"},
+	})
+
+	details := status.Convert(err).Details()
+	if len(details) != 1 {
+		t.Fatalf("expected a BadRequest detail, got %v", details)
+	}
+	badRequest, ok := details[0].(*errdetails.BadRequest)
+	if !ok {
+		t.Fatalf("expected a BadRequest detail, got %T", details[0])
+	}
+	violations := badRequest.GetFieldViolations()
+	if len(violations) != 2 || violations[0].GetField() != "new_allocation.stonks" || violations[1].GetField() != "new_allocation.bonds" {
+		t.Errorf("expected a violation for each asset, got %v", violations)
+	}
+}
@@ -0,0 +1,217 @@
+package grpcserver
+
+import (
+	"context"
+	"log"
+	rebalancerv1 "portfolio-rebalancer/api/rebalancer/v1"
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/services"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the PortfolioRebalancer gRPC service on top of the same services as the
+// HTTP handlers
+type Server struct {
+	rebalancerv1.UnimplementedPortfolioRebalancerServer
+
+	portfolioService services.PortfolioService
+	rebalanceService services.RebalanceService
+	planner          services.RebalancePlanner
+}
+
+// NewServer creates a new gRPC service with injected dependencies
+func NewServer(
+	portfolioService services.PortfolioService,
+	rebalanceService services.RebalanceService,
+	planner services.RebalancePlanner,
+) *Server {
+	return &Server{
+		portfolioService: portfolioService,
+		rebalanceService: rebalanceService,
+		planner:          planner,
+	}
+}
+
+// Register registers the service on s along with the health and reflection services, and
+// returns the health server so callers can report NOT_SERVING while shutting down
+func Register(s *grpc.Server, server *Server) *health.Server {
+	rebalancerv1.RegisterPortfolioRebalancerServer(s, server)
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(rebalancerv1.PortfolioRebalancer_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+
+	reflection.Register(s)
+	return healthServer
+}
+
+// CreatePortfolio creates a user's portfolio
+func (s *Server) CreatePortfolio(ctx context.Context, req *rebalancerv1.CreatePortfolioRequest) (*rebalancerv1.Portfolio, error) {
+	if req.GetPortfolio() == nil {
+		return nil, invalidField("portfolio", "portfolio is required")
+	}
+
+	portfolio, err := s.portfolioService.CreatePortfolio(ctx, fromPortfolio(req.GetPortfolio()))
+	if err != nil {
+		log.Printf("Failed to create portfolio for user %s: %v", req.GetPortfolio().GetUserId(), err)
+		return nil, serviceError(err, "Failed to create portfolio. Please try again")
+	}
+
+	return toPortfolio(portfolio), nil
+}
+
+// GetPortfolio returns a user's portfolio. End users' tokens may leave out user_id and only
+// read their own portfolio unless they have the admin role.
+func (s *Server) GetPortfolio(ctx context.Context, req *rebalancerv1.GetPortfolioRequest) (*rebalancerv1.Portfolio, error) {
+	userID := requestUserID(ctx, req.GetUserId())
+	if err := authorizeUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	portfolio, err := s.portfolioService.GetPortfolio(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to get portfolio for user %s: %v", userID, err)
+		return nil, serviceError(err, "Failed to retrieve portfolio")
+	}
+
+	return toPortfolio(portfolio), nil
+}
+
+// UpdatePortfolio replaces a portfolio's target allocation, its schedule or both. The
+// target of a portfolio following a model portfolio changes with the model instead.
+func (s *Server) UpdatePortfolio(ctx context.Context, req *rebalancerv1.UpdatePortfolioRequest) (*rebalancerv1.Portfolio, error) {
+	if len(req.GetOriginalAllocation()) == 0 && req.Schedule == nil {
+		return nil, invalidField("original_allocation", "original_allocation or schedule is required")
+	}
+
+	portfolio, err := s.portfolioService.GetPortfolio(ctx, req.GetUserId())
+	if err != nil {
+		log.Printf("Failed to get portfolio for user %s: %v", req.GetUserId(), err)
+		return nil, serviceError(err, "Failed to retrieve portfolio")
+	}
+
+	if len(req.GetOriginalAllocation()) > 0 {
+		if portfolio.ModelID != "" {
+			return nil, status.Errorf(codes.FailedPrecondition, "portfolio follows model portfolio %s, whose allocation is its target", portfolio.ModelID)
+		}
+		portfolio, err = s.portfolioService.SetTargetAllocation(ctx, req.GetUserId(), req.GetOriginalAllocation(), models.AllocationSourceUser)
+		if err != nil {
+			log.Printf("Failed to set target allocation for user %s: %v", req.GetUserId(), err)
+			return nil, serviceError(err, "Failed to update portfolio. Please try again")
+		}
+	}
+
+	if req.Schedule != nil {
+		portfolio, err = s.portfolioService.SetSchedule(ctx, req.GetUserId(), req.GetSchedule())
+		if err != nil {
+			log.Printf("Failed to set schedule for user %s: %v", req.GetUserId(), err)
+			return nil, serviceError(err, "Failed to update portfolio. Please try again")
+		}
+	}
+
+	return toPortfolio(portfolio), nil
+}
+
+// switches pairs the plan's transactions into switches when asked to
+func (s *Server) switches(plan *services.RebalancePlan, include bool) []*rebalancerv1.Switch {
+	if !include {
+		return nil
+	}
+	return toSwitches(s.rebalanceService.PairTransactions(plan.Transactions))
+}
+
+// Rebalance submits the transactions taking a portfolio from the allocation the provider
+// reports back to its target, then records the reported allocation
+func (s *Server) Rebalance(ctx context.Context, req *rebalancerv1.RebalanceRequest) (*rebalancerv1.RebalanceResponse, error) {
+	plan, err := s.planner.Rebalance(ctx, req.GetUserId(), req.GetNewAllocation())
+	if err != nil {
+		log.Printf("Failed to rebalance portfolio for user %s: %v", req.GetUserId(), err)
+		return nil, serviceError(err, "Failed to queue rebalance transactions. Please try again")
+	}
+
+	response := &rebalancerv1.RebalanceResponse{
+		UserId:          req.GetUserId(),
+		Transactions:    toTransactions(plan.Transactions),
+		Switches:        s.switches(plan, req.GetIncludeSwitches()),
+		Constraints:     toConstraintResult(plan.Constraints),
+		Drift:           toDrift(plan.Drift),
+		AssetValidation: toAssetValidation(plan.Validation),
+	}
+	if plan.Rebalance != nil {
+		response.RebalanceId = plan.Rebalance.ID
+		response.Status = plan.Rebalance.Status
+	}
+	return response, nil
+}
+
+// PreviewRebalance returns the transactions Rebalance would submit, without submitting them
+// or recording the reported allocation
+func (s *Server) PreviewRebalance(ctx context.Context, req *rebalancerv1.PreviewRebalanceRequest) (*rebalancerv1.PreviewRebalanceResponse, error) {
+	plan, err := s.planner.Plan(ctx, req.GetUserId(), req.GetNewAllocation())
+	if err != nil {
+		log.Printf("Failed to plan rebalance for user %s: %v", req.GetUserId(), err)
+		return nil, serviceError(err, "Failed to calculate rebalance. Please try again")
+	}
+
+	return &rebalancerv1.PreviewRebalanceResponse{
+		UserId:          req.GetUserId(),
+		Transactions:    toTransactions(plan.Transactions),
+		Switches:        s.switches(plan, req.GetIncludeSwitches()),
+		Constraints:     toConstraintResult(plan.Constraints),
+		Drift:           toDrift(plan.Drift),
+		AssetValidation: toAssetValidation(plan.Validation),
+	}, nil
+}
+
+// ListTransactions lists the transactions of a user's rebalances between from and to,
+// newest first. End users' tokens may leave out user_id and only list their own.
+func (s *Server) ListTransactions(ctx context.Context, req *rebalancerv1.ListTransactionsRequest) (*rebalancerv1.ListTransactionsResponse, error) {
+	userID := requestUserID(ctx, req.GetUserId())
+	if err := authorizeUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	from, to := fromTimestamp(req.GetFrom()), fromTimestamp(req.GetTo())
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return nil, invalidField("from", "from must not be after to")
+	}
+
+	transactions, err := s.rebalanceService.ListTransactions(ctx, userID, from, to)
+	if err != nil {
+		log.Printf("Failed to list transactions for user %s: %v", userID, err)
+		return nil, serviceError(err, "Failed to retrieve transactions")
+	}
+
+	return &rebalancerv1.ListTransactionsResponse{Transactions: toTransactions(transactions)}, nil
+}
+
+// requestUserID returns the user_id of a request, defaulting to the end user whose token
+// authenticated the call
+func requestUserID(ctx context.Context, userID string) string {
+	if userID != "" {
+		return userID
+	}
+	if user, ok := auth.UserFromContext(ctx); ok {
+		return user.ID
+	}
+	return ""
+}
+
+// authorizeUser returns PermissionDenied when an end user's token asks for another user's
+// data without the admin role. API key calls are authorized by their scopes alone.
+func authorizeUser(ctx context.Context, userID string) error {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok || user.Admin || user.ID == userID {
+		return nil
+	}
+
+	log.Printf("User %s denied access to user %s", user.ID, userID)
+	return status.Error(codes.PermissionDenied, "Tokens can only read their own user's data")
+}
@@ -0,0 +1,505 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	rebalancerv1 "portfolio-rebalancer/api/rebalancer/v1"
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/middleware"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/services"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Mock portfolio service. Methods the server does not call are left to the embedded
+// interface, and panic if called.
+type mockPortfolioService struct {
+	services.PortfolioService
+	createFunc   func(ctx context.Context, p models.Portfolio) (*models.Portfolio, error)
+	getFunc      func(ctx context.Context, userID string) (*models.Portfolio, error)
+	updateFunc   func(ctx context.Context, portfolio models.Portfolio) error
+	setFunc      func(ctx context.Context, userID string, target map[string]float64, source string) (*models.Portfolio, error)
+	scheduleFunc func(ctx context.Context, userID string, schedule string) (*models.Portfolio, error)
+}
+
+func (m *mockPortfolioService) CreatePortfolio(ctx context.Context, p models.Portfolio) (*models.Portfolio, error) {
+	if m.createFunc != nil {
+		return m.createFunc(ctx, p)
+	}
+	return &p, nil
+}
+
+func (m *mockPortfolioService) GetPortfolio(ctx context.Context, userID string) (*models.Portfolio, error) {
+	if m.getFunc != nil {
+		return m.getFunc(ctx, userID)
+	}
+	return &models.Portfolio{
+		UserID:             userID,
+		Allocation:         map[string]float64{"stocks": 60, "bonds": 40},
+		OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40},
+	}, nil
+}
+
+func (m *mockPortfolioService) UpdatePortfolio(ctx context.Context, portfolio models.Portfolio) error {
+	if m.updateFunc != nil {
+		return m.updateFunc(ctx, portfolio)
+	}
+	return nil
+}
+
+func (m *mockPortfolioService) SetTargetAllocation(ctx context.Context, userID string, target map[string]float64, source string) (*models.Portfolio, error) {
+	if m.setFunc != nil {
+		return m.setFunc(ctx, userID, target, source)
+	}
+	portfolio, err := m.GetPortfolio(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	portfolio.OriginalAllocation = target
+	return portfolio, nil
+}
+
+func (m *mockPortfolioService) SetSchedule(ctx context.Context, userID string, schedule string) (*models.Portfolio, error) {
+	if m.scheduleFunc != nil {
+		return m.scheduleFunc(ctx, userID, schedule)
+	}
+	portfolio, err := m.GetPortfolio(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	portfolio.Schedule = schedule
+	return portfolio, nil
+}
+
+// Mock rebalance service, calculating one SELL and one BUY leg for any allocation
+type mockRebalanceService struct {
+	services.RebalanceService
+	submitFunc func(ctx context.Context, portfolio models.Portfolio, transactions []models.RebalanceTransaction, expected map[string]float64, actor string) (*models.Rebalance, error)
+	listFunc   func(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error)
+}
+
+func (m *mockRebalanceService) CalculateRebalance(currentAllocation, targetAllocation map[string]float64, userID string) []models.RebalanceTransaction {
+	return []models.RebalanceTransaction{
+		{UserID: userID, Action: "SELL", Asset: "stocks", RebalancePercent: 10, Timestamp: "2024-05-01T00:00:00Z"},
+		{UserID: userID, Action: "BUY", Asset: "bonds", RebalancePercent: 10, Timestamp: "2024-05-01T00:00:00Z"},
+	}
+}
+
+func (m *mockRebalanceService) PairTransactions(transactions []models.RebalanceTransaction) []models.RebalanceSwitch {
+	return []models.RebalanceSwitch{{RebalanceID: transactions[0].RebalanceID, From: "stocks", To: "bonds", RebalancePercent: 10}}
+}
+
+func (m *mockRebalanceService) SubmitRebalance(ctx context.Context, portfolio models.Portfolio, transactions []models.RebalanceTransaction, expected map[string]float64, actor string) (*models.Rebalance, error) {
+	if m.submitFunc != nil {
+		return m.submitFunc(ctx, portfolio, transactions, expected, actor)
+	}
+	for i := range transactions {
+		transactions[i].RebalanceID = "rb1"
+	}
+	return &models.Rebalance{ID: "rb1", UserID: portfolio.UserID, Status: models.RebalanceStatusApproved, Transactions: transactions}, nil
+}
+
+func (m *mockRebalanceService) ListTransactions(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx, userID, from, to)
+	}
+	return []models.RebalanceTransaction{{RebalanceID: "rb1", UserID: userID, Action: "SELL", Asset: "stocks", RebalancePercent: 10, Timestamp: "2024-05-01T00:00:00Z"}}, nil
+}
+
+// Mock asset validator, accepting any allocation
+type mockAssetValidator struct {
+	validateFunc func(ctx context.Context, allocation map[string]float64, portfolio models.Portfolio) (*models.AssetValidation, error)
+}
+
+func (m *mockAssetValidator) ValidateAllocation(ctx context.Context, allocation map[string]float64, portfolio models.Portfolio) (*models.AssetValidation, error) {
+	if m.validateFunc != nil {
+		return m.validateFunc(ctx, allocation, portfolio)
+	}
+	return &models.AssetValidation{Mode: models.AssetValidationStrict, Allocation: allocation}, nil
+}
+
+// newServer creates a server whose rebalances run through the real planner over the mocks
+func newServer(portfolioService services.PortfolioService, rebalanceService services.RebalanceService, validator services.AssetValidator) *Server {
+	return NewServer(portfolioService, rebalanceService, services.NewRebalancePlanner(portfolioService, rebalanceService, validator))
+}
+
+func TestGetPortfolio(t *testing.T) {
+	portfolioService := &mockPortfolioService{
+		getFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+			if userID == "missing" {
+				return nil, &services.NotFoundError{Resource: "portfolio", ID: userID}
+			}
+			return &models.Portfolio{UserID: userID, Schedule: "monthly", NextRebalanceAt: "2024-06-01T00:00:00Z"}, nil
+		},
+	}
+	server := newServer(portfolioService, &mockRebalanceService{}, &mockAssetValidator{})
+
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		userID         string
+		expectedCode   codes.Code
+		expectedUserID string
+	}{
+		{name: "api key reads any user", ctx: context.Background(), userID: "1", expectedCode: codes.OK, expectedUserID: "1"},
+		{name: "missing portfolio", ctx: context.Background(), userID: "missing", expectedCode: codes.NotFound},
+		{name: "token defaults to its user", ctx: auth.WithUser(context.Background(), &auth.User{ID: "2"}), expectedCode: codes.OK, expectedUserID: "2"},
+		{name: "token reads another user", ctx: auth.WithUser(context.Background(), &auth.User{ID: "2"}), userID: "1", expectedCode: codes.PermissionDenied},
+		{name: "admin token reads another user", ctx: auth.WithUser(context.Background(), &auth.User{ID: "ops", Admin: true}), userID: "1", expectedCode: codes.OK, expectedUserID: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			portfolio, err := server.GetPortfolio(tt.ctx, &rebalancerv1.GetPortfolioRequest{UserId: tt.userID})
+
+			if code := status.Code(err); code != tt.expectedCode {
+				t.Fatalf("expected %s, got %v", tt.expectedCode, err)
+			}
+			if err != nil {
+				return
+			}
+			if portfolio.GetUserId() != tt.expectedUserID || !portfolio.GetNextRebalanceAt().AsTime().Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("expected user %s's portfolio, got %v", tt.expectedUserID, portfolio)
+			}
+		})
+	}
+}
+
+func TestCreatePortfolio(t *testing.T) {
+	portfolioService := &mockPortfolioService{
+		createFunc: func(ctx context.Context, p models.Portfolio) (*models.Portfolio, error) {
+			if p.OriginalAllocation["stocks"]+p.OriginalAllocation["bonds"] != 100 {
+				return nil, &services.ValidationError{Message: "allocation must add up to 100", Fields: []services.FieldError{{Field: "allocation", Message: "must add up to 100"}}}
+			}
+			if *p.Constraints["stocks"].Max != 70 {
+				t.Errorf("expected the constraints to be passed on, got %+v", p.Constraints)
+			}
+			return &p, nil
+		},
+	}
+	server := newServer(portfolioService, &mockRebalanceService{}, &mockAssetValidator{})
+	max := 70.0
+
+	portfolio, err := server.CreatePortfolio(context.Background(), &rebalancerv1.CreatePortfolioRequest{Portfolio: &rebalancerv1.Portfolio{
+		UserId:             "1",
+		OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40},
+		Constraints:        map[string]*rebalancerv1.AssetConstraint{"stocks": {Max: &max}},
+	}})
+	if err != nil || portfolio.GetUserId() != "1" || portfolio.GetConstraints()["stocks"].GetMax() != 70 {
+		t.Fatalf("expected the created portfolio, got %v, %v", portfolio, err)
+	}
+
+	_, err = server.CreatePortfolio(context.Background(), &rebalancerv1.CreatePortfolioRequest{Portfolio: &rebalancerv1.Portfolio{
+		UserId:             "1",
+		OriginalAllocation: map[string]float64{"stocks": 60},
+	}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+
+	if _, err := server.CreatePortfolio(context.Background(), &rebalancerv1.CreatePortfolioRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected a missing portfolio to be rejected, got %v", err)
+	}
+}
+
+func TestUpdatePortfolio(t *testing.T) {
+	schedule := "quarterly"
+	empty := ""
+
+	tests := []struct {
+		name             string
+		req              *rebalancerv1.UpdatePortfolioRequest
+		modelID          string
+		expectedCode     codes.Code
+		expectedSchedule string
+		expectedTarget   float64
+	}{
+		{
+			name:             "target and schedule",
+			req:              &rebalancerv1.UpdatePortfolioRequest{UserId: "1", OriginalAllocation: map[string]float64{"stocks": 70, "bonds": 30}, Schedule: &schedule},
+			expectedCode:     codes.OK,
+			expectedSchedule: "quarterly",
+			expectedTarget:   70,
+		},
+		{
+			name:           "schedule turned off",
+			req:            &rebalancerv1.UpdatePortfolioRequest{UserId: "1", Schedule: &empty},
+			expectedCode:   codes.OK,
+			expectedTarget: 60,
+		},
+		{
+			name:         "nothing to update",
+			req:          &rebalancerv1.UpdatePortfolioRequest{UserId: "1"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "target follows a model",
+			req:          &rebalancerv1.UpdatePortfolioRequest{UserId: "1", OriginalAllocation: map[string]float64{"stocks": 70, "bonds": 30}},
+			modelID:      "balanced",
+			expectedCode: codes.FailedPrecondition,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every update changes the same stored portfolio
+			stored := &models.Portfolio{UserID: "1", ModelID: tt.modelID, Schedule: "monthly", OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40}}
+			portfolioService := &mockPortfolioService{
+				getFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+					return stored, nil
+				},
+			}
+			server := newServer(portfolioService, &mockRebalanceService{}, &mockAssetValidator{})
+
+			portfolio, err := server.UpdatePortfolio(context.Background(), tt.req)
+
+			if code := status.Code(err); code != tt.expectedCode {
+				t.Fatalf("expected %s, got %v", tt.expectedCode, err)
+			}
+			if err != nil {
+				return
+			}
+			if portfolio.GetSchedule() != tt.expectedSchedule || portfolio.GetOriginalAllocation()["stocks"] != tt.expectedTarget {
+				t.Errorf("expected schedule %q and %v%% stocks, got %v", tt.expectedSchedule, tt.expectedTarget, portfolio)
+			}
+		})
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	tests := []struct {
+		name           string
+		req            *rebalancerv1.RebalanceRequest
+		validateErr    error
+		submitErr      error
+		expectedCode   codes.Code
+		expectedFields []string
+	}{
+		{
+			name:         "submitted with switches",
+			req:          &rebalancerv1.RebalanceRequest{UserId: "1", NewAllocation: map[string]float64{"stocks": 70, "bonds": 30}, IncludeSwitches: true},
+			expectedCode: codes.OK,
+		},
+		{
+			name:           "missing user",
+			req:            &rebalancerv1.RebalanceRequest{NewAllocation: map[string]float64{"stocks": 70, "bonds": 30}},
+			expectedCode:   codes.InvalidArgument,
+			expectedFields: []string{"user_id"},
+		},
+		{
+			name:           "allocation not adding up to 100",
+			req:            &rebalancerv1.RebalanceRequest{UserId: "1", NewAllocation: map[string]float64{"stocks": 70}},
+			expectedCode:   codes.InvalidArgument,
+			expectedFields: []string{"new_allocation"},
+		},
+		{
+			name:           "unknown asset",
+			req:            &rebalancerv1.RebalanceRequest{UserId: "1", NewAllocation: map[string]float64{"stonks": 70, "bonds": 30}},
+			validateErr:    &services.AssetUniverseError{Unknown: []string{"stonks"}},
+			expectedCode:   codes.InvalidArgument,
+			expectedFields: []string{"new_allocation.stonks"},
+		},
+		{
+			name:         "kafka down",
+			req:          &rebalancerv1.RebalanceRequest{UserId: "1", NewAllocation: map[string]float64{"stocks": 70, "bonds": 30}},
+			submitErr:    &services.DependencyUnavailableError{Dependency: services.DependencyKafka, Err: errors.New("broker down")},
+			expectedCode: codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updated *models.Portfolio
+			portfolioService := &mockPortfolioService{
+				updateFunc: func(ctx context.Context, portfolio models.Portfolio) error {
+					updated = &portfolio
+					return nil
+				},
+			}
+			rebalanceService := &mockRebalanceService{}
+			if tt.submitErr != nil {
+				rebalanceService.submitFunc = func(ctx context.Context, portfolio models.Portfolio, transactions []models.RebalanceTransaction, expected map[string]float64, actor string) (*models.Rebalance, error) {
+					return nil, tt.submitErr
+				}
+			}
+			validator := &mockAssetValidator{}
+			if tt.validateErr != nil {
+				validator.validateFunc = func(ctx context.Context, allocation map[string]float64, portfolio models.Portfolio) (*models.AssetValidation, error) {
+					return nil, tt.validateErr
+				}
+			}
+			server := newServer(portfolioService, rebalanceService, validator)
+
+			resp, err := server.Rebalance(context.Background(), tt.req)
+
+			if code := status.Code(err); code != tt.expectedCode {
+				t.Fatalf("expected %s, got %v", tt.expectedCode, err)
+			}
+			if fields := violatedFields(err); !equalStrings(fields, tt.expectedFields) {
+				t.Errorf("expected violations of %v, got %v", tt.expectedFields, fields)
+			}
+			if err != nil {
+				if updated != nil {
+					t.Errorf("expected the portfolio to be left alone, got %+v", updated)
+				}
+				return
+			}
+
+			if resp.GetRebalanceId() != "rb1" || resp.GetStatus() != models.RebalanceStatusApproved || len(resp.GetTransactions()) != 2 || len(resp.GetSwitches()) != 1 {
+				t.Errorf("expected the submitted rebalance with its switches, got %v", resp)
+			}
+			if resp.GetTransactions()[0].GetRebalanceId() != "rb1" || resp.GetSwitches()[0].GetRebalanceId() != "rb1" {
+				t.Errorf("expected the submitted legs and switches to share the rebalance ID, got %v", resp)
+			}
+			if updated == nil || updated.Allocation["stocks"] != 70 {
+				t.Errorf("expected the reported allocation to be recorded, got %+v", updated)
+			}
+		})
+	}
+}
+
+func TestPreviewRebalance(t *testing.T) {
+	portfolioService := &mockPortfolioService{
+		updateFunc: func(ctx context.Context, portfolio models.Portfolio) error {
+			t.Errorf("expected the portfolio to be left alone")
+			return nil
+		},
+	}
+	rebalanceService := &mockRebalanceService{
+		submitFunc: func(ctx context.Context, portfolio models.Portfolio, transactions []models.RebalanceTransaction, expected map[string]float64, actor string) (*models.Rebalance, error) {
+			t.Errorf("expected nothing to be submitted")
+			return nil, nil
+		},
+	}
+	server := newServer(portfolioService, rebalanceService, &mockAssetValidator{})
+
+	resp, err := server.PreviewRebalance(context.Background(), &rebalancerv1.PreviewRebalanceRequest{UserId: "1", NewAllocation: map[string]float64{"stocks": 70, "bonds": 30}})
+	if err != nil || len(resp.GetTransactions()) != 2 || resp.GetSwitches() != nil {
+		t.Errorf("expected the calculated transactions without switches, got %v, %v", resp, err)
+	}
+}
+
+func TestListTransactions(t *testing.T) {
+	var listedFrom time.Time
+	rebalanceService := &mockRebalanceService{
+		listFunc: func(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error) {
+			listedFrom = from
+			if userID == "down" {
+				return nil, &services.DependencyUnavailableError{Dependency: services.DependencyElasticsearch, Err: errors.New("es down")}
+			}
+			return []models.RebalanceTransaction{{RebalanceID: "rb1", UserID: userID, Action: "SELL", Asset: "stocks", Timestamp: "2024-05-01T00:00:00Z"}}, nil
+		},
+	}
+	server := newServer(&mockPortfolioService{}, rebalanceService, &mockAssetValidator{})
+	may := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	resp, err := server.ListTransactions(context.Background(), &rebalancerv1.ListTransactionsRequest{UserId: "1", From: timestamppb.New(may)})
+	if err != nil || len(resp.GetTransactions()) != 1 || !resp.GetTransactions()[0].GetTimestamp().AsTime().Equal(may) || !listedFrom.Equal(may) {
+		t.Errorf("expected the transactions since May, got %v, %v", resp, err)
+	}
+
+	_, err = server.ListTransactions(context.Background(), &rebalancerv1.ListTransactionsRequest{UserId: "1", From: timestamppb.New(may), To: timestamppb.New(may.Add(-time.Hour))})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an inverted range to be rejected, got %v", err)
+	}
+
+	_, err = server.ListTransactions(context.Background(), &rebalancerv1.ListTransactionsRequest{UserId: "down"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+}
+
+// Mock authenticator, knowing a single read-only key
+type mockAuthenticator struct{}
+
+func (m *mockAuthenticator) Authenticate(ctx context.Context, key string) (*models.APIKey, error) {
+	if key == "app-key" {
+		return &models.APIKey{ID: "k1", Name: "app", Scopes: []string{auth.ScopePortfolioRead}}, nil
+	}
+	return nil, errors.New("invalid api key")
+}
+
+// TestServe calls the server over a connection with the log and authentication interceptors
+func TestServe(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(middleware.UnaryLog, middleware.UnaryAuthenticate(&mockAuthenticator{}, nil, middleware.DefaultRPCPolicy())),
+		grpc.ChainStreamInterceptor(middleware.StreamLog, middleware.StreamAuthenticate(&mockAuthenticator{}, nil, middleware.DefaultRPCPolicy())),
+	)
+	Register(s, newServer(&mockPortfolioService{}, &mockRebalanceService{}, &mockAssetValidator{}))
+	go s.Serve(listener)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	client := rebalancerv1.NewPortfolioRebalancerClient(conn)
+	ctx := context.Background()
+
+	health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: rebalancerv1.PortfolioRebalancer_ServiceDesc.ServiceName})
+	if err != nil || health.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("expected the service to be serving without credentials, got %v, %v", health, err)
+	}
+
+	if _, err := client.GetPortfolio(ctx, &rebalancerv1.GetPortfolioRequest{UserId: "1"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without credentials, got %v", err)
+	}
+
+	var header metadata.MD
+	authenticated := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer app-key", "x-request-id", "req-1")
+	portfolio, err := client.GetPortfolio(authenticated, &rebalancerv1.GetPortfolioRequest{UserId: "1"}, grpc.Header(&header))
+	if err != nil || portfolio.GetUserId() != "1" {
+		t.Errorf("expected user 1's portfolio, got %v, %v", portfolio, err)
+	}
+	if ids := header.Get("x-request-id"); len(ids) != 1 || ids[0] != "req-1" {
+		t.Errorf("expected the request ID to be echoed, got %v", ids)
+	}
+
+	_, err = client.Rebalance(authenticated, &rebalancerv1.RebalanceRequest{UserId: "1", NewAllocation: map[string]float64{"stocks": 70, "bonds": 30}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected a read-only key to be denied rebalances, got %v", err)
+	}
+}
+
+// violatedFields lists the fields of an InvalidArgument status' BadRequest details
+func violatedFields(err error) []string {
+	var fields []string
+	for _, detail := range status.Convert(err).Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.GetFieldViolations() {
+				fields = append(fields, violation.GetField())
+			}
+		}
+	}
+	return fields
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"portfolio-rebalancer/internal/auth"
+)
+
+// userIDParam returns the user_id path or query parameter, defaulting to the end user whose
+// token authenticated the request
+func userIDParam(r *http.Request) string {
+	if userID := param(r, "user_id"); userID != "" {
+		return userID
+	}
+	if user, ok := auth.UserFromContext(r.Context()); ok {
+		return user.ID
+	}
+	return ""
+}
+
+// authorizeUser responds with 403 and returns false when an end user's token asks for another
+// user's data without the admin role. API key requests are authorized by their scopes alone.
+func authorizeUser(w http.ResponseWriter, r *http.Request, userID string) bool {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok || user.Admin || user.ID == userID {
+		return true
+	}
+
+	log.Printf("User %s denied %s %s for user %s", user.ID, r.Method, r.URL.Path, userID)
+	RespondWithError(w, http.StatusForbidden, "Tokens can only read their own user's data")
+	return false
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"portfolio-rebalancer/internal/services"
+	"portfolio-rebalancer/pkg/router"
+)
+
+type APIKeyHandler struct {
+	apiKeyService services.APIKeyService
+}
+
+// NewAPIKeyHandler creates a new API key handler with injected dependencies
+func NewAPIKeyHandler(mux *router.Router, apiKeyService services.APIKeyService) {
+	handler := &APIKeyHandler{
+		apiKeyService: apiKeyService,
+	}
+
+	// Register routes
+	mux.HandleFunc(http.MethodGet, "/v1/api-keys", handler.handleListKeys)
+	mux.HandleFunc(http.MethodPost, "/v1/api-keys", handler.handleCreateKey)
+	mux.HandleFunc(http.MethodDelete, "/v1/api-keys/{id}", handler.handleRevokeKey)
+
+	// Unversioned routes, deprecated in favour of /v1
+	mux.HandleFunc(router.AnyMethod, "/api-keys", deprecated(handler.HandleAPIKeys))
+}
+
+// HandleAPIKeys routes API key management requests by HTTP method
+func (h *APIKeyHandler) HandleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.handleListKeys(w, r)
+	case http.MethodPost:
+		h.handleCreateKey(w, r)
+	case http.MethodDelete:
+		h.handleRevokeKey(w, r)
+	default:
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only GET, POST and DELETE methods are allowed")
+	}
+}
+
+// handleListKeys lists every API key with its scopes, never the key itself
+// GET /v1/api-keys
+func (h *APIKeyHandler) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.apiKeyService.ListKeys(r.Context())
+	if err != nil {
+		log.Printf("Failed to list api keys: %v", err)
+		respondWithServiceError(w, err, "Failed to retrieve API keys")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"api_keys": keys,
+		"count":    len(keys),
+	})
+}
+
+// handleCreateKey issues a new API key. The key is only returned in this response.
+// Sample Request (POST /v1/api-keys):
+//
+//	{
+//	    "name": "allocation-provider",
+//	    "scopes": ["rebalance:submit"]
+//	}
+func (h *APIKeyHandler) handleCreateKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithInvalidBody(w)
+		return
+	}
+
+	key, record, err := h.apiKeyService.CreateKey(r.Context(), req.Name, req.Scopes)
+	if err != nil {
+		log.Printf("Failed to issue api key %s: %v", req.Name, err)
+		respondWithServiceError(w, err, "Failed to issue API key. Please try again")
+		return
+	}
+
+	log.Printf("Issued API key %s (%s) with scopes %v", record.ID, record.Name, record.Scopes)
+	RespondWithJSON(w, http.StatusCreated, map[string]interface{}{
+		"key":     key,
+		"api_key": record,
+		"message": "Store this key now, it cannot be retrieved again",
+	})
+}
+
+// handleRevokeKey stops an API key from authenticating
+// DELETE /v1/api-keys/9f1c2e...
+// DELETE /api-keys?id=9f1c2e...
+func (h *APIKeyHandler) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	id := param(r, "id")
+	if id == "" {
+		respondWithInvalidField(w, "id", "id query parameter is required")
+		return
+	}
+
+	record, err := h.apiKeyService.RevokeKey(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to revoke api key %s: %v", id, err)
+		respondWithServiceError(w, err, "Failed to revoke API key")
+		return
+	}
+
+	log.Printf("Revoked API key %s (%s)", record.ID, record.Name)
+	RespondWithJSON(w, http.StatusOK, record)
+}
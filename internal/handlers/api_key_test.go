@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/services"
+)
+
+// Mock API key service
+type mockAPIKeyService struct {
+	createFunc func(ctx context.Context, name string, scopes []string) (string, *models.APIKey, error)
+	revokeFunc func(ctx context.Context, id string) (*models.APIKey, error)
+}
+
+func (m *mockAPIKeyService) CreateKey(ctx context.Context, name string, scopes []string) (string, *models.APIKey, error) {
+	if m.createFunc != nil {
+		return m.createFunc(ctx, name, scopes)
+	}
+	return "prk_secret", &models.APIKey{ID: "k1", Name: name, Scopes: scopes}, nil
+}
+
+func (m *mockAPIKeyService) EnsureKey(ctx context.Context, id, name, key string, scopes []string) error {
+	return nil
+}
+
+func (m *mockAPIKeyService) Authenticate(ctx context.Context, key string) (*models.APIKey, error) {
+	return nil, services.ErrInvalidAPIKey
+}
+
+func (m *mockAPIKeyService) ListKeys(ctx context.Context) ([]models.APIKey, error) {
+	return []models.APIKey{{ID: "k1", Name: "provider"}}, nil
+}
+
+func (m *mockAPIKeyService) RevokeKey(ctx context.Context, id string) (*models.APIKey, error) {
+	if m.revokeFunc != nil {
+		return m.revokeFunc(ctx, id)
+	}
+	return &models.APIKey{ID: id, RevokedAt: "2024-05-01T00:00:00Z"}, nil
+}
+
+func TestHandleAPIKeys(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		requestBody    interface{}
+		service        *mockAPIKeyService
+		expectedStatus int
+	}{
+		{
+			name:           "list keys",
+			method:         http.MethodGet,
+			url:            "/api-keys",
+			service:        &mockAPIKeyService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "create key",
+			method:         http.MethodPost,
+			url:            "/api-keys",
+			requestBody:    map[string]interface{}{"name": "provider", "scopes": []string{"rebalance:submit"}},
+			service:        &mockAPIKeyService{},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "create with invalid JSON",
+			method:         http.MethodPost,
+			url:            "/api-keys",
+			requestBody:    "invalid json",
+			service:        &mockAPIKeyService{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "create with unknown scope",
+			method:      http.MethodPost,
+			url:         "/api-keys",
+			requestBody: map[string]interface{}{"name": "provider", "scopes": []string{"everything"}},
+			service: &mockAPIKeyService{
+				createFunc: func(ctx context.Context, name string, scopes []string) (string, *models.APIKey, error) {
+					return "", nil, &services.ValidationError{Message: `unknown scope "everything"`}
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "revoke key",
+			method:         http.MethodDelete,
+			url:            "/api-keys?id=k1",
+			service:        &mockAPIKeyService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "revoke without id",
+			method:         http.MethodDelete,
+			url:            "/api-keys",
+			service:        &mockAPIKeyService{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "revoke unknown key",
+			method: http.MethodDelete,
+			url:    "/api-keys?id=missing",
+			service: &mockAPIKeyService{
+				revokeFunc: func(ctx context.Context, id string) (*models.APIKey, error) {
+					return nil, &services.NotFoundError{Resource: "api key", ID: id}
+				},
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodPut,
+			url:            "/api-keys",
+			service:        &mockAPIKeyService{},
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &APIKeyHandler{
+				apiKeyService: tt.service,
+			}
+
+			var body []byte
+			if str, ok := tt.requestBody.(string); ok {
+				body = []byte(str)
+			} else if tt.requestBody != nil {
+				body, _ = json.Marshal(tt.requestBody)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.url, bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			handler.HandleAPIKeys(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/services"
+	"portfolio-rebalancer/pkg/router"
+)
+
+type AssetHandler struct {
+	assetService services.AssetService
+}
+
+// NewAssetHandler creates a new asset registry handler with injected dependencies
+func NewAssetHandler(mux *router.Router, assetService services.AssetService) {
+	handler := &AssetHandler{
+		assetService: assetService,
+	}
+
+	// Register routes
+	mux.HandleFunc(http.MethodGet, "/v1/assets", handler.handleGetAssets)
+	mux.HandleFunc(http.MethodPost, "/v1/assets", handler.handleCreateAsset)
+	mux.HandleFunc(http.MethodGet, "/v1/assets/{id}", handler.handleGetAssets)
+	mux.HandleFunc(http.MethodPut, "/v1/assets/{id}", handler.handleUpdateAsset)
+
+	// Unversioned routes, deprecated in favour of /v1
+	mux.HandleFunc(router.AnyMethod, "/assets", deprecated(handler.HandleAssets))
+}
+
+// HandleAssets routes asset registry requests by HTTP method
+func (h *AssetHandler) HandleAssets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.handleGetAssets(w, r)
+	case http.MethodPost:
+		h.handleCreateAsset(w, r)
+	case http.MethodPut:
+		h.handleUpdateAsset(w, r)
+	default:
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only GET, POST and PUT methods are allowed")
+	}
+}
+
+// handleGetAssets retrieves one asset by id, or the whole registry
+// GET /v1/assets
+// GET /v1/assets/stocks
+// GET /assets?id=stocks
+func (h *AssetHandler) handleGetAssets(w http.ResponseWriter, r *http.Request) {
+	id := param(r, "id")
+	if id == "" {
+		assets, err := h.assetService.ListAssets(r.Context())
+		if err != nil {
+			log.Printf("Failed to list assets: %v", err)
+			respondWithServiceError(w, err, "Failed to list assets")
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"assets": assets,
+			"count":  len(assets),
+		})
+		return
+	}
+
+	asset, err := h.assetService.GetAsset(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to get asset %s: %v", id, err)
+		respondWithServiceError(w, err, "Failed to retrieve asset")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, asset)
+}
+
+// handleCreateAsset registers an asset
+// Sample Request (POST /v1/assets):
+//
+//	{
+//	    "id": "stocks",
+//	    "name": "Global Equity Index",
+//	    "class": "equity",
+//	    "currency": "USD",
+//	    "tradable": true,
+//	    "min_lot": 1,
+//	    "aliases": ["stock", "equities"]
+//	}
+func (h *AssetHandler) handleCreateAsset(w http.ResponseWriter, r *http.Request) {
+	var asset models.Asset
+	if err := json.NewDecoder(r.Body).Decode(&asset); err != nil {
+		respondWithInvalidBody(w)
+		return
+	}
+
+	created, err := h.assetService.CreateAsset(r.Context(), asset)
+	if err != nil {
+		log.Printf("Failed to create asset %s: %v", asset.ID, err)
+		respondWithServiceError(w, err, "Failed to create asset. Please try again")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusCreated, created)
+}
+
+// handleUpdateAsset replaces an asset's metadata and aliases
+// Sample Request (PUT /v1/assets/stocks): same body as POST, the id taken from the path.
+// PUT /assets takes the id in the body.
+func (h *AssetHandler) handleUpdateAsset(w http.ResponseWriter, r *http.Request) {
+	var asset models.Asset
+	if err := json.NewDecoder(r.Body).Decode(&asset); err != nil {
+		respondWithInvalidBody(w)
+		return
+	}
+
+	bindPathParam(r, "id", &asset.ID)
+
+	updated, err := h.assetService.UpdateAsset(r.Context(), asset)
+	if err != nil {
+		log.Printf("Failed to update asset %s: %v", asset.ID, err)
+		respondWithServiceError(w, err, "Failed to update asset. Please try again")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, updated)
+}
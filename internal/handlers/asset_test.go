@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/services"
+)
+
+// Mock asset service
+type mockAssetService struct {
+	createFunc func(ctx context.Context, asset models.Asset) (*models.Asset, error)
+	getFunc    func(ctx context.Context, id string) (*models.Asset, error)
+	listFunc   func(ctx context.Context) ([]models.Asset, error)
+	updateFunc func(ctx context.Context, asset models.Asset) (*models.Asset, error)
+}
+
+func (m *mockAssetService) CreateAsset(ctx context.Context, asset models.Asset) (*models.Asset, error) {
+	if m.createFunc != nil {
+		return m.createFunc(ctx, asset)
+	}
+	return &asset, nil
+}
+
+func (m *mockAssetService) GetAsset(ctx context.Context, id string) (*models.Asset, error) {
+	if m.getFunc != nil {
+		return m.getFunc(ctx, id)
+	}
+	return nil, &services.NotFoundError{Resource: "asset", ID: id}
+}
+
+func (m *mockAssetService) ListAssets(ctx context.Context) ([]models.Asset, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx)
+	}
+	return []models.Asset{}, nil
+}
+
+func (m *mockAssetService) UpdateAsset(ctx context.Context, asset models.Asset) (*models.Asset, error) {
+	if m.updateFunc != nil {
+		return m.updateFunc(ctx, asset)
+	}
+	return &asset, nil
+}
+
+func (m *mockAssetService) Registry(ctx context.Context) (*models.AssetRegistry, error) {
+	assets, err := m.ListAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return models.NewAssetRegistry(assets), nil
+}
+
+func TestHandleAssets(t *testing.T) {
+	validAsset := map[string]interface{}{
+		"id":       "stocks",
+		"name":     "Global Equity",
+		"class":    "equity",
+		"currency": "USD",
+		"tradable": true,
+		"aliases":  []string{"stock"},
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		requestBody    interface{}
+		service        *mockAssetService
+		expectedStatus int
+	}{
+		{
+			name:           "list assets",
+			method:         http.MethodGet,
+			url:            "/assets",
+			service:        &mockAssetService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "list error",
+			method: http.MethodGet,
+			url:    "/assets",
+			service: &mockAssetService{
+				listFunc: func(ctx context.Context) ([]models.Asset, error) {
+					return nil, errors.New("es down")
+				},
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:   "get asset",
+			method: http.MethodGet,
+			url:    "/assets?id=stocks",
+			service: &mockAssetService{
+				getFunc: func(ctx context.Context, id string) (*models.Asset, error) {
+					return &models.Asset{ID: id}, nil
+				},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "get unknown asset",
+			method:         http.MethodGet,
+			url:            "/assets?id=missing",
+			service:        &mockAssetService{},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "create asset",
+			method:         http.MethodPost,
+			url:            "/assets",
+			requestBody:    validAsset,
+			service:        &mockAssetService{},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "create with invalid JSON",
+			method:         http.MethodPost,
+			url:            "/assets",
+			requestBody:    "invalid json",
+			service:        &mockAssetService{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "create with alias conflict",
+			method:      http.MethodPost,
+			url:         "/assets",
+			requestBody: validAsset,
+			service: &mockAssetService{
+				createFunc: func(ctx context.Context, asset models.Asset) (*models.Asset, error) {
+					return nil, &services.ConflictError{Err: errors.New("stock is already used by asset equity_fund")}
+				},
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "update asset",
+			method:         http.MethodPut,
+			url:            "/assets",
+			requestBody:    validAsset,
+			service:        &mockAssetService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "update unknown asset",
+			method:      http.MethodPut,
+			url:         "/assets",
+			requestBody: validAsset,
+			service: &mockAssetService{
+				updateFunc: func(ctx context.Context, asset models.Asset) (*models.Asset, error) {
+					return nil, &services.NotFoundError{Resource: "asset", ID: "stocks"}
+				},
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodDelete,
+			url:            "/assets?id=stocks",
+			service:        &mockAssetService{},
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &AssetHandler{
+				assetService: tt.service,
+			}
+
+			var body []byte
+			if str, ok := tt.requestBody.(string); ok {
+				body = []byte(str)
+			} else if tt.requestBody != nil {
+				body, _ = json.Marshal(tt.requestBody)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.url, bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			handler.HandleAssets(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
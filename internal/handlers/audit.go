@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"portfolio-rebalancer/internal/services"
+	"portfolio-rebalancer/pkg/router"
+)
+
+type AuditHandler struct {
+	auditService services.AuditService
+}
+
+// NewAuditHandler creates a new audit handler with injected dependencies
+func NewAuditHandler(mux *router.Router, auditService services.AuditService) {
+	handler := &AuditHandler{
+		auditService: auditService,
+	}
+
+	// Register routes
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/{user_id}/audit", handler.HandleAudit)
+
+	// Unversioned routes, deprecated in favour of /v1
+	mux.HandleFunc(router.AnyMethod, "/audit", deprecated(handler.HandleAudit))
+}
+
+// HandleAudit lists the audit trail of a user's portfolio and rebalances, newest first
+// GET /v1/portfolios/1/audit?from=2025-01-01T00:00:00Z&to=2025-02-01T00:00:00Z
+// GET /audit?user_id=1
+func (h *AuditHandler) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	userID := param(r, "user_id")
+	if userID == "" {
+		respondWithInvalidField(w, "user_id", "user_id query parameter is required")
+		return
+	}
+
+	from, to, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := h.auditService.ListEntries(r.Context(), userID, from, to)
+	if err != nil {
+		log.Printf("Failed to list audit entries for user %s: %v", userID, err)
+		respondWithServiceError(w, err, "Failed to retrieve audit entries")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"portfolio-rebalancer/internal/models"
+)
+
+// Mock audit service
+type mockAuditService struct {
+	recorded []models.AuditEntry
+	listFunc func(ctx context.Context, userID string, from, to time.Time) ([]models.AuditEntry, error)
+}
+
+func (m *mockAuditService) Record(ctx context.Context, entry models.AuditEntry) {
+	m.recorded = append(m.recorded, entry)
+}
+
+func (m *mockAuditService) ListEntries(ctx context.Context, userID string, from, to time.Time) ([]models.AuditEntry, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx, userID, from, to)
+	}
+	return []models.AuditEntry{}, nil
+}
+
+func TestHandleAudit(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		listErr        error
+		expectedStatus int
+		expectedCount  int
+	}{
+		{
+			name:           "list entries",
+			method:         http.MethodGet,
+			url:            "/audit?user_id=1",
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+		},
+		{
+			name:           "list entries in range",
+			method:         http.MethodGet,
+			url:            "/audit?user_id=1&from=2024-05-01T00:00:00Z&to=2024-06-01T00:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+		},
+		{
+			name:           "missing user_id",
+			method:         http.MethodGet,
+			url:            "/audit",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid from",
+			method:         http.MethodGet,
+			url:            "/audit?user_id=1&from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "from after to",
+			method:         http.MethodGet,
+			url:            "/audit?user_id=1&from=2024-06-01T00:00:00Z&to=2024-05-01T00:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "list error",
+			method:         http.MethodGet,
+			url:            "/audit?user_id=1",
+			listErr:        errors.New("es down"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodPost,
+			url:            "/audit?user_id=1",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &AuditHandler{
+				auditService: &mockAuditService{
+					listFunc: func(ctx context.Context, userID string, from, to time.Time) ([]models.AuditEntry, error) {
+						if tt.listErr != nil {
+							return nil, tt.listErr
+						}
+						return []models.AuditEntry{
+							{ID: "a2", UserID: userID, Action: models.AuditRebalanceSubmit},
+							{ID: "a1", UserID: userID, Action: models.AuditPortfolioCreate},
+						}, nil
+					},
+				},
+			}
+
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			w := httptest.NewRecorder()
+
+			handler.HandleAudit(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				UserID  string              `json:"user_id"`
+				Entries []models.AuditEntry `json:"entries"`
+				Count   int                 `json:"count"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Count != tt.expectedCount || len(resp.Entries) != tt.expectedCount {
+				t.Errorf("expected %d entries, got count %d with %d entries", tt.expectedCount, resp.Count, len(resp.Entries))
+			}
+		})
+	}
+}
@@ -2,24 +2,84 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"portfolio-rebalancer/internal/services"
 )
 
-// ErrorResponse represents a structured error response
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
-	Code    int    `json:"code"`
+// ProblemContentType is the media type of every error response (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// Problem types. They are stable, so clients can branch on them instead of on messages.
+const (
+	ProblemValidation            = "/problems/validation-error"
+	ProblemUnauthorized          = "/problems/unauthorized"
+	ProblemForbidden             = "/problems/forbidden"
+	ProblemNotFound              = "/problems/not-found"
+	ProblemMethodNotAllowed      = "/problems/method-not-allowed"
+	ProblemConflict              = "/problems/conflict"
+	ProblemPayloadTooLarge       = "/problems/payload-too-large"
+	ProblemUnprocessable         = "/problems/unprocessable"
+	ProblemInfeasibleConstraints = "/problems/infeasible-constraints"
+	ProblemFXRateUnavailable     = "/problems/fx-rate-unavailable"
+	ProblemRateLimited           = "/problems/rate-limited"
+	ProblemInternal              = "/problems/internal-error"
+	ProblemDependencyUnavailable = "/problems/dependency-unavailable"
+	ProblemUnavailable           = "/problems/unavailable"
+)
+
+// problemTypes is the problem type of an error response with a given status
+var problemTypes = map[int]string{
+	http.StatusBadRequest:            ProblemValidation,
+	http.StatusUnauthorized:          ProblemUnauthorized,
+	http.StatusForbidden:             ProblemForbidden,
+	http.StatusNotFound:              ProblemNotFound,
+	http.StatusMethodNotAllowed:      ProblemMethodNotAllowed,
+	http.StatusConflict:              ProblemConflict,
+	http.StatusRequestEntityTooLarge: ProblemPayloadTooLarge,
+	http.StatusUnprocessableEntity:   ProblemUnprocessable,
+	http.StatusTooManyRequests:       ProblemRateLimited,
+	http.StatusInternalServerError:   ProblemInternal,
+	http.StatusServiceUnavailable:    ProblemUnavailable,
+}
+
+// Problem is an RFC 7807 problem details response
+type Problem struct {
+	Type    string       `json:"type"`
+	Title   string       `json:"title"`
+	Status  int          `json:"status"`
+	Detail  string       `json:"detail,omitempty"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// FieldError describes a problem with one field of the request
+type FieldError = services.FieldError
+
+// RespondWithProblem sends an application/problem+json response
+func RespondWithProblem(w http.ResponseWriter, problem Problem) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
 }
 
-// RespondWithError sends a JSON error response
+// RespondWithError sends a problem response of the type matching code
 func RespondWithError(w http.ResponseWriter, code int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(ErrorResponse{
-		Error:   http.StatusText(code),
-		Message: message,
-		Code:    code,
+	RespondWithFieldErrors(w, code, message, nil)
+}
+
+// RespondWithFieldErrors sends a problem response listing the offending fields
+func RespondWithFieldErrors(w http.ResponseWriter, code int, message string, details []FieldError) {
+	problemType, ok := problemTypes[code]
+	if !ok {
+		problemType = "about:blank"
+	}
+
+	RespondWithProblem(w, Problem{
+		Type:    problemType,
+		Title:   http.StatusText(code),
+		Status:  code,
+		Detail:  message,
+		Details: details,
 	})
 }
 
@@ -29,3 +89,56 @@ func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(payload)
 }
+
+// respondWithInvalidField sends a validation problem about one field of the request
+func respondWithInvalidField(w http.ResponseWriter, field, message string) {
+	RespondWithFieldErrors(w, http.StatusBadRequest, message, []FieldError{{Field: field, Message: message}})
+}
+
+// respondWithInvalidBody sends a validation problem for a body that is not valid JSON
+func respondWithInvalidBody(w http.ResponseWriter) {
+	respondWithInvalidField(w, "body", "Invalid JSON format in request body")
+}
+
+// respondWithServiceError sends the problem matching a service error. Errors outside the
+// services' taxonomy are internal, and reported with message rather than their own text.
+func respondWithServiceError(w http.ResponseWriter, err error, message string) {
+	var validationErr *services.ValidationError
+	var notFoundErr *services.NotFoundError
+	var conflictErr *services.ConflictError
+	var constraintErr *services.ConstraintError
+	var fxErr *services.FXError
+	var unavailableErr *services.DependencyUnavailableError
+
+	switch {
+	case errors.As(err, &validationErr):
+		RespondWithFieldErrors(w, http.StatusBadRequest, validationErr.Message, validationErr.Fields)
+	case errors.As(err, &notFoundErr):
+		RespondWithError(w, http.StatusNotFound, notFoundErr.Error())
+	case errors.As(err, &conflictErr):
+		RespondWithError(w, http.StatusConflict, conflictErr.Error())
+	case errors.As(err, &constraintErr):
+		respondUnprocessable(w, ProblemInfeasibleConstraints, constraintErr.Error())
+	case errors.As(err, &fxErr):
+		respondUnprocessable(w, ProblemFXRateUnavailable, fxErr.Error())
+	case errors.As(err, &unavailableErr):
+		RespondWithProblem(w, Problem{
+			Type:   ProblemDependencyUnavailable,
+			Title:  http.StatusText(http.StatusServiceUnavailable),
+			Status: http.StatusServiceUnavailable,
+			Detail: unavailableErr.Dependency + " is unavailable. Please try again",
+		})
+	default:
+		RespondWithError(w, http.StatusInternalServerError, message)
+	}
+}
+
+// respondUnprocessable sends a 422 problem of a type more specific than ProblemUnprocessable
+func respondUnprocessable(w http.ResponseWriter, problemType, detail string) {
+	RespondWithProblem(w, Problem{
+		Type:   problemType,
+		Title:  http.StatusText(http.StatusUnprocessableEntity),
+		Status: http.StatusUnprocessableEntity,
+		Detail: detail,
+	})
+}
@@ -2,9 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+
+	"portfolio-rebalancer/internal/services"
 )
 
 func TestRespondWithError(t *testing.T) {
@@ -13,17 +18,18 @@ func TestRespondWithError(t *testing.T) {
 		code         int
 		message      string
 		expectedCode int
-		expectedBody ErrorResponse
+		expectedBody Problem
 	}{
 		{
 			name:         "bad request error",
 			code:         http.StatusBadRequest,
 			message:      "Invalid input",
 			expectedCode: http.StatusBadRequest,
-			expectedBody: ErrorResponse{
-				Error:   "Bad Request",
-				Message: "Invalid input",
-				Code:    400,
+			expectedBody: Problem{
+				Type:   ProblemValidation,
+				Title:  "Bad Request",
+				Status: 400,
+				Detail: "Invalid input",
 			},
 		},
 		{
@@ -31,10 +37,11 @@ func TestRespondWithError(t *testing.T) {
 			code:         http.StatusNotFound,
 			message:      "Resource not found",
 			expectedCode: http.StatusNotFound,
-			expectedBody: ErrorResponse{
-				Error:   "Not Found",
-				Message: "Resource not found",
-				Code:    404,
+			expectedBody: Problem{
+				Type:   ProblemNotFound,
+				Title:  "Not Found",
+				Status: 404,
+				Detail: "Resource not found",
 			},
 		},
 		{
@@ -42,10 +49,23 @@ func TestRespondWithError(t *testing.T) {
 			code:         http.StatusInternalServerError,
 			message:      "Something went wrong",
 			expectedCode: http.StatusInternalServerError,
-			expectedBody: ErrorResponse{
-				Error:   "Internal Server Error",
-				Message: "Something went wrong",
-				Code:    500,
+			expectedBody: Problem{
+				Type:   ProblemInternal,
+				Title:  "Internal Server Error",
+				Status: 500,
+				Detail: "Something went wrong",
+			},
+		},
+		{
+			name:         "status without a problem type",
+			code:         http.StatusTeapot,
+			message:      "Short and stout",
+			expectedCode: http.StatusTeapot,
+			expectedBody: Problem{
+				Type:   "about:blank",
+				Title:  "I'm a teapot",
+				Status: 418,
+				Detail: "Short and stout",
 			},
 		},
 	}
@@ -60,24 +80,18 @@ func TestRespondWithError(t *testing.T) {
 				t.Errorf("expected status code %d, got %d", tt.expectedCode, w.Code)
 			}
 
-			var response ErrorResponse
+			var response Problem
 			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
 				t.Fatalf("failed to decode response: %v", err)
 			}
 
-			if response.Error != tt.expectedBody.Error {
-				t.Errorf("expected error '%s', got '%s'", tt.expectedBody.Error, response.Error)
-			}
-			if response.Message != tt.expectedBody.Message {
-				t.Errorf("expected message '%s', got '%s'", tt.expectedBody.Message, response.Message)
-			}
-			if response.Code != tt.expectedBody.Code {
-				t.Errorf("expected code %d, got %d", tt.expectedBody.Code, response.Code)
+			if !reflect.DeepEqual(response, tt.expectedBody) {
+				t.Errorf("expected %+v, got %+v", tt.expectedBody, response)
 			}
 
 			contentType := w.Header().Get("Content-Type")
-			if contentType != "application/json" {
-				t.Errorf("expected Content-Type 'application/json', got '%s'", contentType)
+			if contentType != "application/problem+json" {
+				t.Errorf("expected Content-Type 'application/problem+json', got '%s'", contentType)
 			}
 		})
 	}
@@ -136,3 +150,119 @@ func TestRespondWithJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestRespondWithFieldErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+	details := []FieldError{
+		{Field: "new_allocation.stock", Message: "unknown asset"},
+		{Field: "new_allocation.stocks", Message: "missing asset held or targeted by the portfolio"},
+	}
+
+	RespondWithFieldErrors(w, http.StatusBadRequest, "new_allocation does not match the portfolio's assets", details)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	var response Problem
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Status != http.StatusBadRequest || response.Type != ProblemValidation {
+		t.Errorf("unexpected problem %+v", response)
+	}
+	if !reflect.DeepEqual(response.Details, details) {
+		t.Errorf("expected details %+v, got %+v", details, response.Details)
+	}
+}
+
+func TestRespondWithServiceError(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		expectedCode    int
+		expectedType    string
+		expectedDetail  string
+		expectedDetails []FieldError
+	}{
+		{
+			name: "validation error",
+			err: &services.ValidationError{
+				Message: "user_id is required and cannot be empty",
+				Fields:  []FieldError{{Field: "user_id", Message: "user_id is required and cannot be empty"}},
+			},
+			expectedCode:    http.StatusBadRequest,
+			expectedType:    ProblemValidation,
+			expectedDetail:  "user_id is required and cannot be empty",
+			expectedDetails: []FieldError{{Field: "user_id", Message: "user_id is required and cannot be empty"}},
+		},
+		{
+			name:           "not found",
+			err:            &services.NotFoundError{Resource: "rebalance", ID: "r1"},
+			expectedCode:   http.StatusNotFound,
+			expectedType:   ProblemNotFound,
+			expectedDetail: "rebalance not found: r1",
+		},
+		{
+			name:           "wrapped conflict",
+			err:            fmt.Errorf("decide: %w", &services.ConflictError{Err: fmt.Errorf("%w: rebalance r1 is APPROVED", services.ErrInvalidTransition)}),
+			expectedCode:   http.StatusConflict,
+			expectedType:   ProblemConflict,
+			expectedDetail: "invalid rebalance status transition: rebalance r1 is APPROVED",
+		},
+		{
+			name:           "infeasible constraints",
+			err:            &services.ConstraintError{Err: fmt.Errorf("%w: ceilings and buy restrictions allow at most 90.00%%", services.ErrInfeasibleConstraints)},
+			expectedCode:   http.StatusUnprocessableEntity,
+			expectedType:   ProblemInfeasibleConstraints,
+			expectedDetail: "constraints cannot be satisfied: ceilings and buy restrictions allow at most 90.00%",
+		},
+		{
+			name:           "missing FX rate",
+			err:            &services.FXError{From: "JPY", To: "USD", Err: errors.New("no FX rate from JPY to USD")},
+			expectedCode:   http.StatusUnprocessableEntity,
+			expectedType:   ProblemFXRateUnavailable,
+			expectedDetail: "no FX rate from JPY to USD",
+		},
+		{
+			name:           "dependency unavailable hides the cause",
+			err:            &services.DependencyUnavailableError{Dependency: services.DependencyKafka, Err: errors.New("dial tcp 10.0.0.7:9092: connection refused")},
+			expectedCode:   http.StatusServiceUnavailable,
+			expectedType:   ProblemDependencyUnavailable,
+			expectedDetail: "Kafka is unavailable. Please try again",
+		},
+		{
+			name:           "untyped error is internal",
+			err:            errors.New("nil map"),
+			expectedCode:   http.StatusInternalServerError,
+			expectedType:   ProblemInternal,
+			expectedDetail: "Failed to do it",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+
+			respondWithServiceError(w, tt.err, "Failed to do it")
+
+			if w.Code != tt.expectedCode {
+				t.Errorf("expected status code %d, got %d", tt.expectedCode, w.Code)
+			}
+
+			var response Problem
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if response.Type != tt.expectedType || response.Status != tt.expectedCode {
+				t.Errorf("expected a %s problem with status %d, got %+v", tt.expectedType, tt.expectedCode, response)
+			}
+			if response.Detail != tt.expectedDetail {
+				t.Errorf("expected detail %q, got %q", tt.expectedDetail, response.Detail)
+			}
+			if !reflect.DeepEqual(response.Details, tt.expectedDetails) {
+				t.Errorf("expected details %+v, got %+v", tt.expectedDetails, response.Details)
+			}
+		})
+	}
+}
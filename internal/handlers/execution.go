@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"portfolio-rebalancer/internal/services"
+	"portfolio-rebalancer/pkg/router"
+)
+
+type ExecutionHandler struct {
+	executionService services.ExecutionService
+}
+
+// NewExecutionHandler creates a new execution handler with injected dependencies
+func NewExecutionHandler(mux *router.Router, executionService services.ExecutionService) {
+	handler := &ExecutionHandler{
+		executionService: executionService,
+	}
+
+	// Register routes
+	mux.HandleFunc(http.MethodGet, "/v1/rebalances/{rebalance_id}/executions", handler.HandleExecutions)
+
+	// Unversioned routes, deprecated in favour of /v1
+	mux.HandleFunc(router.AnyMethod, "/executions", deprecated(handler.HandleExecutions))
+}
+
+// HandleExecutions returns what the broker filled for each transaction of a rebalance
+// GET /v1/rebalances/9f1c2e.../executions
+// GET /executions?rebalance_id=9f1c2e...
+func (h *ExecutionHandler) HandleExecutions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	rebalanceID := param(r, "rebalance_id")
+	if rebalanceID == "" {
+		respondWithInvalidField(w, "rebalance_id", "rebalance_id query parameter is required")
+		return
+	}
+
+	executions, err := h.executionService.ListExecutions(r.Context(), rebalanceID)
+	if err != nil {
+		log.Printf("Failed to list executions for rebalance %s: %v", rebalanceID, err)
+		respondWithServiceError(w, err, "Failed to retrieve executions")
+		return
+	}
+	for _, execution := range executions {
+		if !authorizeUser(w, r, execution.UserID) {
+			return
+		}
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"rebalance_id": rebalanceID,
+		"executions":   executions,
+		"count":        len(executions),
+	})
+}
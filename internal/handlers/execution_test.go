@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+)
+
+// Mock execution service
+type mockExecutionService struct {
+	listFunc func(ctx context.Context, rebalanceID string) ([]models.Execution, error)
+}
+
+func (m *mockExecutionService) ExecuteRebalance(ctx context.Context, rebalance *models.Rebalance, transactions []models.RebalanceTransaction) []models.Execution {
+	return nil
+}
+
+func (m *mockExecutionService) ListExecutions(ctx context.Context, rebalanceID string) ([]models.Execution, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx, rebalanceID)
+	}
+	return []models.Execution{}, nil
+}
+
+func TestHandleExecutions(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		service        *mockExecutionService
+		expectedStatus int
+		expectedCount  int
+	}{
+		{
+			name:   "list executions",
+			method: http.MethodGet,
+			url:    "/executions?rebalance_id=r1",
+			service: &mockExecutionService{
+				listFunc: func(ctx context.Context, rebalanceID string) ([]models.Execution, error) {
+					return []models.Execution{
+						{RebalanceID: rebalanceID, Asset: "stocks", Status: models.ExecutionFilled},
+						{RebalanceID: rebalanceID, Asset: "bonds", Status: models.ExecutionPartiallyFilled},
+					}, nil
+				},
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+		},
+		{
+			name:           "missing rebalance_id",
+			method:         http.MethodGet,
+			url:            "/executions",
+			service:        &mockExecutionService{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "list error",
+			method: http.MethodGet,
+			url:    "/executions?rebalance_id=r1",
+			service: &mockExecutionService{
+				listFunc: func(ctx context.Context, rebalanceID string) ([]models.Execution, error) {
+					return nil, errors.New("es down")
+				},
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodPost,
+			url:            "/executions?rebalance_id=r1",
+			service:        &mockExecutionService{},
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &ExecutionHandler{
+				executionService: tt.service,
+			}
+
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			w := httptest.NewRecorder()
+
+			handler.HandleExecutions(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Count int `json:"count"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if response.Count != tt.expectedCount {
+				t.Errorf("expected %d executions, got %d", tt.expectedCount, response.Count)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/services"
+	"portfolio-rebalancer/pkg/router"
+)
+
+type ModelPortfolioHandler struct {
+	modelService services.ModelPortfolioService
+}
+
+// NewModelPortfolioHandler creates a new model portfolio handler with injected dependencies
+func NewModelPortfolioHandler(mux *router.Router, modelService services.ModelPortfolioService) {
+	handler := &ModelPortfolioHandler{
+		modelService: modelService,
+	}
+
+	// Register routes
+	mux.HandleFunc(http.MethodGet, "/v1/models", handler.handleGetModels)
+	mux.HandleFunc(http.MethodPost, "/v1/models", handler.handleCreateModel)
+	mux.HandleFunc(http.MethodGet, "/v1/models/{id}", handler.handleGetModels)
+	mux.HandleFunc(http.MethodPut, "/v1/models/{id}", handler.handleUpdateModel)
+	mux.HandleFunc(http.MethodDelete, "/v1/models/{id}", handler.handleDeleteModel)
+	mux.HandleFunc(http.MethodPost, "/v1/models/{id}/preview", handler.HandlePreviewModel)
+
+	// Unversioned routes, deprecated in favour of /v1
+	mux.HandleFunc(router.AnyMethod, "/models", deprecated(handler.HandleModels))
+	mux.HandleFunc(router.AnyMethod, "/models/preview", deprecated(handler.HandlePreviewModel))
+}
+
+// HandleModels routes model portfolio CRUD requests by HTTP method
+func (h *ModelPortfolioHandler) HandleModels(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.handleGetModels(w, r)
+	case http.MethodPost:
+		h.handleCreateModel(w, r)
+	case http.MethodPut:
+		h.handleUpdateModel(w, r)
+	case http.MethodDelete:
+		h.handleDeleteModel(w, r)
+	default:
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only GET, POST, PUT and DELETE methods are allowed")
+	}
+}
+
+// handleGetModels retrieves one model portfolio by id, or all of them
+// GET /v1/models
+// GET /v1/models/balanced-60-40
+// GET /models?id=balanced-60-40
+func (h *ModelPortfolioHandler) handleGetModels(w http.ResponseWriter, r *http.Request) {
+	id := param(r, "id")
+	if id == "" {
+		modelPortfolios, err := h.modelService.ListModels(r.Context())
+		if err != nil {
+			log.Printf("Failed to list model portfolios: %v", err)
+			respondWithServiceError(w, err, "Failed to list model portfolios")
+			return
+		}
+
+		RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"models": modelPortfolios,
+			"count":  len(modelPortfolios),
+		})
+		return
+	}
+
+	model, err := h.modelService.GetModel(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to get model portfolio %s: %v", id, err)
+		respondWithServiceError(w, err, "Failed to retrieve model portfolio")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, model)
+}
+
+// handleCreateModel creates a model portfolio
+// Sample Request (POST /v1/models):
+//
+//	{
+//	    "id": "balanced-60-40",
+//	    "name": "Balanced 60/40",
+//	    "allocation": {"stocks": 60, "bonds": 40}
+//	}
+func (h *ModelPortfolioHandler) handleCreateModel(w http.ResponseWriter, r *http.Request) {
+	var model models.ModelPortfolio
+	if err := json.NewDecoder(r.Body).Decode(&model); err != nil {
+		respondWithInvalidBody(w)
+		return
+	}
+
+	created, err := h.modelService.CreateModel(r.Context(), model)
+	if err != nil {
+		log.Printf("Failed to create model portfolio %s: %v", model.ID, err)
+		respondWithServiceError(w, err, "Failed to create model portfolio. Please try again")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusCreated, created)
+}
+
+// handleUpdateModel changes a model portfolio and rebalances every subscribed user.
+// Use POST /v1/models/{id}/preview first to see the impact.
+// Sample Request (PUT /v1/models/balanced-60-40):
+//
+//	{
+//	    "name": "Balanced 60/40",
+//	    "allocation": {"stocks": 55, "bonds": 45}
+//	}
+//
+// PUT /models takes the same body with the id in it.
+func (h *ModelPortfolioHandler) handleUpdateModel(w http.ResponseWriter, r *http.Request) {
+	var model models.ModelPortfolio
+	if err := json.NewDecoder(r.Body).Decode(&model); err != nil {
+		respondWithInvalidBody(w)
+		return
+	}
+
+	bindPathParam(r, "id", &model.ID)
+
+	impacts, err := h.modelService.UpdateModel(r.Context(), model)
+	if err != nil {
+		log.Printf("Failed to update model portfolio %s: %v", model.ID, err)
+		respondWithServiceError(w, err, "Failed to update model portfolio. Please try again")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"id":               model.ID,
+		"impacts":          impacts,
+		"subscriber_count": len(impacts),
+		"message":          "Model portfolio updated and subscriber rebalances queued",
+	})
+}
+
+// handleDeleteModel removes a model portfolio without subscribers
+// DELETE /v1/models/balanced-60-40
+// DELETE /models?id=balanced-60-40
+func (h *ModelPortfolioHandler) handleDeleteModel(w http.ResponseWriter, r *http.Request) {
+	id := param(r, "id")
+	if id == "" {
+		respondWithInvalidField(w, "id", "id query parameter is required")
+		return
+	}
+
+	if err := h.modelService.DeleteModel(r.Context(), id); err != nil {
+		log.Printf("Failed to delete model portfolio %s: %v", id, err)
+		respondWithServiceError(w, err, "Failed to delete model portfolio. Please try again")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlePreviewModel shows the rebalance each subscriber would need for a proposed model change
+// Sample Request (POST /v1/models/balanced-60-40/preview):
+//
+//	{
+//	    "allocation": {"stocks": 55, "bonds": 45}
+//	}
+//
+// POST /models/preview takes the same body with the id in it.
+func (h *ModelPortfolioHandler) HandlePreviewModel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+
+	var req struct {
+		ID         string             `json:"id"`
+		Allocation map[string]float64 `json:"allocation"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithInvalidBody(w)
+		return
+	}
+
+	bindPathParam(r, "id", &req.ID)
+
+	impacts, err := h.modelService.PreviewModelUpdate(r.Context(), req.ID, req.Allocation)
+	if err != nil {
+		log.Printf("Failed to preview model portfolio %s: %v", req.ID, err)
+		respondWithServiceError(w, err, "Failed to preview model portfolio")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"id":               req.ID,
+		"impacts":          impacts,
+		"subscriber_count": len(impacts),
+	})
+}
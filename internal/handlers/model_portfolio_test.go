@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/services"
+)
+
+// Mock model portfolio service
+type mockModelPortfolioService struct {
+	createFunc  func(ctx context.Context, model models.ModelPortfolio) (*models.ModelPortfolio, error)
+	getFunc     func(ctx context.Context, id string) (*models.ModelPortfolio, error)
+	listFunc    func(ctx context.Context) ([]models.ModelPortfolio, error)
+	updateFunc  func(ctx context.Context, model models.ModelPortfolio) ([]models.ModelImpact, error)
+	deleteFunc  func(ctx context.Context, id string) error
+	previewFunc func(ctx context.Context, id string, allocation map[string]float64) ([]models.ModelImpact, error)
+}
+
+func (m *mockModelPortfolioService) CreateModel(ctx context.Context, model models.ModelPortfolio) (*models.ModelPortfolio, error) {
+	if m.createFunc != nil {
+		return m.createFunc(ctx, model)
+	}
+	return &model, nil
+}
+
+func (m *mockModelPortfolioService) GetModel(ctx context.Context, id string) (*models.ModelPortfolio, error) {
+	if m.getFunc != nil {
+		return m.getFunc(ctx, id)
+	}
+	return nil, &services.NotFoundError{Resource: "model portfolio", ID: id}
+}
+
+func (m *mockModelPortfolioService) ListModels(ctx context.Context) ([]models.ModelPortfolio, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx)
+	}
+	return []models.ModelPortfolio{}, nil
+}
+
+func (m *mockModelPortfolioService) UpdateModel(ctx context.Context, model models.ModelPortfolio) ([]models.ModelImpact, error) {
+	if m.updateFunc != nil {
+		return m.updateFunc(ctx, model)
+	}
+	return []models.ModelImpact{}, nil
+}
+
+func (m *mockModelPortfolioService) DeleteModel(ctx context.Context, id string) error {
+	if m.deleteFunc != nil {
+		return m.deleteFunc(ctx, id)
+	}
+	return nil
+}
+
+func (m *mockModelPortfolioService) PreviewModelUpdate(ctx context.Context, id string, allocation map[string]float64) ([]models.ModelImpact, error) {
+	if m.previewFunc != nil {
+		return m.previewFunc(ctx, id, allocation)
+	}
+	return []models.ModelImpact{}, nil
+}
+
+func TestHandleModels(t *testing.T) {
+	validModel := map[string]interface{}{
+		"id":         "balanced",
+		"name":       "Balanced 60/40",
+		"allocation": map[string]float64{"stocks": 60.0, "bonds": 40.0},
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		requestBody    interface{}
+		service        *mockModelPortfolioService
+		expectedStatus int
+	}{
+		{
+			name:           "list models",
+			method:         http.MethodGet,
+			url:            "/models",
+			service:        &mockModelPortfolioService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "get model",
+			method: http.MethodGet,
+			url:    "/models?id=balanced",
+			service: &mockModelPortfolioService{
+				getFunc: func(ctx context.Context, id string) (*models.ModelPortfolio, error) {
+					return &models.ModelPortfolio{ID: id}, nil
+				},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "get unknown model",
+			method:         http.MethodGet,
+			url:            "/models?id=missing",
+			service:        &mockModelPortfolioService{},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "create model",
+			method:         http.MethodPost,
+			url:            "/models",
+			requestBody:    validModel,
+			service:        &mockModelPortfolioService{},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "create with invalid JSON",
+			method:         http.MethodPost,
+			url:            "/models",
+			requestBody:    "invalid json",
+			service:        &mockModelPortfolioService{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "create validation error",
+			method:      http.MethodPost,
+			url:         "/models",
+			requestBody: validModel,
+			service: &mockModelPortfolioService{
+				createFunc: func(ctx context.Context, model models.ModelPortfolio) (*models.ModelPortfolio, error) {
+					return nil, &services.ValidationError{Message: "name is required and cannot be empty"}
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "update model",
+			method:      http.MethodPut,
+			url:         "/models",
+			requestBody: validModel,
+			service: &mockModelPortfolioService{
+				updateFunc: func(ctx context.Context, model models.ModelPortfolio) ([]models.ModelImpact, error) {
+					return []models.ModelImpact{{UserID: "user1"}}, nil
+				},
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "delete model",
+			method:         http.MethodDelete,
+			url:            "/models?id=balanced",
+			service:        &mockModelPortfolioService{},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "delete without id",
+			method:         http.MethodDelete,
+			url:            "/models",
+			service:        &mockModelPortfolioService{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "delete model in use",
+			method: http.MethodDelete,
+			url:    "/models?id=balanced",
+			service: &mockModelPortfolioService{
+				deleteFunc: func(ctx context.Context, id string) error {
+					return &services.ConflictError{Err: fmt.Errorf("%w: 3 users", services.ErrModelInUse)}
+				},
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodPatch,
+			url:            "/models",
+			service:        &mockModelPortfolioService{},
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &ModelPortfolioHandler{
+				modelService: tt.service,
+			}
+
+			var body []byte
+			if str, ok := tt.requestBody.(string); ok {
+				body = []byte(str)
+			} else if tt.requestBody != nil {
+				body, _ = json.Marshal(tt.requestBody)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.url, bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			handler.HandleModels(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandlePreviewModel(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		requestBody    interface{}
+		mockPreview    func(ctx context.Context, id string, allocation map[string]float64) ([]models.ModelImpact, error)
+		expectedStatus int
+	}{
+		{
+			name:   "successful preview",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"id":         "balanced",
+				"allocation": map[string]float64{"stocks": 55.0, "bonds": 45.0},
+			},
+			mockPreview: func(ctx context.Context, id string, allocation map[string]float64) ([]models.ModelImpact, error) {
+				return []models.ModelImpact{{UserID: "user1"}}, nil
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "invalid JSON",
+			method:         http.MethodPost,
+			requestBody:    "invalid json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid allocation",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"id":         "balanced",
+				"allocation": map[string]float64{"stocks": 55.0},
+			},
+			mockPreview: func(ctx context.Context, id string, allocation map[string]float64) ([]models.ModelImpact, error) {
+				return nil, &services.ValidationError{Message: "allocation must sum to 100%, got 55.00%"}
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &ModelPortfolioHandler{
+				modelService: &mockModelPortfolioService{
+					previewFunc: tt.mockPreview,
+				},
+			}
+
+			var body []byte
+			if str, ok := tt.requestBody.(string); ok {
+				body = []byte(str)
+			} else if tt.requestBody != nil {
+				body, _ = json.Marshal(tt.requestBody)
+			}
+
+			req := httptest.NewRequest(tt.method, "/models/preview", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			handler.HandlePreviewModel(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"portfolio-rebalancer/pkg/router"
+)
+
+type OpenAPIHandler struct {
+	spec []byte
+}
+
+// NewOpenAPIHandler creates a handler serving the API's OpenAPI document
+func NewOpenAPIHandler(mux *router.Router, spec []byte) {
+	handler := &OpenAPIHandler{
+		spec: spec,
+	}
+
+	// Register routes
+	mux.HandleFunc(http.MethodGet, "/openapi.json", handler.HandleOpenAPI)
+}
+
+// HandleOpenAPI returns the OpenAPI 3 document describing every route
+// GET /openapi.json
+func (h *OpenAPIHandler) HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(h.spec)
+}
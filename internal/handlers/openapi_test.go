@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"portfolio-rebalancer/api"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/services"
+	"portfolio-rebalancer/pkg/router"
+)
+
+// specMux registers every handler with mock services returning realistic data, as main does
+func specMux() *router.Router {
+	portfolio := func(userID string) *models.Portfolio {
+		return &models.Portfolio{
+			UserID:             userID,
+			Allocation:         map[string]float64{"stocks": 70, "bonds": 30},
+			OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40},
+			Currency:           models.DefaultCurrency,
+			TotalValue:         10000,
+		}
+	}
+	transactions := []models.RebalanceTransaction{
+		{RebalanceID: "rb1", UserID: "1", Action: "SELL", Asset: "stocks", RebalancePercent: 10, Timestamp: "2024-05-01T00:00:00Z"},
+		{RebalanceID: "rb1", UserID: "1", Action: "BUY", Asset: "bonds", RebalancePercent: 10, Timestamp: "2024-05-01T00:00:00Z"},
+	}
+	rebalance := &models.Rebalance{
+		ID:           "rb1",
+		UserID:       "1",
+		Status:       models.RebalanceStatusProposed,
+		Transactions: transactions,
+		Events:       []models.RebalanceEvent{{Status: models.RebalanceStatusProposed, Actor: models.ActorProvider, Timestamp: "2024-05-01T00:00:00Z"}},
+		CreatedAt:    "2024-05-01T00:00:00Z",
+		UpdatedAt:    "2024-05-01T00:00:00Z",
+	}
+	asset := models.Asset{ID: "stocks", Name: "Global Equity Index", Class: "equity", Tradable: true, UpdatedAt: "2024-05-01T00:00:00Z"}
+	model := models.ModelPortfolio{ID: "balanced", Name: "Balanced 60/40", Allocation: map[string]float64{"stocks": 60, "bonds": 40}, UpdatedAt: "2024-05-01T00:00:00Z"}
+	impacts := []models.ModelImpact{{UserID: "1", Transactions: transactions}}
+
+	portfolioService := &mockPortfolioService{
+		getFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+			if userID == "missing" {
+				return nil, &services.NotFoundError{Resource: "portfolio", ID: userID}
+			}
+			return portfolio(userID), nil
+		},
+		historyFunc: func(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error) {
+			return []models.AllocationHistory{{UserID: userID, Allocation: map[string]float64{"stocks": 60, "bonds": 40}, OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40}, Source: models.AllocationSourceUser, Timestamp: "2024-05-01T00:00:00Z"}}, nil
+		},
+	}
+	rebalanceService := &mockRebalanceService{
+		calculateFunc: func(currentAllocation, targetAllocation map[string]float64, userID string) []models.RebalanceTransaction {
+			return transactions
+		},
+		cashFlowFunc: func(currentAllocation, targetAllocation map[string]float64, flow models.CashFlow) (*models.CashFlowResult, error) {
+			return &models.CashFlowResult{Transactions: transactions, NewAllocation: targetAllocation, NewValue: flow.PortfolioValue + flow.Amount}, nil
+		},
+		pairFunc: func(transactions []models.RebalanceTransaction) []models.RebalanceSwitch {
+			return []models.RebalanceSwitch{{RebalanceID: "rb1", UserID: "1", From: "stocks", To: "bonds", RebalancePercent: 10, Timestamp: "2024-05-01T00:00:00Z"}}
+		},
+		approveFunc: func(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, error) {
+			if decision.RebalanceID == "decided" {
+				return nil, &services.ConflictError{Err: services.ErrInvalidTransition}
+			}
+			approved := *rebalance
+			approved.Status = models.RebalanceStatusApproved
+			return &approved, nil
+		},
+		getFunc: func(ctx context.Context, id string) (*models.Rebalance, error) {
+			if id != rebalance.ID {
+				return nil, services.ErrRebalanceNotFound
+			}
+			return rebalance, nil
+		},
+		listFunc: func(ctx context.Context, userID, status string) ([]models.Rebalance, error) {
+			return []models.Rebalance{*rebalance}, nil
+		},
+		txListFunc: func(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error) {
+			return transactions, nil
+		},
+	}
+
+	mux := NewRouter()
+	NewPortfolioHandler(mux, portfolioService)
+	NewRebalanceHandler(mux, rebalanceService, portfolioService, services.NewRebalancePlanner(portfolioService, rebalanceService, &mockAssetValidator{}))
+	NewModelPortfolioHandler(mux, &mockModelPortfolioService{
+		getFunc: func(ctx context.Context, id string) (*models.ModelPortfolio, error) {
+			if id != model.ID {
+				return nil, &services.NotFoundError{Resource: "model portfolio", ID: id}
+			}
+			return &model, nil
+		},
+		listFunc: func(ctx context.Context) ([]models.ModelPortfolio, error) {
+			return []models.ModelPortfolio{model}, nil
+		},
+		createFunc: func(ctx context.Context, m models.ModelPortfolio) (*models.ModelPortfolio, error) {
+			m.UpdatedAt = model.UpdatedAt
+			return &m, nil
+		},
+		updateFunc: func(ctx context.Context, m models.ModelPortfolio) ([]models.ModelImpact, error) {
+			return impacts, nil
+		},
+		previewFunc: func(ctx context.Context, id string, allocation map[string]float64) ([]models.ModelImpact, error) {
+			return impacts, nil
+		},
+		deleteFunc: func(ctx context.Context, id string) error {
+			if id == "in-use" {
+				return &services.ConflictError{Err: services.ErrModelInUse}
+			}
+			return nil
+		},
+	})
+	NewAssetHandler(mux, &mockAssetService{
+		getFunc: func(ctx context.Context, id string) (*models.Asset, error) {
+			return &asset, nil
+		},
+		listFunc: func(ctx context.Context) ([]models.Asset, error) {
+			return []models.Asset{asset}, nil
+		},
+		createFunc: func(ctx context.Context, a models.Asset) (*models.Asset, error) {
+			a.UpdatedAt = asset.UpdatedAt
+			return &a, nil
+		},
+		updateFunc: func(ctx context.Context, a models.Asset) (*models.Asset, error) {
+			a.UpdatedAt = asset.UpdatedAt
+			return &a, nil
+		},
+	})
+	NewExecutionHandler(mux, &mockExecutionService{
+		listFunc: func(ctx context.Context, rebalanceID string) ([]models.Execution, error) {
+			return []models.Execution{{ID: "e1", RebalanceID: rebalanceID, UserID: "1", Action: "SELL", Asset: "stocks", Status: models.ExecutionFilled, RequestedPercent: 10, FilledPercent: 10, Broker: "simulated", ExecutedAt: "2024-05-01T00:00:00Z"}}, nil
+		},
+	})
+	NewReconciliationHandler(mux, &mockReconciliationService{
+		reconcileFunc: func(ctx context.Context, userID string) (*models.ReconciliationReport, error) {
+			return &models.ReconciliationReport{ID: "r1", UserID: userID, Status: models.ReconciliationStatusMatched, Target: map[string]float64{"stocks": 60}, Reported: map[string]float64{"stocks": 60}, Actual: map[string]float64{"stocks": 60}, Tolerance: 1, CreatedAt: "2024-05-01T00:00:00Z"}, nil
+		},
+		summaryFunc: func(ctx context.Context, since time.Time) (*models.ReconciliationSummary, error) {
+			return &models.ReconciliationSummary{Since: since.Format(time.RFC3339), BreakUsers: []string{}}, nil
+		},
+	})
+	NewAPIKeyHandler(mux, &mockAPIKeyService{
+		createFunc: func(ctx context.Context, name string, scopes []string) (string, *models.APIKey, error) {
+			return "prk_secret", &models.APIKey{ID: "k1", Name: name, Prefix: "prk_secr", Scopes: scopes, CreatedAt: "2024-05-01T00:00:00Z"}, nil
+		},
+		revokeFunc: func(ctx context.Context, id string) (*models.APIKey, error) {
+			if id == "missing" {
+				return nil, services.ErrAPIKeyNotFound
+			}
+			return &models.APIKey{ID: id, Name: "provider", Prefix: "prk_secr", Scopes: []string{"rebalance:submit"}, CreatedAt: "2024-05-01T00:00:00Z", RevokedAt: "2024-05-02T00:00:00Z"}, nil
+		},
+	})
+	NewAuditHandler(mux, &mockAuditService{
+		listFunc: func(ctx context.Context, userID string, from, to time.Time) ([]models.AuditEntry, error) {
+			return []models.AuditEntry{{ID: "a1", UserID: userID, Action: models.AuditPortfolioCreate, Actor: "admin", After: portfolio(userID), Timestamp: "2024-05-01T00:00:00Z"}}, nil
+		},
+	})
+	NewOpenAPIHandler(mux, api.Spec)
+	return mux
+}
+
+// TestHandlersMatchSpec sends a request for every documented operation, and the errors each
+// one is most likely to return, and checks each response is documented with that body. A
+// handler that adds a field, changes a status or a type fails here until the spec follows.
+// Deprecated operations must answer with a Deprecation header and the others without.
+func TestHandlersMatchSpec(t *testing.T) {
+	doc, err := api.Load()
+	if err != nil {
+		t.Fatalf("failed to load spec: %v", err)
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/v1/portfolios", `{"user_id":"1","allocation":{"stocks":60,"bonds":40},"schedule":"monthly"}`, http.StatusCreated},
+		{http.MethodGet, "/v1/portfolios/1", "", http.StatusOK},
+		{http.MethodGet, "/v1/portfolios/missing", "", http.StatusNotFound},
+		{http.MethodGet, "/v1/portfolios/1/history?from=2024-05-01T00:00:00Z", "", http.StatusOK},
+		{http.MethodPut, "/v1/portfolios/1/schedule", `{"schedule":"quarterly"}`, http.StatusOK},
+		{http.MethodPut, "/v1/portfolios/missing/schedule", `{"schedule":"quarterly"}`, http.StatusNotFound},
+		{http.MethodPost, "/v1/portfolios/1/cashflows", `{"type":"DEPOSIT","amount":1000}`, http.StatusOK},
+		{http.MethodPost, "/v1/portfolios/1/rebalances", `{"new_allocation":{"stocks":70,"bonds":30}}`, http.StatusOK},
+		{http.MethodPost, "/v1/portfolios/missing/rebalances", `{"new_allocation":{"stocks":70,"bonds":30}}`, http.StatusNotFound},
+		{http.MethodGet, "/v1/portfolios/1/rebalances?status=PROPOSED", "", http.StatusOK},
+		{http.MethodGet, "/v1/portfolios/1/transactions?from=2024-05-01T00:00:00Z", "", http.StatusOK},
+		{http.MethodGet, "/v1/portfolios/1/transactions?from=2024-06-01T00:00:00Z&to=2024-05-01T00:00:00Z", "", http.StatusBadRequest},
+		{http.MethodGet, "/v1/portfolios/1/reconciliations", "", http.StatusOK},
+		{http.MethodPost, "/v1/portfolios/1/reconciliations", "", http.StatusOK},
+		{http.MethodGet, "/v1/portfolios/1/audit", "", http.StatusOK},
+		{http.MethodGet, "/v1/rebalances/rb1", "", http.StatusOK},
+		{http.MethodGet, "/v1/rebalances/unknown", "", http.StatusNotFound},
+		{http.MethodPost, "/v1/rebalances/rb1/approve", `{"actor":"jane"}`, http.StatusOK},
+		{http.MethodPost, "/v1/rebalances/decided/approve", `{"actor":"jane"}`, http.StatusConflict},
+		{http.MethodPost, "/v1/rebalances/rb1/reject", `{"actor":"jane","reason":"outside mandate"}`, http.StatusOK},
+		{http.MethodGet, "/v1/rebalances/rb1/executions", "", http.StatusOK},
+		{http.MethodGet, "/v1/reconciliations/summary?since=2024-05-01T00:00:00Z", "", http.StatusOK},
+		{http.MethodGet, "/v1/models", "", http.StatusOK},
+		{http.MethodPost, "/v1/models", `{"name":"Balanced 60/40","allocation":{"stocks":60,"bonds":40}}`, http.StatusCreated},
+		{http.MethodGet, "/v1/models/balanced", "", http.StatusOK},
+		{http.MethodGet, "/v1/models/unknown", "", http.StatusNotFound},
+		{http.MethodPut, "/v1/models/balanced", `{"name":"Balanced 55/45","allocation":{"stocks":55,"bonds":45}}`, http.StatusOK},
+		{http.MethodDelete, "/v1/models/balanced", "", http.StatusNoContent},
+		{http.MethodDelete, "/v1/models/in-use", "", http.StatusConflict},
+		{http.MethodPost, "/v1/models/balanced/preview", `{"allocation":{"stocks":55,"bonds":45}}`, http.StatusOK},
+		{http.MethodGet, "/v1/assets", "", http.StatusOK},
+		{http.MethodPost, "/v1/assets", `{"id":"gold","name":"Gold","class":"commodity","tradable":true,"aliases":["xau"]}`, http.StatusCreated},
+		{http.MethodGet, "/v1/assets/stocks", "", http.StatusOK},
+		{http.MethodPut, "/v1/assets/gold", `{"name":"Gold","class":"commodity","tradable":false}`, http.StatusOK},
+		{http.MethodGet, "/v1/api-keys", "", http.StatusOK},
+		{http.MethodPost, "/v1/api-keys", `{"name":"provider","scopes":["rebalance:submit"]}`, http.StatusCreated},
+		{http.MethodDelete, "/v1/api-keys/k1", "", http.StatusOK},
+		{http.MethodDelete, "/v1/api-keys/missing", "", http.StatusNotFound},
+		{http.MethodGet, "/portfolio?user_id=1", "", http.StatusOK},
+		{http.MethodGet, "/portfolio?user_id=missing", "", http.StatusNotFound},
+		{http.MethodPost, "/portfolio", `{"user_id":"1","allocation":{"stocks":60,"bonds":40},"schedule":"monthly"}`, http.StatusCreated},
+		{http.MethodGet, "/portfolio/history?user_id=1&from=2024-05-01T00:00:00Z", "", http.StatusOK},
+		{http.MethodGet, "/portfolio/history?user_id=1&from=2024-06-01T00:00:00Z&to=2024-05-01T00:00:00Z", "", http.StatusBadRequest},
+		{http.MethodPut, "/portfolio/schedule", `{"user_id":"1","schedule":"quarterly"}`, http.StatusOK},
+		{http.MethodPut, "/portfolio/schedule", `{"user_id":"missing","schedule":"quarterly"}`, http.StatusNotFound},
+		{http.MethodPost, "/portfolio/cashflow", `{"user_id":"1","type":"DEPOSIT","amount":1000}`, http.StatusOK},
+		{http.MethodPost, "/portfolio/cashflow", `{"user_id":"1","type":"DEPOSIT","amount":0}`, http.StatusBadRequest},
+		{http.MethodPost, "/rebalance", `{"user_id":"1","new_allocation":{"stocks":70,"bonds":30}}`, http.StatusOK},
+		{http.MethodPost, "/rebalance?mode=switches", `{"user_id":"1","new_allocation":{"stocks":70,"bonds":30}}`, http.StatusOK},
+		{http.MethodPost, "/rebalance", `{"user_id":"missing","new_allocation":{"stocks":70,"bonds":30}}`, http.StatusNotFound},
+		{http.MethodPost, "/rebalance/approve", `{"rebalance_id":"rb1","actor":"jane"}`, http.StatusOK},
+		{http.MethodPost, "/rebalance/approve", `{"rebalance_id":"decided","actor":"jane"}`, http.StatusConflict},
+		{http.MethodPost, "/rebalance/reject", `{"rebalance_id":"rb1","actor":"jane","reason":"outside mandate"}`, http.StatusOK},
+		{http.MethodGet, "/rebalances?id=rb1", "", http.StatusOK},
+		{http.MethodGet, "/rebalances?id=unknown", "", http.StatusNotFound},
+		{http.MethodGet, "/rebalances?user_id=1&status=PROPOSED", "", http.StatusOK},
+		{http.MethodGet, "/executions?rebalance_id=rb1", "", http.StatusOK},
+		{http.MethodGet, "/reconciliation?user_id=1", "", http.StatusOK},
+		{http.MethodPost, "/reconciliation?user_id=1", "", http.StatusOK},
+		{http.MethodGet, "/reconciliation/summary?since=2024-05-01T00:00:00Z", "", http.StatusOK},
+		{http.MethodGet, "/models", "", http.StatusOK},
+		{http.MethodGet, "/models?id=balanced", "", http.StatusOK},
+		{http.MethodGet, "/models?id=unknown", "", http.StatusNotFound},
+		{http.MethodPost, "/models", `{"name":"Balanced 60/40","allocation":{"stocks":60,"bonds":40}}`, http.StatusCreated},
+		{http.MethodPut, "/models", `{"id":"balanced","name":"Balanced 55/45","allocation":{"stocks":55,"bonds":45}}`, http.StatusOK},
+		{http.MethodDelete, "/models?id=balanced", "", http.StatusNoContent},
+		{http.MethodDelete, "/models?id=in-use", "", http.StatusConflict},
+		{http.MethodPost, "/models/preview", `{"id":"balanced","allocation":{"stocks":55,"bonds":45}}`, http.StatusOK},
+		{http.MethodGet, "/assets", "", http.StatusOK},
+		{http.MethodGet, "/assets?id=stocks", "", http.StatusOK},
+		{http.MethodPost, "/assets", `{"id":"gold","name":"Gold","class":"commodity","tradable":true,"aliases":["xau"]}`, http.StatusCreated},
+		{http.MethodPut, "/assets", `{"id":"gold","name":"Gold","class":"commodity","tradable":false}`, http.StatusOK},
+		{http.MethodGet, "/api-keys", "", http.StatusOK},
+		{http.MethodPost, "/api-keys", `{"name":"provider","scopes":["rebalance:submit"]}`, http.StatusCreated},
+		{http.MethodDelete, "/api-keys?id=k1", "", http.StatusOK},
+		{http.MethodDelete, "/api-keys?id=missing", "", http.StatusNotFound},
+		{http.MethodGet, "/audit?user_id=1", "", http.StatusOK},
+		{http.MethodGet, "/openapi.json", "", http.StatusOK},
+	}
+
+	mux := specMux()
+	var pattern string
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pattern = router.Pattern(r)
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	exercised := make(map[[2]string]bool)
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.url, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			op, ok := doc.Operation(req.Method, pattern)
+			if !ok {
+				t.Fatalf("%s %s is not documented", req.Method, pattern)
+			}
+			exercised[[2]string{req.Method, pattern}] = true
+
+			if errs := doc.ValidateRequest(op, req.URL.Query(), []byte(tt.body)); len(errs) > 0 {
+				t.Fatalf("request does not match the spec: %+v", errs)
+			}
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if deprecation := w.Header().Get("Deprecation"); (deprecation != "") != op.Deprecated {
+				t.Errorf("expected deprecated %v, got Deprecation header %q", op.Deprecated, deprecation)
+			}
+			if errs := doc.ValidateResponse(op, w.Code, w.Header().Get("Content-Type"), w.Body.Bytes()); len(errs) > 0 {
+				t.Errorf("response does not match the spec: %+v\n%s", errs, w.Body.String())
+			}
+		})
+	}
+
+	for _, operation := range doc.Operations() {
+		if !exercised[operation] {
+			t.Errorf("%s %s is documented but not exercised", operation[0], operation[1])
+		}
+	}
+}
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"portfolio-rebalancer/internal/models"
 	"portfolio-rebalancer/internal/services"
+	"portfolio-rebalancer/pkg/router"
+	"time"
 )
 
 type PortfolioHandler struct {
@@ -13,13 +16,21 @@ type PortfolioHandler struct {
 }
 
 // NewPortfolioHandler creates a new portfolio handler with injected dependencies
-func NewPortfolioHandler(mux *http.ServeMux, portfolioService services.PortfolioService) {
+func NewPortfolioHandler(mux *router.Router, portfolioService services.PortfolioService) {
 	handler := &PortfolioHandler{
 		portfolioService: portfolioService,
 	}
 
 	// Register routes
-	mux.HandleFunc("/portfolio", handler.HandlePortfolio)
+	mux.HandleFunc(http.MethodPost, "/v1/portfolios", handler.handleCreatePortfolio)
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/{user_id}", handler.handleGetPortfolio)
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/{user_id}/history", handler.HandlePortfolioHistory)
+	mux.HandleFunc(http.MethodPut, "/v1/portfolios/{user_id}/schedule", handler.HandlePortfolioSchedule)
+
+	// Unversioned routes, deprecated in favour of /v1
+	mux.HandleFunc(router.AnyMethod, "/portfolio", deprecated(handler.HandlePortfolio))
+	mux.HandleFunc(router.AnyMethod, "/portfolio/history", deprecated(handler.HandlePortfolioHistory))
+	mux.HandleFunc(router.AnyMethod, "/portfolio/schedule", deprecated(handler.HandlePortfolioSchedule))
 }
 
 // Uses HTTP method to determine action - proper REST design
@@ -35,51 +46,175 @@ func (h *PortfolioHandler) HandlePortfolio(w http.ResponseWriter, r *http.Reques
 }
 
 // HandlePortfolio handles new portfolio creation requests (feel free to update the request parameter/model)
-// Sample Request (POST /portfolio):
+// Sample Request (POST /v1/portfolios or POST /portfolio):
 //
 //	{
 //	    "user_id": "1",
 //	    "allocation": {"stocks": 60, "bonds": 30, "gold": 10}
 //	}
 func (h *PortfolioHandler) handleCreatePortfolio(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	var p models.Portfolio
-	err := json.NewDecoder(r.Body).Decode(&p)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		respondWithInvalidBody(w)
 		return
 	}
 
 	createdPortfolio, err := h.portfolioService.CreatePortfolio(r.Context(), p)
 	if err != nil {
 		log.Printf("Failed to create portfolio for user %s: %v", p.UserID, err)
-		RespondWithError(w, http.StatusBadRequest, err.Error())
+		respondWithServiceError(w, err, "Failed to create portfolio. Please try again")
 		return
 	}
 
 	RespondWithJSON(w, http.StatusCreated, createdPortfolio)
 }
 
-// handleGetPortfolio retrieves a user's portfolio
+// handleGetPortfolio retrieves a user's portfolio, optionally as it was at a point in time
+// GET /v1/portfolios/1
+// GET /v1/portfolios/1?as_of=2025-01-01T00:00:00Z
 // GET /portfolio?user_id=1
+//
+// End users' tokens may leave out user_id and only read their own portfolio unless they have the admin role.
 func (h *PortfolioHandler) handleGetPortfolio(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
+	userID := userIDParam(r)
 	if userID == "" {
-		RespondWithError(w, http.StatusBadRequest, "user_id query parameter is required")
+		respondWithInvalidField(w, "user_id", "user_id query parameter is required")
+		return
+	}
+	if !authorizeUser(w, r, userID) {
 		return
 	}
 
-	portfolio, err := h.portfolioService.GetPortfolio(r.Context(), userID)
+	asOf, err := parseTimeParam(r, "as_of")
+	if err != nil {
+		respondWithInvalidField(w, "as_of", err.Error())
+		return
+	}
+
+	var portfolio *models.Portfolio
+	if asOf.IsZero() {
+		portfolio, err = h.portfolioService.GetPortfolio(r.Context(), userID)
+	} else {
+		portfolio, err = h.portfolioService.GetPortfolioAsOf(r.Context(), userID, asOf)
+	}
 	if err != nil {
 		log.Printf("Failed to get portfolio for user %s: %v", userID, err)
-		RespondWithError(w, http.StatusNotFound, "Portfolio not found for user "+userID)
+		respondWithServiceError(w, err, "Failed to retrieve portfolio")
 		return
 	}
 
 	log.Printf("Portfolio retrieved for user %s", userID)
 	RespondWithJSON(w, http.StatusOK, portfolio)
 }
+
+// HandlePortfolioHistory lists a user's allocation changes
+// GET /v1/portfolios/1/history?from=2025-01-01T00:00:00Z&to=2025-02-01T00:00:00Z
+// GET /portfolio/history?user_id=1&from=2025-01-01T00:00:00Z
+func (h *PortfolioHandler) HandlePortfolioHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	userID := userIDParam(r)
+	if userID == "" {
+		respondWithInvalidField(w, "user_id", "user_id query parameter is required")
+		return
+	}
+	if !authorizeUser(w, r, userID) {
+		return
+	}
+
+	from, to, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := h.portfolioService.GetAllocationHistory(r.Context(), userID, from, to)
+	if err != nil {
+		log.Printf("Failed to get allocation history for user %s: %v", userID, err)
+		respondWithServiceError(w, err, "Failed to retrieve allocation history")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"history": history,
+		"count":   len(history),
+	})
+}
+
+// HandlePortfolioSchedule sets or clears the schedule on which a portfolio is rebalanced
+// Sample Request (PUT /v1/portfolios/1/schedule):
+//
+//	{
+//	    "schedule": "quarterly"
+//	}
+//
+// PUT /portfolio/schedule takes the same body with the user_id in it.
+// schedule is monthly, quarterly or a five-field cron expression in UTC, e.g. "0 9 * * 1".
+func (h *PortfolioHandler) HandlePortfolioSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only PUT method is allowed")
+		return
+	}
+
+	var req models.PortfolioSchedule
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithInvalidBody(w)
+		return
+	}
+	bindPathParam(r, "user_id", &req.UserID)
+
+	if req.UserID == "" {
+		respondWithInvalidField(w, "user_id", "user_id is required and cannot be empty")
+		return
+	}
+
+	updated, err := h.portfolioService.SetSchedule(r.Context(), req.UserID, req.Schedule)
+	if err != nil {
+		log.Printf("Failed to set schedule for user %s: %v", req.UserID, err)
+		respondWithServiceError(w, err, "Failed to set schedule. Please try again")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, updated)
+}
+
+// parseTimeParam parses an optional RFC3339 query parameter, returning the zero time when absent
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC3339 timestamp", name)
+	}
+
+	return t, nil
+}
+
+// parseTimeRange parses the optional from and to query parameters, responding with 400 and
+// returning false when either is invalid or from is after to
+func parseTimeRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	from, err := parseTimeParam(r, "from")
+	if err != nil {
+		respondWithInvalidField(w, "from", err.Error())
+		return time.Time{}, time.Time{}, false
+	}
+
+	to, err := parseTimeParam(r, "to")
+	if err != nil {
+		respondWithInvalidField(w, "to", err.Error())
+		return time.Time{}, time.Time{}, false
+	}
+
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		respondWithInvalidField(w, "from", "from must not be after to")
+		return time.Time{}, time.Time{}, false
+	}
+
+	return from, to, true
+}
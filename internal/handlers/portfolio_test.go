@@ -8,15 +8,24 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"portfolio-rebalancer/internal/auth"
 	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/services"
 )
 
 // Mock portfolio service
 type mockPortfolioService struct {
-	createFunc func(ctx context.Context, p models.Portfolio) (*models.Portfolio, error)
-	getFunc    func(ctx context.Context, userID string) (*models.Portfolio, error)
-	updateFunc func(ctx context.Context, portfolio models.Portfolio) error
+	createFunc   func(ctx context.Context, p models.Portfolio) (*models.Portfolio, error)
+	getFunc      func(ctx context.Context, userID string) (*models.Portfolio, error)
+	updateFunc   func(ctx context.Context, portfolio models.Portfolio) error
+	getAsOfFunc  func(ctx context.Context, userID string, asOf time.Time) (*models.Portfolio, error)
+	historyFunc  func(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error)
+	listFunc     func(ctx context.Context, modelID string) ([]models.Portfolio, error)
+	setFunc      func(ctx context.Context, userID string, target map[string]float64, source string) (*models.Portfolio, error)
+	sourceFunc   func(ctx context.Context, portfolio models.Portfolio, source string) error
+	scheduleFunc func(ctx context.Context, userID string, schedule string) (*models.Portfolio, error)
 }
 
 func (m *mockPortfolioService) CreatePortfolio(ctx context.Context, p models.Portfolio) (*models.Portfolio, error) {
@@ -30,7 +39,7 @@ func (m *mockPortfolioService) GetPortfolio(ctx context.Context, userID string)
 	if m.getFunc != nil {
 		return m.getFunc(ctx, userID)
 	}
-	return nil, errors.New("not found")
+	return nil, &services.NotFoundError{Resource: "portfolio", ID: userID}
 }
 
 func (m *mockPortfolioService) UpdatePortfolio(ctx context.Context, portfolio models.Portfolio) error {
@@ -40,6 +49,62 @@ func (m *mockPortfolioService) UpdatePortfolio(ctx context.Context, portfolio mo
 	return nil
 }
 
+func (m *mockPortfolioService) UpdateAllocation(ctx context.Context, portfolio models.Portfolio, source string) error {
+	if m.sourceFunc != nil {
+		return m.sourceFunc(ctx, portfolio, source)
+	}
+	return nil
+}
+
+func (m *mockPortfolioService) ReplaceAllocation(ctx context.Context, userID string, allocation map[string]float64, source, rebalanceID string) (*models.Portfolio, error) {
+	return &models.Portfolio{UserID: userID, Allocation: allocation}, nil
+}
+
+func (m *mockPortfolioService) GetPortfolioAsOf(ctx context.Context, userID string, asOf time.Time) (*models.Portfolio, error) {
+	if m.getAsOfFunc != nil {
+		return m.getAsOfFunc(ctx, userID, asOf)
+	}
+	return nil, &services.NotFoundError{Resource: "portfolio", ID: userID}
+}
+
+func (m *mockPortfolioService) GetAllocationHistory(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error) {
+	if m.historyFunc != nil {
+		return m.historyFunc(ctx, userID, from, to)
+	}
+	return []models.AllocationHistory{}, nil
+}
+
+func (m *mockPortfolioService) ListByModel(ctx context.Context, modelID string) ([]models.Portfolio, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx, modelID)
+	}
+	return nil, nil
+}
+
+func (m *mockPortfolioService) SetTargetAllocation(ctx context.Context, userID string, target map[string]float64, source string) (*models.Portfolio, error) {
+	if m.setFunc != nil {
+		return m.setFunc(ctx, userID, target, source)
+	}
+	portfolio, err := m.GetPortfolio(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	portfolio.OriginalAllocation = target
+	return portfolio, nil
+}
+
+func (m *mockPortfolioService) SetSchedule(ctx context.Context, userID string, schedule string) (*models.Portfolio, error) {
+	if m.scheduleFunc != nil {
+		return m.scheduleFunc(ctx, userID, schedule)
+	}
+	portfolio, err := m.GetPortfolio(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	portfolio.Schedule = schedule
+	return portfolio, nil
+}
+
 func TestHandlePortfolio(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -152,7 +217,7 @@ func TestHandleCreatePortfolio(t *testing.T) {
 				},
 			},
 			mockCreate: func(ctx context.Context, p models.Portfolio) (*models.Portfolio, error) {
-				return nil, errors.New("service error")
+				return nil, &services.ValidationError{Message: "allocation is required and cannot be empty"}
 			},
 			expectedStatus: http.StatusBadRequest,
 		},
@@ -191,7 +256,9 @@ func TestHandleGetPortfolio(t *testing.T) {
 	tests := []struct {
 		name           string
 		userID         string
+		asOf           string
 		mockGet        func(ctx context.Context, userID string) (*models.Portfolio, error)
+		mockGetAsOf    func(ctx context.Context, userID string, asOf time.Time) (*models.Portfolio, error)
 		expectedStatus int
 	}{
 		{
@@ -217,7 +284,34 @@ func TestHandleGetPortfolio(t *testing.T) {
 			name:   "portfolio not found",
 			userID: "user1",
 			mockGet: func(ctx context.Context, userID string) (*models.Portfolio, error) {
-				return nil, errors.New("not found")
+				return nil, &services.NotFoundError{Resource: "portfolio", ID: userID}
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:   "successful get as of",
+			userID: "user1",
+			asOf:   "2025-01-01T00:00:00Z",
+			mockGetAsOf: func(ctx context.Context, userID string, asOf time.Time) (*models.Portfolio, error) {
+				if !asOf.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+					return nil, errors.New("unexpected as_of")
+				}
+				return &models.Portfolio{UserID: userID}, nil
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "invalid as_of",
+			userID:         "user1",
+			asOf:           "yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "no history as of",
+			userID: "user1",
+			asOf:   "2020-01-01T00:00:00Z",
+			mockGetAsOf: func(ctx context.Context, userID string, asOf time.Time) (*models.Portfolio, error) {
+				return nil, &services.NotFoundError{Resource: "portfolio", ID: userID}
 			},
 			expectedStatus: http.StatusNotFound,
 		},
@@ -226,7 +320,8 @@ func TestHandleGetPortfolio(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockService := &mockPortfolioService{
-				getFunc: tt.mockGet,
+				getFunc:     tt.mockGet,
+				getAsOfFunc: tt.mockGetAsOf,
 			}
 
 			handler := &PortfolioHandler{
@@ -237,6 +332,9 @@ func TestHandleGetPortfolio(t *testing.T) {
 			if tt.userID != "" {
 				url += "?user_id=" + tt.userID
 			}
+			if tt.asOf != "" {
+				url += "&as_of=" + tt.asOf
+			}
 
 			req := httptest.NewRequest(http.MethodGet, url, nil)
 			w := httptest.NewRecorder()
@@ -249,3 +347,266 @@ func TestHandleGetPortfolio(t *testing.T) {
 		})
 	}
 }
+
+func TestHandlePortfolioHistory(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		mockHistory    func(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error)
+		expectedStatus int
+		expectedCount  int
+	}{
+		{
+			name:   "successful list",
+			method: http.MethodGet,
+			query:  "?user_id=user1&from=2025-01-01T00:00:00Z&to=2025-02-01T00:00:00Z",
+			mockHistory: func(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error) {
+				return []models.AllocationHistory{
+					{UserID: userID, Source: models.AllocationSourceUser},
+					{UserID: userID, Source: models.AllocationSourceProvider},
+				}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedCount:  2,
+		},
+		{
+			name:           "open range",
+			method:         http.MethodGet,
+			query:          "?user_id=user1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodPost,
+			query:          "?user_id=user1",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "missing user_id",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid from",
+			method:         http.MethodGet,
+			query:          "?user_id=user1&from=2025-01-01",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "from after to",
+			method:         http.MethodGet,
+			query:          "?user_id=user1&from=2025-02-01T00:00:00Z&to=2025-01-01T00:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "service error",
+			method: http.MethodGet,
+			query:  "?user_id=user1",
+			mockHistory: func(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error) {
+				return nil, errors.New("es down")
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &PortfolioHandler{
+				portfolioService: &mockPortfolioService{
+					historyFunc: tt.mockHistory,
+				},
+			}
+
+			req := httptest.NewRequest(tt.method, "/portfolio/history"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			handler.HandlePortfolioHistory(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				var response struct {
+					Count int `json:"count"`
+				}
+				if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if response.Count != tt.expectedCount {
+					t.Errorf("expected count %d, got %d", tt.expectedCount, response.Count)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlePortfolioSchedule(t *testing.T) {
+	existing := func(ctx context.Context, userID string) (*models.Portfolio, error) {
+		return &models.Portfolio{UserID: userID, Allocation: map[string]float64{"stocks": 100}}, nil
+	}
+
+	tests := []struct {
+		name             string
+		method           string
+		body             string
+		mockGet          func(ctx context.Context, userID string) (*models.Portfolio, error)
+		mockSchedule     func(ctx context.Context, userID string, schedule string) (*models.Portfolio, error)
+		expectedStatus   int
+		expectedSchedule string
+	}{
+		{
+			name:             "set schedule",
+			method:           http.MethodPut,
+			body:             `{"user_id": "user1", "schedule": "quarterly"}`,
+			mockGet:          existing,
+			expectedStatus:   http.StatusOK,
+			expectedSchedule: "quarterly",
+		},
+		{
+			name:           "clear schedule",
+			method:         http.MethodPut,
+			body:           `{"user_id": "user1", "schedule": ""}`,
+			mockGet:        existing,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodPost,
+			body:           `{"user_id": "user1", "schedule": "monthly"}`,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "invalid json",
+			method:         http.MethodPut,
+			body:           `{`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "missing user_id",
+			method:         http.MethodPut,
+			body:           `{"schedule": "monthly"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "portfolio not found",
+			method:         http.MethodPut,
+			body:           `{"user_id": "user1", "schedule": "monthly"}`,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:    "invalid schedule",
+			method:  http.MethodPut,
+			body:    `{"user_id": "user1", "schedule": "every tuesday"}`,
+			mockGet: existing,
+			mockSchedule: func(ctx context.Context, userID string, schedule string) (*models.Portfolio, error) {
+				return nil, &services.ValidationError{Message: "schedule must be a descriptor or have 5 fields"}
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &PortfolioHandler{
+				portfolioService: &mockPortfolioService{
+					getFunc:      tt.mockGet,
+					scheduleFunc: tt.mockSchedule,
+				},
+			}
+
+			req := httptest.NewRequest(tt.method, "/portfolio/schedule", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			handler.HandlePortfolioSchedule(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				var response models.Portfolio
+				if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if response.Schedule != tt.expectedSchedule {
+					t.Errorf("expected schedule %q, got %q", tt.expectedSchedule, response.Schedule)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleGetPortfolioOwnership(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		user           *auth.User
+		expectedUserID string
+		expectedStatus int
+	}{
+		{
+			name:           "user reads their own portfolio",
+			url:            "/portfolio?user_id=1",
+			user:           &auth.User{ID: "1"},
+			expectedUserID: "1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "user_id defaults to the token subject",
+			url:            "/portfolio",
+			user:           &auth.User{ID: "1"},
+			expectedUserID: "1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "user cannot read another user's portfolio",
+			url:            "/portfolio?user_id=2",
+			user:           &auth.User{ID: "1"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "admin role reads any portfolio",
+			url:            "/portfolio?user_id=2",
+			user:           &auth.User{ID: "1", Admin: true},
+			expectedUserID: "2",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "api keys are not limited to one user",
+			url:            "/portfolio?user_id=2",
+			expectedUserID: "2",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requested string
+			handler := &PortfolioHandler{
+				portfolioService: &mockPortfolioService{
+					getFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+						requested = userID
+						return &models.Portfolio{UserID: userID}, nil
+					},
+				},
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.user != nil {
+				req = req.WithContext(auth.WithUser(req.Context(), tt.user))
+			}
+			w := httptest.NewRecorder()
+
+			handler.HandlePortfolio(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if requested != tt.expectedUserID {
+				t.Errorf("expected portfolio of user %q to be read, got %q", tt.expectedUserID, requested)
+			}
+		})
+	}
+}
@@ -1,114 +1,392 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
 	"portfolio-rebalancer/internal/models"
 	"portfolio-rebalancer/internal/services"
+	"portfolio-rebalancer/pkg/router"
 )
 
 type RebalanceHandler struct {
 	rebalanceService services.RebalanceService
 	portfolioService services.PortfolioService
+	planner          services.RebalancePlanner
 }
 
 // NewRebalanceHandler creates a new rebalance handler with injected dependencies
 func NewRebalanceHandler(
-	mux *http.ServeMux,
+	mux *router.Router,
 	rebalanceService services.RebalanceService,
 	portfolioService services.PortfolioService,
+	planner services.RebalancePlanner,
 ) {
 	handler := &RebalanceHandler{
 		rebalanceService: rebalanceService,
 		portfolioService: portfolioService,
+		planner:          planner,
 	}
 
 	// Register routes
-	mux.HandleFunc("/rebalance", handler.HandleRebalance)
+	mux.HandleFunc(http.MethodPost, "/v1/portfolios/{user_id}/rebalances", handler.HandleRebalance)
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/{user_id}/rebalances", handler.handleListRebalances)
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/{user_id}/transactions", handler.HandleTransactions)
+	mux.HandleFunc(http.MethodPost, "/v1/portfolios/{user_id}/cashflows", handler.HandleCashFlow)
+	mux.HandleFunc(http.MethodGet, "/v1/rebalances/{rebalance_id}", handler.handleGetRebalance)
+	mux.HandleFunc(http.MethodPost, "/v1/rebalances/{rebalance_id}/approve", handler.HandleApproveRebalance)
+	mux.HandleFunc(http.MethodPost, "/v1/rebalances/{rebalance_id}/reject", handler.HandleRejectRebalance)
+
+	// Unversioned routes, deprecated in favour of /v1
+	mux.HandleFunc(router.AnyMethod, "/rebalance", deprecated(handler.HandleRebalance))
+	mux.HandleFunc(router.AnyMethod, "/portfolio/cashflow", deprecated(handler.HandleCashFlow))
+	mux.HandleFunc(router.AnyMethod, "/rebalance/approve", deprecated(handler.HandleApproveRebalance))
+	mux.HandleFunc(router.AnyMethod, "/rebalance/reject", deprecated(handler.HandleRejectRebalance))
+	mux.HandleFunc(router.AnyMethod, "/rebalances", deprecated(handler.HandleRebalances))
 }
 
+// Output modes of a rebalance
+const (
+	rebalanceModeLegs     = "legs"     // independent BUY and SELL legs
+	rebalanceModeSwitches = "switches" // legs plus the from→to switches they net to
+)
+
 // HandleRebalance handles portfolio rebalance requests from 3rd party provider
-// Sample Request (POST /rebalance):
+// Sample Request (POST /v1/portfolios/1/rebalances?mode=switches, mode defaults to legs):
 //
 //	{
-//	    "user_id": "1",
 //	    "new_allocation": {"stocks": 70, "bonds": 20, "gold": 10}
 //	}
+//
+// POST /rebalance takes the same body with the user_id in it.
 func (h *RebalanceHandler) HandleRebalance(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		RespondWithError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
 		return
 	}
 
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = rebalanceModeLegs
+	}
+	if mode != rebalanceModeLegs && mode != rebalanceModeSwitches {
+		respondWithInvalidField(w, "mode", "mode must be legs or switches")
+		return
+	}
+
 	var req models.UpdatedPortfolio
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid JSON format in request body")
+		respondWithInvalidBody(w)
 		return
 	}
+	bindPathParam(r, "user_id", &req.UserID)
 
-	// Validate user ID
-	if req.UserID == "" {
-		RespondWithError(w, http.StatusBadRequest, "user_id is required and cannot be empty")
+	plan, err := h.planner.Rebalance(r.Context(), req.UserID, req.NewAllocation)
+	if err != nil {
+		log.Printf("Failed to rebalance portfolio for user %s: %v", req.UserID, err)
+		respondWithServiceError(w, err, "Failed to queue rebalance transactions. Please try again")
+		return
+	}
+	transactions := plan.Transactions
+	rebalance := plan.Rebalance
+
+	// Return the calculated transactions
+	response := map[string]interface{}{
+		"user_id":           req.UserID,
+		"transactions":      transactions,
+		"transaction_count": len(transactions),
+		"message":           "Rebalance transactions queued for processing",
+	}
+
+	if len(transactions) == 0 {
+		response["message"] = "No rebalancing needed - portfolio already at target allocation"
+	}
+
+	if rebalance != nil {
+		response["rebalance_id"] = rebalance.ID
+		response["status"] = rebalance.Status
+		if rebalance.Status == models.RebalanceStatusProposed {
+			response["message"] = "Rebalance transactions are awaiting approval"
+		}
+	}
+
+	if plan.Constraints != nil {
+		response["constraints"] = plan.Constraints
+	}
+
+	if plan.Validation.HasIssues() || len(plan.Validation.Aliased) > 0 {
+		response["asset_validation"] = plan.Validation
+	}
+
+	// Switches are derived from the published legs, so both views reconcile exactly
+	if mode == rebalanceModeSwitches {
+		response["switches"] = h.rebalanceService.PairTransactions(transactions)
+	}
+
+	// Hierarchical targets also get drift reported for every asset class level
+	if len(plan.Portfolio.AllocationTree) > 0 {
+		response["drift"] = plan.Drift
+	}
+
+	RespondWithJSON(w, http.StatusOK, response)
+}
+
+// HandleCashFlow handles deposits and withdrawals, using the cash to move the portfolio towards its target
+// Sample Request (POST /v1/portfolios/1/cashflows, or POST /portfolio/cashflow with the user_id in the body):
+//
+//	{
+//	    "type": "DEPOSIT",
+//	    "amount": 1000,
+//	    "currency": "EUR",
+//	    "portfolio_value": 10000
+//	}
+//
+// amount is converted into the portfolio currency when currency differs from it;
+// portfolio_value is always in the portfolio currency.
+func (h *RebalanceHandler) HandleCashFlow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
 		return
 	}
 
-	// Validate new allocation exists
-	if len(req.NewAllocation) == 0 {
-		RespondWithError(w, http.StatusBadRequest, "new_allocation is required and cannot be empty")
+	var req models.CashFlow
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithInvalidBody(w)
 		return
 	}
+	bindPathParam(r, "user_id", &req.UserID)
 
-	// Validate new allocation percentages
-	if err := models.ValidateAllocation(req.NewAllocation); err != nil {
-		RespondWithError(w, http.StatusBadRequest, err.Error())
+	if req.UserID == "" {
+		respondWithInvalidField(w, "user_id", "user_id is required and cannot be empty")
+		return
+	}
+	if req.Type != models.CashFlowDeposit && req.Type != models.CashFlowWithdrawal {
+		respondWithInvalidField(w, "type", "type must be DEPOSIT or WITHDRAWAL")
+		return
+	}
+	if req.Amount <= 0 {
+		respondWithInvalidField(w, "amount", "amount must be greater than zero")
 		return
 	}
 
-	// Fetch original portfolio
 	portfolio, err := h.portfolioService.GetPortfolio(r.Context(), req.UserID)
 	if err != nil {
 		log.Printf("Failed to get portfolio for user %s: %v", req.UserID, err)
-		RespondWithError(w, http.StatusNotFound, "User portfolio not found. Please create portfolio first using /portfolio endpoint")
+		respondWithServiceError(w, err, "Failed to retrieve portfolio")
 		return
 	}
 
-	// Calculate rebalance transactions
-	// Compare new allocation (from 3rd party provider) against target allocation (original portfolio)
-	// This tells us what to BUY/SELL to get back to the target
-	transactions := h.rebalanceService.CalculateRebalance(req.NewAllocation, portfolio.OriginalAllocation, req.UserID)
+	// Fall back to the last known value when the caller does not report one
+	if req.PortfolioValue <= 0 {
+		req.PortfolioValue = portfolio.TotalValue
+	}
+	if req.Type == models.CashFlowWithdrawal && req.PortfolioValue <= 0 {
+		respondWithInvalidField(w, "portfolio_value", "portfolio_value is required for withdrawals")
+		return
+	}
 
-	// Publish transactions for async processing
-	if err := h.rebalanceService.PublishRebalanceTransactions(r.Context(), transactions); err != nil {
-		log.Printf("Failed to publish transactions for user %s: %v", req.UserID, err)
-		RespondWithError(w, http.StatusInternalServerError, "Failed to queue rebalance transactions. Please try again")
+	flow, err := h.rebalanceService.ConvertCashFlow(r.Context(), req, portfolio.BaseCurrency())
+	if err != nil {
+		log.Printf("Failed to convert cash flow for user %s: %v", req.UserID, err)
+		respondWithServiceError(w, err, "Failed to convert cash flow")
 		return
 	}
 
-	if len(transactions) > 0 {
-		log.Printf("Published %d transactions for user %s", len(transactions), req.UserID)
-	} else {
-		log.Printf("No rebalancing needed for user %s - portfolio already at target allocation", req.UserID)
+	// Constrained assets keep to their bounds and the rest of the flow moves the others
+	result, err := h.rebalanceService.CalculateCashFlow(portfolio.Allocation, portfolio.OriginalAllocation, portfolio.Constraints, flow)
+	if err != nil {
+		log.Printf("Failed to calculate cash flow for user %s: %v", req.UserID, err)
+		respondWithServiceError(w, err, "Failed to calculate cash flow transactions")
+		return
 	}
 
-	// Update portfolio's current allocation
-	portfolio.Allocation = req.NewAllocation
-	if err := h.portfolioService.UpdatePortfolio(r.Context(), *portfolio); err != nil {
-		log.Printf("Failed to update portfolio for user %s: %v", req.UserID, err)
-		// Don't fail the request, transactions are already queued
+	rebalance, err := h.rebalanceService.SubmitRebalance(r.Context(), *portfolio, result.Transactions, result.NewAllocation, models.ActorUser)
+	if err != nil {
+		log.Printf("Failed to publish cash flow transactions for user %s: %v", req.UserID, err)
+		respondWithServiceError(w, err, "Failed to queue rebalance transactions. Please try again")
+		return
 	}
 
-	// Return the calculated transactions
 	response := map[string]interface{}{
 		"user_id":           req.UserID,
-		"transactions":      transactions,
-		"transaction_count": len(transactions),
-		"message":           "Rebalance transactions queued for processing",
+		"type":              req.Type,
+		"amount":            flow.Amount,
+		"currency":          flow.Currency,
+		"fx_rate":           flow.FXRate,
+		"transactions":      result.Transactions,
+		"transaction_count": len(result.Transactions),
+		"new_allocation":    result.NewAllocation,
+		"portfolio_value":   result.NewValue,
+		"message":           "Cash flow transactions queued for processing",
 	}
 
-	if len(transactions) == 0 {
-		response["message"] = "No rebalancing needed - portfolio already at target allocation"
+	if rebalance != nil {
+		response["transactions"] = rebalance.Transactions
+		response["rebalance_id"] = rebalance.ID
+		response["status"] = rebalance.Status
+		log.Printf("Submitted %d cash flow transactions for user %s as %s", len(rebalance.Transactions), req.UserID, rebalance.Status)
+	}
+
+	if rebalance != nil && rebalance.Status == models.RebalanceStatusProposed {
+		response["message"] = "Cash flow transactions are awaiting approval"
+	}
+
+	// The cash has moved whether or not its trades await approval, so the value changes now.
+	// The allocation only changes once the trades execute.
+	flowed := models.Portfolio{UserID: req.UserID, TotalValue: result.NewValue}
+	if err := h.portfolioService.UpdateAllocation(r.Context(), flowed, models.AllocationSourceCashFlow); err != nil {
+		log.Printf("Failed to update portfolio value for user %s: %v", req.UserID, err)
+		// Don't fail the request, transactions are already queued
 	}
 
 	RespondWithJSON(w, http.StatusOK, response)
 }
+
+// HandleApproveRebalance approves a proposed rebalance and queues its transactions for execution
+// Sample Request (POST /v1/rebalances/9f1c2e.../approve):
+//
+//	{
+//	    "actor": "jane.doe",
+//	    "reason": "within mandate"
+//	}
+//
+// POST /rebalance/approve takes the same body with the rebalance_id in it.
+func (h *RebalanceHandler) HandleApproveRebalance(w http.ResponseWriter, r *http.Request) {
+	h.handleDecision(w, r, h.rebalanceService.ApproveRebalance)
+}
+
+// HandleRejectRebalance rejects a proposed rebalance so none of its transactions execute
+// Sample Request (POST /v1/rebalances/9f1c2e.../reject), same body as approve
+func (h *RebalanceHandler) HandleRejectRebalance(w http.ResponseWriter, r *http.Request) {
+	h.handleDecision(w, r, h.rebalanceService.RejectRebalance)
+}
+
+// handleDecision applies an approval or rejection to a rebalance as a unit
+func (h *RebalanceHandler) handleDecision(w http.ResponseWriter, r *http.Request, decide func(context.Context, models.RebalanceDecision) (*models.Rebalance, error)) {
+	if r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+
+	var decision models.RebalanceDecision
+	if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+		respondWithInvalidBody(w)
+		return
+	}
+	bindPathParam(r, "rebalance_id", &decision.RebalanceID)
+
+	if decision.RebalanceID == "" {
+		respondWithInvalidField(w, "rebalance_id", "rebalance_id is required and cannot be empty")
+		return
+	}
+	if decision.Actor == "" {
+		respondWithInvalidField(w, "actor", "actor is required and cannot be empty")
+		return
+	}
+
+	rebalance, err := decide(r.Context(), decision)
+	if err != nil {
+		log.Printf("Failed to decide rebalance %s: %v", decision.RebalanceID, err)
+		respondWithServiceError(w, err, "Failed to update rebalance. Please try again")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, rebalance)
+}
+
+// HandleRebalances returns one rebalance with its status history, or lists a user's rebalances
+// GET /rebalances?id=9f1c2e...
+// GET /rebalances?user_id=1&status=PROPOSED
+func (h *RebalanceHandler) HandleRebalances(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		h.getRebalance(w, r, id)
+		return
+	}
+
+	h.handleListRebalances(w, r)
+}
+
+// handleGetRebalance returns one rebalance with its status history
+// GET /v1/rebalances/9f1c2e...
+func (h *RebalanceHandler) handleGetRebalance(w http.ResponseWriter, r *http.Request) {
+	h.getRebalance(w, r, router.Param(r, "rebalance_id"))
+}
+
+// getRebalance returns the rebalance id if it belongs to a user the request may read
+func (h *RebalanceHandler) getRebalance(w http.ResponseWriter, r *http.Request, id string) {
+	rebalance, err := h.rebalanceService.GetRebalance(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to get rebalance %s: %v", id, err)
+		respondWithServiceError(w, err, "Failed to retrieve rebalance")
+		return
+	}
+	if !authorizeUser(w, r, rebalance.UserID) {
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, rebalance)
+}
+
+// handleListRebalances lists a user's rebalances, newest first
+// GET /v1/portfolios/1/rebalances?status=PROPOSED
+func (h *RebalanceHandler) handleListRebalances(w http.ResponseWriter, r *http.Request) {
+	userID := userIDParam(r)
+	if userID == "" {
+		respondWithInvalidField(w, "user_id", "id or user_id query parameter is required")
+		return
+	}
+	if !authorizeUser(w, r, userID) {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	rebalances, err := h.rebalanceService.ListRebalances(r.Context(), userID, status)
+	if err != nil {
+		log.Printf("Failed to list rebalances for user %s: %v", userID, err)
+		respondWithServiceError(w, err, "Failed to retrieve rebalances")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id":    userID,
+		"rebalances": rebalances,
+		"count":      len(rebalances),
+	})
+}
+
+// HandleTransactions lists the transactions processed for a user's rebalances, newest first
+// GET /v1/portfolios/1/transactions?from=2025-01-01T00:00:00Z&to=2025-02-01T00:00:00Z
+func (h *RebalanceHandler) HandleTransactions(w http.ResponseWriter, r *http.Request) {
+	userID := userIDParam(r)
+	if userID == "" {
+		respondWithInvalidField(w, "user_id", "user_id is required and cannot be empty")
+		return
+	}
+	if !authorizeUser(w, r, userID) {
+		return
+	}
+
+	from, to, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	transactions, err := h.rebalanceService.ListTransactions(r.Context(), userID, from, to)
+	if err != nil {
+		log.Printf("Failed to list transactions for user %s: %v", userID, err)
+		respondWithServiceError(w, err, "Failed to retrieve transactions")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id":      userID,
+		"transactions": transactions,
+		"count":        len(transactions),
+	})
+}
@@ -5,11 +5,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
 
 	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/services"
 )
 
 // Mock rebalance service
@@ -17,6 +21,17 @@ type mockRebalanceService struct {
 	calculateFunc func(currentAllocation, targetAllocation map[string]float64, userID string) []models.RebalanceTransaction
 	publishFunc   func(ctx context.Context, transactions []models.RebalanceTransaction) error
 	processFunc   func(ctx context.Context, message []byte) error
+	driftFunc     func(currentAllocation map[string]float64, targetTree []models.AllocationNode) []models.AllocationDrift
+	applyFunc     func(currentAllocation, targetAllocation map[string]float64, constraints map[string]models.AssetConstraint) (*models.ConstraintResult, error)
+	cashFlowFunc  func(currentAllocation, targetAllocation map[string]float64, flow models.CashFlow) (*models.CashFlowResult, error)
+	pairFunc      func(transactions []models.RebalanceTransaction) []models.RebalanceSwitch
+	convertFunc   func(ctx context.Context, flow models.CashFlow, currency string) (models.CashFlow, error)
+	submitFunc    func(ctx context.Context, portfolio models.Portfolio, transactions []models.RebalanceTransaction, expected map[string]float64, actor string) (*models.Rebalance, error)
+	approveFunc   func(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, error)
+	rejectFunc    func(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, error)
+	getFunc       func(ctx context.Context, id string) (*models.Rebalance, error)
+	listFunc      func(ctx context.Context, userID, status string) ([]models.Rebalance, error)
+	txListFunc    func(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error)
 }
 
 func (m *mockRebalanceService) CalculateRebalance(currentAllocation, targetAllocation map[string]float64, userID string) []models.RebalanceTransaction {
@@ -26,6 +41,42 @@ func (m *mockRebalanceService) CalculateRebalance(currentAllocation, targetAlloc
 	return []models.RebalanceTransaction{}
 }
 
+func (m *mockRebalanceService) CalculateDrift(currentAllocation map[string]float64, targetTree []models.AllocationNode) []models.AllocationDrift {
+	if m.driftFunc != nil {
+		return m.driftFunc(currentAllocation, targetTree)
+	}
+	return []models.AllocationDrift{}
+}
+
+func (m *mockRebalanceService) ApplyConstraints(currentAllocation, targetAllocation map[string]float64, constraints map[string]models.AssetConstraint) (*models.ConstraintResult, error) {
+	if m.applyFunc != nil {
+		return m.applyFunc(currentAllocation, targetAllocation, constraints)
+	}
+	return &models.ConstraintResult{Target: targetAllocation}, nil
+}
+
+func (m *mockRebalanceService) CalculateCashFlow(currentAllocation, targetAllocation map[string]float64, constraints map[string]models.AssetConstraint, flow models.CashFlow) (*models.CashFlowResult, error) {
+	if m.cashFlowFunc != nil {
+		return m.cashFlowFunc(currentAllocation, targetAllocation, flow)
+	}
+	return &models.CashFlowResult{NewAllocation: currentAllocation, NewValue: flow.PortfolioValue}, nil
+}
+
+func (m *mockRebalanceService) ConvertCashFlow(ctx context.Context, flow models.CashFlow, currency string) (models.CashFlow, error) {
+	if m.convertFunc != nil {
+		return m.convertFunc(ctx, flow, currency)
+	}
+	flow.Currency = currency
+	return flow, nil
+}
+
+func (m *mockRebalanceService) PairTransactions(transactions []models.RebalanceTransaction) []models.RebalanceSwitch {
+	if m.pairFunc != nil {
+		return m.pairFunc(transactions)
+	}
+	return []models.RebalanceSwitch{}
+}
+
 func (m *mockRebalanceService) PublishRebalanceTransactions(ctx context.Context, transactions []models.RebalanceTransaction) error {
 	if m.publishFunc != nil {
 		return m.publishFunc(ctx, transactions)
@@ -33,6 +84,60 @@ func (m *mockRebalanceService) PublishRebalanceTransactions(ctx context.Context,
 	return nil
 }
 
+// SubmitRebalance publishes through publishFunc by default, as a portfolio without an approval policy would
+func (m *mockRebalanceService) SubmitRebalance(ctx context.Context, portfolio models.Portfolio, transactions []models.RebalanceTransaction, expected map[string]float64, actor string) (*models.Rebalance, error) {
+	if m.submitFunc != nil {
+		return m.submitFunc(ctx, portfolio, transactions, expected, actor)
+	}
+	if err := m.PublishRebalanceTransactions(ctx, transactions); err != nil {
+		return nil, err
+	}
+	if len(transactions) == 0 {
+		return nil, nil
+	}
+	return &models.Rebalance{
+		ID:           transactions[0].RebalanceID,
+		UserID:       portfolio.UserID,
+		Status:       models.RebalanceStatusApproved,
+		Transactions: transactions,
+	}, nil
+}
+
+func (m *mockRebalanceService) ApproveRebalance(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, error) {
+	if m.approveFunc != nil {
+		return m.approveFunc(ctx, decision)
+	}
+	return &models.Rebalance{ID: decision.RebalanceID, Status: models.RebalanceStatusApproved}, nil
+}
+
+func (m *mockRebalanceService) RejectRebalance(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, error) {
+	if m.rejectFunc != nil {
+		return m.rejectFunc(ctx, decision)
+	}
+	return &models.Rebalance{ID: decision.RebalanceID, Status: models.RebalanceStatusRejected}, nil
+}
+
+func (m *mockRebalanceService) GetRebalance(ctx context.Context, id string) (*models.Rebalance, error) {
+	if m.getFunc != nil {
+		return m.getFunc(ctx, id)
+	}
+	return nil, &services.NotFoundError{Resource: "rebalance", ID: id}
+}
+
+func (m *mockRebalanceService) ListRebalances(ctx context.Context, userID, status string) ([]models.Rebalance, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx, userID, status)
+	}
+	return []models.Rebalance{}, nil
+}
+
+func (m *mockRebalanceService) ListTransactions(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error) {
+	if m.txListFunc != nil {
+		return m.txListFunc(ctx, userID, from, to)
+	}
+	return []models.RebalanceTransaction{}, nil
+}
+
 func (m *mockRebalanceService) ProcessTransactions(ctx context.Context, message []byte) error {
 	if m.processFunc != nil {
 		return m.processFunc(ctx, message)
@@ -40,6 +145,24 @@ func (m *mockRebalanceService) ProcessTransactions(ctx context.Context, message
 	return nil
 }
 
+// Mock asset validator
+type mockAssetValidator struct {
+	validateFunc func(ctx context.Context, allocation map[string]float64, portfolio models.Portfolio) (*models.AssetValidation, error)
+}
+
+func (m *mockAssetValidator) ValidateAllocation(ctx context.Context, allocation map[string]float64, portfolio models.Portfolio) (*models.AssetValidation, error) {
+	if m.validateFunc != nil {
+		return m.validateFunc(ctx, allocation, portfolio)
+	}
+	return &models.AssetValidation{Mode: models.AssetValidationStrict, Allocation: allocation}, nil
+}
+
+// withPlanner runs the handler's rebalances through the real planner over its mocks
+func withPlanner(h *RebalanceHandler, validator services.AssetValidator) *RebalanceHandler {
+	h.planner = services.NewRebalancePlanner(h.portfolioService, h.rebalanceService, validator)
+	return h
+}
+
 func TestHandleRebalance(t *testing.T) {
 	tests := []struct {
 		name              string
@@ -137,7 +260,7 @@ func TestHandleRebalance(t *testing.T) {
 					"bonds":  40.0,
 				},
 			},
-			mockPortfolioErr: errors.New("not found"),
+			mockPortfolioErr: &services.NotFoundError{Resource: "portfolio", ID: "1"},
 			expectedStatus:   http.StatusNotFound,
 		},
 		{
@@ -220,10 +343,10 @@ func TestHandleRebalance(t *testing.T) {
 				},
 			}
 
-			handler := &RebalanceHandler{
+			handler := withPlanner(&RebalanceHandler{
 				rebalanceService: mockRebalanceSvc,
 				portfolioService: mockPortfolioSvc,
-			}
+			}, &mockAssetValidator{})
 
 			var body []byte
 			if str, ok := tt.requestBody.(string); ok {
@@ -243,3 +366,759 @@ func TestHandleRebalance(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleRebalanceReportsDrift(t *testing.T) {
+	tests := []struct {
+		name        string
+		portfolio   *models.Portfolio
+		expectDrift bool
+	}{
+		{
+			name: "hierarchical target",
+			portfolio: &models.Portfolio{
+				UserID:             "user1",
+				OriginalAllocation: map[string]float64{"us": 60.0, "bonds": 40.0},
+				AllocationTree: []models.AllocationNode{
+					{Asset: "equities", Percent: 60.0, Children: []models.AllocationNode{{Asset: "us", Percent: 60.0}}},
+					{Asset: "bonds", Percent: 40.0},
+				},
+			},
+			expectDrift: true,
+		},
+		{
+			name: "flat target",
+			portfolio: &models.Portfolio{
+				UserID:             "user1",
+				OriginalAllocation: map[string]float64{"us": 60.0, "bonds": 40.0},
+			},
+			expectDrift: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := withPlanner(&RebalanceHandler{
+				rebalanceService: &mockRebalanceService{
+					driftFunc: func(currentAllocation map[string]float64, targetTree []models.AllocationNode) []models.AllocationDrift {
+						return []models.AllocationDrift{{Asset: "equities", Current: 70.0, Target: 60.0, Drift: 10.0}}
+					},
+				},
+				portfolioService: &mockPortfolioService{
+					getFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+						return tt.portfolio, nil
+					},
+				},
+			}, &mockAssetValidator{})
+
+			body, _ := json.Marshal(map[string]interface{}{
+				"user_id":        "user1",
+				"new_allocation": map[string]float64{"us": 70.0, "bonds": 30.0},
+			})
+			req := httptest.NewRequest(http.MethodPost, "/rebalance", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			handler.HandleRebalance(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+			}
+
+			var response map[string]interface{}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if _, exists := response["drift"]; exists != tt.expectDrift {
+				t.Errorf("expected drift present=%v, got %v", tt.expectDrift, exists)
+			}
+		})
+	}
+}
+
+func TestHandleRebalanceWithConstraints(t *testing.T) {
+	maxStocks := 60.0
+	portfolio := &models.Portfolio{
+		UserID:             "user1",
+		OriginalAllocation: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+		Constraints: map[string]models.AssetConstraint{
+			"stocks": {Max: &maxStocks},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		mockApply      func(currentAllocation, targetAllocation map[string]float64, constraints map[string]models.AssetConstraint) (*models.ConstraintResult, error)
+		expectedStatus int
+		expectedTarget map[string]float64
+	}{
+		{
+			name: "rebalances to the feasible target",
+			mockApply: func(currentAllocation, targetAllocation map[string]float64, constraints map[string]models.AssetConstraint) (*models.ConstraintResult, error) {
+				return &models.ConstraintResult{
+					Target:     map[string]float64{"stocks": 60.0, "bonds": 40.0},
+					Adjusted:   true,
+					Violations: []string{"stocks target 70.00% is above its maximum of 60.00%"},
+				}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedTarget: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		},
+		{
+			name: "infeasible constraints",
+			mockApply: func(currentAllocation, targetAllocation map[string]float64, constraints map[string]models.AssetConstraint) (*models.ConstraintResult, error) {
+				return nil, &services.ConstraintError{Err: services.ErrInfeasibleConstraints}
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calculatedTarget map[string]float64
+			handler := withPlanner(&RebalanceHandler{
+				rebalanceService: &mockRebalanceService{
+					applyFunc: tt.mockApply,
+					calculateFunc: func(currentAllocation, targetAllocation map[string]float64, userID string) []models.RebalanceTransaction {
+						calculatedTarget = targetAllocation
+						return nil
+					},
+				},
+				portfolioService: &mockPortfolioService{
+					getFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+						return portfolio, nil
+					},
+				},
+			}, &mockAssetValidator{})
+
+			body, _ := json.Marshal(map[string]interface{}{
+				"user_id":        "user1",
+				"new_allocation": map[string]float64{"stocks": 80.0, "bonds": 20.0},
+			})
+			req := httptest.NewRequest(http.MethodPost, "/rebalance", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			handler.HandleRebalance(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			for asset, percent := range tt.expectedTarget {
+				if calculatedTarget[asset] != percent {
+					t.Errorf("expected rebalance towards %s=%.2f, got %.2f", asset, percent, calculatedTarget[asset])
+				}
+			}
+		})
+	}
+}
+
+func TestHandleCashFlow(t *testing.T) {
+	portfolio := &models.Portfolio{
+		UserID:             "user1",
+		Allocation:         map[string]float64{"stocks": 70.0, "bonds": 30.0},
+		OriginalAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		TotalValue:         10000,
+	}
+
+	tests := []struct {
+		name             string
+		method           string
+		requestBody      interface{}
+		mockPortfolio    *models.Portfolio
+		mockPortfolioErr error
+		mockCashFlowErr  error
+		mockConvertErr   error
+		mockPublishErr   error
+		expectedStatus   int
+		expectedValue    float64
+		expectedSource   string
+	}{
+		{
+			name:   "successful deposit",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"user_id": "user1",
+				"type":    models.CashFlowDeposit,
+				"amount":  1000,
+			},
+			mockPortfolio:  portfolio,
+			expectedStatus: http.StatusOK,
+			expectedValue:  10000,
+			expectedSource: models.AllocationSourceCashFlow,
+		},
+		{
+			name:   "reported value overrides stored value",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"user_id":         "user1",
+				"type":            models.CashFlowWithdrawal,
+				"amount":          1000,
+				"portfolio_value": 12000,
+			},
+			mockPortfolio:  portfolio,
+			expectedStatus: http.StatusOK,
+			expectedValue:  12000,
+			expectedSource: models.AllocationSourceCashFlow,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "invalid JSON",
+			method:         http.MethodPost,
+			requestBody:    "invalid json",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown type",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"user_id": "user1",
+				"type":    "TRANSFER",
+				"amount":  1000,
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "negative amount",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"user_id": "user1",
+				"type":    models.CashFlowDeposit,
+				"amount":  -5,
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "portfolio not found",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"user_id": "user1",
+				"type":    models.CashFlowDeposit,
+				"amount":  1000,
+			},
+			mockPortfolioErr: &services.NotFoundError{Resource: "portfolio", ID: "1"},
+			expectedStatus:   http.StatusNotFound,
+		},
+		{
+			name:   "withdrawal without a known value",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"user_id": "user1",
+				"type":    models.CashFlowWithdrawal,
+				"amount":  1000,
+			},
+			mockPortfolio:  &models.Portfolio{UserID: "user1", Allocation: portfolio.Allocation, OriginalAllocation: portfolio.OriginalAllocation},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "withdrawal above value",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"user_id": "user1",
+				"type":    models.CashFlowWithdrawal,
+				"amount":  20000,
+			},
+			mockPortfolio:   portfolio,
+			mockCashFlowErr: &services.ValidationError{Message: "withdrawal of 20000.00 exceeds portfolio value of 10000.00"},
+			expectedStatus:  http.StatusBadRequest,
+		},
+		{
+			name:   "deposit no asset may take",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"user_id": "user1",
+				"type":    models.CashFlowDeposit,
+				"amount":  1000,
+			},
+			mockPortfolio:   portfolio,
+			mockCashFlowErr: &services.ConstraintError{Err: fmt.Errorf("%w: 1000.00 of the deposit cannot be traded without breaching a constraint", services.ErrInfeasibleConstraints)},
+			expectedStatus:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "no FX rate for the deposit currency",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"user_id":  "user1",
+				"type":     models.CashFlowDeposit,
+				"amount":   1000,
+				"currency": "JPY",
+			},
+			mockPortfolio:  portfolio,
+			mockConvertErr: &services.FXError{From: "JPY", To: "USD", Err: errors.New("no FX rate from JPY to USD")},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "publish error",
+			method: http.MethodPost,
+			requestBody: map[string]interface{}{
+				"user_id": "user1",
+				"type":    models.CashFlowDeposit,
+				"amount":  1000,
+			},
+			mockPortfolio:  portfolio,
+			mockPublishErr: errors.New("kafka error"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotValue float64
+			var gotSource string
+			handler := &RebalanceHandler{
+				rebalanceService: &mockRebalanceService{
+					cashFlowFunc: func(currentAllocation, targetAllocation map[string]float64, flow models.CashFlow) (*models.CashFlowResult, error) {
+						if tt.mockCashFlowErr != nil {
+							return nil, tt.mockCashFlowErr
+						}
+						gotValue = flow.PortfolioValue
+						return &models.CashFlowResult{NewAllocation: currentAllocation, NewValue: flow.PortfolioValue}, nil
+					},
+					convertFunc: func(ctx context.Context, flow models.CashFlow, currency string) (models.CashFlow, error) {
+						flow.Currency = currency
+						return flow, tt.mockConvertErr
+					},
+					publishFunc: func(ctx context.Context, transactions []models.RebalanceTransaction) error {
+						return tt.mockPublishErr
+					},
+				},
+				portfolioService: &mockPortfolioService{
+					getFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+						if tt.mockPortfolioErr != nil {
+							return nil, tt.mockPortfolioErr
+						}
+						p := *tt.mockPortfolio
+						return &p, nil
+					},
+					sourceFunc: func(ctx context.Context, portfolio models.Portfolio, source string) error {
+						gotSource = source
+						return nil
+					},
+				},
+			}
+
+			var body []byte
+			if str, ok := tt.requestBody.(string); ok {
+				body = []byte(str)
+			} else if tt.requestBody != nil {
+				body, _ = json.Marshal(tt.requestBody)
+			}
+
+			req := httptest.NewRequest(tt.method, "/portfolio/cashflow", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			handler.HandleCashFlow(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if gotValue != tt.expectedValue {
+				t.Errorf("expected portfolio value %.2f, got %.2f", tt.expectedValue, gotValue)
+			}
+			if gotSource != tt.expectedSource {
+				t.Errorf("expected history source %s, got %s", tt.expectedSource, gotSource)
+			}
+		})
+	}
+}
+
+func TestHandleRebalanceSwitchMode(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectSwitches bool
+	}{
+		{
+			name:           "default mode returns legs only",
+			url:            "/rebalance",
+			expectedStatus: http.StatusOK,
+			expectSwitches: false,
+		},
+		{
+			name:           "switches mode adds switches",
+			url:            "/rebalance?mode=switches",
+			expectedStatus: http.StatusOK,
+			expectSwitches: true,
+		},
+		{
+			name:           "unknown mode",
+			url:            "/rebalance?mode=netted",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := withPlanner(&RebalanceHandler{
+				rebalanceService: &mockRebalanceService{
+					pairFunc: func(transactions []models.RebalanceTransaction) []models.RebalanceSwitch {
+						return []models.RebalanceSwitch{{RebalanceID: "rb1", From: "stocks", To: "bonds", RebalancePercent: 10.0}}
+					},
+				},
+				portfolioService: &mockPortfolioService{
+					getFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+						return &models.Portfolio{
+							UserID:             "user1",
+							OriginalAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+						}, nil
+					},
+				},
+			}, &mockAssetValidator{})
+
+			body, _ := json.Marshal(map[string]interface{}{
+				"user_id":        "user1",
+				"new_allocation": map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			})
+			req := httptest.NewRequest(http.MethodPost, tt.url, bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			handler.HandleRebalance(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var response map[string]interface{}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if _, exists := response["switches"]; exists != tt.expectSwitches {
+				t.Errorf("expected switches present=%v, got %v", tt.expectSwitches, exists)
+			}
+		})
+	}
+}
+
+func TestHandleRebalanceAssetValidation(t *testing.T) {
+	tests := []struct {
+		name            string
+		mockValidate    func(ctx context.Context, allocation map[string]float64, portfolio models.Portfolio) (*models.AssetValidation, error)
+		expectedStatus  int
+		expectedDetails []FieldError
+		expectedTarget  map[string]float64
+	}{
+		{
+			name: "strict mode rejects a typo",
+			mockValidate: func(ctx context.Context, allocation map[string]float64, portfolio models.Portfolio) (*models.AssetValidation, error) {
+				return nil, &services.AssetUniverseError{Unknown: []string{"stock"}, Missing: []string{"stocks"}}
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedDetails: []FieldError{
+				{Field: "new_allocation.stock", Message: "unknown asset"},
+				{Field: "new_allocation.stocks", Message: "missing asset held or targeted by the portfolio"},
+			},
+		},
+		{
+			name: "alias mode rebalances the canonical asset",
+			mockValidate: func(ctx context.Context, allocation map[string]float64, portfolio models.Portfolio) (*models.AssetValidation, error) {
+				return &models.AssetValidation{
+					Mode:       models.AssetValidationAlias,
+					Allocation: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+					Aliased:    map[string]string{"stock": "stocks"},
+				}, nil
+			},
+			expectedStatus: http.StatusOK,
+			expectedTarget: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+		},
+		{
+			name: "validator failure",
+			mockValidate: func(ctx context.Context, allocation map[string]float64, portfolio models.Portfolio) (*models.AssetValidation, error) {
+				return nil, errors.New("registry unavailable")
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calculated map[string]float64
+			handler := withPlanner(&RebalanceHandler{
+				rebalanceService: &mockRebalanceService{
+					calculateFunc: func(currentAllocation, targetAllocation map[string]float64, userID string) []models.RebalanceTransaction {
+						calculated = currentAllocation
+						return nil
+					},
+				},
+				portfolioService: &mockPortfolioService{
+					getFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+						return &models.Portfolio{
+							UserID:             "user1",
+							Allocation:         map[string]float64{"stocks": 60.0, "bonds": 40.0},
+							OriginalAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+						}, nil
+					},
+				},
+			}, &mockAssetValidator{validateFunc: tt.mockValidate})
+
+			body, _ := json.Marshal(map[string]interface{}{
+				"user_id":        "user1",
+				"new_allocation": map[string]float64{"stock": 70.0, "bonds": 30.0},
+			})
+			req := httptest.NewRequest(http.MethodPost, "/rebalance", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			handler.HandleRebalance(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+
+			if tt.expectedDetails != nil {
+				var response Problem
+				if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if !reflect.DeepEqual(response.Details, tt.expectedDetails) {
+					t.Errorf("expected details %+v, got %+v", tt.expectedDetails, response.Details)
+				}
+			}
+			if tt.expectedTarget != nil && !reflect.DeepEqual(calculated, tt.expectedTarget) {
+				t.Errorf("expected rebalance from %v, got %v", tt.expectedTarget, calculated)
+			}
+		})
+	}
+}
+
+func TestHandleRebalanceAwaitingApproval(t *testing.T) {
+	var flowed *models.Portfolio
+	handler := withPlanner(&RebalanceHandler{
+		rebalanceService: &mockRebalanceService{
+			calculateFunc: func(currentAllocation, targetAllocation map[string]float64, userID string) []models.RebalanceTransaction {
+				return []models.RebalanceTransaction{
+					{RebalanceID: "rb1", UserID: userID, Action: "SELL", Asset: "stocks", RebalancePercent: 10.0},
+					{RebalanceID: "rb1", UserID: userID, Action: "BUY", Asset: "bonds", RebalancePercent: 10.0},
+				}
+			},
+			submitFunc: func(ctx context.Context, portfolio models.Portfolio, transactions []models.RebalanceTransaction, expected map[string]float64, actor string) (*models.Rebalance, error) {
+				return &models.Rebalance{ID: transactions[0].RebalanceID, Status: models.RebalanceStatusProposed, Transactions: transactions}, nil
+			},
+		},
+		portfolioService: &mockPortfolioService{
+			getFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+				return &models.Portfolio{
+					UserID:             "user1",
+					OriginalAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+					Allocation:         map[string]float64{"stocks": 60.0, "bonds": 40.0},
+					TotalValue:         10000,
+					RequireApproval:    true,
+				}, nil
+			},
+			sourceFunc: func(ctx context.Context, portfolio models.Portfolio, source string) error {
+				flowed = &portfolio
+				return nil
+			},
+		},
+	}, &mockAssetValidator{})
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"user_id":        "user1",
+		"new_allocation": map[string]float64{"stocks": 70.0, "bonds": 30.0},
+	})
+	w := httptest.NewRecorder()
+	handler.HandleRebalance(w, httptest.NewRequest(http.MethodPost, "/rebalance", bytes.NewBuffer(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var response map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response["status"] != models.RebalanceStatusProposed || response["rebalance_id"] != "rb1" {
+		t.Errorf("expected proposed rebalance rb1, got %v %v", response["status"], response["rebalance_id"])
+	}
+
+	// A proposed cash flow changes the value at once, but not the allocation before it executes
+	body, _ = json.Marshal(map[string]interface{}{
+		"user_id": "user1",
+		"type":    models.CashFlowDeposit,
+		"amount":  1000,
+	})
+	w = httptest.NewRecorder()
+	handler.rebalanceService.(*mockRebalanceService).cashFlowFunc = func(currentAllocation, targetAllocation map[string]float64, flow models.CashFlow) (*models.CashFlowResult, error) {
+		return &models.CashFlowResult{
+			Transactions:  []models.RebalanceTransaction{{RebalanceID: "rb2", Action: "BUY", Asset: "bonds", RebalancePercent: 5.0}},
+			NewAllocation: map[string]float64{"stocks": 55.0, "bonds": 45.0},
+			NewValue:      11000,
+		}, nil
+	}
+	handler.HandleCashFlow(w, httptest.NewRequest(http.MethodPost, "/portfolio/cashflow", bytes.NewBuffer(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if flowed == nil || flowed.TotalValue != 11000 || len(flowed.Allocation) > 0 {
+		t.Errorf("expected only the portfolio value to change while the cash flow awaits approval, got %+v", flowed)
+	}
+}
+
+func TestHandleRebalanceDecision(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		mockErr        error
+		expectedStatus int
+		expectedResult string
+	}{
+		{
+			name:           "approve",
+			method:         http.MethodPost,
+			path:           "/rebalance/approve",
+			body:           `{"rebalance_id": "rb1", "actor": "jane"}`,
+			expectedStatus: http.StatusOK,
+			expectedResult: models.RebalanceStatusApproved,
+		},
+		{
+			name:           "reject",
+			method:         http.MethodPost,
+			path:           "/rebalance/reject",
+			body:           `{"rebalance_id": "rb1", "actor": "jane", "reason": "outside mandate"}`,
+			expectedStatus: http.StatusOK,
+			expectedResult: models.RebalanceStatusRejected,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodGet,
+			path:           "/rebalance/approve",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "invalid json",
+			method:         http.MethodPost,
+			path:           "/rebalance/approve",
+			body:           `{`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "missing rebalance_id",
+			method:         http.MethodPost,
+			path:           "/rebalance/approve",
+			body:           `{"actor": "jane"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "missing actor",
+			method:         http.MethodPost,
+			path:           "/rebalance/reject",
+			body:           `{"rebalance_id": "rb1"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "not found",
+			method:         http.MethodPost,
+			path:           "/rebalance/approve",
+			body:           `{"rebalance_id": "rb1", "actor": "jane"}`,
+			mockErr:        services.ErrRebalanceNotFound,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "already decided",
+			method:         http.MethodPost,
+			path:           "/rebalance/reject",
+			body:           `{"rebalance_id": "rb1", "actor": "jane"}`,
+			mockErr:        &services.ConflictError{Err: services.ErrInvalidTransition},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "publish failure",
+			method:         http.MethodPost,
+			path:           "/rebalance/approve",
+			body:           `{"rebalance_id": "rb1", "actor": "jane"}`,
+			mockErr:        errors.New("kafka down"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decide := func(status string) func(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, error) {
+				return func(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, error) {
+					if tt.mockErr != nil {
+						return nil, tt.mockErr
+					}
+					return &models.Rebalance{ID: decision.RebalanceID, Status: status}, nil
+				}
+			}
+			handler := &RebalanceHandler{
+				rebalanceService: &mockRebalanceService{
+					approveFunc: decide(models.RebalanceStatusApproved),
+					rejectFunc:  decide(models.RebalanceStatusRejected),
+				},
+				portfolioService: &mockPortfolioService{},
+			}
+			mux := http.NewServeMux()
+			mux.HandleFunc("/rebalance/approve", handler.HandleApproveRebalance)
+			mux.HandleFunc("/rebalance/reject", handler.HandleRejectRebalance)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body)))
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedResult != "" {
+				var response models.Rebalance
+				if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if response.Status != tt.expectedResult {
+					t.Errorf("expected status %s, got %s", tt.expectedResult, response.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleRebalances(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		expectedStatus int
+	}{
+		{name: "get by id", method: http.MethodGet, query: "?id=rb1", expectedStatus: http.StatusOK},
+		{name: "unknown id", method: http.MethodGet, query: "?id=missing", expectedStatus: http.StatusNotFound},
+		{name: "list by user", method: http.MethodGet, query: "?user_id=user1&status=PROPOSED", expectedStatus: http.StatusOK},
+		{name: "missing query", method: http.MethodGet, expectedStatus: http.StatusBadRequest},
+		{name: "method not allowed", method: http.MethodPost, query: "?id=rb1", expectedStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &RebalanceHandler{
+				rebalanceService: &mockRebalanceService{
+					getFunc: func(ctx context.Context, id string) (*models.Rebalance, error) {
+						if id != "rb1" {
+							return nil, services.ErrRebalanceNotFound
+						}
+						return &models.Rebalance{ID: id, Status: models.RebalanceStatusProposed}, nil
+					},
+					listFunc: func(ctx context.Context, userID, status string) ([]models.Rebalance, error) {
+						if status != models.RebalanceStatusProposed {
+							t.Errorf("expected status filter %s, got %s", models.RebalanceStatusProposed, status)
+						}
+						return []models.Rebalance{{ID: "rb1", UserID: userID, Status: status}}, nil
+					},
+				},
+				portfolioService: &mockPortfolioService{},
+			}
+
+			w := httptest.NewRecorder()
+			handler.HandleRebalances(w, httptest.NewRequest(tt.method, "/rebalances"+tt.query, nil))
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
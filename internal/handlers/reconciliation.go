@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"portfolio-rebalancer/internal/services"
+	"portfolio-rebalancer/pkg/router"
+	"time"
+)
+
+// defaultSummaryWindow is how far back the summary looks when no since is given
+const defaultSummaryWindow = 24 * time.Hour
+
+type ReconciliationHandler struct {
+	reconciliationService services.ReconciliationService
+}
+
+// NewReconciliationHandler creates a new reconciliation handler with injected dependencies
+func NewReconciliationHandler(mux *router.Router, reconciliationService services.ReconciliationService) {
+	handler := &ReconciliationHandler{
+		reconciliationService: reconciliationService,
+	}
+
+	// Register routes
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/{user_id}/reconciliations", handler.HandleReconciliation)
+	mux.HandleFunc(http.MethodPost, "/v1/portfolios/{user_id}/reconciliations", handler.HandleReconciliation)
+	mux.HandleFunc(http.MethodGet, "/v1/reconciliations/summary", handler.HandleReconciliationSummary)
+
+	// Unversioned routes, deprecated in favour of /v1
+	mux.HandleFunc(router.AnyMethod, "/reconciliation", deprecated(handler.HandleReconciliation))
+	mux.HandleFunc(router.AnyMethod, "/reconciliation/summary", deprecated(handler.HandleReconciliationSummary))
+}
+
+// HandleReconciliation lists a user's reconciliation reports, newest first, or reconciles
+// the portfolio right away
+// GET /v1/portfolios/1/reconciliations
+// POST /v1/portfolios/1/reconciliations
+// GET /reconciliation?user_id=1
+func (h *ReconciliationHandler) HandleReconciliation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only GET and POST methods are allowed")
+		return
+	}
+
+	userID := param(r, "user_id")
+	if userID == "" {
+		respondWithInvalidField(w, "user_id", "user_id query parameter is required")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		report, err := h.reconciliationService.Reconcile(r.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to reconcile portfolio for user %s: %v", userID, err)
+			respondWithServiceError(w, err, "Failed to reconcile portfolio. Please try again")
+			return
+		}
+		RespondWithJSON(w, http.StatusOK, report)
+		return
+	}
+
+	reports, err := h.reconciliationService.ListReports(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list reconciliation reports for user %s: %v", userID, err)
+		respondWithServiceError(w, err, "Failed to retrieve reconciliation reports")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"reports": reports,
+		"count":   len(reports),
+	})
+}
+
+// HandleReconciliationSummary counts matched, broken and pending portfolios by their latest
+// report since an RFC3339 time, the last 24 hours by default
+// GET /v1/reconciliations/summary?since=2024-01-01T00:00:00Z
+func (h *ReconciliationHandler) HandleReconciliationSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	since := time.Now().Add(-defaultSummaryWindow)
+	if value := r.URL.Query().Get("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondWithInvalidField(w, "since", "since must be an RFC3339 timestamp")
+			return
+		}
+		since = parsed
+	}
+
+	summary, err := h.reconciliationService.Summary(r.Context(), since)
+	if err != nil {
+		log.Printf("Failed to summarize reconciliation: %v", err)
+		respondWithServiceError(w, err, "Failed to retrieve reconciliation summary")
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, summary)
+}
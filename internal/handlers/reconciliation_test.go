@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/services"
+)
+
+// Mock reconciliation service
+type mockReconciliationService struct {
+	reconcileFunc func(ctx context.Context, userID string) (*models.ReconciliationReport, error)
+	listFunc      func(ctx context.Context, userID string) ([]models.ReconciliationReport, error)
+	summaryFunc   func(ctx context.Context, since time.Time) (*models.ReconciliationSummary, error)
+}
+
+func (m *mockReconciliationService) Reconcile(ctx context.Context, userID string) (*models.ReconciliationReport, error) {
+	if m.reconcileFunc != nil {
+		return m.reconcileFunc(ctx, userID)
+	}
+	return &models.ReconciliationReport{UserID: userID, Status: models.ReconciliationStatusMatched}, nil
+}
+
+func (m *mockReconciliationService) RunAll(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (m *mockReconciliationService) Start(ctx context.Context) {}
+
+func (m *mockReconciliationService) ListReports(ctx context.Context, userID string) ([]models.ReconciliationReport, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx, userID)
+	}
+	return []models.ReconciliationReport{}, nil
+}
+
+func (m *mockReconciliationService) Summary(ctx context.Context, since time.Time) (*models.ReconciliationSummary, error) {
+	if m.summaryFunc != nil {
+		return m.summaryFunc(ctx, since)
+	}
+	return &models.ReconciliationSummary{Since: since.Format(time.RFC3339)}, nil
+}
+
+func TestHandleReconciliation(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		service        *mockReconciliationService
+		expectedStatus int
+	}{
+		{
+			name:           "list reports",
+			method:         http.MethodGet,
+			url:            "/reconciliation?user_id=1",
+			service:        &mockReconciliationService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing user_id",
+			method:         http.MethodGet,
+			url:            "/reconciliation",
+			service:        &mockReconciliationService{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "list error",
+			method: http.MethodGet,
+			url:    "/reconciliation?user_id=1",
+			service: &mockReconciliationService{
+				listFunc: func(ctx context.Context, userID string) ([]models.ReconciliationReport, error) {
+					return nil, errors.New("es down")
+				},
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "reconcile now",
+			method:         http.MethodPost,
+			url:            "/reconciliation?user_id=1",
+			service:        &mockReconciliationService{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "reconcile unknown portfolio",
+			method: http.MethodPost,
+			url:    "/reconciliation?user_id=missing",
+			service: &mockReconciliationService{
+				reconcileFunc: func(ctx context.Context, userID string) (*models.ReconciliationReport, error) {
+					return nil, &services.NotFoundError{Resource: "portfolio", ID: userID}
+				},
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodDelete,
+			url:            "/reconciliation?user_id=1",
+			service:        &mockReconciliationService{},
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &ReconciliationHandler{
+				reconciliationService: tt.service,
+			}
+
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			w := httptest.NewRecorder()
+
+			handler.HandleReconciliation(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandleReconciliationSummary(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		expectedSince  string
+		expectedStatus int
+	}{
+		{
+			name:           "explicit since",
+			method:         http.MethodGet,
+			url:            "/reconciliation/summary?since=2024-05-01T00:00:00Z",
+			expectedSince:  "2024-05-01T00:00:00Z",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "default window",
+			method:         http.MethodGet,
+			url:            "/reconciliation/summary",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "invalid since",
+			method:         http.MethodGet,
+			url:            "/reconciliation/summary?since=yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodPost,
+			url:            "/reconciliation/summary",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var since time.Time
+			handler := &ReconciliationHandler{
+				reconciliationService: &mockReconciliationService{
+					summaryFunc: func(ctx context.Context, s time.Time) (*models.ReconciliationSummary, error) {
+						since = s
+						return &models.ReconciliationSummary{}, nil
+					},
+				},
+			}
+
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			w := httptest.NewRecorder()
+
+			handler.HandleReconciliationSummary(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedSince != "" && since.Format(time.RFC3339) != tt.expectedSince {
+				t.Errorf("expected since %s, got %s", tt.expectedSince, since.Format(time.RFC3339))
+			}
+			if tt.name == "default window" && time.Since(since) < 23*time.Hour {
+				t.Errorf("expected the summary to default to the last 24 hours, got since %s", since)
+			}
+		})
+	}
+}
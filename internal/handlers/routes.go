@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"portfolio-rebalancer/pkg/router"
+)
+
+// legacyDeprecation is the Deprecation header (RFC 9745) of the unversioned routes: the
+// unix time the /v1 routes replaced them
+const legacyDeprecation = "@1792281600"
+
+// legacyDeprecationLink points clients of the unversioned routes to the document listing
+// their /v1 successors
+const legacyDeprecationLink = `</openapi.json>; rel="deprecation"; type="application/json"`
+
+// NewRouter creates the router every handler registers its routes on. Unknown routes and
+// methods get problem responses like any other error.
+func NewRouter() *router.Router {
+	mux := router.New()
+	mux.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RespondWithError(w, http.StatusNotFound, "No route matches "+r.URL.Path)
+	})
+	mux.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RespondWithError(w, http.StatusMethodNotAllowed, "Only "+w.Header().Get("Allow")+" methods are allowed")
+	})
+	return mux
+}
+
+// deprecated marks every response of an unversioned route as deprecated in favour of /v1
+func deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", legacyDeprecation)
+		w.Header().Add("Link", legacyDeprecationLink)
+		next(w, r)
+	}
+}
+
+// param returns the route's path parameter name or, on the unversioned routes that take it
+// in the query instead, the query parameter
+func param(r *http.Request, name string) string {
+	if value := router.Param(r, name); value != "" {
+		return value
+	}
+	return r.URL.Query().Get(name)
+}
+
+// bindPathParam sets field, decoded from the body of an unversioned route, to the route's
+// path parameter name when it has one
+func bindPathParam(r *http.Request, name string, field *string) {
+	if value := router.Param(r, name); value != "" {
+		*field = value
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"portfolio-rebalancer/pkg/router"
+)
+
+func TestRouter(t *testing.T) {
+	mux := NewRouter()
+	respond := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			RespondWithJSON(w, http.StatusOK, map[string]string{
+				"handler": name,
+				"user_id": param(r, "user_id"),
+			})
+		}
+	}
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/{user_id}", respond("get"))
+	mux.HandleFunc(http.MethodPut, "/v1/portfolios/{user_id}", respond("put"))
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/summary", respond("summary"))
+	mux.HandleFunc(router.AnyMethod, "/portfolio", deprecated(respond("legacy")))
+
+	tests := []struct {
+		name              string
+		method            string
+		url               string
+		expectedStatus    int
+		expectedHandler   string
+		expectedUserID    string
+		expectedAllow     string
+		expectedDeprecate bool
+	}{
+		{name: "path parameter", method: http.MethodGet, url: "/v1/portfolios/1", expectedStatus: http.StatusOK, expectedHandler: "get", expectedUserID: "1"},
+		{name: "escaped path parameter", method: http.MethodGet, url: "/v1/portfolios/a%2Fb", expectedStatus: http.StatusOK, expectedHandler: "get", expectedUserID: "a/b"},
+		{name: "routed by method", method: http.MethodPut, url: "/v1/portfolios/1", expectedStatus: http.StatusOK, expectedHandler: "put", expectedUserID: "1"},
+		{name: "literal beats parameter", method: http.MethodGet, url: "/v1/portfolios/summary", expectedStatus: http.StatusOK, expectedHandler: "summary"},
+		{name: "head served by get", method: http.MethodHead, url: "/v1/portfolios/1", expectedStatus: http.StatusOK},
+		{name: "method not allowed", method: http.MethodDelete, url: "/v1/portfolios/1", expectedStatus: http.StatusMethodNotAllowed, expectedAllow: "GET, HEAD, PUT"},
+		{name: "unknown route", method: http.MethodGet, url: "/v1/portfolios/1/unknown", expectedStatus: http.StatusNotFound},
+		{name: "empty parameter", method: http.MethodGet, url: "/v1/portfolios/", expectedStatus: http.StatusNotFound},
+		{name: "legacy route", method: http.MethodGet, url: "/portfolio?user_id=1", expectedStatus: http.StatusOK, expectedHandler: "legacy", expectedUserID: "1", expectedDeprecate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, nil))
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if allow := w.Header().Get("Allow"); allow != tt.expectedAllow {
+				t.Errorf("expected Allow %q, got %q", tt.expectedAllow, allow)
+			}
+			if deprecation := w.Header().Get("Deprecation"); (deprecation == legacyDeprecation) != tt.expectedDeprecate {
+				t.Errorf("expected deprecated %v, got Deprecation %q", tt.expectedDeprecate, deprecation)
+			}
+
+			if tt.method == http.MethodHead {
+				return
+			}
+			if tt.expectedStatus != http.StatusOK {
+				if contentType := w.Header().Get("Content-Type"); contentType != ProblemContentType {
+					t.Errorf("expected a problem response, got %q", contentType)
+				}
+				return
+			}
+			var response map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if response["handler"] != tt.expectedHandler || response["user_id"] != tt.expectedUserID {
+				t.Errorf("expected handler %q for user %q, got %+v", tt.expectedHandler, tt.expectedUserID, response)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/handlers"
+	"portfolio-rebalancer/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minAdminKeyLength keeps operator supplied admin keys as hard to guess as generated ones
+const minAdminKeyLength = 32
+
+// Authenticator resolves an API key to the client it was issued to
+type Authenticator interface {
+	Authenticate(ctx context.Context, key string) (*models.APIKey, error)
+}
+
+// TokenVerifier checks an end user's bearer token and returns the user it was issued to
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*auth.User, error)
+}
+
+// AuthConfig controls API key and end-user token authentication
+type AuthConfig struct {
+	Enabled      bool
+	AdminKey     string        // installed with the admin scope on startup so the first keys can be issued
+	JWKSURL      string        // where the identity provider publishes its token signing keys
+	JWKSFile     string        // a local key set used instead of JWKSURL, for development and tests
+	JWKSRefresh  time.Duration // how long fetched signing keys are cached
+	JWTIssuer    string        // required iss claim, unchecked when empty
+	JWTAudience  string        // required aud claim, unchecked when empty
+	JWTAdminRole string        // role in the roles claim that may read every user's data
+}
+
+// AuthConfigFromEnv reads AUTH_ENABLED (default true), AUTH_ADMIN_KEY, which must be at least
+// 32 characters when set, and the end-user token settings AUTH_JWKS_URL or AUTH_JWKS_FILE,
+// AUTH_JWKS_REFRESH (default 1h), AUTH_JWT_ISSUER, AUTH_JWT_AUDIENCE and AUTH_JWT_ADMIN_ROLE
+// (default admin). End-user tokens are refused when neither key set is configured.
+func AuthConfigFromEnv() (AuthConfig, error) {
+	config := AuthConfig{
+		Enabled:      true,
+		JWKSRefresh:  time.Hour,
+		JWTAdminRole: "admin",
+	}
+
+	if value := os.Getenv("AUTH_ENABLED"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid AUTH_ENABLED %q, expected true or false", value)
+		}
+		config.Enabled = enabled
+	}
+
+	config.AdminKey = os.Getenv("AUTH_ADMIN_KEY")
+	if config.AdminKey != "" && len(config.AdminKey) < minAdminKeyLength {
+		return config, fmt.Errorf("AUTH_ADMIN_KEY must be at least %d characters", minAdminKeyLength)
+	}
+
+	config.JWKSURL = os.Getenv("AUTH_JWKS_URL")
+	config.JWKSFile = os.Getenv("AUTH_JWKS_FILE")
+	if config.JWKSURL != "" && config.JWKSFile != "" {
+		return config, fmt.Errorf("set only one of AUTH_JWKS_URL and AUTH_JWKS_FILE")
+	}
+
+	if value := os.Getenv("AUTH_JWKS_REFRESH"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return config, fmt.Errorf("invalid AUTH_JWKS_REFRESH %q, expected a duration such as 1h", value)
+		}
+		config.JWKSRefresh = d
+	}
+
+	config.JWTIssuer = os.Getenv("AUTH_JWT_ISSUER")
+	config.JWTAudience = os.Getenv("AUTH_JWT_AUDIENCE")
+	if value := os.Getenv("AUTH_JWT_ADMIN_ROLE"); value != "" {
+		config.JWTAdminRole = value
+	}
+
+	return config, nil
+}
+
+// NewTokenVerifier builds the end-user token verifier the config describes, or returns nil
+// when no key set is configured
+func NewTokenVerifier(config AuthConfig) (TokenVerifier, error) {
+	var keys auth.KeySet
+	switch {
+	case config.JWKSFile != "":
+		data, err := os.ReadFile(config.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read AUTH_JWKS_FILE: %w", err)
+		}
+		static, err := auth.NewStaticKeySet(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = static
+	case config.JWKSURL != "":
+		keys = auth.NewRemoteKeySet(config.JWKSURL, config.JWKSRefresh)
+	default:
+		return nil, nil
+	}
+
+	return auth.NewTokenVerifier(keys, config.JWTIssuer, config.JWTAudience, config.JWTAdminRole), nil
+}
+
+// Rule is the scope a route requires: Read for GET and HEAD, Write for every other method.
+// User routes can also be read with an end-user token, limited by the handler to the user's own data.
+// Public routes can be read without any credentials.
+type Rule struct {
+	Read   string
+	Write  string
+	User   bool
+	Public bool
+}
+
+// Policy maps each route, the pattern the router matched, to the scope it requires. Routes
+// missing from the policy need admin.
+type Policy map[string]Rule
+
+// DefaultPolicy is the scope every route of the API requires. The provider only needs
+// rebalance:submit; the asset registry, model portfolios, API keys and the audit trail are
+// managed by admins.
+// End users can read their portfolio, its history, rebalances, transactions and executions.
+// The OpenAPI document is public.
+func DefaultPolicy() Policy {
+	return Policy{
+		"/v1/portfolios":                           {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioWrite},
+		"/v1/portfolios/{user_id}":                 {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioWrite, User: true},
+		"/v1/portfolios/{user_id}/history":         {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioRead, User: true},
+		"/v1/portfolios/{user_id}/schedule":        {Read: auth.ScopePortfolioWrite, Write: auth.ScopePortfolioWrite},
+		"/v1/portfolios/{user_id}/cashflows":       {Read: auth.ScopePortfolioWrite, Write: auth.ScopePortfolioWrite},
+		"/v1/portfolios/{user_id}/rebalances":      {Read: auth.ScopePortfolioRead, Write: auth.ScopeRebalanceSubmit, User: true},
+		"/v1/portfolios/{user_id}/transactions":    {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioRead, User: true},
+		"/v1/portfolios/{user_id}/reconciliations": {Read: auth.ScopePortfolioRead, Write: auth.ScopeAdmin},
+		"/v1/portfolios/{user_id}/audit":           {Read: auth.ScopeAdmin, Write: auth.ScopeAdmin},
+		"/v1/rebalances/{rebalance_id}":            {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioRead, User: true},
+		"/v1/rebalances/{rebalance_id}/approve":    {Read: auth.ScopePortfolioWrite, Write: auth.ScopePortfolioWrite},
+		"/v1/rebalances/{rebalance_id}/reject":     {Read: auth.ScopePortfolioWrite, Write: auth.ScopePortfolioWrite},
+		"/v1/rebalances/{rebalance_id}/executions": {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioRead, User: true},
+		"/v1/reconciliations/summary":              {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioRead},
+		"/v1/models":                               {Read: auth.ScopePortfolioRead, Write: auth.ScopeAdmin},
+		"/v1/models/{id}":                          {Read: auth.ScopePortfolioRead, Write: auth.ScopeAdmin},
+		"/v1/models/{id}/preview":                  {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioRead},
+		"/v1/assets":                               {Read: auth.ScopePortfolioRead, Write: auth.ScopeAdmin},
+		"/v1/assets/{id}":                          {Read: auth.ScopePortfolioRead, Write: auth.ScopeAdmin},
+		"/v1/api-keys":                             {Read: auth.ScopeAdmin, Write: auth.ScopeAdmin},
+		"/v1/api-keys/{id}":                        {Read: auth.ScopeAdmin, Write: auth.ScopeAdmin},
+		"/openapi.json":                            {Read: auth.ScopeAdmin, Write: auth.ScopeAdmin, Public: true},
+
+		// Unversioned routes, deprecated in favour of /v1
+		"/portfolio":              {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioWrite, User: true},
+		"/portfolio/history":      {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioRead, User: true},
+		"/portfolio/schedule":     {Read: auth.ScopePortfolioWrite, Write: auth.ScopePortfolioWrite},
+		"/portfolio/cashflow":     {Read: auth.ScopePortfolioWrite, Write: auth.ScopePortfolioWrite},
+		"/rebalance":              {Read: auth.ScopeRebalanceSubmit, Write: auth.ScopeRebalanceSubmit},
+		"/rebalance/approve":      {Read: auth.ScopePortfolioWrite, Write: auth.ScopePortfolioWrite},
+		"/rebalance/reject":       {Read: auth.ScopePortfolioWrite, Write: auth.ScopePortfolioWrite},
+		"/rebalances":             {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioRead, User: true},
+		"/executions":             {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioRead, User: true},
+		"/reconciliation":         {Read: auth.ScopePortfolioRead, Write: auth.ScopeAdmin},
+		"/reconciliation/summary": {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioRead},
+		"/models":                 {Read: auth.ScopePortfolioRead, Write: auth.ScopeAdmin},
+		"/models/preview":         {Read: auth.ScopePortfolioRead, Write: auth.ScopePortfolioRead},
+		"/assets":                 {Read: auth.ScopePortfolioRead, Write: auth.ScopeAdmin},
+		"/api-keys":               {Read: auth.ScopeAdmin, Write: auth.ScopeAdmin},
+		"/audit":                  {Read: auth.ScopeAdmin, Write: auth.ScopeAdmin},
+	}
+}
+
+// reads reports whether a request only reads, so its route's Read rule applies
+func reads(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// allowsAnyone reports whether a read or write of route may be made without credentials
+func (p Policy) allowsAnyone(route string, read bool) bool {
+	return p[route].Public && read
+}
+
+// allowsUser reports whether an end-user token may read or write route
+func (p Policy) allowsUser(route string, read bool) bool {
+	return p[route].User && read
+}
+
+// requiredScope returns the scope a read or write of route requires
+func (p Policy) requiredScope(route string, read bool) string {
+	rule, ok := p[route]
+	if !ok {
+		return auth.ScopeAdmin
+	}
+	if read {
+		return rule.Read
+	}
+	return rule.Write
+}
+
+// Authenticate rejects requests without a valid API key or end-user token (401), or whose
+// key lacks the scope the route requires (403). The credential is read from
+// "Authorization: Bearer <key>" or the X-API-Key header. Bearer JWTs are checked with tokens,
+// when set, and only reach the policy's user routes. The authenticated key or user is stored
+// in the request context. The policy's public routes are read without credentials.
+func Authenticate(authenticator Authenticator, tokens TokenVerifier, policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if policy.allowsAnyone(route(r), reads(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := requestKey(r)
+			if key == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="portfolio-rebalancer"`)
+				handlers.RespondWithError(w, http.StatusUnauthorized, "API key is required")
+				return
+			}
+
+			if tokens != nil && isToken(key) {
+				user, err := tokens.Verify(r.Context(), key)
+				if err != nil {
+					log.Printf("Rejected bearer token for %s %s: %v", r.Method, r.URL.Path, err)
+					w.Header().Set("WWW-Authenticate", `Bearer realm="portfolio-rebalancer", error="invalid_token"`)
+					handlers.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired bearer token")
+					return
+				}
+				if !policy.allowsUser(route(r), reads(r)) {
+					handlers.RespondWithError(w, http.StatusForbidden, "End-user tokens cannot "+r.Method+" "+r.URL.Path)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+				return
+			}
+
+			apiKey, err := authenticator.Authenticate(r.Context(), key)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="portfolio-rebalancer", error="invalid_token"`)
+				handlers.RespondWithError(w, http.StatusUnauthorized, "Invalid or revoked API key")
+				return
+			}
+
+			required := policy.requiredScope(route(r), reads(r))
+			if !auth.HasScope(apiKey.Scopes, required) {
+				log.Printf("API key %s (%s) denied %s %s, requires %s", apiKey.ID, apiKey.Name, r.Method, r.URL.Path, required)
+				handlers.RespondWithError(w, http.StatusForbidden, "API key lacks the "+required+" scope")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithAPIKey(r.Context(), apiKey)))
+		})
+	}
+}
+
+// isToken reports whether a bearer credential is a JWT rather than an API key
+func isToken(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// requestKey returns the API key sent with the request, if any
+func requestKey(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
@@ -0,0 +1,352 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/pkg/router"
+)
+
+// Mock authenticator, knowing a fixed set of keys
+type mockAuthenticator struct {
+	keys map[string]*models.APIKey
+}
+
+func (m *mockAuthenticator) Authenticate(ctx context.Context, key string) (*models.APIKey, error) {
+	if apiKey, ok := m.keys[key]; ok {
+		return apiKey, nil
+	}
+	return nil, errors.New("invalid api key")
+}
+
+func TestAuthenticate(t *testing.T) {
+	authenticator := &mockAuthenticator{keys: map[string]*models.APIKey{
+		"provider-key": {ID: "k1", Name: "provider", Scopes: []string{auth.ScopeRebalanceSubmit}},
+		"app-key":      {ID: "k2", Name: "app", Scopes: []string{auth.ScopePortfolioRead, auth.ScopePortfolioWrite}},
+		"admin-key":    {ID: "k3", Name: "ops", Scopes: []string{auth.ScopeAdmin}},
+	}}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{
+			name:           "missing key",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "unknown key",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			headers:        map[string]string{"Authorization": "Bearer wrong"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "provider submits a rebalance",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			headers:        map[string]string{"Authorization": "Bearer provider-key"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "provider cannot read portfolios",
+			method:         http.MethodGet,
+			path:           "/portfolio",
+			headers:        map[string]string{"Authorization": "Bearer provider-key"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "provider cannot create portfolios",
+			method:         http.MethodPost,
+			path:           "/portfolio",
+			headers:        map[string]string{"X-API-Key": "provider-key"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "app reads portfolios with X-API-Key",
+			method:         http.MethodGet,
+			path:           "/portfolio",
+			headers:        map[string]string{"X-API-Key": "app-key"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "app cannot submit rebalances",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			headers:        map[string]string{"Authorization": "Bearer app-key"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "app cannot manage api keys",
+			method:         http.MethodGet,
+			path:           "/api-keys",
+			headers:        map[string]string{"Authorization": "Bearer app-key"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unlisted route requires admin",
+			method:         http.MethodGet,
+			path:           "/internal/debug",
+			headers:        map[string]string{"Authorization": "Bearer app-key"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "anyone reads the openapi document",
+			method:         http.MethodGet,
+			path:           "/openapi.json",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "public routes are only public for reads",
+			method:         http.MethodPost,
+			path:           "/openapi.json",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "admin can do anything",
+			method:         http.MethodPost,
+			path:           "/assets",
+			headers:        map[string]string{"Authorization": "bearer admin-key"},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var authenticated *models.APIKey
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authenticated, _ = auth.APIKeyFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := Authenticate(authenticator, nil, DefaultPolicy())(next)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("expected a WWW-Authenticate challenge")
+			}
+			if w.Code == http.StatusOK && authenticated == nil && !DefaultPolicy()[tt.path].Public {
+				t.Errorf("expected the authenticated key in the request context")
+			}
+		})
+	}
+}
+
+func TestAuthConfigFromEnv(t *testing.T) {
+	t.Setenv("AUTH_ENABLED", "")
+	t.Setenv("AUTH_ADMIN_KEY", "")
+	config, err := AuthConfigFromEnv()
+	if err != nil || !config.Enabled {
+		t.Fatalf("expected auth to be enabled by default, got %+v, %v", config, err)
+	}
+
+	t.Setenv("AUTH_ADMIN_KEY", "too-short")
+	if _, err := AuthConfigFromEnv(); err == nil {
+		t.Errorf("expected a short admin key to be rejected")
+	}
+
+	t.Setenv("AUTH_ADMIN_KEY", "")
+	t.Setenv("AUTH_ENABLED", "maybe")
+	if _, err := AuthConfigFromEnv(); err == nil {
+		t.Errorf("expected an invalid AUTH_ENABLED to be rejected")
+	}
+}
+
+// Mock token verifier, knowing a fixed set of tokens
+type mockTokenVerifier struct {
+	users map[string]*auth.User
+}
+
+func (m *mockTokenVerifier) Verify(ctx context.Context, token string) (*auth.User, error) {
+	if user, ok := m.users[token]; ok {
+		return user, nil
+	}
+	return nil, auth.ErrInvalidToken
+}
+
+func TestAuthenticateTokens(t *testing.T) {
+	authenticator := &mockAuthenticator{keys: map[string]*models.APIKey{
+		"app-key": {ID: "k2", Name: "app", Scopes: []string{auth.ScopePortfolioRead}},
+	}}
+	tokens := &mockTokenVerifier{users: map[string]*auth.User{
+		"user.token.sig":  {ID: "1"},
+		"admin.token.sig": {ID: "ops", Admin: true},
+	}}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{
+			name:           "user reads a portfolio",
+			method:         http.MethodGet,
+			path:           "/portfolio",
+			token:          "user.token.sig",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "user reads executions",
+			method:         http.MethodGet,
+			path:           "/executions",
+			token:          "user.token.sig",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "user cannot create portfolios",
+			method:         http.MethodPost,
+			path:           "/portfolio",
+			token:          "user.token.sig",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "user cannot read reconciliation summaries",
+			method:         http.MethodGet,
+			path:           "/reconciliation/summary",
+			token:          "user.token.sig",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "admin role is still limited to user routes",
+			method:         http.MethodGet,
+			path:           "/api-keys",
+			token:          "admin.token.sig",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "invalid token",
+			method:         http.MethodGet,
+			path:           "/portfolio",
+			token:          "forged.token.sig",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "api keys still work",
+			method:         http.MethodGet,
+			path:           "/portfolio",
+			token:          "app-key",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user *auth.User
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user, _ = auth.UserFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := Authenticate(authenticator, tokens, DefaultPolicy())(next)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Code == http.StatusOK && isToken(tt.token) && user == nil {
+				t.Errorf("expected the token's user in the request context")
+			}
+		})
+	}
+}
+
+func TestAuthenticateRoutes(t *testing.T) {
+	authenticator := &mockAuthenticator{keys: map[string]*models.APIKey{
+		"provider-key": {ID: "k1", Name: "provider", Scopes: []string{auth.ScopeRebalanceSubmit}},
+	}}
+	tokens := &mockTokenVerifier{users: map[string]*auth.User{
+		"user.token.sig": {ID: "1"},
+	}}
+
+	mux := router.New()
+	mux.Use(Authenticate(authenticator, tokens, DefaultPolicy()))
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/{user_id}/rebalances", ok)
+	mux.HandleFunc(http.MethodPost, "/v1/portfolios/{user_id}/rebalances", ok)
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/{user_id}/transactions", ok)
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/{user_id}/audit", ok)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		credential     string
+		expectedStatus int
+	}{
+		{
+			name:           "provider submits a rebalance",
+			method:         http.MethodPost,
+			path:           "/v1/portfolios/1/rebalances",
+			credential:     "provider-key",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "provider cannot list rebalances",
+			method:         http.MethodGet,
+			path:           "/v1/portfolios/1/rebalances",
+			credential:     "provider-key",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "user reads transactions",
+			method:         http.MethodGet,
+			path:           "/v1/portfolios/1/transactions",
+			credential:     "user.token.sig",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "user cannot read the audit trail",
+			method:         http.MethodGet,
+			path:           "/v1/portfolios/1/audit",
+			credential:     "user.token.sig",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unknown routes still need credentials",
+			method:         http.MethodGet,
+			path:           "/v1/unknown",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.credential != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.credential)
+			}
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
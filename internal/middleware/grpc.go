@@ -0,0 +1,308 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	rebalancerv1 "portfolio-rebalancer/api/rebalancer/v1"
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/requestinfo"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	reflectionv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Metadata keys read from RPCs, the lower case gRPC spelling of the HTTP headers
+const (
+	authorizationMetadata = "authorization"
+	apiKeyMetadata        = "x-api-key"
+	requestIDMetadata     = "x-request-id"
+	providerMetadata      = "x-provider-id"
+	timestampMetadata     = "x-signature-timestamp"
+	signatureMetadata     = "x-signature"
+	signedRequestMetadata = "x-signed-request-bin"
+)
+
+// DefaultRPCPolicy is the scope every RPC of the gRPC server requires, keyed by full method
+// name. RPCs have no HTTP method, so each is checked as a read: Read is the scope it
+// requires, and User and Public who else may call it. It mirrors DefaultPolicy for the
+// matching /v1 routes. Health checks and reflection are public, like /openapi.json.
+func DefaultRPCPolicy() Policy {
+	return Policy{
+		rebalancerv1.PortfolioRebalancer_CreatePortfolio_FullMethodName:  {Read: auth.ScopePortfolioWrite},
+		rebalancerv1.PortfolioRebalancer_GetPortfolio_FullMethodName:     {Read: auth.ScopePortfolioRead, User: true},
+		rebalancerv1.PortfolioRebalancer_UpdatePortfolio_FullMethodName:  {Read: auth.ScopePortfolioWrite},
+		rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName:        {Read: auth.ScopeRebalanceSubmit},
+		rebalancerv1.PortfolioRebalancer_PreviewRebalance_FullMethodName: {Read: auth.ScopePortfolioRead},
+		rebalancerv1.PortfolioRebalancer_ListTransactions_FullMethodName: {Read: auth.ScopePortfolioRead, User: true},
+
+		healthpb.Health_Check_FullMethodName:                                   {Public: true},
+		healthpb.Health_Watch_FullMethodName:                                   {Public: true},
+		reflectionv1.ServerReflection_ServerReflectionInfo_FullMethodName:      {Public: true},
+		reflectionv1alpha.ServerReflection_ServerReflectionInfo_FullMethodName: {Public: true},
+	}
+}
+
+// UnaryAuthenticate is Authenticate for unary RPCs, with the credential read from the
+// authorization ("Bearer <key>") or x-api-key metadata. Missing or invalid credentials fail
+// with Unauthenticated, keys lacking the RPC's scope and end-user tokens calling an RPC not
+// open to them with PermissionDenied.
+func UnaryAuthenticate(authenticator Authenticator, tokens TokenVerifier, policy Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticateRPC(ctx, info.FullMethod, authenticator, tokens, policy)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthenticate is UnaryAuthenticate for streaming RPCs, such as reflection
+func StreamAuthenticate(authenticator Authenticator, tokens TokenVerifier, policy Policy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticateRPC(stream.Context(), info.FullMethod, authenticator, tokens, policy)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authenticateRPC checks the credential of a call to method against the policy and returns
+// ctx carrying the authenticated key or user
+func authenticateRPC(ctx context.Context, method string, authenticator Authenticator, tokens TokenVerifier, policy Policy) (context.Context, error) {
+	if policy.allowsAnyone(method, true) {
+		return ctx, nil
+	}
+
+	key := rpcKey(ctx)
+	if key == "" {
+		return nil, status.Error(codes.Unauthenticated, "API key is required")
+	}
+
+	if tokens != nil && isToken(key) {
+		user, err := tokens.Verify(ctx, key)
+		if err != nil {
+			log.Printf("Rejected bearer token for %s: %v", method, err)
+			return nil, status.Error(codes.Unauthenticated, "Invalid or expired bearer token")
+		}
+		if !policy.allowsUser(method, true) {
+			return nil, status.Error(codes.PermissionDenied, "End-user tokens cannot call "+method)
+		}
+		return auth.WithUser(ctx, user), nil
+	}
+
+	apiKey, err := authenticator.Authenticate(ctx, key)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid or revoked API key")
+	}
+
+	required := policy.requiredScope(method, true)
+	if !auth.HasScope(apiKey.Scopes, required) {
+		log.Printf("API key %s (%s) denied %s, requires %s", apiKey.ID, apiKey.Name, method, required)
+		return nil, status.Error(codes.PermissionDenied, "API key lacks the "+required+" scope")
+	}
+
+	return auth.WithAPIKey(ctx, apiKey), nil
+}
+
+// rpcKey returns the API key or token sent with the call, if any
+func rpcKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if header := first(md, authorizationMetadata); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return strings.TrimSpace(first(md, apiKeyMetadata))
+}
+
+// Unary is Limit for unary RPCs, limited under their full method name in RateLimitConfig.Routes
+// and charged to the user_id of the request message. Rate limited and over capacity calls fail
+// with ResourceExhausted, shed ones with Unavailable, both with google.rpc.RetryInfo details
+// saying how long to wait. It runs after UnaryAuthenticate.
+func (l *Limiter) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	release, rejected := l.admit(info.FullMethod, info.FullMethod, rpcClientKey(ctx), rpcUserID(req))
+	if rejected != nil {
+		return nil, rejectionStatus(rejected)
+	}
+	defer release()
+
+	return handler(ctx, req)
+}
+
+// Stream is Unary for streaming RPCs, whose messages carry no user_id
+func (l *Limiter) Stream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	release, rejected := l.admit(info.FullMethod, info.FullMethod, rpcClientKey(stream.Context()), "")
+	if rejected != nil {
+		return rejectionStatus(rejected)
+	}
+	defer release()
+
+	return handler(srv, stream)
+}
+
+// rejectionStatus returns the status of a call the limiter turned away
+func rejectionStatus(rejected *rejection) error {
+	code := codes.ResourceExhausted
+	if rejected.shed {
+		code = codes.Unavailable
+	}
+	st := status.New(code, rejected.message)
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(rejected.wait)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// rpcClientKey is clientKey for RPCs, falling back on the peer's address
+func rpcClientKey(ctx context.Context) string {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr = p.Addr.String()
+	}
+	return callerKey(ctx, addr)
+}
+
+// rpcUserID returns the user_id a request message is about, if any
+func rpcUserID(req interface{}) string {
+	switch m := req.(type) {
+	case interface{ GetUserId() string }:
+		return m.GetUserId()
+	case interface {
+		GetPortfolio() *rebalancerv1.Portfolio
+	}:
+		return m.GetPortfolio().GetUserId()
+	}
+	return ""
+}
+
+// UnaryVerifySignature is VerifySignature for unary RPCs: calls of methods fail with
+// Unauthenticated unless signed with a current secret of the provider named in x-provider-id
+// metadata, with an x-signature-timestamp within the replay window. The x-signature covers
+// "<timestamp>.<full method>.<request>", so a signature cannot be replayed against another
+// method. Protobuf has no canonical encoding to re-create the signed bytes from, so the
+// request is sent as signed in x-signed-request-bin metadata, in any valid encoding, and
+// must decode to the request being called. Other methods pass through.
+func UnaryVerifySignature(config SignatureConfig, methods ...string) grpc.UnaryServerInterceptor {
+	return unaryVerifySignature(config, time.Now, methods...)
+}
+
+// unaryVerifySignature is UnaryVerifySignature with a clock, so tests can move the replay window
+func unaryVerifySignature(config SignatureConfig, now func() time.Time, methods ...string) grpc.UnaryServerInterceptor {
+	signed := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		signed[method] = true
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !signed[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		provider := first(md, providerMetadata)
+		signature := strings.TrimPrefix(first(md, signatureMetadata), "sha256=")
+		signedRequest := first(md, signedRequestMetadata)
+		if provider == "" || signature == "" || first(md, timestampMetadata) == "" || signedRequest == "" {
+			return nil, status.Error(codes.Unauthenticated, "Call must be signed with "+providerMetadata+", "+timestampMetadata+", "+signatureMetadata+" and "+signedRequestMetadata+" metadata")
+		}
+
+		timestamp, err := strconv.ParseInt(first(md, timestampMetadata), 10, 64)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, timestampMetadata+" must be a unix timestamp in seconds")
+		}
+		current := now()
+		if age := current.Sub(time.Unix(timestamp, 0)); age > config.Window || age < -config.Window {
+			log.Printf("Rejected %s from %s: signature timestamp %d is outside the %s replay window", info.FullMethod, provider, timestamp, config.Window)
+			return nil, status.Error(codes.Unauthenticated, "Signature timestamp is outside the replay window")
+		}
+
+		if !config.valid(provider, timestamp, info.FullMethod, []byte(signedRequest), signature, current) {
+			log.Printf("Rejected %s from %s: invalid signature", info.FullMethod, provider)
+			return nil, status.Error(codes.Unauthenticated, "Invalid request signature")
+		}
+
+		message, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Error(codes.Internal, "Request cannot be verified")
+		}
+		signed := message.ProtoReflect().New().Interface()
+		if err := proto.Unmarshal([]byte(signedRequest), signed); err != nil || !proto.Equal(signed, message) {
+			log.Printf("Rejected %s from %s: signed request does not match the call", info.FullMethod, provider)
+			return nil, status.Error(codes.Unauthenticated, "Signed request does not match the call")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// UnaryLog is RequestID for unary RPCs: it reuses the caller's x-request-id metadata, or
+// generates one, returns it in the response header and stores it in the context. Each call
+// is logged with its status code and duration.
+func UnaryLog(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, id := withRPCInfo(ctx, info.FullMethod)
+	start := time.Now()
+
+	resp, err := handler(ctx, req)
+	logRPC(info.FullMethod, id, start, err)
+	return resp, err
+}
+
+// StreamLog is UnaryLog for streaming RPCs, logged once the stream ends
+func StreamLog(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, id := withRPCInfo(stream.Context(), info.FullMethod)
+	start := time.Now()
+
+	err := handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	logRPC(info.FullMethod, id, start, err)
+	return err
+}
+
+// withRPCInfo returns ctx carrying the call's request ID, method and route, and the ID
+func withRPCInfo(ctx context.Context, method string) (context.Context, string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := first(md, requestIDMetadata)
+	if id == "" || len(id) > maxRequestIDLength {
+		id = requestinfo.NewID()
+	}
+	// Sending the header only fails once the response has started, which it has not
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, id))
+
+	return requestinfo.WithInfo(ctx, requestinfo.Info{ID: id, Method: requestinfo.MethodGRPC, Route: method}), id
+}
+
+// logRPC logs the outcome of a call
+func logRPC(method, id string, start time.Time, err error) {
+	log.Printf("gRPC %s %s in %s (request %s)", method, status.Code(err), time.Since(start).Round(time.Microsecond), id)
+}
+
+// first returns the first value of a metadata key, or ""
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// contextStream is a server stream whose handler sees ctx instead of the stream's own context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
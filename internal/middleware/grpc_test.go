@@ -0,0 +1,305 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	rebalancerv1 "portfolio-rebalancer/api/rebalancer/v1"
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/requestinfo"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUnaryAuthenticate(t *testing.T) {
+	authenticator := &mockAuthenticator{keys: map[string]*models.APIKey{
+		"provider-key": {ID: "k1", Name: "provider", Scopes: []string{auth.ScopeRebalanceSubmit}},
+		"app-key":      {ID: "k2", Name: "app", Scopes: []string{auth.ScopePortfolioRead, auth.ScopePortfolioWrite}},
+	}}
+	tokens := &mockTokenVerifier{users: map[string]*auth.User{
+		"user.token.sig": {ID: "1"},
+	}}
+	interceptor := UnaryAuthenticate(authenticator, tokens, DefaultRPCPolicy())
+
+	tests := []struct {
+		name         string
+		method       string
+		metadata     []string
+		expectedCode codes.Code
+	}{
+		{
+			name:         "health is public",
+			method:       healthpb.Health_Check_FullMethodName,
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "missing key",
+			method:       rebalancerv1.PortfolioRebalancer_GetPortfolio_FullMethodName,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "unknown key",
+			method:       rebalancerv1.PortfolioRebalancer_GetPortfolio_FullMethodName,
+			metadata:     []string{"authorization", "Bearer wrong"},
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "app reads a portfolio",
+			method:       rebalancerv1.PortfolioRebalancer_GetPortfolio_FullMethodName,
+			metadata:     []string{"authorization", "Bearer app-key"},
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "key sent as x-api-key",
+			method:       rebalancerv1.PortfolioRebalancer_CreatePortfolio_FullMethodName,
+			metadata:     []string{"x-api-key", "app-key"},
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "app cannot submit a rebalance",
+			method:       rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName,
+			metadata:     []string{"authorization", "Bearer app-key"},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "provider submits a rebalance",
+			method:       rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName,
+			metadata:     []string{"authorization", "Bearer provider-key"},
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "user token reads transactions",
+			method:       rebalancerv1.PortfolioRebalancer_ListTransactions_FullMethodName,
+			metadata:     []string{"authorization", "Bearer user.token.sig"},
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "user token cannot rebalance",
+			method:       rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName,
+			metadata:     []string{"authorization", "Bearer user.token.sig"},
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "invalid user token",
+			method:       rebalancerv1.PortfolioRebalancer_GetPortfolio_FullMethodName,
+			metadata:     []string{"authorization", "Bearer other.token.sig"},
+			expectedCode: codes.Unauthenticated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tt.metadata...))
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				_, hasKey := auth.APIKeyFromContext(ctx)
+				_, hasUser := auth.UserFromContext(ctx)
+				if tt.method != healthpb.Health_Check_FullMethodName && !hasKey && !hasUser {
+					t.Errorf("expected the caller to be stored in the context")
+				}
+				return "ok", nil
+			}
+
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+
+			if code := status.Code(err); code != tt.expectedCode {
+				t.Errorf("expected %s, got %v", tt.expectedCode, err)
+			}
+		})
+	}
+}
+
+func TestUnaryLog(t *testing.T) {
+	tests := []struct {
+		name       string
+		requestID  string
+		expectedID string
+	}{
+		{name: "reuses the caller's ID", requestID: "req-1", expectedID: "req-1"},
+		{name: "generates a missing ID", requestID: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", tt.requestID))
+			var info requestinfo.Info
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				info, _ = requestinfo.FromContext(ctx)
+				return nil, status.Error(codes.NotFound, "portfolio not found: 1")
+			}
+
+			_, err := UnaryLog(ctx, nil, &grpc.UnaryServerInfo{FullMethod: rebalancerv1.PortfolioRebalancer_GetPortfolio_FullMethodName}, handler)
+
+			if status.Code(err) != codes.NotFound {
+				t.Errorf("expected the handler's error to be returned, got %v", err)
+			}
+			if info.ID == "" || (tt.expectedID != "" && info.ID != tt.expectedID) {
+				t.Errorf("expected request ID %q, got %q", tt.expectedID, info.ID)
+			}
+			if info.Method != requestinfo.MethodGRPC || info.Route != rebalancerv1.PortfolioRebalancer_GetPortfolio_FullMethodName {
+				t.Errorf("expected the RPC to be recorded, got %+v", info)
+			}
+		})
+	}
+}
+
+func TestUnaryVerifySignature(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	secret := strings.Repeat("n", 32)
+	config := SignatureConfig{Secrets: map[string][]SigningSecret{"acme": {{Secret: secret}}}, Window: 5 * time.Minute}
+	interceptor := unaryVerifySignature(config, func() time.Time { return now }, rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName)
+	req := &rebalancerv1.RebalanceRequest{UserId: "1", NewAllocation: map[string]float64{"stocks": 70, "bonds": 30}}
+
+	encode := func(msgs ...proto.Message) []byte {
+		var body []byte
+		for _, msg := range msgs {
+			encoded, err := proto.Marshal(msg)
+			if err != nil {
+				t.Fatalf("failed to encode the request: %v", err)
+			}
+			body = append(body, encoded...)
+		}
+		return body
+	}
+	sign := func(method string, signedAt time.Time, body []byte) []string {
+		timestamp := signedAt.Unix()
+		return []string{
+			"x-provider-id", "acme",
+			"x-signature-timestamp", strconv.FormatInt(timestamp, 10),
+			"x-signature", "sha256=" + auth.SignPath(secret, timestamp, method, body),
+			"x-signed-request-bin", string(body),
+		}
+	}
+	other := &rebalancerv1.RebalanceRequest{UserId: "2", NewAllocation: req.NewAllocation}
+	forged := sign(rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName, now, encode(req))
+	forged[len(forged)-1] = string(encode(other))
+
+	tests := []struct {
+		name         string
+		method       string
+		metadata     []string
+		expectedCode codes.Code
+	}{
+		{
+			name:         "signed",
+			method:       rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName,
+			metadata:     sign(rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName, now, encode(req)),
+			expectedCode: codes.OK,
+		},
+		{
+			// Concatenated encodings merge, so these bytes differ from any re-encoding of req
+			name:         "signed in another encoding",
+			method:       rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName,
+			metadata:     sign(rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName, now, encode(&rebalancerv1.RebalanceRequest{NewAllocation: map[string]float64{"bonds": 30}}, &rebalancerv1.RebalanceRequest{UserId: "1", NewAllocation: map[string]float64{"stocks": 70}})),
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "unsigned",
+			method:       rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "call differs from the signed request",
+			method:       rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName,
+			metadata:     sign(rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName, now, encode(other)),
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "signed request changed after signing",
+			method:       rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName,
+			metadata:     forged,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "signed for another method",
+			method:       rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName,
+			metadata:     sign(rebalancerv1.PortfolioRebalancer_PreviewRebalance_FullMethodName, now, encode(req)),
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "replayed outside the window",
+			method:       rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName,
+			metadata:     sign(rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName, now.Add(-10*time.Minute), encode(req)),
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "other methods are not signed",
+			method:       rebalancerv1.PortfolioRebalancer_PreviewRebalance_FullMethodName,
+			expectedCode: codes.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tt.metadata...))
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return "ok", nil
+			}
+
+			_, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+
+			if code := status.Code(err); code != tt.expectedCode {
+				t.Errorf("expected %s, got %v", tt.expectedCode, err)
+			}
+		})
+	}
+}
+
+func TestLimiterUnary(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	config := RateLimitConfig{
+		ClientRate: 1, ClientBurst: 2, UserRate: 0.5, UserBurst: 1,
+		Routes: map[string]RouteLimit{rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName: {MaxKafkaLatency: 500 * time.Millisecond}},
+	}
+	limiter := newLimiter(config, mockLatency(time.Second), nil, func() time.Time { return now })
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	call := func(keyID, method string, req interface{}) error {
+		ctx := auth.WithAPIKey(context.Background(), &models.APIKey{ID: keyID})
+		_, err := limiter.Unary(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	steps := []struct {
+		name         string
+		keyID        string
+		method       string
+		req          interface{}
+		expectedCode codes.Code
+	}{
+		{name: "first call", keyID: "k1", method: rebalancerv1.PortfolioRebalancer_GetPortfolio_FullMethodName, req: &rebalancerv1.GetPortfolioRequest{UserId: "1"}, expectedCode: codes.OK},
+		{name: "same user again", keyID: "k1", method: rebalancerv1.PortfolioRebalancer_ListTransactions_FullMethodName, req: &rebalancerv1.ListTransactionsRequest{UserId: "1"}, expectedCode: codes.ResourceExhausted},
+		{name: "same user from another client", keyID: "k2", method: rebalancerv1.PortfolioRebalancer_CreatePortfolio_FullMethodName, req: &rebalancerv1.CreatePortfolioRequest{Portfolio: &rebalancerv1.Portfolio{UserId: "1"}}, expectedCode: codes.OK},
+		{name: "client burst spent", keyID: "k1", method: rebalancerv1.PortfolioRebalancer_GetPortfolio_FullMethodName, req: &rebalancerv1.GetPortfolioRequest{UserId: "2"}, expectedCode: codes.ResourceExhausted},
+		{name: "shed while kafka is slow", keyID: "k3", method: rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName, req: &rebalancerv1.RebalanceRequest{UserId: "3"}, expectedCode: codes.Unavailable},
+	}
+
+	for _, step := range steps {
+		err := call(step.keyID, step.method, step.req)
+		if code := status.Code(err); code != step.expectedCode {
+			t.Errorf("%s: expected %s, got %v", step.name, step.expectedCode, err)
+		}
+		if err == nil {
+			continue
+		}
+		details := status.Convert(err).Details()
+		if len(details) != 1 {
+			t.Errorf("%s: expected RetryInfo details, got %v", step.name, details)
+			continue
+		}
+		if retry, ok := details[0].(*errdetails.RetryInfo); !ok || retry.GetRetryDelay().AsDuration() <= 0 {
+			t.Errorf("%s: expected a retry delay, got %v", step.name, details[0])
+		}
+	}
+}
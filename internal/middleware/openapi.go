@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"portfolio-rebalancer/internal/handlers"
+	"portfolio-rebalancer/pkg/openapi"
+)
+
+// maxValidatedBodyBytes bounds how much of a request body is buffered to validate it
+const maxValidatedBodyBytes = 1 << 20
+
+// ValidateRequests rejects requests (400) whose query parameters or JSON body do not match
+// the operation the document describes for their method and route: missing required fields,
+// wrong types, values outside an enum and fields the document does not list. Routes and
+// methods missing from the document are left to the router to reject.
+func ValidateRequests(doc *openapi.Document) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			op, ok := doc.Operation(r.Method, route(r))
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var body []byte
+			if op.RequestBody != nil && r.Body != nil {
+				var err error
+				body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxValidatedBodyBytes))
+				if err != nil {
+					handlers.RespondWithError(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+
+			if errs := doc.ValidateRequest(op, r.URL.Query(), body); len(errs) > 0 {
+				log.Printf("Rejected %s %s: %d fields do not match the API specification", r.Method, r.URL.Path, len(errs))
+				handlers.RespondWithFieldErrors(w, http.StatusBadRequest, "Request does not match the API specification", fieldErrors(errs))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// fieldErrors converts validation errors to the API's error details. Problems with the body
+// as a whole are reported against the body field.
+func fieldErrors(errs []openapi.ValidationError) []handlers.FieldError {
+	details := make([]handlers.FieldError, len(errs))
+	for i, err := range errs {
+		field := err.Field
+		if field == "" {
+			field = "body"
+		}
+		details[i] = handlers.FieldError{Field: field, Message: err.Message}
+	}
+	return details
+}
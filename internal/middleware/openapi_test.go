@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"portfolio-rebalancer/api"
+	"portfolio-rebalancer/internal/handlers"
+)
+
+func TestValidateRequests(t *testing.T) {
+	doc, err := api.Load()
+	if err != nil {
+		t.Fatalf("failed to load spec: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		body           string
+		expectedStatus int
+		expectedFields []string
+	}{
+		{
+			name:           "valid portfolio",
+			method:         http.MethodPost,
+			url:            "/portfolio",
+			body:           `{"user_id":"1","allocation":{"stocks":60,"bonds":40},"constraints":{"stocks":{"max":70}}}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unknown field",
+			method:         http.MethodPost,
+			url:            "/portfolio",
+			body:           `{"user_id":"1","allocation":{"stocks":100},"allocations":{"stocks":100}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []string{"allocations"},
+		},
+		{
+			name:           "wrong types",
+			method:         http.MethodPost,
+			url:            "/portfolio",
+			body:           `{"user_id":1,"allocation":{"stocks":"60"},"require_approval":"yes"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []string{"allocation.stocks", "require_approval", "user_id"},
+		},
+		{
+			name:           "nested unknown field",
+			method:         http.MethodPost,
+			url:            "/portfolio",
+			body:           `{"user_id":"1","allocation_tree":[{"asset":"equities","percent":100,"weight":1}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []string{"allocation_tree[0].weight"},
+		},
+		{
+			name:           "missing required fields",
+			method:         http.MethodPost,
+			url:            "/rebalance",
+			body:           `{}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []string{"user_id", "new_allocation"},
+		},
+		{
+			name:           "value outside enum",
+			method:         http.MethodPost,
+			url:            "/portfolio/cashflow",
+			body:           `{"user_id":"1","type":"TRANSFER","amount":100}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []string{"type"},
+		},
+		{
+			name:           "invalid json",
+			method:         http.MethodPost,
+			url:            "/rebalance",
+			body:           `{"user_id":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []string{"body"},
+		},
+		{
+			name:           "missing body",
+			method:         http.MethodPost,
+			url:            "/rebalance/approve",
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []string{"body"},
+		},
+		{
+			name:           "query parameter outside enum",
+			method:         http.MethodPost,
+			url:            "/rebalance?mode=netting",
+			body:           `{"user_id":"1","new_allocation":{"stocks":100}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []string{"mode"},
+		},
+		{
+			name:           "missing required query parameter",
+			method:         http.MethodGet,
+			url:            "/executions",
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []string{"rebalance_id"},
+		},
+		{
+			name:           "invalid timestamp",
+			method:         http.MethodGet,
+			url:            "/portfolio/history?user_id=1&from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedFields: []string{"from"},
+		},
+		{
+			name:           "undocumented route is left to the router",
+			method:         http.MethodGet,
+			url:            "/internal/debug",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "undocumented method is left to the handler",
+			method:         http.MethodPatch,
+			url:            "/portfolio",
+			body:           `not json`,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				received = string(body)
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := ValidateRequests(doc)(next)
+
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code == http.StatusOK {
+				if received != tt.body {
+					t.Errorf("expected the handler to read the body %q, got %q", tt.body, received)
+				}
+				return
+			}
+
+			var resp handlers.Problem
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			fields := make(map[string]bool)
+			for _, detail := range resp.Details {
+				fields[detail.Field] = true
+			}
+			for _, field := range tt.expectedFields {
+				if !fields[field] {
+					t.Errorf("expected a detail for %s, got %+v", field, resp.Details)
+				}
+			}
+			if len(resp.Details) != len(tt.expectedFields) {
+				t.Errorf("expected %d details, got %+v", len(tt.expectedFields), resp.Details)
+			}
+		})
+	}
+}
+
+// TestPolicyMatchesSpec keeps the access policy and the spec describing the same routes
+func TestPolicyMatchesSpec(t *testing.T) {
+	doc, err := api.Load()
+	if err != nil {
+		t.Fatalf("failed to load spec: %v", err)
+	}
+	policy := DefaultPolicy()
+
+	for path := range policy {
+		if !doc.HasPath(path) {
+			t.Errorf("%s has an access rule but is not documented", path)
+		}
+	}
+	for _, operation := range doc.Operations() {
+		if _, ok := policy[operation[1]]; !ok {
+			t.Errorf("%s is documented but has no access rule", operation[1])
+		}
+	}
+}
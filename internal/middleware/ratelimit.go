@@ -0,0 +1,428 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	rebalancerv1 "portfolio-rebalancer/api/rebalancer/v1"
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/handlers"
+	"portfolio-rebalancer/pkg/router"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sheddingRetryAfter is how long shed clients are asked to wait, about the time a
+// dependency's moving average takes to recover
+const sheddingRetryAfter = 5 * time.Second
+
+// inFlightRetryAfter is how long clients turned away by a full route are asked to wait
+const inFlightRetryAfter = time.Second
+
+// maxPeekedBodyBytes bounds how much of a request body is read to find its user_id
+const maxPeekedBodyBytes = 1 << 20
+
+// bucketSweepInterval is how often idle, refilled buckets are dropped
+const bucketSweepInterval = time.Minute
+
+// LatencySource reports a dependency's recent latency
+type LatencySource interface {
+	Value() time.Duration
+}
+
+// RouteLimit bounds the load one route may put on the server. Zero values are unlimited.
+type RouteLimit struct {
+	MaxInFlight     int           // requests handled at once
+	MaxKafkaLatency time.Duration // shed requests while Kafka publishes are slower than this
+	MaxESLatency    time.Duration // shed requests while Elasticsearch is slower than this
+}
+
+// RateLimitConfig controls rate limiting and load shedding. Zero rates are unlimited.
+type RateLimitConfig struct {
+	ClientRate  float64 // requests per second per API key, end user or, without auth, address
+	ClientBurst int
+	UserRate    float64 // requests per second per user_id from each client
+	UserBurst   int
+	Routes      map[string]RouteLimit // by route, or by method and route, e.g. "POST /v1/portfolios/{user_id}/rebalances"
+}
+
+// DefaultRouteLimits protects the routes and RPCs that publish to Kafka, which providers call
+// the most. RPCs are keyed by full method name.
+func DefaultRouteLimits() map[string]RouteLimit {
+	return map[string]RouteLimit{
+		"POST /v1/portfolios/{user_id}/rebalances": {MaxInFlight: 100, MaxKafkaLatency: 500 * time.Millisecond, MaxESLatency: time.Second},
+		"POST /v1/portfolios/{user_id}/cashflows":  {MaxInFlight: 50, MaxKafkaLatency: 500 * time.Millisecond, MaxESLatency: time.Second},
+		"/rebalance":          {MaxInFlight: 100, MaxKafkaLatency: 500 * time.Millisecond, MaxESLatency: time.Second},
+		"/portfolio/cashflow": {MaxInFlight: 50, MaxKafkaLatency: 500 * time.Millisecond, MaxESLatency: time.Second},
+
+		rebalancerv1.PortfolioRebalancer_Rebalance_FullMethodName: {MaxInFlight: 100, MaxKafkaLatency: 500 * time.Millisecond, MaxESLatency: time.Second},
+	}
+}
+
+// RateLimitConfigFromEnv reads RATE_LIMIT_CLIENT_RPS (default 20), RATE_LIMIT_CLIENT_BURST
+// (default 40), RATE_LIMIT_USER_RPS (default 2), RATE_LIMIT_USER_BURST (default 5) and
+// ROUTE_LIMITS, which replaces DefaultRouteLimits with semicolon separated
+// route=setting:value,... entries, e.g. "/rebalance=in_flight:100,kafka:500ms,es:1s". A route
+// may start with a method to only limit that method, e.g. "POST /v1/portfolios/{user_id}/rebalances".
+func RateLimitConfigFromEnv() (RateLimitConfig, error) {
+	config := RateLimitConfig{
+		ClientRate:  20,
+		ClientBurst: 40,
+		UserRate:    2,
+		UserBurst:   5,
+		Routes:      DefaultRouteLimits(),
+	}
+
+	for key, target := range map[string]*float64{
+		"RATE_LIMIT_CLIENT_RPS": &config.ClientRate,
+		"RATE_LIMIT_USER_RPS":   &config.UserRate,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 {
+			return config, fmt.Errorf("invalid %s %q, expected requests per second, 0 for unlimited", key, value)
+		}
+		*target = f
+	}
+
+	for key, target := range map[string]*int{
+		"RATE_LIMIT_CLIENT_BURST": &config.ClientBurst,
+		"RATE_LIMIT_USER_BURST":   &config.UserBurst,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return config, fmt.Errorf("invalid %s %q, expected a positive number of requests", key, value)
+		}
+		*target = n
+	}
+
+	if value := os.Getenv("ROUTE_LIMITS"); value != "" {
+		routes, err := parseRouteLimits(value)
+		if err != nil {
+			return config, err
+		}
+		config.Routes = routes
+	}
+
+	return config, nil
+}
+
+// parseRouteLimits parses the ROUTE_LIMITS format
+func parseRouteLimits(value string) (map[string]RouteLimit, error) {
+	routes := make(map[string]RouteLimit)
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		route, settings, ok := strings.Cut(entry, "=")
+		route = strings.TrimSpace(route)
+		method, path, hasMethod := strings.Cut(route, " ")
+		if !hasMethod {
+			path = route
+		}
+		if !ok || !strings.HasPrefix(path, "/") || (hasMethod && method != strings.ToUpper(method)) {
+			return nil, fmt.Errorf("invalid ROUTE_LIMITS entry %q, expected [METHOD ]/route=setting:value,...", entry)
+		}
+
+		var limit RouteLimit
+		for _, setting := range strings.Split(settings, ",") {
+			name, raw, ok := strings.Cut(strings.TrimSpace(setting), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid ROUTE_LIMITS setting %q for %s, expected name:value", setting, route)
+			}
+			switch name {
+			case "in_flight":
+				n, err := strconv.Atoi(raw)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid ROUTE_LIMITS in_flight %q for %s", raw, route)
+				}
+				limit.MaxInFlight = n
+			case "kafka", "es":
+				d, err := time.ParseDuration(raw)
+				if err != nil || d < 0 {
+					return nil, fmt.Errorf("invalid ROUTE_LIMITS %s latency %q for %s", name, raw, route)
+				}
+				if name == "kafka" {
+					limit.MaxKafkaLatency = d
+				} else {
+					limit.MaxESLatency = d
+				}
+			default:
+				return nil, fmt.Errorf("unknown ROUTE_LIMITS setting %q for %s, expected in_flight, kafka or es", name, route)
+			}
+		}
+		routes[route] = limit
+	}
+	return routes, nil
+}
+
+// Limit rate limits requests per client and per user_id with token buckets, caps how many
+// requests each route handles at once and sheds a route's requests while Kafka or
+// Elasticsearch are slower than its thresholds. Rate limited and over capacity requests get
+// a 429, shed ones a 503, both with Retry-After. It runs after Authenticate, whose key or
+// user identifies the client. User buckets are kept per client, so no client can spend
+// another's budget for a user and lock the user out.
+func Limit(config RateLimitConfig, kafkaLatency, esLatency LatencySource) func(http.Handler) http.Handler {
+	return NewLimiter(config, kafkaLatency, esLatency).Handler
+}
+
+// limit is Limit with a clock, so tests can refill the buckets
+func limit(config RateLimitConfig, kafkaLatency, esLatency LatencySource, now func() time.Time) func(http.Handler) http.Handler {
+	return newLimiter(config, kafkaLatency, esLatency, now).Handler
+}
+
+// Limiter holds the token buckets and in-flight slots behind Limit, so that the HTTP and gRPC
+// servers can share them: a client's requests and RPCs draw from the same budget.
+type Limiter struct {
+	config       RateLimitConfig
+	kafkaLatency LatencySource
+	esLatency    LatencySource
+	clients      *rateLimiter
+	users        *rateLimiter
+	inFlight     map[string]chan struct{}
+}
+
+// NewLimiter creates a limiter enforcing config
+func NewLimiter(config RateLimitConfig, kafkaLatency, esLatency LatencySource) *Limiter {
+	return newLimiter(config, kafkaLatency, esLatency, time.Now)
+}
+
+// newLimiter is NewLimiter with a clock
+func newLimiter(config RateLimitConfig, kafkaLatency, esLatency LatencySource, now func() time.Time) *Limiter {
+	inFlight := make(map[string]chan struct{})
+	for route, routeLimit := range config.Routes {
+		if routeLimit.MaxInFlight > 0 {
+			inFlight[route] = make(chan struct{}, routeLimit.MaxInFlight)
+		}
+	}
+
+	return &Limiter{
+		config:       config,
+		kafkaLatency: kafkaLatency,
+		esLatency:    esLatency,
+		clients:      newRateLimiter(config.ClientRate, config.ClientBurst, now),
+		users:        newRateLimiter(config.UserRate, config.UserBurst, now),
+		inFlight:     inFlight,
+	}
+}
+
+// Handler is Limit's middleware
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, rejected := l.admit(routeLimitKey(l.config.Routes, r), route(r), clientKey(r), requestUserID(r))
+		if rejected != nil {
+			code := http.StatusTooManyRequests
+			if rejected.shed {
+				code = http.StatusServiceUnavailable
+			}
+			respondWithRetry(w, code, rejected.wait, rejected.message)
+			return
+		}
+		defer release()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejection is why the limiter turned a request away
+type rejection struct {
+	shed    bool // a dependency is slow, rather than a limit reached
+	wait    time.Duration
+	message string
+}
+
+// admit checks a request to route, limited under key, from client about userID. It returns a
+// func freeing the request's in-flight slot once handled, or why the request was turned away.
+func (l *Limiter) admit(key, route, client, userID string) (func(), *rejection) {
+	routeLimit := l.config.Routes[key]
+	if dependency, observed := overloaded(routeLimit, l.kafkaLatency, l.esLatency); dependency != "" {
+		log.Printf("Shedding %s: %s latency %s is over the route's limit", route, dependency, observed)
+		return nil, &rejection{shed: true, wait: sheddingRetryAfter, message: "Server is overloaded, retry later"}
+	}
+
+	if ok, wait := l.clients.allow(client); !ok {
+		return nil, &rejection{wait: wait, message: "Rate limit exceeded for this client"}
+	}
+
+	if userID != "" {
+		if ok, wait := l.users.allow(client + " " + userID); !ok {
+			return nil, &rejection{wait: wait, message: "Rate limit exceeded for user " + userID}
+		}
+	}
+
+	if slots, ok := l.inFlight[key]; ok {
+		select {
+		case slots <- struct{}{}:
+			return func() { <-slots }, nil
+		default:
+			return nil, &rejection{wait: inFlightRetryAfter, message: "Too many requests in flight for " + route}
+		}
+	}
+	return func() {}, nil
+}
+
+// routeLimitKey returns the key of the request's limit: its method and route when limited on
+// their own, otherwise its route
+func routeLimitKey(routes map[string]RouteLimit, r *http.Request) string {
+	key := r.Method + " " + route(r)
+	if _, ok := routes[key]; ok {
+		return key
+	}
+	return route(r)
+}
+
+// overloaded returns the dependency, if any, slower than the route allows and its latency
+func overloaded(routeLimit RouteLimit, kafkaLatency, esLatency LatencySource) (string, time.Duration) {
+	if routeLimit.MaxKafkaLatency > 0 && kafkaLatency != nil {
+		if observed := kafkaLatency.Value(); observed > routeLimit.MaxKafkaLatency {
+			return "Kafka", observed
+		}
+	}
+	if routeLimit.MaxESLatency > 0 && esLatency != nil {
+		if observed := esLatency.Value(); observed > routeLimit.MaxESLatency {
+			return "Elasticsearch", observed
+		}
+	}
+	return "", 0
+}
+
+// respondWithRetry sends an error telling the client how many whole seconds to wait
+func respondWithRetry(w http.ResponseWriter, code int, wait time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	handlers.RespondWithError(w, code, message)
+}
+
+// clientKey identifies who sent the request: the API key, the end user or the address
+func clientKey(r *http.Request) string {
+	return callerKey(r.Context(), r.RemoteAddr)
+}
+
+// callerKey identifies the API key or end user authenticated in ctx, or else the address
+func callerKey(ctx context.Context, addr string) string {
+	if key, ok := auth.APIKeyFromContext(ctx); ok {
+		return "key:" + key.ID
+	}
+	if user, ok := auth.UserFromContext(ctx); ok {
+		return "user:" + user.ID
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return "addr:" + host
+}
+
+// requestUserID returns the user_id the request is about, from the path, the query or a
+// JSON body. The body is put back for the handler.
+func requestUserID(r *http.Request) string {
+	if userID := router.Param(r, "user_id"); userID != "" {
+		return userID
+	}
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		return userID
+	}
+	if r.Body == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return ""
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") && r.Header.Get("Content-Type") != "" {
+		return ""
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekedBodyBytes))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return ""
+	}
+
+	var payload struct {
+		UserID string `json:"user_id"`
+	}
+	if json.Unmarshal(body, &payload) != nil {
+		return ""
+	}
+	return payload.UserID
+}
+
+// tokenBucket holds the requests a client may still make
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter keeps a token bucket per key that refills at rate tokens per second up to burst
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	now     func() time.Time
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// newRateLimiter creates a limiter; a zero rate allows everything
+func newRateLimiter(rate float64, burst int, now func() time.Time) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     now,
+		buckets: make(map[string]*tokenBucket),
+		swept:   now(),
+	}
+}
+
+// allow takes a token from key's bucket, or returns how long until one is available
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep drops buckets that have refilled, which behave the same as missing ones. Callers hold l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < bucketSweepInterval {
+		return
+	}
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= full {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/pkg/router"
+)
+
+// Fixed latency source
+type mockLatency time.Duration
+
+func (m mockLatency) Value() time.Duration {
+	return time.Duration(m)
+}
+
+func TestLimitRates(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	config := RateLimitConfig{ClientRate: 1, ClientBurst: 2, UserRate: 0.5, UserBurst: 1}
+
+	var received []string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, string(body))
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := limit(config, nil, nil, clock)(next)
+
+	send := func(keyID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/rebalance", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(auth.WithAPIKey(req.Context(), &models.APIKey{ID: keyID}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	steps := []struct {
+		name           string
+		advance        time.Duration
+		keyID          string
+		body           string
+		expectedStatus int
+		expectedRetry  string
+	}{
+		{name: "first request", keyID: "k1", body: `{"user_id":"1"}`, expectedStatus: http.StatusOK},
+		{name: "same user again", keyID: "k1", body: `{"user_id":"1"}`, expectedStatus: http.StatusTooManyRequests, expectedRetry: "2"},
+		{name: "same user from another client", keyID: "k2", body: `{"user_id":"1"}`, expectedStatus: http.StatusOK},
+		{name: "client burst spent", keyID: "k1", body: `{"user_id":"2"}`, expectedStatus: http.StatusTooManyRequests, expectedRetry: "1"},
+		{name: "client refilled", advance: time.Second, keyID: "k1", body: `{"user_id":"2"}`, expectedStatus: http.StatusOK},
+		{name: "user refilled", advance: 2 * time.Second, keyID: "k1", body: `{"user_id":"1"}`, expectedStatus: http.StatusOK},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		w := send(step.keyID, step.body)
+		if w.Code != step.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", step.name, step.expectedStatus, w.Code)
+		}
+		if retry := w.Header().Get("Retry-After"); retry != step.expectedRetry {
+			t.Errorf("%s: expected Retry-After %q, got %q", step.name, step.expectedRetry, retry)
+		}
+	}
+
+	if len(received) != 4 || received[0] != `{"user_id":"1"}` {
+		t.Errorf("expected 4 requests to reach the handler with their bodies intact, got %q", received)
+	}
+}
+
+func TestLimitShedding(t *testing.T) {
+	config := RateLimitConfig{Routes: map[string]RouteLimit{
+		"/rebalance": {MaxKafkaLatency: 500 * time.Millisecond, MaxESLatency: time.Second},
+	}}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		kafka          time.Duration
+		es             time.Duration
+		expectedStatus int
+	}{
+		{name: "healthy", path: "/rebalance", kafka: 100 * time.Millisecond, es: 200 * time.Millisecond, expectedStatus: http.StatusOK},
+		{name: "slow kafka", path: "/rebalance", kafka: time.Second, es: 200 * time.Millisecond, expectedStatus: http.StatusServiceUnavailable},
+		{name: "slow elasticsearch", path: "/rebalance", kafka: 100 * time.Millisecond, es: 2 * time.Second, expectedStatus: http.StatusServiceUnavailable},
+		{name: "route without thresholds", path: "/portfolio", kafka: time.Second, es: 2 * time.Second, expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Limit(config, mockLatency(tt.kafka), mockLatency(tt.es))(next)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+				t.Errorf("expected a Retry-After header")
+			}
+		})
+	}
+}
+
+func TestLimitInFlight(t *testing.T) {
+	config := RateLimitConfig{Routes: map[string]RouteLimit{"/rebalance": {MaxInFlight: 1}}}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rebalance" {
+			entered <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := Limit(config, nil, nil)(next)
+
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		return w.Code
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve("/rebalance")
+	}()
+	<-entered
+
+	if code := serve("/rebalance"); code != http.StatusTooManyRequests {
+		t.Errorf("expected a second request in flight to get 429, got %d", code)
+	}
+	if code := serve("/portfolio"); code != http.StatusOK {
+		t.Errorf("expected other routes to be unaffected, got %d", code)
+	}
+
+	close(release)
+	wg.Wait()
+
+	go func() { <-entered }()
+	if code := serve("/rebalance"); code != http.StatusOK {
+		t.Errorf("expected the slot to be freed once the first request finished, got %d", code)
+	}
+}
+
+func TestLimitRoutes(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	config := RateLimitConfig{
+		ClientRate: 100, ClientBurst: 100, UserRate: 0.5, UserBurst: 1,
+		Routes: map[string]RouteLimit{
+			"POST /v1/portfolios/{user_id}/rebalances": {MaxKafkaLatency: 500 * time.Millisecond},
+		},
+	}
+
+	mux := router.New()
+	mux.Use(limit(config, mockLatency(time.Second), nil, func() time.Time { return now }))
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	mux.HandleFunc(http.MethodGet, "/v1/portfolios/{user_id}/rebalances", ok)
+	mux.HandleFunc(http.MethodPost, "/v1/portfolios/{user_id}/rebalances", ok)
+
+	steps := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{name: "method limit sheds writes", method: http.MethodPost, path: "/v1/portfolios/1/rebalances", expectedStatus: http.StatusServiceUnavailable},
+		{name: "reads of the route are not shed", method: http.MethodGet, path: "/v1/portfolios/1/rebalances", expectedStatus: http.StatusOK},
+		{name: "user from the path is limited", method: http.MethodGet, path: "/v1/portfolios/1/rebalances", expectedStatus: http.StatusTooManyRequests},
+		{name: "other users are not", method: http.MethodGet, path: "/v1/portfolios/2/rebalances", expectedStatus: http.StatusOK},
+	}
+
+	for _, step := range steps {
+		req := httptest.NewRequest(step.method, step.path, nil)
+		req = req.WithContext(auth.WithAPIKey(req.Context(), &models.APIKey{ID: "k1"}))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != step.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", step.name, step.expectedStatus, w.Code)
+		}
+	}
+}
+
+func TestRateLimitConfigFromEnv(t *testing.T) {
+	for _, key := range []string{"RATE_LIMIT_CLIENT_RPS", "RATE_LIMIT_CLIENT_BURST", "RATE_LIMIT_USER_RPS", "RATE_LIMIT_USER_BURST", "ROUTE_LIMITS"} {
+		t.Setenv(key, "")
+	}
+	config, err := RateLimitConfigFromEnv()
+	if err != nil || config.Routes["/rebalance"].MaxInFlight == 0 {
+		t.Fatalf("expected /rebalance to be limited by default, got %+v, %v", config, err)
+	}
+
+	t.Setenv("ROUTE_LIMITS", "/rebalance=in_flight:10,kafka:250ms,es:2s; /portfolio=in_flight:5")
+	config, err = RateLimitConfigFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := RouteLimit{MaxInFlight: 10, MaxKafkaLatency: 250 * time.Millisecond, MaxESLatency: 2 * time.Second}
+	if config.Routes["/rebalance"] != expected || config.Routes["/portfolio"].MaxInFlight != 5 || len(config.Routes) != 2 {
+		t.Errorf("expected the configured route limits, got %+v", config.Routes)
+	}
+
+	t.Setenv("ROUTE_LIMITS", "POST /v1/portfolios/{user_id}/rebalances=in_flight:10")
+	config, err = RateLimitConfigFromEnv()
+	if err != nil || config.Routes["POST /v1/portfolios/{user_id}/rebalances"].MaxInFlight != 10 {
+		t.Errorf("expected a limit on the method and route, got %+v, %v", config.Routes, err)
+	}
+
+	t.Setenv("ROUTE_LIMITS", "post /rebalance=in_flight:10")
+	if _, err := RateLimitConfigFromEnv(); err == nil {
+		t.Errorf("expected a lowercase method to be rejected")
+	}
+
+	t.Setenv("ROUTE_LIMITS", "/rebalance=queue:10")
+	if _, err := RateLimitConfigFromEnv(); err == nil {
+		t.Errorf("expected an unknown route setting to be rejected")
+	}
+
+	t.Setenv("ROUTE_LIMITS", "")
+	t.Setenv("RATE_LIMIT_CLIENT_BURST", "0")
+	if _, err := RateLimitConfigFromEnv(); err == nil {
+		t.Errorf("expected a zero burst to be rejected")
+	}
+}
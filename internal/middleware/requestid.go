@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+	"portfolio-rebalancer/internal/requestinfo"
+)
+
+// RequestIDHeader carries the ID tying a request to its log lines and audit entries
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength keeps client supplied request IDs from bloating logs and audit entries
+const maxRequestIDLength = 128
+
+// RequestID reuses the client's X-Request-ID, or generates one, echoes it on the response
+// and stores it with the request's method and route in the request context
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = requestinfo.NewID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := requestinfo.WithInfo(r.Context(), requestinfo.Info{ID: id, Method: r.Method, Route: r.URL.Path})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"portfolio-rebalancer/internal/requestinfo"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		expectedID string
+	}{
+		{name: "client supplied", header: "req-1", expectedID: "req-1"},
+		{name: "generated when missing"},
+		{name: "generated when too long", header: strings.Repeat("x", maxRequestIDLength+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var info requestinfo.Info
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				info, _ = requestinfo.FromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/portfolio", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if tt.expectedID != "" && info.ID != tt.expectedID {
+				t.Errorf("expected request ID %q, got %q", tt.expectedID, info.ID)
+			}
+			if tt.expectedID == "" && (info.ID == "" || info.ID == tt.header) {
+				t.Errorf("expected a generated request ID, got %q", info.ID)
+			}
+			if got := w.Header().Get(RequestIDHeader); got != info.ID {
+				t.Errorf("expected %s %q on the response, got %q", RequestIDHeader, info.ID, got)
+			}
+			if info.Method != http.MethodPost || info.Route != "/portfolio" {
+				t.Errorf("expected POST /portfolio, got %s %s", info.Method, info.Route)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"net/http"
+	"portfolio-rebalancer/pkg/router"
+)
+
+// route returns the pattern the router matched for the request, e.g. /v1/portfolios/{user_id},
+// which policies and limits are keyed by. Requests no route matches, or that did not pass
+// through the router, fall back to their path.
+func route(r *http.Request) string {
+	if pattern := router.Pattern(r); pattern != "" {
+		return pattern
+	}
+	return r.URL.Path
+}
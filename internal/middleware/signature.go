@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/handlers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of a signed request
+const (
+	ProviderHeader  = "X-Provider-ID"         // which provider's secrets to check against
+	TimestampHeader = "X-Signature-Timestamp" // unix seconds when the request was signed
+	SignatureHeader = "X-Signature"           // "sha256=" followed by the hex HMAC-SHA256
+)
+
+// minSigningSecretLength keeps provider secrets as hard to guess as generated API keys
+const minSigningSecretLength = 32
+
+// maxSignedBodyBytes bounds how much of a request body is buffered to check its signature
+const maxSignedBodyBytes = 1 << 20
+
+// SigningSecret is a secret shared with a provider. A zero ExpiresAt never expires.
+type SigningSecret struct {
+	Secret    string
+	ExpiresAt time.Time
+}
+
+// SignatureConfig controls request signing by allocation providers
+type SignatureConfig struct {
+	Secrets map[string][]SigningSecret // per provider, any unexpired secret is accepted
+	Window  time.Duration              // how far a request's timestamp may be from now
+}
+
+// Enabled reports whether any provider has a signing secret
+func (c SignatureConfig) Enabled() bool {
+	return len(c.Secrets) > 0
+}
+
+// SignatureConfigFromEnv reads SIGNING_SECRETS as comma separated provider=secret pairs,
+// SIGNING_WINDOW (default 5m) and, while a secret is being rotated, SIGNING_PREVIOUS_SECRETS
+// in the same format, accepted until SIGNING_PREVIOUS_SECRETS_UNTIL (RFC 3339). Signing is
+// off when SIGNING_SECRETS is unset.
+func SignatureConfigFromEnv() (SignatureConfig, error) {
+	config := SignatureConfig{
+		Secrets: make(map[string][]SigningSecret),
+		Window:  5 * time.Minute,
+	}
+
+	if value := os.Getenv("SIGNING_WINDOW"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return config, fmt.Errorf("invalid SIGNING_WINDOW %q, expected a duration such as 5m", value)
+		}
+		config.Window = d
+	}
+
+	current, err := parseSigningSecrets("SIGNING_SECRETS")
+	if err != nil {
+		return config, err
+	}
+	for provider, secret := range current {
+		config.Secrets[provider] = []SigningSecret{{Secret: secret}}
+	}
+
+	previous, err := parseSigningSecrets("SIGNING_PREVIOUS_SECRETS")
+	if err != nil {
+		return config, err
+	}
+	if len(previous) == 0 {
+		return config, nil
+	}
+
+	value := os.Getenv("SIGNING_PREVIOUS_SECRETS_UNTIL")
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return config, fmt.Errorf("invalid SIGNING_PREVIOUS_SECRETS_UNTIL %q, expected an RFC 3339 time ending the grace period", value)
+	}
+	for provider, secret := range previous {
+		if _, ok := current[provider]; !ok {
+			return config, fmt.Errorf("SIGNING_PREVIOUS_SECRETS has a secret for %s but SIGNING_SECRETS does not", provider)
+		}
+		config.Secrets[provider] = append(config.Secrets[provider], SigningSecret{Secret: secret, ExpiresAt: until})
+	}
+
+	return config, nil
+}
+
+// parseSigningSecrets parses the provider=secret pairs of the environment variable key
+func parseSigningSecrets(key string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		provider, secret, ok := strings.Cut(pair, "=")
+		provider, secret = strings.TrimSpace(provider), strings.TrimSpace(secret)
+		if !ok || provider == "" {
+			return nil, fmt.Errorf("invalid %s entry, expected provider=secret", key)
+		}
+		if len(secret) < minSigningSecretLength {
+			return nil, fmt.Errorf("%s secret for %s must be at least %d characters", key, provider, minSigningSecretLength)
+		}
+		secrets[provider] = secret
+	}
+	return secrets, nil
+}
+
+// VerifySignature rejects writes to routes (401) unless they are signed with a current secret
+// of the provider named in X-Provider-ID and their timestamp is within the replay window.
+// Routes with path parameters have their path signed along with the body. Reads and other
+// routes pass through unchecked.
+func VerifySignature(config SignatureConfig, routes ...string) func(http.Handler) http.Handler {
+	return verifySignature(config, time.Now, routes...)
+}
+
+// verifySignature is VerifySignature with a clock, so tests can move the replay window
+func verifySignature(config SignatureConfig, now func() time.Time, routes ...string) func(http.Handler) http.Handler {
+	signed := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		signed[route] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !signed[route(r)] || r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			provider := r.Header.Get(ProviderHeader)
+			signature := strings.TrimPrefix(r.Header.Get(SignatureHeader), "sha256=")
+			if provider == "" || signature == "" || r.Header.Get(TimestampHeader) == "" {
+				handlers.RespondWithError(w, http.StatusUnauthorized, "Request must be signed with "+ProviderHeader+", "+TimestampHeader+" and "+SignatureHeader+" headers")
+				return
+			}
+
+			timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+			if err != nil {
+				handlers.RespondWithError(w, http.StatusUnauthorized, TimestampHeader+" must be a unix timestamp in seconds")
+				return
+			}
+			current := now()
+			if age := current.Sub(time.Unix(timestamp, 0)); age > config.Window || age < -config.Window {
+				log.Printf("Rejected %s %s from %s: signature timestamp %d is outside the %s replay window", r.Method, r.URL.Path, provider, timestamp, config.Window)
+				handlers.RespondWithError(w, http.StatusUnauthorized, "Signature timestamp is outside the replay window")
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodyBytes))
+			if err != nil {
+				handlers.RespondWithError(w, http.StatusRequestEntityTooLarge, "Request body is too large to verify")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			if !config.valid(provider, timestamp, signedPath(r), body, signature, current) {
+				log.Printf("Rejected %s %s from %s: invalid signature", r.Method, r.URL.Path, provider)
+				handlers.RespondWithError(w, http.StatusUnauthorized, "Invalid request signature")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// signedPath returns the path a request's signature covers: the escaped path on routes with
+// path parameters, nothing on the rest
+func signedPath(r *http.Request) string {
+	if strings.Contains(route(r), "{") {
+		return r.URL.EscapedPath()
+	}
+	return ""
+}
+
+// valid reports whether signature matches any of the provider's unexpired secrets
+func (c SignatureConfig) valid(provider string, timestamp int64, path string, body []byte, signature string, now time.Time) bool {
+	for _, secret := range c.Secrets[provider] {
+		if !secret.ExpiresAt.IsZero() && now.After(secret.ExpiresAt) {
+			continue
+		}
+		expected := auth.Sign(secret.Secret, timestamp, body)
+		if path != "" {
+			expected = auth.SignPath(secret.Secret, timestamp, path, body)
+		}
+		if hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/pkg/router"
+)
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	newSecret := strings.Repeat("n", 32)
+	oldSecret := strings.Repeat("o", 32)
+	expiredSecret := strings.Repeat("e", 32)
+	config := SignatureConfig{
+		Secrets: map[string][]SigningSecret{
+			"acme": {
+				{Secret: newSecret},
+				{Secret: oldSecret, ExpiresAt: now.Add(time.Hour)},
+				{Secret: expiredSecret, ExpiresAt: now.Add(-time.Hour)},
+			},
+		},
+		Window: 5 * time.Minute,
+	}
+	body := `{"user_id":"1","new_allocation":{"stocks":70,"bonds":30}}`
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		provider       string
+		secret         string
+		signedAt       time.Time
+		tamper         bool
+		unsigned       bool
+		expectedStatus int
+	}{
+		{
+			name:           "signed with the current secret",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			provider:       "acme",
+			secret:         newSecret,
+			signedAt:       now,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "signed with the previous secret during the grace period",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			provider:       "acme",
+			secret:         oldSecret,
+			signedAt:       now,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "signed with a secret past its grace period",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			provider:       "acme",
+			secret:         expiredSecret,
+			signedAt:       now,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "unknown provider",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			provider:       "other",
+			secret:         newSecret,
+			signedAt:       now,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "body changed after signing",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			provider:       "acme",
+			secret:         newSecret,
+			signedAt:       now,
+			tamper:         true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "replayed outside the window",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			provider:       "acme",
+			secret:         newSecret,
+			signedAt:       now.Add(-10 * time.Minute),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "timestamp in the future",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			provider:       "acme",
+			secret:         newSecret,
+			signedAt:       now.Add(10 * time.Minute),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "unsigned",
+			method:         http.MethodPost,
+			path:           "/rebalance",
+			unsigned:       true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "reads are not signed",
+			method:         http.MethodGet,
+			path:           "/rebalance",
+			unsigned:       true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "other routes are not signed",
+			method:         http.MethodPost,
+			path:           "/portfolio",
+			unsigned:       true,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				received = string(b)
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := verifySignature(config, func() time.Time { return now }, "/rebalance")(next)
+
+			sent := body
+			if tt.tamper {
+				sent = strings.Replace(body, "70", "90", 1)
+			}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(sent))
+			if !tt.unsigned {
+				timestamp := tt.signedAt.Unix()
+				req.Header.Set(ProviderHeader, tt.provider)
+				req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+				req.Header.Set(SignatureHeader, "sha256="+auth.Sign(tt.secret, timestamp, []byte(body)))
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code == http.StatusOK && tt.method == http.MethodPost && received != sent {
+				t.Errorf("expected the handler to receive the signed body, got %q", received)
+			}
+		})
+	}
+}
+
+func TestVerifySignaturePath(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	secret := strings.Repeat("n", 32)
+	config := SignatureConfig{Secrets: map[string][]SigningSecret{"acme": {{Secret: secret}}}, Window: 5 * time.Minute}
+	body := `{"new_allocation":{"stocks":70,"bonds":30}}`
+
+	mux := router.New()
+	mux.Use(verifySignature(config, func() time.Time { return now }, "/v1/portfolios/{user_id}/rebalances"))
+	mux.HandleFunc(http.MethodPost, "/v1/portfolios/{user_id}/rebalances", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		signature      string
+		expectedStatus int
+	}{
+		{
+			name:           "path signed with the body",
+			path:           "/v1/portfolios/1/rebalances",
+			signature:      auth.SignPath(secret, now.Unix(), "/v1/portfolios/1/rebalances", []byte(body)),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "signature replayed for another user",
+			path:           "/v1/portfolios/2/rebalances",
+			signature:      auth.SignPath(secret, now.Unix(), "/v1/portfolios/1/rebalances", []byte(body)),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "body signed without the path",
+			path:           "/v1/portfolios/1/rebalances",
+			signature:      auth.Sign(secret, now.Unix(), []byte(body)),
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(body))
+			req.Header.Set(ProviderHeader, "acme")
+			req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+			req.Header.Set(SignatureHeader, "sha256="+tt.signature)
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSignatureConfigFromEnv(t *testing.T) {
+	secret := strings.Repeat("s", 32)
+	t.Setenv("SIGNING_SECRETS", "")
+	t.Setenv("SIGNING_PREVIOUS_SECRETS", "")
+	t.Setenv("SIGNING_PREVIOUS_SECRETS_UNTIL", "")
+	t.Setenv("SIGNING_WINDOW", "")
+	config, err := SignatureConfigFromEnv()
+	if err != nil || config.Enabled() || config.Window != 5*time.Minute {
+		t.Fatalf("expected signing to be off with a 5m window by default, got %+v, %v", config, err)
+	}
+
+	t.Setenv("SIGNING_SECRETS", "acme="+secret)
+	t.Setenv("SIGNING_PREVIOUS_SECRETS", "acme="+strings.Repeat("p", 32))
+	t.Setenv("SIGNING_PREVIOUS_SECRETS_UNTIL", "2024-06-01T00:00:00Z")
+	config, err = SignatureConfigFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(config.Secrets["acme"]) != 2 || config.Secrets["acme"][1].ExpiresAt.IsZero() {
+		t.Errorf("expected the current secret and a previous one with a deadline, got %+v", config.Secrets["acme"])
+	}
+
+	t.Setenv("SIGNING_PREVIOUS_SECRETS_UNTIL", "")
+	if _, err := SignatureConfigFromEnv(); err == nil {
+		t.Errorf("expected previous secrets without a grace deadline to be rejected")
+	}
+
+	t.Setenv("SIGNING_PREVIOUS_SECRETS", "")
+	t.Setenv("SIGNING_SECRETS", "acme=short")
+	if _, err := SignatureConfigFromEnv(); err == nil {
+		t.Errorf("expected a short secret to be rejected")
+	}
+}
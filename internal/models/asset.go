@@ -0,0 +1,83 @@
+package models
+
+import "fmt"
+
+// Asset validation modes for allocations reported by the provider
+const (
+	AssetValidationStrict = "strict" // reject unknown or missing assets
+	AssetValidationWarn   = "warn"   // accept, but report unknown or missing assets
+	AssetValidationAlias  = "alias"  // map aliases to canonical assets, then reject what is still unknown
+)
+
+// AssetValidation describes how a reported allocation compares with the portfolio's asset universe
+type AssetValidation struct {
+	Mode       string             `json:"mode"`
+	Allocation map[string]float64 `json:"-"`                 // allocation after aliases were applied
+	Unknown    []string           `json:"unknown,omitempty"` // assets outside the universe
+	Missing    []string           `json:"missing,omitempty"` // held or targeted assets absent from the allocation
+	Aliased    map[string]string  `json:"aliased,omitempty"` // alias -> canonical asset
+}
+
+// HasIssues reports whether the allocation had unknown or missing assets
+func (v *AssetValidation) HasIssues() bool {
+	return len(v.Unknown) > 0 || len(v.Missing) > 0
+}
+
+// Asset is an entry in the asset registry. Its ID is the canonical name used in
+// allocations and transactions; aliases resolve to it.
+type Asset struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`               // display name
+	Class     string   `json:"class"`              // asset class, e.g. equity, fixed_income, commodity
+	Currency  string   `json:"currency,omitempty"` // ISO 4217 code the asset is priced in
+	Tradable  bool     `json:"tradable"`
+	MinLot    float64  `json:"min_lot,omitempty"` // smallest tradable quantity, 0 when fractional
+	Aliases   []string `json:"aliases,omitempty"`
+	UpdatedAt string   `json:"updated_at"`
+}
+
+// AssetRegistry looks up assets by ID or alias
+type AssetRegistry struct {
+	byName map[string]Asset
+}
+
+// NewAssetRegistry indexes the assets by ID and by every alias
+func NewAssetRegistry(assets []Asset) *AssetRegistry {
+	r := &AssetRegistry{byName: make(map[string]Asset)}
+	for _, asset := range assets {
+		r.byName[asset.ID] = asset
+		for _, alias := range asset.Aliases {
+			r.byName[alias] = asset
+		}
+	}
+	return r
+}
+
+// Lookup returns the asset with the given ID or alias
+func (r *AssetRegistry) Lookup(name string) (Asset, bool) {
+	asset, ok := r.byName[name]
+	return asset, ok
+}
+
+// Empty reports whether no assets are registered, in which case names are used as-is
+func (r *AssetRegistry) Empty() bool {
+	return len(r.byName) == 0
+}
+
+// Canonical maps every key of the allocation to its asset ID, adding up aliases of the
+// same asset. It fails on names the registry does not know unless the registry is empty.
+func (r *AssetRegistry) Canonical(allocation map[string]float64) (map[string]float64, error) {
+	if r.Empty() || allocation == nil {
+		return allocation, nil
+	}
+
+	result := make(map[string]float64, len(allocation))
+	for name, percent := range allocation {
+		asset, ok := r.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown asset %s", name)
+		}
+		result[asset.ID] += percent
+	}
+	return result, nil
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestAssetRegistryCanonical(t *testing.T) {
+	registry := NewAssetRegistry([]Asset{
+		{ID: "stocks", Name: "Global Equity", Class: "equity", Aliases: []string{"stock", "equities"}},
+		{ID: "bonds", Name: "Aggregate Bonds", Class: "fixed_income", Aliases: []string{"bond"}},
+	})
+
+	tests := []struct {
+		name        string
+		registry    *AssetRegistry
+		allocation  map[string]float64
+		expected    map[string]float64
+		expectError bool
+	}{
+		{
+			name:       "aliases are merged into the canonical asset",
+			registry:   registry,
+			allocation: map[string]float64{"stock": 30.0, "equities": 30.0, "bond": 40.0},
+			expected:   map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		},
+		{
+			name:        "unknown asset",
+			registry:    registry,
+			allocation:  map[string]float64{"crypto": 100.0},
+			expectError: true,
+		},
+		{
+			name:       "empty registry keeps names",
+			registry:   NewAssetRegistry(nil),
+			allocation: map[string]float64{"anything": 100.0},
+			expected:   map[string]float64{"anything": 100.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.registry.Canonical(tt.allocation)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, result)
+			}
+			for asset, percent := range tt.expected {
+				if result[asset] != percent {
+					t.Errorf("expected %s=%.2f, got %.2f", asset, percent, result[asset])
+				}
+			}
+		})
+	}
+}
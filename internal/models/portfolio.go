@@ -4,6 +4,63 @@ type Portfolio struct {
 	UserID             string             `json:"user_id"`
 	Allocation         map[string]float64 `json:"allocation"`          // Current user allocation in percentage terms
 	OriginalAllocation map[string]float64 `json:"original_allocation"` // Target allocation to maintain
+	ModelID            string             `json:"model_id,omitempty"`  // Model portfolio the target follows, if any
+	// Hierarchical form of the target, e.g. equities -> US/international. Its leaves make up OriginalAllocation
+	AllocationTree []AllocationNode `json:"allocation_tree,omitempty"`
+	// Per-asset restrictions the rebalance must respect, e.g. employer stock that cannot be sold
+	Constraints map[string]AssetConstraint `json:"constraints,omitempty"`
+	TotalValue  float64                    `json:"total_value,omitempty"` // Portfolio value, needed to turn cash flows into percentages
+	Currency    string                     `json:"currency,omitempty"`    // Base currency of TotalValue and cash flows, defaults to USD
+	// When the scheduler rebalances the portfolio: monthly, quarterly or a cron expression (UTC)
+	Schedule        string `json:"schedule,omitempty"`
+	NextRebalanceAt string `json:"next_rebalance_at,omitempty"` // Next scheduled run, set from Schedule
+	// Rebalances stay PROPOSED until someone approves them, e.g. for discretionary mandates
+	RequireApproval bool `json:"require_approval,omitempty"`
+}
+
+// DefaultCurrency is the base currency of portfolios created without one
+const DefaultCurrency = "USD"
+
+// BaseCurrency returns the portfolio currency, falling back to DefaultCurrency for
+// portfolios stored before currencies were introduced
+func (p Portfolio) BaseCurrency() string {
+	if p.Currency == "" {
+		return DefaultCurrency
+	}
+	return p.Currency
+}
+
+// AssetConstraint restricts how far a rebalance may move one asset
+type AssetConstraint struct {
+	Min    *float64 `json:"min,omitempty"`     // floor in percentage terms
+	Max    *float64 `json:"max,omitempty"`     // ceiling in percentage terms, e.g. a regulatory cap
+	NoBuy  bool     `json:"no_buy,omitempty"`  // may only be held or sold
+	NoSell bool     `json:"no_sell,omitempty"` // may only be held or bought
+	Locked bool     `json:"locked,omitempty"`  // neither bought nor sold
+}
+
+// ConstraintResult is the closest allocation to the target that satisfies a portfolio's constraints
+type ConstraintResult struct {
+	Target     map[string]float64 `json:"target"`               // feasible target the rebalance moves to
+	Adjusted   bool               `json:"adjusted"`             // true when the requested target violated a constraint
+	Violations []string           `json:"violations,omitempty"` // why the requested target was adjusted
+}
+
+// AllocationNode is one asset class in a hierarchical allocation. Children split the
+// parent's share, so their percentages must add up to the parent's percentage.
+type AllocationNode struct {
+	Asset    string           `json:"asset"`
+	Percent  float64          `json:"percent"` // share of the whole portfolio, not of the parent
+	Children []AllocationNode `json:"children,omitempty"`
+}
+
+// AllocationDrift reports how far an asset class is from its target, including its sub-classes
+type AllocationDrift struct {
+	Asset    string            `json:"asset"`
+	Current  float64           `json:"current"`
+	Target   float64           `json:"target"`
+	Drift    float64           `json:"drift"` // current - target, positive when overweight
+	Children []AllocationDrift `json:"children,omitempty"`
 }
 
 type UpdatedPortfolio struct {
@@ -11,10 +68,265 @@ type UpdatedPortfolio struct {
 	NewAllocation map[string]float64 `json:"new_allocation"` // Updated user allocation from provider in percentage terms
 }
 
+// PortfolioSchedule sets when the scheduler rebalances a portfolio; an empty schedule turns it off
+type PortfolioSchedule struct {
+	UserID   string `json:"user_id"`
+	Schedule string `json:"schedule"` // monthly, quarterly or a cron expression
+}
+
 type RebalanceTransaction struct {
+	RebalanceID      string   `json:"rebalance_id,omitempty"` // shared by every leg of one rebalance
+	UserID           string   `json:"user_id"`
+	Action           string   `json:"action"`                // BUY or SELL
+	Asset            string   `json:"asset"`                 // stocks, bonds, gold, etc.
+	AssetName        string   `json:"asset_name,omitempty"`  // display name from the asset registry
+	AssetClass       string   `json:"asset_class,omitempty"` // asset class from the asset registry
+	RebalancePercent float64  `json:"rebalance_percent"`     // percentage to buy/sell
+	Amount           float64  `json:"amount,omitempty"`      // cash amount to buy/sell, set for cash flow driven trades
+	Currency         string   `json:"currency,omitempty"`    // portfolio base currency of Amount
+	AssetCurrency    string   `json:"asset_currency,omitempty"`
+	AssetAmount      float64  `json:"asset_amount,omitempty"` // Amount converted into the asset's currency
+	FXRates          []FXRate `json:"fx_rates,omitempty"`     // every rate applied to reach AssetAmount
+	Status           string   `json:"status,omitempty"`       // lifecycle status of the rebalance the leg belongs to
+	Timestamp        string   `json:"timestamp"`
+}
+
+// Lifecycle statuses of a rebalance and its transactions
+const (
+	RebalanceStatusProposed = "PROPOSED" // waiting for approval
+	RebalanceStatusApproved = "APPROVED" // published for execution
+	RebalanceStatusRejected = "REJECTED" // never executed
+	RebalanceStatusExecuted = "EXECUTED"
+	RebalanceStatusFailed   = "FAILED"
+)
+
+// Actors recorded on rebalance events that are not made by a person
+const (
+	ActorProvider  = "provider"  // allocation reported through /rebalance
+	ActorScheduler = "scheduler" // scheduled rebalance
+	ActorModel     = "model"     // model portfolio change
+	ActorUser      = "user"      // deposit or withdrawal made by the user
+	ActorSystem    = "system"    // automatic approval and execution outcomes
+)
+
+// Rebalance groups the transactions of one rebalance so they are approved, rejected
+// and executed as a unit
+type Rebalance struct {
+	ID           string                 `json:"id"` // the transactions' rebalance_id
+	UserID       string                 `json:"user_id"`
+	Status       string                 `json:"status"`
+	Transactions []RebalanceTransaction `json:"transactions"`
+	// Allocation the portfolio reaches once every transaction fills; execution results
+	// are applied against it
+	ExpectedAllocation map[string]float64 `json:"expected_allocation,omitempty"`
+	Events             []RebalanceEvent   `json:"events"` // every status change, oldest first
+	CreatedAt          string             `json:"created_at"`
+	UpdatedAt          string             `json:"updated_at"`
+}
+
+// RebalanceEvent records who moved a rebalance to a status, and why
+type RebalanceEvent struct {
+	Status    string `json:"status"`
+	Actor     string `json:"actor"`
+	Reason    string `json:"reason,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Execution statuses reported by the broker for one transaction
+const (
+	ExecutionFilled          = "FILLED"
+	ExecutionPartiallyFilled = "PARTIALLY_FILLED"
+	ExecutionRejected        = "REJECTED"
+)
+
+// Execution is the broker's result for one transaction
+type Execution struct {
+	ID               string  `json:"id"`
+	RebalanceID      string  `json:"rebalance_id"`
 	UserID           string  `json:"user_id"`
-	Action           string  `json:"action"`            // BUY or SELL
-	Asset            string  `json:"asset"`             // stocks, bonds, gold, etc.
-	RebalancePercent float64 `json:"rebalance_percent"` // percentage to buy/sell
+	Action           string  `json:"action"`
+	Asset            string  `json:"asset"`
+	Status           string  `json:"status"`            // FILLED, PARTIALLY_FILLED or REJECTED
+	RequestedPercent float64 `json:"requested_percent"` // rebalance_percent of the transaction
+	FilledPercent    float64 `json:"filled_percent"`
+	Quantity         float64 `json:"quantity,omitempty"`   // units filled, known when the transaction has an amount
+	FillPrice        float64 `json:"fill_price,omitempty"` // average price per unit
+	Reason           string  `json:"reason,omitempty"`     // why the order was rejected or not fully filled
+	Broker           string  `json:"broker"`
+	ExecutedAt       string  `json:"executed_at"`
+}
+
+// Reconciliation statuses of a portfolio
+const (
+	ReconciliationStatusMatched = "MATCHED" // every asset is within tolerance of its target
+	ReconciliationStatusBreak   = "BREAK"   // at least one asset is off target by more than the tolerance
+	ReconciliationStatusPending = "PENDING" // off target while a rebalance is still awaiting approval or execution
+)
+
+// ReconciliationReport compares a portfolio's target with the last reported allocation
+// plus every execution since that report
+type ReconciliationReport struct {
+	ID         string                `json:"id"`
+	UserID     string                `json:"user_id"`
+	Status     string                `json:"status"` // MATCHED, BREAK or PENDING
+	Target     map[string]float64    `json:"target"`
+	Reported   map[string]float64    `json:"reported"`
+	ReportedAt string                `json:"reported_at,omitempty"`
+	Actual     map[string]float64    `json:"actual"`     // reported plus the filled part of each execution
+	Executions int                   `json:"executions"` // executions applied since the report
+	Breaks     []ReconciliationBreak `json:"breaks,omitempty"`
+	Tolerance  float64               `json:"tolerance"` // percentage points an asset may be off target
+	CreatedAt  string                `json:"created_at"`
+}
+
+// ReconciliationBreak is one asset whose actual allocation is off target
+type ReconciliationBreak struct {
+	Asset      string  `json:"asset"`
+	Target     float64 `json:"target"`
+	Actual     float64 `json:"actual"`
+	Difference float64 `json:"difference"` // actual minus target
+}
+
+// ReconciliationSummary counts the latest report of every portfolio reconciled since a point in time
+type ReconciliationSummary struct {
+	Since      string   `json:"since"`
+	Portfolios int      `json:"portfolios"`
+	Matched    int      `json:"matched"`
+	Breaks     int      `json:"breaks"`
+	Pending    int      `json:"pending"`
+	BreakUsers []string `json:"break_users"` // users whose latest report is a BREAK, sorted
+}
+
+// RebalanceDecision approves or rejects a proposed rebalance
+type RebalanceDecision struct {
+	RebalanceID string `json:"rebalance_id"`
+	Actor       string `json:"actor"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// APIKey identifies a client of the API and what it may do. The key itself is only
+// shown once, when it is created; KeyHash is what is stored.
+type APIKey struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`               // e.g. the provider or team the key was issued to
+	Prefix    string   `json:"prefix"`             // first characters of the key, to recognise it
+	KeyHash   string   `json:"key_hash,omitempty"` // SHA-256 of the key, never returned by the API
+	Scopes    []string `json:"scopes"`             // portfolio:read, portfolio:write, rebalance:submit or admin
+	CreatedAt string   `json:"created_at"`
+	RevokedAt string   `json:"revoked_at,omitempty"` // set once the key no longer authenticates
+}
+
+// Audited actions
+const (
+	AuditPortfolioCreate  = "portfolio.create"  // portfolio created
+	AuditPortfolioUpdate  = "portfolio.update"  // allocation, target or schedule changed
+	AuditRebalanceSubmit  = "rebalance.submit"  // rebalance proposed or published
+	AuditRebalanceApprove = "rebalance.approve" // proposed rebalance approved and published
+	AuditRebalanceReject  = "rebalance.reject"  // proposed rebalance rejected
+)
+
+// AuditEntry records who changed a portfolio or submitted or decided a rebalance, and how.
+// Entries are append-only. Before is empty for a new portfolio; for a submitted rebalance,
+// After is the portfolio at the allocation the rebalance is expected to reach. Decisions
+// carry no snapshots.
+type AuditEntry struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Action      string     `json:"action"`
+	Actor       string     `json:"actor"`            // API key name, end user or the system actor
+	Client      string     `json:"client,omitempty"` // API key ID or end-user token, empty for background jobs
+	Method      string     `json:"method,omitempty"`
+	Route       string     `json:"route,omitempty"`
+	RequestID   string     `json:"request_id,omitempty"`
+	RebalanceID string     `json:"rebalance_id,omitempty"`
+	Before      *Portfolio `json:"before,omitempty"`
+	After       *Portfolio `json:"after,omitempty"`
+	Timestamp   string     `json:"timestamp"`
+}
+
+// FXRate is a conversion rate applied to an amount, kept for auditability
+type FXRate struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Rate   float64 `json:"rate"` // amount in To = amount in From * Rate
+	AsOf   string  `json:"as_of,omitempty"`
+	Source string  `json:"source"`
+}
+
+// CashAsset is the counterpart of a switch when sells and buys do not net to zero, and
+// holds what unfilled orders leave uninvested
+const CashAsset = "cash"
+
+// RebalanceSwitch moves a percentage of the portfolio from one asset to another,
+// the form in which custodians execute a rebalance
+type RebalanceSwitch struct {
+	RebalanceID      string  `json:"rebalance_id"`
+	UserID           string  `json:"user_id"`
+	From             string  `json:"from"`              // asset sold, or cash when buys exceed sells
+	To               string  `json:"to"`                // asset bought, or cash when sells exceed buys
+	RebalancePercent float64 `json:"rebalance_percent"` // percentage moved
 	Timestamp        string  `json:"timestamp"`
 }
+
+// Cash flow types
+const (
+	CashFlowDeposit    = "DEPOSIT"
+	CashFlowWithdrawal = "WITHDRAWAL"
+)
+
+// CashFlow is money added to or taken out of a portfolio
+type CashFlow struct {
+	UserID         string  `json:"user_id"`
+	Type           string  `json:"type"`                      // DEPOSIT or WITHDRAWAL
+	Amount         float64 `json:"amount"`                    // always positive
+	PortfolioValue float64 `json:"portfolio_value,omitempty"` // value before the cash flow, defaults to the stored total_value
+	Currency       string  `json:"currency,omitempty"`        // currency of Amount, defaults to the portfolio currency
+	FXRate         *FXRate `json:"fx_rate,omitempty"`         // conversion into the portfolio currency, when one was needed
+}
+
+// CashFlowResult is the outcome of directing a cash flow across a portfolio
+type CashFlowResult struct {
+	Transactions  []RebalanceTransaction `json:"transactions"`
+	NewAllocation map[string]float64     `json:"new_allocation"` // allocation after the trades, in percentage terms
+	NewValue      float64                `json:"new_value"`
+}
+
+// Sources of an allocation change recorded in the portfolio history
+const (
+	AllocationSourceUser      = "user"      // target set by the user
+	AllocationSourceProvider  = "provider"  // drift reported by the 3rd party provider
+	AllocationSourceModel     = "model"     // target changed by the subscribed model portfolio
+	AllocationSourceCashFlow  = "cashflow"  // allocation moved by a deposit or withdrawal
+	AllocationSourceSchedule  = "schedule"  // allocation moved by a scheduled rebalance, in older history; executions now record the fills
+	AllocationSourceExecution = "execution" // allocation corrected by what the broker actually filled
+)
+
+// AllocationHistory is an append-only snapshot of a portfolio taken on every allocation change
+type AllocationHistory struct {
+	UserID             string             `json:"user_id"`
+	Allocation         map[string]float64 `json:"allocation"`
+	OriginalAllocation map[string]float64 `json:"original_allocation"`
+	// The rest of the portfolio at the time, so it can be reconstructed as of the change
+	ModelID        string                     `json:"model_id,omitempty"`
+	AllocationTree []AllocationNode           `json:"allocation_tree,omitempty"`
+	Constraints    map[string]AssetConstraint `json:"constraints,omitempty"`
+	TotalValue     float64                    `json:"total_value,omitempty"`
+	Currency       string                     `json:"currency,omitempty"`
+	Source         string                     `json:"source"`    // user, provider, model or cashflow
+	Timestamp      string                     `json:"timestamp"` // RFC3339 with nanoseconds to keep changes ordered
+}
+
+// ModelPortfolio is a target allocation managed by advisors that many users can subscribe to
+type ModelPortfolio struct {
+	ID         string             `json:"id"`
+	Name       string             `json:"name"`       // e.g. "Balanced 60/40"
+	Allocation map[string]float64 `json:"allocation"` // Target allocation in percentage terms
+	UpdatedAt  string             `json:"updated_at"`
+}
+
+// ModelImpact is the rebalance a model change causes for one subscribed user
+type ModelImpact struct {
+	UserID       string                 `json:"user_id"`
+	Transactions []RebalanceTransaction `json:"transactions"`
+	Error        string                 `json:"error,omitempty"` // set when applying the change failed for this user
+}
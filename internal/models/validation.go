@@ -26,3 +26,93 @@ func ValidateAllocation(allocation map[string]float64) error {
 
 	return nil
 }
+
+// ValidateAllocationTree checks a hierarchical allocation: the top level must pass
+// ValidateAllocation, children must sum to their parent and asset names must be unique
+func ValidateAllocationTree(nodes []AllocationNode) error {
+	if len(nodes) == 0 {
+		return fmt.Errorf("allocation cannot be empty")
+	}
+
+	topLevel := make(map[string]float64, len(nodes))
+	for _, node := range nodes {
+		topLevel[node.Asset] = node.Percent
+	}
+	if len(topLevel) != len(nodes) {
+		return fmt.Errorf("allocation tree contains duplicate assets")
+	}
+	if err := ValidateAllocation(topLevel); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	return validateNodes(nodes, seen)
+}
+
+func validateNodes(nodes []AllocationNode, seen map[string]bool) error {
+	for _, node := range nodes {
+		if node.Asset == "" {
+			return fmt.Errorf("allocation tree contains a node without an asset name")
+		}
+		if seen[node.Asset] {
+			return fmt.Errorf("asset %s appears more than once in the allocation tree", node.Asset)
+		}
+		seen[node.Asset] = true
+
+		if node.Percent < 0 {
+			return fmt.Errorf("allocation for %s cannot be negative", node.Asset)
+		}
+
+		if len(node.Children) == 0 {
+			continue
+		}
+
+		var total float64
+		for _, child := range node.Children {
+			total += child.Percent
+		}
+		if math.Abs(total-node.Percent) > 0.01 {
+			return fmt.Errorf("children of %s must sum to %.2f%%, got %.2f%%", node.Asset, node.Percent, total)
+		}
+
+		if err := validateNodes(node.Children, seen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FlattenAllocation returns the leaf assets of a hierarchical allocation as a flat allocation map
+func FlattenAllocation(nodes []AllocationNode) map[string]float64 {
+	allocation := make(map[string]float64)
+	flattenInto(nodes, allocation)
+	return allocation
+}
+
+func flattenInto(nodes []AllocationNode, allocation map[string]float64) {
+	for _, node := range nodes {
+		if len(node.Children) == 0 {
+			allocation[node.Asset] = node.Percent
+			continue
+		}
+		flattenInto(node.Children, allocation)
+	}
+}
+
+// ValidateConstraints checks that each constraint is internally consistent
+func ValidateConstraints(constraints map[string]AssetConstraint) error {
+	for asset, c := range constraints {
+		if c.Min != nil && (*c.Min < 0 || *c.Min > 100) {
+			return fmt.Errorf("min for %s must be between 0 and 100", asset)
+		}
+		if c.Max != nil && (*c.Max < 0 || *c.Max > 100) {
+			return fmt.Errorf("max for %s must be between 0 and 100", asset)
+		}
+		if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+			return fmt.Errorf("min for %s cannot be greater than max", asset)
+		}
+	}
+
+	return nil
+}
@@ -77,8 +77,8 @@ func TestValidateAllocation(t *testing.T) {
 			errorMsg:    "allocation must sum to 100%, got 150.00%",
 		},
 		{
-			name: "invalid allocation - empty",
-			allocation: map[string]float64{},
+			name:        "invalid allocation - empty",
+			allocation:  map[string]float64{},
 			expectError: true,
 			errorMsg:    "allocation cannot be empty",
 		},
@@ -139,3 +139,198 @@ func TestValidateAllocation(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateAllocationTree(t *testing.T) {
+	tests := []struct {
+		name        string
+		tree        []AllocationNode
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name: "valid two-level tree",
+			tree: []AllocationNode{
+				{Asset: "equities", Percent: 60.0, Children: []AllocationNode{
+					{Asset: "us", Percent: 40.0},
+					{Asset: "international", Percent: 15.0},
+					{Asset: "em", Percent: 5.0},
+				}},
+				{Asset: "bonds", Percent: 40.0, Children: []AllocationNode{
+					{Asset: "govt", Percent: 25.0},
+					{Asset: "corp", Percent: 15.0},
+				}},
+			},
+			expectError: false,
+		},
+		{
+			name: "valid flat tree",
+			tree: []AllocationNode{
+				{Asset: "stocks", Percent: 60.0},
+				{Asset: "bonds", Percent: 40.0},
+			},
+			expectError: false,
+		},
+		{
+			name:        "empty tree",
+			tree:        []AllocationNode{},
+			expectError: true,
+			errorMsg:    "allocation cannot be empty",
+		},
+		{
+			name: "top level does not sum to 100",
+			tree: []AllocationNode{
+				{Asset: "equities", Percent: 60.0},
+				{Asset: "bonds", Percent: 30.0},
+			},
+			expectError: true,
+			errorMsg:    "allocation must sum to 100%, got 90.00%",
+		},
+		{
+			name: "children do not sum to parent",
+			tree: []AllocationNode{
+				{Asset: "equities", Percent: 60.0, Children: []AllocationNode{
+					{Asset: "us", Percent: 40.0},
+					{Asset: "international", Percent: 15.0},
+				}},
+				{Asset: "bonds", Percent: 40.0},
+			},
+			expectError: true,
+			errorMsg:    "children of equities must sum to 60.00%, got 55.00%",
+		},
+		{
+			name: "negative child",
+			tree: []AllocationNode{
+				{Asset: "equities", Percent: 60.0, Children: []AllocationNode{
+					{Asset: "us", Percent: 70.0},
+					{Asset: "em", Percent: -10.0},
+				}},
+				{Asset: "bonds", Percent: 40.0},
+			},
+			expectError: true,
+			errorMsg:    "allocation for em cannot be negative",
+		},
+		{
+			name: "duplicate asset across levels",
+			tree: []AllocationNode{
+				{Asset: "equities", Percent: 60.0, Children: []AllocationNode{
+					{Asset: "bonds", Percent: 60.0},
+				}},
+				{Asset: "bonds", Percent: 40.0},
+			},
+			expectError: true,
+			errorMsg:    "asset bonds appears more than once in the allocation tree",
+		},
+		{
+			name: "duplicate top-level asset",
+			tree: []AllocationNode{
+				{Asset: "stocks", Percent: 50.0},
+				{Asset: "stocks", Percent: 50.0},
+			},
+			expectError: true,
+			errorMsg:    "allocation tree contains duplicate assets",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAllocationTree(tt.tree)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got nil")
+					return
+				}
+				if err.Error() != tt.errorMsg {
+					t.Errorf("expected error message '%s', got '%s'", tt.errorMsg, err.Error())
+				}
+			} else {
+				if err != nil {
+					t.Errorf("expected no error but got: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestFlattenAllocation(t *testing.T) {
+	tree := []AllocationNode{
+		{Asset: "equities", Percent: 60.0, Children: []AllocationNode{
+			{Asset: "us", Percent: 40.0},
+			{Asset: "intl", Percent: 20.0, Children: []AllocationNode{
+				{Asset: "europe", Percent: 12.0},
+				{Asset: "asia", Percent: 8.0},
+			}},
+		}},
+		{Asset: "gold", Percent: 40.0},
+	}
+
+	expected := map[string]float64{"us": 40.0, "europe": 12.0, "asia": 8.0, "gold": 40.0}
+	flat := FlattenAllocation(tree)
+
+	if len(flat) != len(expected) {
+		t.Fatalf("expected %d leaves, got %d: %v", len(expected), len(flat), flat)
+	}
+	for asset, percent := range expected {
+		if flat[asset] != percent {
+			t.Errorf("expected %s=%.2f, got %.2f", asset, percent, flat[asset])
+		}
+	}
+	if err := ValidateAllocation(flat); err != nil {
+		t.Errorf("expected flattened allocation to be valid, got %v", err)
+	}
+}
+
+func TestValidateConstraints(t *testing.T) {
+	low, high, over := 10.0, 50.0, 120.0
+
+	tests := []struct {
+		name        string
+		constraints map[string]AssetConstraint
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:        "no constraints",
+			constraints: nil,
+			expectError: false,
+		},
+		{
+			name: "valid floor and ceiling",
+			constraints: map[string]AssetConstraint{
+				"stocks": {Min: &low, Max: &high},
+				"acme":   {Locked: true},
+			},
+			expectError: false,
+		},
+		{
+			name: "min greater than max",
+			constraints: map[string]AssetConstraint{
+				"stocks": {Min: &high, Max: &low},
+			},
+			expectError: true,
+			errorMsg:    "min for stocks cannot be greater than max",
+		},
+		{
+			name: "max above 100",
+			constraints: map[string]AssetConstraint{
+				"stocks": {Max: &over},
+			},
+			expectError: true,
+			errorMsg:    "max for stocks must be between 0 and 100",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConstraints(tt.constraints)
+
+			if tt.expectError {
+				if err == nil || err.Error() != tt.errorMsg {
+					t.Errorf("expected error '%s', got '%v'", tt.errorMsg, err)
+				}
+			} else if err != nil {
+				t.Errorf("expected no error but got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"portfolio-rebalancer/internal/models"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// maxHistoryResults caps the number of history records returned by a single query
+const maxHistoryResults = 1000
+
+type AllocationHistoryRepository interface {
+	Append(ctx context.Context, record models.AllocationHistory) error
+	ListByUserID(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error)
+	GetAsOf(ctx context.Context, userID string, asOf time.Time) (*models.AllocationHistory, error)
+	GetLatestBySource(ctx context.Context, userID string, sources []string) (*models.AllocationHistory, error)
+}
+
+// AllocationHistoryRepositoryImpl implements AllocationHistoryRepository using Elasticsearch
+type AllocationHistoryRepositoryImpl struct {
+	client *elasticsearch.Client
+}
+
+// NewAllocationHistoryRepository creates a new Elasticsearch allocation history repository
+func NewAllocationHistoryRepository(client *elasticsearch.Client) AllocationHistoryRepository {
+	return &AllocationHistoryRepositoryImpl{
+		client: client,
+	}
+}
+
+// Append stores a new history record. Records are never updated, so Elasticsearch generates the ID
+func (r *AllocationHistoryRepositoryImpl) Append(ctx context.Context, record models.AllocationHistory) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.client.Index(historyIndex, bytes.NewReader(body),
+		r.client.Index.WithOpType("create"),
+		r.client.Index.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error saving allocation history: %s", res.String())
+	}
+
+	return nil
+}
+
+// ListByUserID returns the history of a user between from and to, oldest first.
+// A zero from or to leaves that side of the range open.
+func (r *AllocationHistoryRepositoryImpl) ListByUserID(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error) {
+	timestampRange := map[string]interface{}{}
+	if !from.IsZero() {
+		timestampRange["gte"] = from.UTC().Format(time.RFC3339Nano)
+	}
+	if !to.IsZero() {
+		timestampRange["lte"] = to.UTC().Format(time.RFC3339Nano)
+	}
+
+	return r.search(ctx, userID, timestampRange, nil, "asc", maxHistoryResults)
+}
+
+// GetAsOf returns the latest history record at or before asOf
+func (r *AllocationHistoryRepositoryImpl) GetAsOf(ctx context.Context, userID string, asOf time.Time) (*models.AllocationHistory, error) {
+	records, err := r.search(ctx, userID, map[string]interface{}{
+		"lte": asOf.UTC().Format(time.RFC3339Nano),
+	}, nil, "desc", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no allocation history at %s: %w", asOf.UTC().Format(time.RFC3339), ErrNotFound)
+	}
+
+	return &records[0], nil
+}
+
+// GetLatestBySource returns the latest history record written by one of sources
+func (r *AllocationHistoryRepositoryImpl) GetLatestBySource(ctx context.Context, userID string, sources []string) (*models.AllocationHistory, error) {
+	records, err := r.search(ctx, userID, nil, sources, "desc", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no allocation history from %v: %w", sources, ErrNotFound)
+	}
+
+	return &records[0], nil
+}
+
+func (r *AllocationHistoryRepositoryImpl) search(ctx context.Context, userID string, timestampRange map[string]interface{}, sources []string, order string, size int) ([]models.AllocationHistory, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filters := []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"user_id": userID}},
+	}
+	if len(timestampRange) > 0 {
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"timestamp": timestampRange}})
+	}
+	if len(sources) > 0 {
+		filters = append(filters, map[string]interface{}{"terms": map[string]interface{}{"source": sources}})
+	}
+
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{"filter": filters},
+		},
+		"sort": []interface{}{
+			map[string]interface{}{"timestamp": map[string]interface{}{"order": order}},
+		},
+		"size": size,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Search(
+		r.client.Search.WithIndex(historyIndex),
+		r.client.Search.WithBody(bytes.NewReader(query)),
+		r.client.Search.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error searching allocation history: %s", res.String())
+	}
+
+	var esResp struct {
+		Hits struct {
+			Hits []struct {
+				Source models.AllocationHistory `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	records := make([]models.AllocationHistory, 0, len(esResp.Hits.Hits))
+	for _, hit := range esResp.Hits.Hits {
+		records = append(records, hit.Source)
+	}
+
+	return records, nil
+}
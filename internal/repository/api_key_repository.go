@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"portfolio-rebalancer/internal/models"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// maxAPIKeys caps the number of API keys returned by List
+const maxAPIKeys = 1000
+
+type APIKeyRepository interface {
+	Save(ctx context.Context, key models.APIKey) error
+	GetByID(ctx context.Context, id string) (*models.APIKey, error)
+	GetByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	List(ctx context.Context) ([]models.APIKey, error)
+}
+
+// APIKeyRepositoryImpl implements APIKeyRepository using Elasticsearch
+type APIKeyRepositoryImpl struct {
+	client *elasticsearch.Client
+}
+
+// NewAPIKeyRepository creates a new Elasticsearch API key repository
+func NewAPIKeyRepository(client *elasticsearch.Client) APIKeyRepository {
+	return &APIKeyRepositoryImpl{
+		client: client,
+	}
+}
+
+// Save creates or replaces an API key in Elasticsearch
+func (r *APIKeyRepositoryImpl) Save(ctx context.Context, key models.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.client.Index(apiKeyIndex, bytes.NewReader(body),
+		r.client.Index.WithDocumentID(key.ID),
+		r.client.Index.WithRefresh("true"),
+		r.client.Index.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error saving api key: %s", res.String())
+	}
+
+	return nil
+}
+
+// GetByID retrieves an API key by ID from Elasticsearch
+func (r *APIKeyRepositoryImpl) GetByID(ctx context.Context, id string) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := r.client.Get(apiKeyIndex, id, r.client.Get.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("error getting api key: %s", res.String())
+	}
+
+	var esResp struct {
+		Source models.APIKey `json:"_source"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	return &esResp.Source, nil
+}
+
+// GetByHash retrieves the API key whose hash matches
+func (r *APIKeyRepositoryImpl) GetByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	keys, err := r.search(ctx, map[string]interface{}{
+		"query": map[string]interface{}{"term": map[string]interface{}{"key_hash": hash}},
+		"size":  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("api key not found")
+	}
+
+	return &keys[0], nil
+}
+
+// List returns every API key, oldest first
+func (r *APIKeyRepositoryImpl) List(ctx context.Context) ([]models.APIKey, error) {
+	return r.search(ctx, map[string]interface{}{
+		"sort": []interface{}{map[string]interface{}{"created_at": "asc"}},
+		"size": maxAPIKeys,
+	})
+}
+
+func (r *APIKeyRepositoryImpl) search(ctx context.Context, request map[string]interface{}) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Search(
+		r.client.Search.WithIndex(apiKeyIndex),
+		r.client.Search.WithBody(bytes.NewReader(body)),
+		r.client.Search.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error searching api keys: %s", res.String())
+	}
+
+	var esResp struct {
+		Hits struct {
+			Hits []struct {
+				Source models.APIKey `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	keys := make([]models.APIKey, 0, len(esResp.Hits.Hits))
+	for _, hit := range esResp.Hits.Hits {
+		keys = append(keys, hit.Source)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"portfolio-rebalancer/internal/models"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// maxAssets caps the number of assets returned by List. The registry holds the
+// instruments the platform trades, which stays well below a single search page.
+const maxAssets = 10000
+
+type AssetRepository interface {
+	Save(ctx context.Context, asset models.Asset) error
+	GetByID(ctx context.Context, id string) (*models.Asset, error)
+	List(ctx context.Context) ([]models.Asset, error)
+}
+
+// AssetRepositoryImpl implements AssetRepository using Elasticsearch
+type AssetRepositoryImpl struct {
+	client *elasticsearch.Client
+}
+
+// NewAssetRepository creates a new Elasticsearch asset repository
+func NewAssetRepository(client *elasticsearch.Client) AssetRepository {
+	return &AssetRepositoryImpl{
+		client: client,
+	}
+}
+
+// Save creates or replaces an asset in Elasticsearch
+func (r *AssetRepositoryImpl) Save(ctx context.Context, asset models.Asset) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.client.Index(assetIndex, bytes.NewReader(body),
+		r.client.Index.WithDocumentID(asset.ID),
+		r.client.Index.WithRefresh("true"),
+		r.client.Index.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error saving asset: %s", res.String())
+	}
+
+	log.Printf("Asset %s saved", asset.ID)
+	return nil
+}
+
+// GetByID retrieves an asset by ID from Elasticsearch
+func (r *AssetRepositoryImpl) GetByID(ctx context.Context, id string) (*models.Asset, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := r.client.Get(assetIndex, id, r.client.Get.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("error getting asset: %s", res.String())
+	}
+
+	var esResp struct {
+		Source models.Asset `json:"_source"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	return &esResp.Source, nil
+}
+
+// List returns all assets ordered by ID
+func (r *AssetRepositoryImpl) List(ctx context.Context) ([]models.Asset, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"match_all": map[string]interface{}{}},
+		"sort":  []interface{}{map[string]interface{}{"id": "asc"}},
+		"size":  maxAssets,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Search(
+		r.client.Search.WithIndex(assetIndex),
+		r.client.Search.WithBody(bytes.NewReader(query)),
+		r.client.Search.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error listing assets: %s", res.String())
+	}
+
+	var esResp struct {
+		Hits struct {
+			Hits []struct {
+				Source models.Asset `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	result := make([]models.Asset, 0, len(esResp.Hits.Hits))
+	for _, hit := range esResp.Hits.Hits {
+		result = append(result, hit.Source)
+	}
+
+	return result, nil
+}
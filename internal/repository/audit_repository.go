@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"portfolio-rebalancer/internal/models"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// maxAuditEntries caps the number of audit entries returned by a single query
+const maxAuditEntries = 1000
+
+type AuditRepository interface {
+	Append(ctx context.Context, entry models.AuditEntry) error
+	ListByUserID(ctx context.Context, userID string, from, to time.Time) ([]models.AuditEntry, error)
+}
+
+// AuditRepositoryImpl implements AuditRepository using Elasticsearch
+type AuditRepositoryImpl struct {
+	client *elasticsearch.Client
+}
+
+// NewAuditRepository creates a new Elasticsearch audit repository
+func NewAuditRepository(client *elasticsearch.Client) AuditRepository {
+	return &AuditRepositoryImpl{
+		client: client,
+	}
+}
+
+// Append stores a new audit entry. Entries are created, never overwritten, so an existing
+// ID is an error rather than an update.
+func (r *AuditRepositoryImpl) Append(ctx context.Context, entry models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.client.Index(auditIndex, bytes.NewReader(body),
+		r.client.Index.WithDocumentID(entry.ID),
+		r.client.Index.WithOpType("create"),
+		r.client.Index.WithRefresh("true"),
+		r.client.Index.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error appending audit entry: %s", res.String())
+	}
+
+	return nil
+}
+
+// ListByUserID returns a user's audit entries between from and to, newest first.
+// Zero times leave that end of the range open.
+func (r *AuditRepositoryImpl) ListByUserID(ctx context.Context, userID string, from, to time.Time) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filters := []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"user_id": userID}},
+	}
+	timestampRange := map[string]interface{}{}
+	if !from.IsZero() {
+		timestampRange["gte"] = from.UTC().Format(time.RFC3339Nano)
+	}
+	if !to.IsZero() {
+		timestampRange["lte"] = to.UTC().Format(time.RFC3339Nano)
+	}
+	if len(timestampRange) > 0 {
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"timestamp": timestampRange}})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": filters}},
+		"sort":  []interface{}{map[string]interface{}{"timestamp": "desc"}},
+		"size":  maxAuditEntries,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Search(
+		r.client.Search.WithIndex(auditIndex),
+		r.client.Search.WithBody(bytes.NewReader(body)),
+		r.client.Search.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error searching audit entries: %s", res.String())
+	}
+
+	var esResp struct {
+		Hits struct {
+			Hits []struct {
+				Source models.AuditEntry `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	entries := make([]models.AuditEntry, 0, len(esResp.Hits.Hits))
+	for _, hit := range esResp.Hits.Hits {
+		entries = append(entries, hit.Source)
+	}
+
+	return entries, nil
+}
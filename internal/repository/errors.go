@@ -0,0 +1,16 @@
+package repository
+
+import "errors"
+
+// ErrNotFound is returned when the requested document does not exist. Any other error
+// means Elasticsearch could not answer.
+var ErrNotFound = errors.New("document not found")
+
+// ErrConflict is returned by a conditional write when the document changed since it was read
+var ErrConflict = errors.New("document changed since it was read")
+
+// Version identifies the state of a document a conditional write expects to replace
+type Version struct {
+	SeqNo       int
+	PrimaryTerm int
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"portfolio-rebalancer/internal/models"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// maxExecutions caps the number of executions returned by a single query
+const maxExecutions = 1000
+
+type ExecutionRepository interface {
+	Save(ctx context.Context, execution models.Execution) error
+	ListByRebalanceID(ctx context.Context, rebalanceID string) ([]models.Execution, error)
+	ListByUserID(ctx context.Context, userID string, since time.Time) ([]models.Execution, error)
+}
+
+// ExecutionRepositoryImpl implements ExecutionRepository using Elasticsearch
+type ExecutionRepositoryImpl struct {
+	client *elasticsearch.Client
+}
+
+// NewExecutionRepository creates a new Elasticsearch execution repository
+func NewExecutionRepository(client *elasticsearch.Client) ExecutionRepository {
+	return &ExecutionRepositoryImpl{
+		client: client,
+	}
+}
+
+// Save stores an execution result in Elasticsearch
+func (r *ExecutionRepositoryImpl) Save(ctx context.Context, execution models.Execution) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(execution)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.client.Index(executionIndex, bytes.NewReader(body),
+		r.client.Index.WithDocumentID(execution.ID),
+		r.client.Index.WithRefresh("true"),
+		r.client.Index.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error saving execution: %s", res.String())
+	}
+
+	return nil
+}
+
+// ListByRebalanceID returns the executions of one rebalance, oldest first
+func (r *ExecutionRepositoryImpl) ListByRebalanceID(ctx context.Context, rebalanceID string) ([]models.Execution, error) {
+	return r.search(ctx, map[string]interface{}{"term": map[string]interface{}{"rebalance_id": rebalanceID}})
+}
+
+// ListByUserID returns a user's executions at or after since, oldest first
+func (r *ExecutionRepositoryImpl) ListByUserID(ctx context.Context, userID string, since time.Time) ([]models.Execution, error) {
+	return r.search(ctx, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": []interface{}{
+				map[string]interface{}{"term": map[string]interface{}{"user_id": userID}},
+				map[string]interface{}{"range": map[string]interface{}{
+					"executed_at": map[string]interface{}{"gte": since.UTC().Format(time.RFC3339)},
+				}},
+			},
+		},
+	})
+}
+
+func (r *ExecutionRepositoryImpl) search(ctx context.Context, query map[string]interface{}) ([]models.Execution, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"query": query,
+		"sort":  []interface{}{map[string]interface{}{"executed_at": "asc"}},
+		"size":  maxExecutions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Search(
+		r.client.Search.WithIndex(executionIndex),
+		r.client.Search.WithBody(bytes.NewReader(body)),
+		r.client.Search.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error searching executions: %s", res.String())
+	}
+
+	var esResp struct {
+		Hits struct {
+			Hits []struct {
+				Source models.Execution `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	executions := make([]models.Execution, 0, len(esResp.Hits.Hits))
+	for _, hit := range esResp.Hits.Hits {
+		executions = append(executions, hit.Source)
+	}
+
+	return executions, nil
+}
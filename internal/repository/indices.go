@@ -0,0 +1,281 @@
+package repository
+
+import (
+	es "portfolio-rebalancer/pkg/elasticsearch"
+)
+
+// Aliases the repositories read from and write to. The physical indices behind
+// them are versioned and managed by es.Migrate on startup.
+const (
+	portfolioIndex      = "portfolios"
+	transactionIndex    = "rebalance_transactions"
+	historyIndex        = "portfolio_history"
+	modelPortfolioIndex = "model_portfolios"
+	assetIndex          = "assets"
+	lockIndex           = "locks"
+	rebalanceIndex      = "rebalances"
+	executionIndex      = "executions"
+	reconciliationIndex = "reconciliation_reports"
+	apiKeyIndex         = "api_keys"
+	auditIndex          = "audit_log"
+)
+
+// IndexSpecs returns the versioned index definitions owned by the repositories
+func IndexSpecs() []es.IndexSpec {
+	return []es.IndexSpec{
+		{
+			Alias: portfolioIndex,
+			Versions: []es.IndexVersion{
+				{
+					Settings: es.DefaultSettings(),
+					Mappings: map[string]interface{}{
+						"dynamic": false,
+						"properties": map[string]interface{}{
+							"user_id": map[string]interface{}{"type": "keyword"},
+							// Asset names are free-form keys, so they are kept out of the field mapping
+							"allocation":          map[string]interface{}{"type": "flattened"},
+							"original_allocation": map[string]interface{}{"type": "flattened"},
+							// Finds the subscribers of a model portfolio
+							"model_id": map[string]interface{}{"type": "keyword"},
+							// Finds the portfolios the scheduler has due
+							"schedule":          map[string]interface{}{"type": "keyword"},
+							"next_rebalance_at": map[string]interface{}{"type": "date"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Alias: transactionIndex,
+			Versions: []es.IndexVersion{
+				{
+					Settings: es.DefaultSettings(),
+					Mappings: transactionMappings(),
+				},
+				{
+					// Date-rolled indices keep storage bounded while the alias still spans all history.
+					// rebalance_id looks up the legs of one rebalance together, and status tells
+					// executed and failed legs apart.
+					Settings:  es.DefaultSettings(),
+					Mappings:  transactionMappings("rebalance_id", "status"),
+					Lifecycle: transactionLifecycle(),
+				},
+			},
+		},
+		{
+			Alias: historyIndex,
+			Versions: []es.IndexVersion{
+				{
+					Settings: es.DefaultSettings(),
+					Mappings: map[string]interface{}{
+						"dynamic": false,
+						"properties": map[string]interface{}{
+							"user_id":             map[string]interface{}{"type": "keyword"},
+							"allocation":          map[string]interface{}{"type": "flattened"},
+							"original_allocation": map[string]interface{}{"type": "flattened"},
+							"source":              map[string]interface{}{"type": "keyword"},
+							// Nanosecond precision keeps changes made within the same millisecond ordered
+							"timestamp": map[string]interface{}{"type": "date_nanos"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Alias: modelPortfolioIndex,
+			Versions: []es.IndexVersion{
+				{
+					Settings: es.DefaultSettings(),
+					Mappings: map[string]interface{}{
+						"dynamic": false,
+						"properties": map[string]interface{}{
+							"id":         map[string]interface{}{"type": "keyword"},
+							"name":       map[string]interface{}{"type": "text"},
+							"allocation": map[string]interface{}{"type": "flattened"},
+							"updated_at": map[string]interface{}{"type": "date"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Alias: assetIndex,
+			Versions: []es.IndexVersion{
+				{
+					Settings: es.DefaultSettings(),
+					Mappings: map[string]interface{}{
+						"dynamic": false,
+						"properties": map[string]interface{}{
+							"id":         map[string]interface{}{"type": "keyword"},
+							"name":       map[string]interface{}{"type": "text"},
+							"class":      map[string]interface{}{"type": "keyword"},
+							"currency":   map[string]interface{}{"type": "keyword"},
+							"tradable":   map[string]interface{}{"type": "boolean"},
+							"min_lot":    map[string]interface{}{"type": "double"},
+							"aliases":    map[string]interface{}{"type": "keyword"},
+							"updated_at": map[string]interface{}{"type": "date"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Alias: rebalanceIndex,
+			Versions: []es.IndexVersion{
+				{
+					Settings: es.DefaultSettings(),
+					Mappings: map[string]interface{}{
+						// Transactions and events are only read back with their rebalance
+						"dynamic": false,
+						"properties": map[string]interface{}{
+							"id":         map[string]interface{}{"type": "keyword"},
+							"user_id":    map[string]interface{}{"type": "keyword"},
+							"status":     map[string]interface{}{"type": "keyword"},
+							"created_at": map[string]interface{}{"type": "date"},
+							"updated_at": map[string]interface{}{"type": "date"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Alias: executionIndex,
+			Versions: []es.IndexVersion{
+				{
+					Settings: es.DefaultSettings(),
+					Mappings: map[string]interface{}{
+						"dynamic": false,
+						"properties": map[string]interface{}{
+							"id":                map[string]interface{}{"type": "keyword"},
+							"rebalance_id":      map[string]interface{}{"type": "keyword"},
+							"user_id":           map[string]interface{}{"type": "keyword"},
+							"action":            map[string]interface{}{"type": "keyword"},
+							"asset":             map[string]interface{}{"type": "keyword"},
+							"status":            map[string]interface{}{"type": "keyword"},
+							"requested_percent": map[string]interface{}{"type": "double"},
+							"filled_percent":    map[string]interface{}{"type": "double"},
+							"quantity":          map[string]interface{}{"type": "double"},
+							"fill_price":        map[string]interface{}{"type": "double"},
+							"broker":            map[string]interface{}{"type": "keyword"},
+							"executed_at":       map[string]interface{}{"type": "date"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Alias: reconciliationIndex,
+			Versions: []es.IndexVersion{
+				{
+					Settings: es.DefaultSettings(),
+					Mappings: map[string]interface{}{
+						// Allocations and breaks are only read back with their report
+						"dynamic": false,
+						"properties": map[string]interface{}{
+							"id":         map[string]interface{}{"type": "keyword"},
+							"user_id":    map[string]interface{}{"type": "keyword"},
+							"status":     map[string]interface{}{"type": "keyword"},
+							"created_at": map[string]interface{}{"type": "date"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Alias: apiKeyIndex,
+			Versions: []es.IndexVersion{
+				{
+					Settings: es.DefaultSettings(),
+					Mappings: map[string]interface{}{
+						"dynamic": false,
+						"properties": map[string]interface{}{
+							"id":         map[string]interface{}{"type": "keyword"},
+							"name":       map[string]interface{}{"type": "keyword"},
+							"prefix":     map[string]interface{}{"type": "keyword"},
+							"key_hash":   map[string]interface{}{"type": "keyword"},
+							"scopes":     map[string]interface{}{"type": "keyword"},
+							"created_at": map[string]interface{}{"type": "date"},
+							"revoked_at": map[string]interface{}{"type": "date"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Alias: auditIndex,
+			Versions: []es.IndexVersion{
+				{
+					Settings: es.DefaultSettings(),
+					Mappings: map[string]interface{}{
+						// Portfolio snapshots are only read back with their entry
+						"dynamic": false,
+						"properties": map[string]interface{}{
+							"id":           map[string]interface{}{"type": "keyword"},
+							"user_id":      map[string]interface{}{"type": "keyword"},
+							"action":       map[string]interface{}{"type": "keyword"},
+							"actor":        map[string]interface{}{"type": "keyword"},
+							"client":       map[string]interface{}{"type": "keyword"},
+							"route":        map[string]interface{}{"type": "keyword"},
+							"request_id":   map[string]interface{}{"type": "keyword"},
+							"rebalance_id": map[string]interface{}{"type": "keyword"},
+							"timestamp":    map[string]interface{}{"type": "date"},
+						},
+					},
+				},
+			},
+		},
+		LockIndexSpec(),
+	}
+}
+
+// LockIndexSpec returns the index behind the locks. It is created before any lock can be
+// taken, by every replica at once, so it must keep a single version.
+func LockIndexSpec() es.IndexSpec {
+	return es.IndexSpec{
+		Alias: lockIndex,
+		Versions: []es.IndexVersion{
+			{
+				Settings: es.DefaultSettings(),
+				Mappings: map[string]interface{}{
+					"dynamic": false,
+					"properties": map[string]interface{}{
+						"owner":      map[string]interface{}{"type": "keyword"},
+						"expires_at": map[string]interface{}{"type": "date"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// transactionLifecycle rolls transaction indices monthly and keeps 12 months of history
+// unless overridden through the TRANSACTIONS_* environment variables
+func transactionLifecycle() *es.LifecyclePolicy {
+	policy := es.LifecyclePolicyFromEnv(transactionIndex+"-policy", "TRANSACTIONS", es.LifecyclePolicy{
+		RolloverMaxAge:  "30d",
+		RolloverMaxSize: "50gb",
+		WarmAfter:       "30d",
+		DeleteAfter:     "372d",
+	})
+	return &policy
+}
+
+// transactionMappings returns the transaction mapping with any extra keyword fields
+// introduced by later versions
+func transactionMappings(keywords ...string) map[string]interface{} {
+	properties := map[string]interface{}{
+		"user_id":           map[string]interface{}{"type": "keyword"},
+		"action":            map[string]interface{}{"type": "keyword"},
+		"asset":             map[string]interface{}{"type": "keyword"},
+		"rebalance_percent": map[string]interface{}{"type": "double"},
+		"timestamp":         map[string]interface{}{"type": "date"},
+	}
+	for _, field := range keywords {
+		properties[field] = map[string]interface{}{"type": "keyword"}
+	}
+
+	return map[string]interface{}{
+		"dynamic":    false,
+		"properties": properties,
+	}
+}
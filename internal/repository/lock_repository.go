@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// LockRepository provides named, expiring locks shared by every replica of the service
+type LockRepository interface {
+	Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, name, owner string) error
+}
+
+// LockRepositoryImpl implements LockRepository with one Elasticsearch document per lock.
+// Creates and takeovers are conditional writes, so only one owner can win a race.
+type LockRepositoryImpl struct {
+	client *elasticsearch.Client
+}
+
+// NewLockRepository creates a new Elasticsearch lock repository
+func NewLockRepository(client *elasticsearch.Client) LockRepository {
+	return &LockRepositoryImpl{
+		client: client,
+	}
+}
+
+type lockDocument struct {
+	Owner     string `json:"owner"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// Acquire takes the lock for ttl, returning false when another owner holds it.
+// An expired lock, e.g. left by a crashed replica, is taken over.
+func (r *LockRepositoryImpl) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	body, err := json.Marshal(lockDocument{
+		Owner:     owner,
+		ExpiresAt: now.Add(ttl).Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.client.Index(lockIndex, bytes.NewReader(body),
+		r.client.Index.WithDocumentID(name),
+		r.client.Index.WithOpType("create"),
+		r.client.Index.WithRefresh("true"),
+		r.client.Index.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	if !res.IsError() {
+		return true, nil
+	}
+	if res.StatusCode != http.StatusConflict {
+		return false, fmt.Errorf("error acquiring lock %s: %s", name, res.String())
+	}
+
+	current, seqNo, primaryTerm, err := r.get(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if current == nil {
+		// Released between the create and the read; the next attempt can create it
+		return false, nil
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339Nano, current.ExpiresAt)
+	if err == nil && current.Owner != owner && now.Before(expiresAt) {
+		return false, nil
+	}
+
+	res, err = r.client.Index(lockIndex, bytes.NewReader(body),
+		r.client.Index.WithDocumentID(name),
+		r.client.Index.WithIfSeqNo(seqNo),
+		r.client.Index.WithIfPrimaryTerm(primaryTerm),
+		r.client.Index.WithRefresh("true"),
+		r.client.Index.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusConflict {
+		// Another replica took the expired lock first
+		return false, nil
+	}
+	if res.IsError() {
+		return false, fmt.Errorf("error acquiring lock %s: %s", name, res.String())
+	}
+
+	return true, nil
+}
+
+// Release deletes the lock if owner still holds it
+func (r *LockRepositoryImpl) Release(ctx context.Context, name, owner string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	current, seqNo, primaryTerm, err := r.get(ctx, name)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Owner != owner {
+		return nil
+	}
+
+	res, err := r.client.Delete(lockIndex, name,
+		r.client.Delete.WithIfSeqNo(seqNo),
+		r.client.Delete.WithIfPrimaryTerm(primaryTerm),
+		r.client.Delete.WithRefresh("true"),
+		r.client.Delete.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// A conflict means the lock expired and was taken over, so it is no longer ours to delete
+	if res.IsError() && res.StatusCode != http.StatusConflict && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("error releasing lock %s: %s", name, res.String())
+	}
+
+	return nil
+}
+
+// get reads a lock with the sequence number and primary term needed for a conditional write.
+// A missing lock is returned as nil.
+func (r *LockRepositoryImpl) get(ctx context.Context, name string) (*lockDocument, int, int, error) {
+	res, err := r.client.Get(lockIndex, name, r.client.Get.WithContext(ctx))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, 0, 0, nil
+	}
+	if res.IsError() {
+		return nil, 0, 0, fmt.Errorf("error reading lock %s: %s", name, res.String())
+	}
+
+	var esResp struct {
+		SeqNo       int          `json:"_seq_no"`
+		PrimaryTerm int          `json:"_primary_term"`
+		Source      lockDocument `json:"_source"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, 0, 0, err
+	}
+
+	return &esResp.Source, esResp.SeqNo, esResp.PrimaryTerm, nil
+}
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"portfolio-rebalancer/internal/models"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// maxModelPortfolios caps the number of model portfolios returned by List.
+// Advisors manage a handful of models, so no pagination is needed.
+const maxModelPortfolios = 1000
+
+type ModelPortfolioRepository interface {
+	Save(ctx context.Context, model models.ModelPortfolio) error
+	Create(ctx context.Context, model models.ModelPortfolio) error
+	GetByID(ctx context.Context, id string) (*models.ModelPortfolio, error)
+	List(ctx context.Context) ([]models.ModelPortfolio, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// ModelPortfolioRepositoryImpl implements ModelPortfolioRepository using Elasticsearch
+type ModelPortfolioRepositoryImpl struct {
+	client *elasticsearch.Client
+}
+
+// NewModelPortfolioRepository creates a new Elasticsearch model portfolio repository
+func NewModelPortfolioRepository(client *elasticsearch.Client) ModelPortfolioRepository {
+	return &ModelPortfolioRepositoryImpl{
+		client: client,
+	}
+}
+
+// Save saves a model portfolio to Elasticsearch
+func (r *ModelPortfolioRepositoryImpl) Save(ctx context.Context, m models.ModelPortfolio) error {
+	return r.save(ctx, m)
+}
+
+// Create saves a new model portfolio, returning ErrConflict when its ID is already taken.
+// The check is part of the write, so two concurrent creates cannot both succeed.
+func (r *ModelPortfolioRepositoryImpl) Create(ctx context.Context, m models.ModelPortfolio) error {
+	return r.save(ctx, m, r.client.Index.WithOpType("create"))
+}
+
+func (r *ModelPortfolioRepositoryImpl) save(ctx context.Context, m models.ModelPortfolio, opts ...func(*esapi.IndexRequest)) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	opts = append(opts,
+		r.client.Index.WithDocumentID(m.ID),
+		r.client.Index.WithRefresh("true"),
+		r.client.Index.WithContext(ctx))
+	res, err := r.client.Index(modelPortfolioIndex, bytes.NewReader(body), opts...)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusConflict {
+		return ErrConflict
+	}
+	if res.IsError() {
+		return fmt.Errorf("error saving model portfolio: %s", res.String())
+	}
+
+	log.Printf("Model portfolio %s saved", m.ID)
+	return nil
+}
+
+// GetByID retrieves a model portfolio by ID from Elasticsearch
+func (r *ModelPortfolioRepositoryImpl) GetByID(ctx context.Context, id string) (*models.ModelPortfolio, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := r.client.Get(modelPortfolioIndex, id, r.client.Get.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("error getting model portfolio: %s", res.String())
+	}
+
+	var esResp struct {
+		Source models.ModelPortfolio `json:"_source"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	return &esResp.Source, nil
+}
+
+// List returns all model portfolios ordered by ID
+func (r *ModelPortfolioRepositoryImpl) List(ctx context.Context) ([]models.ModelPortfolio, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"match_all": map[string]interface{}{}},
+		"sort":  []interface{}{map[string]interface{}{"id": "asc"}},
+		"size":  maxModelPortfolios,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Search(
+		r.client.Search.WithIndex(modelPortfolioIndex),
+		r.client.Search.WithBody(bytes.NewReader(query)),
+		r.client.Search.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error listing model portfolios: %s", res.String())
+	}
+
+	var esResp struct {
+		Hits struct {
+			Hits []struct {
+				Source models.ModelPortfolio `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	result := make([]models.ModelPortfolio, 0, len(esResp.Hits.Hits))
+	for _, hit := range esResp.Hits.Hits {
+		result = append(result, hit.Source)
+	}
+
+	return result, nil
+}
+
+// Delete removes a model portfolio from Elasticsearch
+func (r *ModelPortfolioRepositoryImpl) Delete(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := r.client.Delete(modelPortfolioIndex, id,
+		r.client.Delete.WithRefresh("true"),
+		r.client.Delete.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error deleting model portfolio: %s", res.String())
+	}
+
+	log.Printf("Model portfolio %s deleted", id)
+	return nil
+}
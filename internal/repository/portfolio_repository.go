@@ -6,15 +6,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"portfolio-rebalancer/internal/models"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
 )
 
+// portfolioPageSize is the number of portfolios fetched per search request
+const portfolioPageSize = 500
+
 type PortfolioRepository interface {
 	Save(ctx context.Context, portfolio models.Portfolio) error
 	GetByUserID(ctx context.Context, userID string) (*models.Portfolio, error)
+	GetVersioned(ctx context.Context, userID string) (*models.Portfolio, Version, error)
+	SaveIfVersion(ctx context.Context, portfolio models.Portfolio, version Version) error
+	ListByModelID(ctx context.Context, modelID string) ([]models.Portfolio, error)
+	ListDue(ctx context.Context, now time.Time) ([]models.Portfolio, error)
+	ListAll(ctx context.Context) ([]models.Portfolio, error)
 }
 
 // PortfolioRepository implements PortfolioRepository using Elasticsearch
@@ -31,6 +41,18 @@ func NewPortfolioRepository(client *elasticsearch.Client) PortfolioRepository {
 
 // Save saves a portfolio to Elasticsearch
 func (r *PortfolioRepositoryImpl) Save(ctx context.Context, p models.Portfolio) error {
+	return r.save(ctx, p)
+}
+
+// SaveIfVersion saves a portfolio only if it is still at version, and returns ErrConflict
+// when another write landed since it was read
+func (r *PortfolioRepositoryImpl) SaveIfVersion(ctx context.Context, p models.Portfolio, version Version) error {
+	return r.save(ctx, p,
+		r.client.Index.WithIfSeqNo(version.SeqNo),
+		r.client.Index.WithIfPrimaryTerm(version.PrimaryTerm))
+}
+
+func (r *PortfolioRepositoryImpl) save(ctx context.Context, p models.Portfolio, opts ...func(*esapi.IndexRequest)) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
@@ -39,14 +61,18 @@ func (r *PortfolioRepositoryImpl) Save(ctx context.Context, p models.Portfolio)
 		return err
 	}
 
-	res, err := r.client.Index("portfolios", bytes.NewReader(body),
+	opts = append(opts,
 		r.client.Index.WithDocumentID(p.UserID),
 		r.client.Index.WithContext(ctx))
+	res, err := r.client.Index(portfolioIndex, bytes.NewReader(body), opts...)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusConflict {
+		return ErrConflict
+	}
 	if res.IsError() {
 		return fmt.Errorf("error saving portfolio: %s", res.String())
 	}
@@ -57,26 +83,160 @@ func (r *PortfolioRepositoryImpl) Save(ctx context.Context, p models.Portfolio)
 
 // GetByUserID retrieves a portfolio by user ID from Elasticsearch
 func (r *PortfolioRepositoryImpl) GetByUserID(ctx context.Context, userID string) (*models.Portfolio, error) {
+	portfolio, _, err := r.GetVersioned(ctx, userID)
+	return portfolio, err
+}
+
+// GetVersioned retrieves a portfolio with the version SaveIfVersion needs to write it back
+func (r *PortfolioRepositoryImpl) GetVersioned(ctx context.Context, userID string) (*models.Portfolio, Version, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	res, err := r.client.Get("portfolios", userID, r.client.Get.WithContext(ctx))
+	res, err := r.client.Get(portfolioIndex, userID, r.client.Get.WithContext(ctx))
 	if err != nil {
-		return nil, err
+		return nil, Version{}, err
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotFound {
+		return nil, Version{}, ErrNotFound
+	}
 	if res.IsError() {
-		return nil, fmt.Errorf("user not found")
+		return nil, Version{}, fmt.Errorf("error getting portfolio: %s", res.String())
 	}
 
 	var esResp struct {
-		Source models.Portfolio `json:"_source"`
+		SeqNo       int              `json:"_seq_no"`
+		PrimaryTerm int              `json:"_primary_term"`
+		Source      models.Portfolio `json:"_source"`
 	}
 
 	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
-		return nil, err
+		return nil, Version{}, err
 	}
 
-	return &esResp.Source, nil
+	return &esResp.Source, Version{SeqNo: esResp.SeqNo, PrimaryTerm: esResp.PrimaryTerm}, nil
+}
+
+// ListByModelID retrieves every portfolio subscribed to a model portfolio.
+// Pages through results with search_after so the number of subscribers is not capped.
+func (r *PortfolioRepositoryImpl) ListByModelID(ctx context.Context, modelID string) ([]models.Portfolio, error) {
+	var portfolios []models.Portfolio
+	var searchAfter []interface{}
+
+	for {
+		page, next, err := r.searchPage(ctx, map[string]interface{}{
+			"term": map[string]interface{}{"model_id": modelID},
+		}, searchAfter)
+		if err != nil {
+			return nil, err
+		}
+
+		portfolios = append(portfolios, page...)
+		if next == nil {
+			return portfolios, nil
+		}
+		searchAfter = next
+	}
+}
+
+// ListDue retrieves every scheduled portfolio whose next rebalance is at or before now
+func (r *PortfolioRepositoryImpl) ListDue(ctx context.Context, now time.Time) ([]models.Portfolio, error) {
+	var portfolios []models.Portfolio
+	var searchAfter []interface{}
+
+	for {
+		page, next, err := r.searchPage(ctx, map[string]interface{}{
+			"range": map[string]interface{}{
+				"next_rebalance_at": map[string]interface{}{"lte": now.UTC().Format(time.RFC3339)},
+			},
+		}, searchAfter)
+		if err != nil {
+			return nil, err
+		}
+
+		portfolios = append(portfolios, page...)
+		if next == nil {
+			return portfolios, nil
+		}
+		searchAfter = next
+	}
+}
+
+// ListAll retrieves every portfolio
+func (r *PortfolioRepositoryImpl) ListAll(ctx context.Context) ([]models.Portfolio, error) {
+	var portfolios []models.Portfolio
+	var searchAfter []interface{}
+
+	for {
+		page, next, err := r.searchPage(ctx, map[string]interface{}{
+			"match_all": map[string]interface{}{},
+		}, searchAfter)
+		if err != nil {
+			return nil, err
+		}
+
+		portfolios = append(portfolios, page...)
+		if next == nil {
+			return portfolios, nil
+		}
+		searchAfter = next
+	}
+}
+
+// searchPage runs one page of a query sorted by user ID, returning the sort values to continue from
+func (r *PortfolioRepositoryImpl) searchPage(ctx context.Context, query map[string]interface{}, searchAfter []interface{}) ([]models.Portfolio, []interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	request := map[string]interface{}{
+		"query": query,
+		"sort":  []interface{}{map[string]interface{}{"user_id": "asc"}},
+		"size":  portfolioPageSize,
+	}
+	if searchAfter != nil {
+		request["search_after"] = searchAfter
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := r.client.Search(
+		r.client.Search.WithIndex(portfolioIndex),
+		r.client.Search.WithBody(bytes.NewReader(body)),
+		r.client.Search.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, nil, fmt.Errorf("error searching portfolios: %s", res.String())
+	}
+
+	var esResp struct {
+		Hits struct {
+			Hits []struct {
+				Source models.Portfolio `json:"_source"`
+				Sort   []interface{}    `json:"sort"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, nil, err
+	}
+
+	hits := esResp.Hits.Hits
+	portfolios := make([]models.Portfolio, 0, len(hits))
+	for _, hit := range hits {
+		portfolios = append(portfolios, hit.Source)
+	}
+
+	if len(hits) < portfolioPageSize {
+		return portfolios, nil, nil
+	}
+	return portfolios, hits[len(hits)-1].Sort, nil
 }
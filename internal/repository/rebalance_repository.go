@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"portfolio-rebalancer/internal/models"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// maxRebalances caps the number of rebalances returned for one user
+const maxRebalances = 1000
+
+type RebalanceRepository interface {
+	Save(ctx context.Context, rebalance models.Rebalance) error
+	GetByID(ctx context.Context, id string) (*models.Rebalance, error)
+	GetVersioned(ctx context.Context, id string) (*models.Rebalance, Version, error)
+	SaveIfVersion(ctx context.Context, rebalance models.Rebalance, version Version) error
+	ListByUserID(ctx context.Context, userID, status string) ([]models.Rebalance, error)
+}
+
+// RebalanceRepositoryImpl implements RebalanceRepository using Elasticsearch
+type RebalanceRepositoryImpl struct {
+	client *elasticsearch.Client
+}
+
+// NewRebalanceRepository creates a new Elasticsearch rebalance repository
+func NewRebalanceRepository(client *elasticsearch.Client) RebalanceRepository {
+	return &RebalanceRepositoryImpl{
+		client: client,
+	}
+}
+
+// Save creates or replaces a rebalance in Elasticsearch
+func (r *RebalanceRepositoryImpl) Save(ctx context.Context, rebalance models.Rebalance) error {
+	return r.save(ctx, rebalance)
+}
+
+// SaveIfVersion saves a rebalance only if it is still at version, and returns ErrConflict
+// when another write landed since it was read
+func (r *RebalanceRepositoryImpl) SaveIfVersion(ctx context.Context, rebalance models.Rebalance, version Version) error {
+	return r.save(ctx, rebalance,
+		r.client.Index.WithIfSeqNo(version.SeqNo),
+		r.client.Index.WithIfPrimaryTerm(version.PrimaryTerm))
+}
+
+func (r *RebalanceRepositoryImpl) save(ctx context.Context, rebalance models.Rebalance, opts ...func(*esapi.IndexRequest)) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(rebalance)
+	if err != nil {
+		return err
+	}
+
+	opts = append(opts,
+		r.client.Index.WithDocumentID(rebalance.ID),
+		r.client.Index.WithRefresh("true"),
+		r.client.Index.WithContext(ctx))
+	res, err := r.client.Index(rebalanceIndex, bytes.NewReader(body), opts...)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusConflict {
+		return ErrConflict
+	}
+	if res.IsError() {
+		return fmt.Errorf("error saving rebalance: %s", res.String())
+	}
+
+	return nil
+}
+
+// GetByID retrieves a rebalance by ID from Elasticsearch
+func (r *RebalanceRepositoryImpl) GetByID(ctx context.Context, id string) (*models.Rebalance, error) {
+	rebalance, _, err := r.GetVersioned(ctx, id)
+	return rebalance, err
+}
+
+// GetVersioned retrieves a rebalance with the version SaveIfVersion needs to write it back
+func (r *RebalanceRepositoryImpl) GetVersioned(ctx context.Context, id string) (*models.Rebalance, Version, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	res, err := r.client.Get(rebalanceIndex, id, r.client.Get.WithContext(ctx))
+	if err != nil {
+		return nil, Version{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, Version{}, ErrNotFound
+	}
+	if res.IsError() {
+		return nil, Version{}, fmt.Errorf("error getting rebalance: %s", res.String())
+	}
+
+	var esResp struct {
+		SeqNo       int              `json:"_seq_no"`
+		PrimaryTerm int              `json:"_primary_term"`
+		Source      models.Rebalance `json:"_source"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, Version{}, err
+	}
+
+	return &esResp.Source, Version{SeqNo: esResp.SeqNo, PrimaryTerm: esResp.PrimaryTerm}, nil
+}
+
+// ListByUserID returns a user's rebalances, newest first, optionally only those in status
+func (r *RebalanceRepositoryImpl) ListByUserID(ctx context.Context, userID, status string) ([]models.Rebalance, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filters := []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"user_id": userID}},
+	}
+	if status != "" {
+		filters = append(filters, map[string]interface{}{"term": map[string]interface{}{"status": status}})
+	}
+
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": filters}},
+		"sort":  []interface{}{map[string]interface{}{"created_at": "desc"}},
+		"size":  maxRebalances,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Search(
+		r.client.Search.WithIndex(rebalanceIndex),
+		r.client.Search.WithBody(bytes.NewReader(query)),
+		r.client.Search.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error searching rebalances: %s", res.String())
+	}
+
+	var esResp struct {
+		Hits struct {
+			Hits []struct {
+				Source models.Rebalance `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	rebalances := make([]models.Rebalance, 0, len(esResp.Hits.Hits))
+	for _, hit := range esResp.Hits.Hits {
+		rebalances = append(rebalances, hit.Source)
+	}
+
+	return rebalances, nil
+}
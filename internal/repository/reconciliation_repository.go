@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"portfolio-rebalancer/internal/models"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// maxReconciliations caps the number of reports returned by a single query
+const maxReconciliations = 1000
+
+// maxReconciledPortfolios caps the number of portfolios a summary covers, Elasticsearch's default result window
+const maxReconciledPortfolios = 10000
+
+type ReconciliationRepository interface {
+	Save(ctx context.Context, report models.ReconciliationReport) error
+	ListByUserID(ctx context.Context, userID string) ([]models.ReconciliationReport, error)
+	ListLatest(ctx context.Context, since time.Time) ([]models.ReconciliationReport, error)
+}
+
+// ReconciliationRepositoryImpl implements ReconciliationRepository using Elasticsearch
+type ReconciliationRepositoryImpl struct {
+	client *elasticsearch.Client
+}
+
+// NewReconciliationRepository creates a new Elasticsearch reconciliation repository
+func NewReconciliationRepository(client *elasticsearch.Client) ReconciliationRepository {
+	return &ReconciliationRepositoryImpl{
+		client: client,
+	}
+}
+
+// Save stores a reconciliation report in Elasticsearch
+func (r *ReconciliationRepositoryImpl) Save(ctx context.Context, report models.ReconciliationReport) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.client.Index(reconciliationIndex, bytes.NewReader(body),
+		r.client.Index.WithDocumentID(report.ID),
+		r.client.Index.WithRefresh("true"),
+		r.client.Index.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error saving reconciliation report: %s", res.String())
+	}
+
+	return nil
+}
+
+// ListByUserID returns a user's reconciliation reports, newest first
+func (r *ReconciliationRepositoryImpl) ListByUserID(ctx context.Context, userID string) ([]models.ReconciliationReport, error) {
+	return r.search(ctx, map[string]interface{}{
+		"query": map[string]interface{}{"term": map[string]interface{}{"user_id": userID}},
+		"sort":  []interface{}{map[string]interface{}{"created_at": "desc"}},
+		"size":  maxReconciliations,
+	})
+}
+
+// ListLatest returns the latest report of every portfolio reconciled at or after since
+func (r *ReconciliationRepositoryImpl) ListLatest(ctx context.Context, since time.Time) ([]models.ReconciliationReport, error) {
+	return r.search(ctx, map[string]interface{}{
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{
+				"created_at": map[string]interface{}{"gte": since.UTC().Format(time.RFC3339)},
+			},
+		},
+		"collapse": map[string]interface{}{"field": "user_id"},
+		"sort":     []interface{}{map[string]interface{}{"created_at": "desc"}},
+		"size":     maxReconciledPortfolios,
+	})
+}
+
+func (r *ReconciliationRepositoryImpl) search(ctx context.Context, request map[string]interface{}) ([]models.ReconciliationReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Search(
+		r.client.Search.WithIndex(reconciliationIndex),
+		r.client.Search.WithBody(bytes.NewReader(body)),
+		r.client.Search.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error searching reconciliation reports: %s", res.String())
+	}
+
+	var esResp struct {
+		Hits struct {
+			Hits []struct {
+				Source models.ReconciliationReport `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	reports := make([]models.ReconciliationReport, 0, len(esResp.Hits.Hits))
+	for _, hit := range esResp.Hits.Hits {
+		reports = append(reports, hit.Source)
+	}
+
+	return reports, nil
+}
@@ -12,8 +12,12 @@ import (
 	"github.com/elastic/go-elasticsearch/v8"
 )
 
+// maxTransactions caps the number of transactions returned by a single query
+const maxTransactions = 1000
+
 type TransactionRepository interface {
 	SaveTransaction(ctx context.Context, tx models.RebalanceTransaction) error
+	ListByUserID(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error)
 }
 
 // TransactionRepository implements TransactionRepository using Elasticsearch
@@ -41,7 +45,7 @@ func (r *TransactionRepositoryImpl) SaveTransaction(ctx context.Context, tx mode
 	// Use timestamp + userID + asset as document ID for uniqueness
 	docID := fmt.Sprintf("%s_%s_%s", tx.UserID, tx.Asset, tx.Timestamp)
 
-	res, err := r.client.Index("rebalance_transactions", bytes.NewReader(body),
+	res, err := r.client.Index(transactionIndex, bytes.NewReader(body),
 		r.client.Index.WithDocumentID(docID),
 		r.client.Index.WithContext(ctx))
 	if err != nil {
@@ -57,3 +61,65 @@ func (r *TransactionRepositoryImpl) SaveTransaction(ctx context.Context, tx mode
 		tx.UserID, tx.Action, tx.Asset, tx.RebalancePercent)
 	return nil
 }
+
+// ListByUserID returns a user's processed transactions between from and to, newest first.
+// Zero times leave that end of the range open.
+func (r *TransactionRepositoryImpl) ListByUserID(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filters := []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"user_id": userID}},
+	}
+	timestampRange := map[string]interface{}{}
+	if !from.IsZero() {
+		timestampRange["gte"] = from.UTC().Format(time.RFC3339)
+	}
+	if !to.IsZero() {
+		timestampRange["lte"] = to.UTC().Format(time.RFC3339)
+	}
+	if len(timestampRange) > 0 {
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"timestamp": timestampRange}})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": filters}},
+		"sort":  []interface{}{map[string]interface{}{"timestamp": "desc"}},
+		"size":  maxTransactions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Search(
+		r.client.Search.WithIndex(transactionIndex),
+		r.client.Search.WithBody(bytes.NewReader(body)),
+		r.client.Search.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error searching rebalance transactions: %s", res.String())
+	}
+
+	var esResp struct {
+		Hits struct {
+			Hits []struct {
+				Source models.RebalanceTransaction `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&esResp); err != nil {
+		return nil, err
+	}
+
+	transactions := make([]models.RebalanceTransaction, 0, len(esResp.Hits.Hits))
+	for _, hit := range esResp.Hits.Hits {
+		transactions = append(transactions, hit.Source)
+	}
+
+	return transactions, nil
+}
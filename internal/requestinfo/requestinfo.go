@@ -0,0 +1,34 @@
+package requestinfo
+
+import (
+	"context"
+	"portfolio-rebalancer/pkg/idgen"
+)
+
+// Info describes the HTTP request or RPC a piece of work is done for
+type Info struct {
+	ID     string // from the X-Request-ID header, or generated
+	Method string // the HTTP method, or MethodGRPC
+	Route  string // the route, or the RPC's full method name
+}
+
+// MethodGRPC is the Method of RPCs made to the gRPC server
+const MethodGRPC = "GRPC"
+
+type contextKey struct{}
+
+// NewID returns a new request ID
+func NewID() string {
+	return idgen.New()
+}
+
+// WithInfo returns a copy of ctx carrying the request's info
+func WithInfo(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext returns the info of the request ctx belongs to. Background work has none.
+func FromContext(ctx context.Context) (Info, bool) {
+	info, ok := ctx.Value(contextKey{}).(Info)
+	return info, ok
+}
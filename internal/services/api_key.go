@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/pkg/idgen"
+	"time"
+)
+
+// keyPrefixLength is how much of a key is kept in the clear to recognise it
+const keyPrefixLength = 12
+
+var (
+	// ErrInvalidAPIKey is returned when a key is unknown or revoked
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrAPIKeyNotFound matches the error returned when no API key has the requested ID
+	ErrAPIKeyNotFound = &NotFoundError{Resource: "api key"}
+)
+
+type APIKeyService interface {
+	CreateKey(ctx context.Context, name string, scopes []string) (string, *models.APIKey, error)
+	EnsureKey(ctx context.Context, id, name, key string, scopes []string) error
+	Authenticate(ctx context.Context, key string) (*models.APIKey, error)
+	ListKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeKey(ctx context.Context, id string) (*models.APIKey, error)
+}
+
+// APIKeyServiceImpl issues, checks and revokes API keys
+type APIKeyServiceImpl struct {
+	apiKeyRepository repository.APIKeyRepository
+}
+
+// NewAPIKeyService creates a new API key service instance
+func NewAPIKeyService(apiKeyRepository repository.APIKeyRepository) APIKeyService {
+	return &APIKeyServiceImpl{
+		apiKeyRepository: apiKeyRepository,
+	}
+}
+
+// CreateKey issues a new key with the given scopes. The returned key is the only copy
+// of it; only its hash is stored.
+func (s *APIKeyServiceImpl) CreateKey(ctx context.Context, name string, scopes []string) (string, *models.APIKey, error) {
+	key := auth.GenerateKey()
+	record, err := s.newKey(idgen.New(), name, key, scopes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := s.apiKeyRepository.Save(ctx, *record); err != nil {
+		return "", nil, fmt.Errorf("failed to save api key: %w", err)
+	}
+
+	return key, redact(record), nil
+}
+
+// EnsureKey stores a key supplied by the operator under a fixed ID, e.g. the bootstrap
+// admin key, replacing whatever that ID held before
+func (s *APIKeyServiceImpl) EnsureKey(ctx context.Context, id, name, key string, scopes []string) error {
+	if existing, err := s.apiKeyRepository.GetByID(ctx, id); err == nil && existing.KeyHash == auth.HashKey(key) && existing.RevokedAt == "" {
+		return nil
+	}
+
+	record, err := s.newKey(id, name, key, scopes)
+	if err != nil {
+		return err
+	}
+
+	if err := s.apiKeyRepository.Save(ctx, *record); err != nil {
+		return fmt.Errorf("failed to save api key: %w", err)
+	}
+
+	return nil
+}
+
+// Authenticate returns the key record matching key, or ErrInvalidAPIKey
+func (s *APIKeyServiceImpl) Authenticate(ctx context.Context, key string) (*models.APIKey, error) {
+	if key == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	record, err := s.apiKeyRepository.GetByHash(ctx, auth.HashKey(key))
+	if err != nil || record.RevokedAt != "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return redact(record), nil
+}
+
+// ListKeys lists every API key without its hash
+func (s *APIKeyServiceImpl) ListKeys(ctx context.Context) ([]models.APIKey, error) {
+	keys, err := s.apiKeyRepository.List(ctx)
+	if err != nil {
+		return nil, storageError("failed to list api keys: %w", err)
+	}
+
+	for i := range keys {
+		keys[i].KeyHash = ""
+	}
+
+	return keys, nil
+}
+
+// RevokeKey stops a key from authenticating. Revoking a revoked key is a no-op.
+func (s *APIKeyServiceImpl) RevokeKey(ctx context.Context, id string) (*models.APIKey, error) {
+	if id == "" {
+		return nil, invalid("id", "id is required and cannot be empty")
+	}
+
+	record, err := s.apiKeyRepository.GetByID(ctx, id)
+	if err != nil {
+		return nil, lookupError("api key", id, err)
+	}
+
+	if record.RevokedAt == "" {
+		record.RevokedAt = time.Now().UTC().Format(time.RFC3339)
+		if err := s.apiKeyRepository.Save(ctx, *record); err != nil {
+			return nil, storageError("failed to save api key: %w", err)
+		}
+	}
+
+	return redact(record), nil
+}
+
+// newKey validates the name and scopes and builds the record stored for key
+func (s *APIKeyServiceImpl) newKey(id, name, key string, scopes []string) (*models.APIKey, error) {
+	if name == "" {
+		return nil, invalid("name", "name is required and cannot be empty")
+	}
+	if len(scopes) == 0 {
+		return nil, invalid("scopes", "at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !auth.ValidScope(scope) {
+			return nil, invalid("scopes", "unknown scope %q", scope)
+		}
+	}
+
+	prefix := key
+	if len(prefix) > keyPrefixLength {
+		prefix = prefix[:keyPrefixLength]
+	}
+
+	return &models.APIKey{
+		ID:        id,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   auth.HashKey(key),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// redact returns a copy of the key without its hash, for returning to callers
+func redact(key *models.APIKey) *models.APIKey {
+	redacted := *key
+	redacted.KeyHash = ""
+	return &redacted
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+)
+
+// Mock API key repository, keeping saved keys in memory
+type mockAPIKeyRepository struct {
+	keys map[string]models.APIKey
+}
+
+func (m *mockAPIKeyRepository) Save(ctx context.Context, key models.APIKey) error {
+	if m.keys == nil {
+		m.keys = make(map[string]models.APIKey)
+	}
+	m.keys[key.ID] = key
+	return nil
+}
+
+func (m *mockAPIKeyRepository) GetByID(ctx context.Context, id string) (*models.APIKey, error) {
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &key, nil
+}
+
+func (m *mockAPIKeyRepository) GetByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	for _, key := range m.keys {
+		if key.KeyHash == hash {
+			return &key, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (m *mockAPIKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
+	keys := make([]models.APIKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func TestCreateKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyName string
+		scopes  []string
+		wantErr bool
+	}{
+		{name: "provider key", keyName: "provider", scopes: []string{auth.ScopeRebalanceSubmit}},
+		{name: "several scopes", keyName: "app", scopes: []string{auth.ScopePortfolioRead, auth.ScopePortfolioWrite}},
+		{name: "missing name", scopes: []string{auth.ScopeAdmin}, wantErr: true},
+		{name: "missing scopes", keyName: "app", wantErr: true},
+		{name: "unknown scope", keyName: "app", scopes: []string{"portfolio:delete"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockAPIKeyRepository{}
+			service := NewAPIKeyService(repo)
+
+			key, record, err := service.CreateKey(context.Background(), tt.keyName, tt.scopes)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !strings.HasPrefix(key, record.Prefix) || record.KeyHash != "" {
+				t.Errorf("expected a redacted record whose prefix matches the key, got %+v", record)
+			}
+			stored := repo.keys[record.ID]
+			if stored.KeyHash != auth.HashKey(key) || strings.Contains(stored.KeyHash, key) {
+				t.Errorf("expected only the key's hash to be stored, got %+v", stored)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAndRevoke(t *testing.T) {
+	repo := &mockAPIKeyRepository{}
+	service := NewAPIKeyService(repo)
+
+	key, record, err := service.CreateKey(context.Background(), "provider", []string{auth.ScopeRebalanceSubmit})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	authenticated, err := service.Authenticate(context.Background(), key)
+	if err != nil || authenticated.ID != record.ID || authenticated.KeyHash != "" {
+		t.Fatalf("expected the key to authenticate without exposing its hash, got %+v, %v", authenticated, err)
+	}
+	if _, err := service.Authenticate(context.Background(), key+"x"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expected ErrInvalidAPIKey for an unknown key, got %v", err)
+	}
+	if _, err := service.Authenticate(context.Background(), ""); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expected ErrInvalidAPIKey without a key, got %v", err)
+	}
+
+	revoked, err := service.RevokeKey(context.Background(), record.ID)
+	if err != nil || revoked.RevokedAt == "" {
+		t.Fatalf("expected the key to be revoked, got %+v, %v", revoked, err)
+	}
+	if _, err := service.Authenticate(context.Background(), key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expected a revoked key to be rejected, got %v", err)
+	}
+	if _, err := service.RevokeKey(context.Background(), "missing"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+
+	keys, err := service.ListKeys(context.Background())
+	if err != nil || len(keys) != 1 || keys[0].KeyHash != "" {
+		t.Errorf("expected one key listed without its hash, got %+v, %v", keys, err)
+	}
+}
+
+func TestEnsureKey(t *testing.T) {
+	repo := &mockAPIKeyRepository{}
+	service := NewAPIKeyService(repo)
+	adminKey := strings.Repeat("a", 32)
+
+	if err := service.EnsureKey(context.Background(), "bootstrap-admin", "bootstrap admin", adminKey, []string{auth.ScopeAdmin}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	created := repo.keys["bootstrap-admin"].CreatedAt
+
+	// Restarting with the same key leaves the record alone
+	if err := service.EnsureKey(context.Background(), "bootstrap-admin", "bootstrap admin", adminKey, []string{auth.ScopeAdmin}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(repo.keys) != 1 || repo.keys["bootstrap-admin"].CreatedAt != created {
+		t.Errorf("expected the bootstrap key to be kept as-is")
+	}
+
+	if key, err := service.Authenticate(context.Background(), adminKey); err != nil || !auth.HasScope(key.Scopes, auth.ScopePortfolioWrite) {
+		t.Errorf("expected the admin key to grant every scope, got %+v, %v", key, err)
+	}
+
+	// Rotating the key replaces the old one
+	rotated := strings.Repeat("b", 32)
+	if err := service.EnsureKey(context.Background(), "bootstrap-admin", "bootstrap admin", rotated, []string{auth.ScopeAdmin}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := service.Authenticate(context.Background(), adminKey); err == nil {
+		t.Errorf("expected the previous admin key to stop working")
+	}
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRebalanceNotFound matches the error returned when no rebalance has the requested ID
+	ErrRebalanceNotFound = &NotFoundError{Resource: "rebalance"}
+	// ErrInvalidTransition is returned when a rebalance cannot move to the requested status
+	ErrInvalidTransition = errors.New("invalid rebalance status transition")
+)
+
+// SubmitRebalance records the transactions as one rebalance. Portfolios that require
+// approval keep it PROPOSED; otherwise it is approved on the actor's behalf and published.
+// expected is the allocation the transactions reach once filled in full; executions that
+// fall short correct the portfolio's allocation from it. Nothing is recorded when there
+// are no transactions.
+func (s *RebalanceServiceImpl) SubmitRebalance(ctx context.Context, portfolio models.Portfolio, transactions []models.RebalanceTransaction, expected map[string]float64, actor string) (*models.Rebalance, error) {
+	if len(transactions) == 0 {
+		return nil, nil
+	}
+	if actor == "" {
+		return nil, invalid("actor", "actor is required")
+	}
+
+	timestamp := s.timestamp()
+	rebalance := models.Rebalance{
+		ID:                 transactions[0].RebalanceID,
+		UserID:             portfolio.UserID,
+		Transactions:       append([]models.RebalanceTransaction(nil), transactions...),
+		CreatedAt:          timestamp,
+		ExpectedAllocation: copyAllocation(expected),
+	}
+	if rebalance.ID == "" {
+		rebalance.ID = s.nextID()
+	}
+	for i := range rebalance.Transactions {
+		rebalance.Transactions[i].RebalanceID = rebalance.ID
+	}
+
+	s.transition(&rebalance, models.RebalanceStatusProposed, actor, "")
+	if portfolio.RequireApproval {
+		if err := s.rebalanceRepo.Save(ctx, rebalance); err != nil {
+			return nil, storageError("failed to save rebalance: %w", err)
+		}
+		log.Printf("Rebalance %s for user %s awaits approval", rebalance.ID, rebalance.UserID)
+		s.auditSubmission(ctx, portfolio, rebalance, actor)
+		return &rebalance, nil
+	}
+
+	s.transition(&rebalance, models.RebalanceStatusApproved, models.ActorSystem, "approval not required")
+	published, err := s.publish(ctx, rebalance)
+	if err != nil {
+		return nil, err
+	}
+	s.auditSubmission(ctx, portfolio, *published, actor)
+
+	return published, nil
+}
+
+// auditSubmission records a submitted rebalance in the audit trail, when one is kept. The
+// after snapshot is the portfolio at the allocation the rebalance is expected to reach.
+func (s *RebalanceServiceImpl) auditSubmission(ctx context.Context, portfolio models.Portfolio, rebalance models.Rebalance, actor string) {
+	if s.auditService == nil {
+		return
+	}
+
+	after := snapshot(portfolio)
+	if rebalance.ExpectedAllocation != nil {
+		after.Allocation = copyAllocation(rebalance.ExpectedAllocation)
+	}
+
+	s.auditService.Record(ctx, models.AuditEntry{
+		UserID:      rebalance.UserID,
+		Action:      models.AuditRebalanceSubmit,
+		Actor:       actor,
+		RebalanceID: rebalance.ID,
+		Before:      snapshot(portfolio),
+		After:       after,
+	})
+}
+
+// ApproveRebalance approves a proposed rebalance and publishes its transactions
+func (s *RebalanceServiceImpl) ApproveRebalance(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, error) {
+	rebalance, version, err := s.decide(ctx, decision)
+	if err != nil {
+		return nil, err
+	}
+
+	s.transition(rebalance, models.RebalanceStatusApproved, decision.Actor, decision.Reason)
+	if err := s.saveDecision(ctx, *rebalance, version); err != nil {
+		return nil, err
+	}
+	published, err := s.send(ctx, *rebalance)
+	if err != nil {
+		return nil, err
+	}
+	s.auditDecision(ctx, *published, models.AuditRebalanceApprove, decision.Actor)
+
+	return published, nil
+}
+
+// RejectRebalance rejects a proposed rebalance so it is never executed
+func (s *RebalanceServiceImpl) RejectRebalance(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, error) {
+	rebalance, version, err := s.decide(ctx, decision)
+	if err != nil {
+		return nil, err
+	}
+
+	s.transition(rebalance, models.RebalanceStatusRejected, decision.Actor, decision.Reason)
+	if err := s.saveDecision(ctx, *rebalance, version); err != nil {
+		return nil, err
+	}
+	s.auditDecision(ctx, *rebalance, models.AuditRebalanceReject, decision.Actor)
+
+	return rebalance, nil
+}
+
+// auditDecision records who approved or rejected a rebalance, when an audit trail is kept
+func (s *RebalanceServiceImpl) auditDecision(ctx context.Context, rebalance models.Rebalance, action, actor string) {
+	if s.auditService == nil {
+		return
+	}
+
+	s.auditService.Record(ctx, models.AuditEntry{
+		UserID:      rebalance.UserID,
+		Action:      action,
+		Actor:       actor,
+		RebalanceID: rebalance.ID,
+	})
+}
+
+// GetRebalance retrieves a rebalance with its transactions and status history
+func (s *RebalanceServiceImpl) GetRebalance(ctx context.Context, id string) (*models.Rebalance, error) {
+	rebalance, err := s.rebalanceRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, lookupError("rebalance", id, err)
+	}
+
+	return rebalance, nil
+}
+
+// ListRebalances lists a user's rebalances, newest first, optionally only those in status
+func (s *RebalanceServiceImpl) ListRebalances(ctx context.Context, userID, status string) ([]models.Rebalance, error) {
+	if userID == "" {
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+
+	rebalances, err := s.rebalanceRepo.ListByUserID(ctx, userID, status)
+	if err != nil {
+		return nil, storageError("failed to list rebalances: %w", err)
+	}
+
+	return rebalances, nil
+}
+
+// ListTransactions lists the transactions processed for a user between from and to, newest
+// first. Zero times leave that end of the range open.
+func (s *RebalanceServiceImpl) ListTransactions(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error) {
+	if userID == "" {
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+
+	transactions, err := s.transactionRepo.ListByUserID(ctx, userID, from, to)
+	if err != nil {
+		return nil, storageError("failed to list transactions: %w", err)
+	}
+
+	return transactions, nil
+}
+
+// decide loads the rebalance a decision applies to, with the version its decision must be
+// written over, and checks it is still waiting for one
+func (s *RebalanceServiceImpl) decide(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, repository.Version, error) {
+	if decision.RebalanceID == "" {
+		return nil, repository.Version{}, invalid("rebalance_id", "rebalance_id is required and cannot be empty")
+	}
+	if decision.Actor == "" {
+		return nil, repository.Version{}, invalid("actor", "actor is required and cannot be empty")
+	}
+
+	rebalance, version, err := s.rebalanceRepo.GetVersioned(ctx, decision.RebalanceID)
+	if err != nil {
+		return nil, repository.Version{}, lookupError("rebalance", decision.RebalanceID, err)
+	}
+	if rebalance.Status != models.RebalanceStatusProposed {
+		return nil, repository.Version{}, &ConflictError{Err: fmt.Errorf("%w: rebalance %s is %s, only %s rebalances can be decided",
+			ErrInvalidTransition, rebalance.ID, rebalance.Status, models.RebalanceStatusProposed)}
+	}
+
+	return rebalance, version, nil
+}
+
+// saveDecision writes a decided rebalance only if it is still at the version it was proposed
+// at, so of two concurrent decisions only the first takes effect
+func (s *RebalanceServiceImpl) saveDecision(ctx context.Context, rebalance models.Rebalance, version repository.Version) error {
+	err := s.rebalanceRepo.SaveIfVersion(ctx, rebalance, version)
+	if errors.Is(err, repository.ErrConflict) {
+		return &ConflictError{Err: fmt.Errorf("%w: rebalance %s was decided concurrently", ErrInvalidTransition, rebalance.ID)}
+	}
+	if err != nil {
+		return storageError("failed to save rebalance: %w", err)
+	}
+
+	return nil
+}
+
+// publish saves an approved rebalance and publishes its transactions for execution.
+// The approval is saved first so a rebalance is never executed without a record of it.
+func (s *RebalanceServiceImpl) publish(ctx context.Context, rebalance models.Rebalance) (*models.Rebalance, error) {
+	if err := s.rebalanceRepo.Save(ctx, rebalance); err != nil {
+		return nil, storageError("failed to save rebalance: %w", err)
+	}
+
+	return s.send(ctx, rebalance)
+}
+
+// send publishes the transactions of a saved, approved rebalance. A publish failure is
+// recorded as FAILED.
+func (s *RebalanceServiceImpl) send(ctx context.Context, rebalance models.Rebalance) (*models.Rebalance, error) {
+	if err := s.PublishRebalanceTransactions(ctx, rebalance.Transactions); err != nil {
+		s.transition(&rebalance, models.RebalanceStatusFailed, models.ActorSystem, err.Error())
+		if saveErr := s.rebalanceRepo.Save(ctx, rebalance); saveErr != nil {
+			log.Printf("Failed to record publish failure of rebalance %s: %v", rebalance.ID, saveErr)
+		}
+		return nil, err
+	}
+
+	return &rebalance, nil
+}
+
+// processRebalance executes and stores the legs of one approved rebalance, then moves it
+// to EXECUTED or FAILED. A redelivered message for a rebalance that already has an outcome
+// is skipped so no order reaches the broker twice. Rebalances published before the workflow
+// existed have no record; their legs are still processed.
+func (s *RebalanceServiceImpl) processRebalance(ctx context.Context, id string, transactions []models.RebalanceTransaction) {
+	var rebalance *models.Rebalance
+	if id != "" {
+		if stored, err := s.rebalanceRepo.GetByID(ctx, id); err == nil {
+			if stored.Status != models.RebalanceStatusApproved {
+				log.Printf("Skipping redelivered rebalance %s, already %s", id, stored.Status)
+				return
+			}
+			rebalance = stored
+		}
+	}
+
+	// Without a broker every leg counts as filled in full
+	executions := make(map[int]models.Execution)
+	if s.executionService != nil {
+		for i, e := range s.executionService.ExecuteRebalance(ctx, rebalance, transactions) {
+			executions[i] = e
+		}
+	}
+
+	var failures, partial []string
+	for i, tx := range transactions {
+		tx.Status = models.RebalanceStatusExecuted
+		if e, ok := executions[i]; ok {
+			switch e.Status {
+			case models.ExecutionRejected:
+				tx.Status = models.RebalanceStatusFailed
+				failures = append(failures, fmt.Sprintf("%s %s: %s", tx.Action, tx.Asset, e.Reason))
+			case models.ExecutionPartiallyFilled:
+				partial = append(partial, fmt.Sprintf("%s %s: %s", tx.Action, tx.Asset, e.Reason))
+			}
+		}
+
+		if err := s.transactionRepo.SaveTransaction(ctx, tx); err != nil {
+			log.Printf("Failed to save transaction for user %s, asset %s: %v", tx.UserID, tx.Asset, err)
+			failures = append(failures, fmt.Sprintf("%s %s: %v", tx.Action, tx.Asset, err))
+			// Continue processing other transactions even if one fails
+			continue
+		}
+	}
+
+	if rebalance == nil {
+		return
+	}
+
+	status, reason := models.RebalanceStatusExecuted, ""
+	if len(partial) > 0 {
+		reason = "partially filled: " + strings.Join(partial, "; ")
+	}
+	if len(failures) > 0 {
+		status, reason = models.RebalanceStatusFailed, strings.Join(failures, "; ")
+	}
+
+	s.transition(rebalance, status, models.ActorSystem, reason)
+	if err := s.rebalanceRepo.Save(ctx, *rebalance); err != nil {
+		log.Printf("Failed to record outcome of rebalance %s: %v", id, err)
+	}
+}
+
+// transition moves the rebalance and its transactions to status and records the event
+func (s *RebalanceServiceImpl) transition(rebalance *models.Rebalance, status, actor, reason string) {
+	timestamp := s.timestamp()
+	rebalance.Status = status
+	rebalance.UpdatedAt = timestamp
+	rebalance.Events = append(rebalance.Events, models.RebalanceEvent{
+		Status:    status,
+		Actor:     actor,
+		Reason:    reason,
+		Timestamp: timestamp,
+	})
+	for i := range rebalance.Transactions {
+		rebalance.Transactions[i].Status = status
+	}
+}
@@ -0,0 +1,420 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/pkg/fx"
+	"testing"
+	"time"
+)
+
+// Mock rebalance repository, keeping saved rebalances in memory with a sequence number
+// that every write bumps
+type mockRebalanceRepository struct {
+	rebalances map[string]models.Rebalance
+	seqNos     map[string]int
+	// beforeSaveIfVersion runs ahead of a conditional write, e.g. to race it with another
+	beforeSaveIfVersion func(rebalance models.Rebalance)
+}
+
+func (m *mockRebalanceRepository) Save(ctx context.Context, rebalance models.Rebalance) error {
+	if m.rebalances == nil {
+		m.rebalances = make(map[string]models.Rebalance)
+		m.seqNos = make(map[string]int)
+	}
+	m.rebalances[rebalance.ID] = rebalance
+	m.seqNos[rebalance.ID]++
+	return nil
+}
+
+func (m *mockRebalanceRepository) GetByID(ctx context.Context, id string) (*models.Rebalance, error) {
+	rebalance, _, err := m.GetVersioned(ctx, id)
+	return rebalance, err
+}
+
+func (m *mockRebalanceRepository) GetVersioned(ctx context.Context, id string) (*models.Rebalance, repository.Version, error) {
+	rebalance, ok := m.rebalances[id]
+	if !ok {
+		return nil, repository.Version{}, repository.ErrNotFound
+	}
+	return &rebalance, repository.Version{SeqNo: m.seqNos[id], PrimaryTerm: 1}, nil
+}
+
+func (m *mockRebalanceRepository) SaveIfVersion(ctx context.Context, rebalance models.Rebalance, version repository.Version) error {
+	if m.beforeSaveIfVersion != nil {
+		hook := m.beforeSaveIfVersion
+		m.beforeSaveIfVersion = nil
+		hook(rebalance)
+	}
+	if version.SeqNo != m.seqNos[rebalance.ID] {
+		return repository.ErrConflict
+	}
+	return m.Save(ctx, rebalance)
+}
+
+func (m *mockRebalanceRepository) ListByUserID(ctx context.Context, userID, status string) ([]models.Rebalance, error) {
+	var rebalances []models.Rebalance
+	for _, rebalance := range m.rebalances {
+		if rebalance.UserID == userID && (status == "" || rebalance.Status == status) {
+			rebalances = append(rebalances, rebalance)
+		}
+	}
+	return rebalances, nil
+}
+
+// statuses lists the statuses of the rebalance's events in order
+func statuses(rebalance models.Rebalance) []string {
+	result := make([]string, len(rebalance.Events))
+	for i, event := range rebalance.Events {
+		result[i] = event.Status + " by " + event.Actor
+	}
+	return result
+}
+
+func TestRebalanceApprovalWorkflow(t *testing.T) {
+	tests := []struct {
+		name              string
+		requireApproval   bool
+		decide            string // approve, reject or empty for none
+		publishErr        error
+		expectedStatus    string
+		expectedEvents    []string
+		expectedPublished int
+		expectedErr       bool
+	}{
+		{
+			name:              "approval not required",
+			expectedStatus:    models.RebalanceStatusApproved,
+			expectedEvents:    []string{"PROPOSED by provider", "APPROVED by system"},
+			expectedPublished: 1,
+		},
+		{
+			name:            "awaiting approval",
+			requireApproval: true,
+			expectedStatus:  models.RebalanceStatusProposed,
+			expectedEvents:  []string{"PROPOSED by provider"},
+		},
+		{
+			name:              "approved",
+			requireApproval:   true,
+			decide:            "approve",
+			expectedStatus:    models.RebalanceStatusApproved,
+			expectedEvents:    []string{"PROPOSED by provider", "APPROVED by jane"},
+			expectedPublished: 1,
+		},
+		{
+			name:            "rejected",
+			requireApproval: true,
+			decide:          "reject",
+			expectedStatus:  models.RebalanceStatusRejected,
+			expectedEvents:  []string{"PROPOSED by provider", "REJECTED by jane"},
+		},
+		{
+			name:              "publish failure",
+			requireApproval:   true,
+			decide:            "approve",
+			publishErr:        errors.New("kafka down"),
+			expectedStatus:    models.RebalanceStatusFailed,
+			expectedEvents:    []string{"PROPOSED by provider", "APPROVED by jane", "FAILED by system"},
+			expectedPublished: 1,
+			expectedErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			published := 0
+			var publishedTx []models.RebalanceTransaction
+			publisher := &mockPublisher{
+				publishFunc: func(ctx context.Context, message []byte) error {
+					published++
+					if err := json.Unmarshal(message, &publishedTx); err != nil {
+						t.Fatalf("failed to decode published message: %v", err)
+					}
+					return tt.publishErr
+				},
+			}
+
+			repo := &mockRebalanceRepository{}
+			service := NewRebalanceService(&mockTransactionRepository{}, repo, publisher, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
+			portfolio := models.Portfolio{UserID: "user1", RequireApproval: tt.requireApproval}
+			transactions := service.CalculateRebalance(
+				map[string]float64{"stocks": 70, "bonds": 30},
+				map[string]float64{"stocks": 60, "bonds": 40},
+				"user1")
+
+			submitted, err := service.SubmitRebalance(context.Background(), portfolio, transactions, nil, models.ActorProvider)
+			if err != nil {
+				t.Fatalf("unexpected submit error: %v", err)
+			}
+
+			decision := models.RebalanceDecision{RebalanceID: transactions[0].RebalanceID, Actor: "jane"}
+			switch tt.decide {
+			case "approve":
+				_, err = service.ApproveRebalance(context.Background(), decision)
+			case "reject":
+				_, err = service.RejectRebalance(context.Background(), decision)
+			}
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if submitted == nil || submitted.ID != transactions[0].RebalanceID {
+				t.Fatalf("expected the rebalance to be keyed by the transactions' rebalance_id")
+			}
+
+			stored, err := service.GetRebalance(context.Background(), decision.RebalanceID)
+			if err != nil {
+				t.Fatalf("expected the rebalance to be stored: %v", err)
+			}
+			if stored.Status != tt.expectedStatus {
+				t.Errorf("expected status %s, got %s", tt.expectedStatus, stored.Status)
+			}
+			events := statuses(*stored)
+			if len(events) != len(tt.expectedEvents) {
+				t.Fatalf("expected events %v, got %v", tt.expectedEvents, events)
+			}
+			for i := range events {
+				if events[i] != tt.expectedEvents[i] {
+					t.Errorf("event %d: expected %s, got %s", i, tt.expectedEvents[i], events[i])
+				}
+			}
+			for _, tx := range stored.Transactions {
+				if tx.Status != tt.expectedStatus {
+					t.Errorf("expected transaction status %s, got %s", tt.expectedStatus, tx.Status)
+				}
+			}
+
+			if published != tt.expectedPublished {
+				t.Errorf("expected %d publishes, got %d", tt.expectedPublished, published)
+			}
+			for _, tx := range publishedTx {
+				if tx.Status != models.RebalanceStatusApproved {
+					t.Errorf("expected only approved transactions to be published, got %s", tx.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestDecideRebalanceErrors(t *testing.T) {
+	repo := &mockRebalanceRepository{}
+	service := NewRebalanceService(&mockTransactionRepository{}, repo, &mockPublisher{}, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
+	transactions := service.CalculateRebalance(
+		map[string]float64{"stocks": 70, "bonds": 30},
+		map[string]float64{"stocks": 60, "bonds": 40},
+		"user1")
+	if _, err := service.SubmitRebalance(context.Background(), models.Portfolio{UserID: "user1"}, transactions, nil, models.ActorProvider); err != nil {
+		t.Fatalf("unexpected submit error: %v", err)
+	}
+	id := transactions[0].RebalanceID
+
+	if _, err := service.ApproveRebalance(context.Background(), models.RebalanceDecision{RebalanceID: "missing", Actor: "jane"}); !errors.Is(err, ErrRebalanceNotFound) {
+		t.Errorf("expected ErrRebalanceNotFound, got %v", err)
+	}
+	if _, err := service.RejectRebalance(context.Background(), models.RebalanceDecision{RebalanceID: id, Actor: "jane"}); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("expected ErrInvalidTransition for an approved rebalance, got %v", err)
+	}
+	if _, err := service.ApproveRebalance(context.Background(), models.RebalanceDecision{RebalanceID: id}); err == nil {
+		t.Errorf("expected an error without an actor")
+	}
+	if rebalance, err := service.SubmitRebalance(context.Background(), models.Portfolio{UserID: "user1"}, nil, nil, models.ActorProvider); rebalance != nil || err != nil {
+		t.Errorf("expected nothing to be recorded without transactions, got %v, %v", rebalance, err)
+	}
+}
+
+func TestConcurrentDecisions(t *testing.T) {
+	tests := []struct {
+		name              string
+		first, second     string // approve or reject; second runs between first's read and write
+		expectedStatus    string
+		expectedPublished int
+	}{
+		{name: "approve and approve", first: "approve", second: "approve", expectedStatus: models.RebalanceStatusApproved, expectedPublished: 1},
+		{name: "approve and reject", first: "approve", second: "reject", expectedStatus: models.RebalanceStatusRejected},
+		{name: "reject and approve", first: "reject", second: "approve", expectedStatus: models.RebalanceStatusApproved, expectedPublished: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			published := 0
+			publisher := &mockPublisher{
+				publishFunc: func(ctx context.Context, message []byte) error {
+					published++
+					return nil
+				},
+			}
+			repo := &mockRebalanceRepository{}
+			service := NewRebalanceService(&mockTransactionRepository{}, repo, publisher, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
+			transactions := service.CalculateRebalance(
+				map[string]float64{"stocks": 70, "bonds": 30},
+				map[string]float64{"stocks": 60, "bonds": 40},
+				"user1")
+			if _, err := service.SubmitRebalance(context.Background(), models.Portfolio{UserID: "user1", RequireApproval: true}, transactions, nil, models.ActorProvider); err != nil {
+				t.Fatalf("unexpected submit error: %v", err)
+			}
+
+			decide := func(action, actor string) error {
+				decision := models.RebalanceDecision{RebalanceID: transactions[0].RebalanceID, Actor: actor}
+				var err error
+				if action == "approve" {
+					_, err = service.ApproveRebalance(context.Background(), decision)
+				} else {
+					_, err = service.RejectRebalance(context.Background(), decision)
+				}
+				return err
+			}
+
+			var secondErr error
+			repo.beforeSaveIfVersion = func(models.Rebalance) {
+				secondErr = decide(tt.second, "joe")
+			}
+			firstErr := decide(tt.first, "jane")
+
+			if secondErr != nil {
+				t.Fatalf("expected the decision that wrote first to succeed, got %v", secondErr)
+			}
+			var conflictErr *ConflictError
+			if !errors.As(firstErr, &conflictErr) || !errors.Is(firstErr, ErrInvalidTransition) {
+				t.Errorf("expected the later decision to conflict, got %v", firstErr)
+			}
+
+			stored, err := service.GetRebalance(context.Background(), transactions[0].RebalanceID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stored.Status != tt.expectedStatus {
+				t.Errorf("expected status %s, got %s", tt.expectedStatus, stored.Status)
+			}
+			if published != tt.expectedPublished {
+				t.Errorf("expected %d publishes, got %d", tt.expectedPublished, published)
+			}
+		})
+	}
+}
+
+func TestListTransactions(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	stored := []models.RebalanceTransaction{{RebalanceID: "rb1", UserID: "user1", Action: "SELL", Asset: "stocks", RebalancePercent: 10, Timestamp: "2024-05-02T00:00:00Z"}}
+	esDown := errors.New("es down")
+
+	tests := []struct {
+		name        string
+		userID      string
+		listErr     error
+		expectedLen int
+		expectErr   func(error) bool
+	}{
+		{name: "lists the user's transactions", userID: "user1", expectedLen: 1},
+		{
+			name:   "requires a user",
+			userID: "",
+			expectErr: func(err error) bool {
+				var validationErr *ValidationError
+				return errors.As(err, &validationErr)
+			},
+		},
+		{
+			name:    "store failure",
+			userID:  "user1",
+			listErr: esDown,
+			expectErr: func(err error) bool {
+				var unavailableErr *DependencyUnavailableError
+				return errors.As(err, &unavailableErr) && errors.Is(err, esDown)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txRepo := &mockTransactionRepository{
+				listFunc: func(ctx context.Context, userID string, gotFrom, gotTo time.Time) ([]models.RebalanceTransaction, error) {
+					if userID != tt.userID || !gotFrom.Equal(from) || !gotTo.IsZero() {
+						t.Errorf("unexpected query for %q from %v to %v", userID, gotFrom, gotTo)
+					}
+					return stored, tt.listErr
+				},
+			}
+			service := NewRebalanceService(txRepo, &mockRebalanceRepository{}, &mockPublisher{}, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
+
+			transactions, err := service.ListTransactions(context.Background(), tt.userID, from, time.Time{})
+
+			if tt.expectErr != nil {
+				if !tt.expectErr(err) {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(transactions) != tt.expectedLen {
+				t.Errorf("expected %d transactions, got %d", tt.expectedLen, len(transactions))
+			}
+		})
+	}
+}
+
+func TestProcessTransactionsRecordsOutcome(t *testing.T) {
+	tests := []struct {
+		name           string
+		saveErr        error
+		expectedStatus string
+	}{
+		{name: "executed", expectedStatus: models.RebalanceStatusExecuted},
+		{name: "failed", saveErr: errors.New("es down"), expectedStatus: models.RebalanceStatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var message []byte
+			publisher := &mockPublisher{
+				publishFunc: func(ctx context.Context, m []byte) error {
+					message = m
+					return nil
+				},
+			}
+			var savedStatus []string
+			txRepo := &mockTransactionRepository{
+				saveFunc: func(ctx context.Context, tx models.RebalanceTransaction) error {
+					savedStatus = append(savedStatus, tx.Status)
+					return tt.saveErr
+				},
+			}
+
+			repo := &mockRebalanceRepository{}
+			service := NewRebalanceService(txRepo, repo, publisher, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
+			transactions := service.CalculateRebalance(
+				map[string]float64{"stocks": 70, "bonds": 30},
+				map[string]float64{"stocks": 60, "bonds": 40},
+				"user1")
+			if _, err := service.SubmitRebalance(context.Background(), models.Portfolio{UserID: "user1"}, transactions, nil, models.ActorProvider); err != nil {
+				t.Fatalf("unexpected submit error: %v", err)
+			}
+
+			if err := service.ProcessTransactions(context.Background(), message); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// A redelivered message must not record a second outcome
+			if err := service.ProcessTransactions(context.Background(), message); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, status := range savedStatus {
+				if status != models.RebalanceStatusExecuted {
+					t.Errorf("expected transactions to be saved as executed, got %s", status)
+				}
+			}
+
+			stored, _ := service.GetRebalance(context.Background(), transactions[0].RebalanceID)
+			if stored.Status != tt.expectedStatus {
+				t.Errorf("expected status %s, got %s", tt.expectedStatus, stored.Status)
+			}
+			if len(stored.Events) != 3 {
+				t.Errorf("expected 3 events, got %v", statuses(*stored))
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"regexp"
+	"time"
+)
+
+// currencyPattern matches an ISO 4217 currency code
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+type AssetService interface {
+	CreateAsset(ctx context.Context, asset models.Asset) (*models.Asset, error)
+	GetAsset(ctx context.Context, id string) (*models.Asset, error)
+	ListAssets(ctx context.Context) ([]models.Asset, error)
+	UpdateAsset(ctx context.Context, asset models.Asset) (*models.Asset, error)
+	Registry(ctx context.Context) (*models.AssetRegistry, error)
+}
+
+// AssetServiceImpl manages the asset registry
+type AssetServiceImpl struct {
+	assetRepository repository.AssetRepository
+}
+
+// NewAssetService creates a new asset service instance
+func NewAssetService(assetRepository repository.AssetRepository) AssetService {
+	return &AssetServiceImpl{
+		assetRepository: assetRepository,
+	}
+}
+
+// CreateAsset registers a new asset
+func (s *AssetServiceImpl) CreateAsset(ctx context.Context, asset models.Asset) (*models.Asset, error) {
+	if err := validateAsset(asset); err != nil {
+		return nil, err
+	}
+
+	_, err := s.assetRepository.GetByID(ctx, asset.ID)
+	if err == nil {
+		return nil, &ConflictError{Err: fmt.Errorf("asset %s already exists", asset.ID)}
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, lookupError("asset", asset.ID, err)
+	}
+
+	return s.save(ctx, asset)
+}
+
+// GetAsset retrieves an asset by ID
+func (s *AssetServiceImpl) GetAsset(ctx context.Context, id string) (*models.Asset, error) {
+	if id == "" {
+		return nil, invalid("id", "id is required and cannot be empty")
+	}
+
+	asset, err := s.assetRepository.GetByID(ctx, id)
+	if err != nil {
+		return nil, lookupError("asset", id, err)
+	}
+
+	return asset, nil
+}
+
+// ListAssets retrieves every registered asset
+func (s *AssetServiceImpl) ListAssets(ctx context.Context) ([]models.Asset, error) {
+	assets, err := s.assetRepository.List(ctx)
+	if err != nil {
+		return nil, storageError("failed to list assets: %w", err)
+	}
+
+	return assets, nil
+}
+
+// UpdateAsset replaces the metadata and aliases of an existing asset
+func (s *AssetServiceImpl) UpdateAsset(ctx context.Context, asset models.Asset) (*models.Asset, error) {
+	if err := validateAsset(asset); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.GetAsset(ctx, asset.ID); err != nil {
+		return nil, err
+	}
+
+	return s.save(ctx, asset)
+}
+
+// Registry returns a lookup of every registered asset by ID and alias
+func (s *AssetServiceImpl) Registry(ctx context.Context) (*models.AssetRegistry, error) {
+	assets, err := s.ListAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.NewAssetRegistry(assets), nil
+}
+
+// save stores the asset once its ID and aliases are known not to clash with another asset
+func (s *AssetServiceImpl) save(ctx context.Context, asset models.Asset) (*models.Asset, error) {
+	registry, err := s.Registry(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range append([]string{asset.ID}, asset.Aliases...) {
+		if other, ok := registry.Lookup(name); ok && other.ID != asset.ID {
+			return nil, &ConflictError{Err: fmt.Errorf("%s is already used by asset %s", name, other.ID)}
+		}
+	}
+
+	asset.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := s.assetRepository.Save(ctx, asset); err != nil {
+		return nil, storageError("failed to save asset: %w", err)
+	}
+
+	return &asset, nil
+}
+
+func validateAsset(asset models.Asset) error {
+	if asset.ID == "" {
+		return invalid("id", "id is required and cannot be empty")
+	}
+	if asset.Name == "" {
+		return invalid("name", "name is required and cannot be empty")
+	}
+	if asset.Class == "" {
+		return invalid("class", "class is required and cannot be empty")
+	}
+	if asset.Currency != "" && !currencyPattern.MatchString(asset.Currency) {
+		return invalid("currency", "currency must be a 3-letter ISO 4217 code, got %s", asset.Currency)
+	}
+	if asset.MinLot < 0 {
+		return invalid("min_lot", "min_lot cannot be negative")
+	}
+
+	seen := map[string]bool{asset.ID: true}
+	for _, alias := range asset.Aliases {
+		if alias == "" {
+			return invalid("aliases", "aliases cannot be empty")
+		}
+		if seen[alias] {
+			return invalid("aliases", "alias %s is repeated or equal to the asset ID", alias)
+		}
+		seen[alias] = true
+	}
+
+	return nil
+}
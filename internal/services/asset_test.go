@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+)
+
+// Mock asset repository
+type mockAssetRepository struct {
+	assets   []models.Asset
+	saveFunc func(ctx context.Context, asset models.Asset) error
+}
+
+func (m *mockAssetRepository) Save(ctx context.Context, asset models.Asset) error {
+	if m.saveFunc != nil {
+		return m.saveFunc(ctx, asset)
+	}
+	return nil
+}
+
+func (m *mockAssetRepository) GetByID(ctx context.Context, id string) (*models.Asset, error) {
+	for _, asset := range m.assets {
+		if asset.ID == id {
+			return &asset, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (m *mockAssetRepository) List(ctx context.Context) ([]models.Asset, error) {
+	return m.assets, nil
+}
+
+// registeredAssets is a small registry with aliases used across service tests
+func registeredAssets() *mockAssetRepository {
+	return &mockAssetRepository{
+		assets: []models.Asset{
+			{ID: "stocks", Name: "Global Equity", Class: "equity", Currency: "USD", Tradable: true, Aliases: []string{"stock", "equities"}},
+			{ID: "bonds", Name: "Aggregate Bonds", Class: "fixed_income", Currency: "USD", Tradable: true, Aliases: []string{"bond"}},
+			{ID: "gold", Name: "Gold", Class: "commodity", Currency: "USD", Tradable: true},
+		},
+	}
+}
+
+func TestCreateAsset(t *testing.T) {
+	tests := []struct {
+		name        string
+		asset       models.Asset
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:  "valid asset",
+			asset: models.Asset{ID: "reit", Name: "Real Estate", Class: "real_estate", Currency: "IDR", Tradable: true, MinLot: 100, Aliases: []string{"property"}},
+		},
+		{
+			name:        "duplicate ID",
+			asset:       models.Asset{ID: "gold", Name: "Gold", Class: "commodity"},
+			expectError: true,
+			errorMsg:    "asset gold already exists",
+		},
+		{
+			name:        "alias used by another asset",
+			asset:       models.Asset{ID: "equity_fund", Name: "Equity Fund", Class: "equity", Aliases: []string{"stock"}},
+			expectError: true,
+			errorMsg:    "stock is already used by asset stocks",
+		},
+		{
+			name:        "ID used as another asset's alias",
+			asset:       models.Asset{ID: "bond", Name: "Bond", Class: "fixed_income"},
+			expectError: true,
+			errorMsg:    "bond is already used by asset bonds",
+		},
+		{
+			name:        "missing class",
+			asset:       models.Asset{ID: "reit", Name: "Real Estate"},
+			expectError: true,
+			errorMsg:    "class is required and cannot be empty",
+		},
+		{
+			name:        "invalid currency",
+			asset:       models.Asset{ID: "reit", Name: "Real Estate", Class: "real_estate", Currency: "usd"},
+			expectError: true,
+			errorMsg:    "currency must be a 3-letter ISO 4217 code, got usd",
+		},
+		{
+			name:        "negative min lot",
+			asset:       models.Asset{ID: "reit", Name: "Real Estate", Class: "real_estate", MinLot: -1},
+			expectError: true,
+			errorMsg:    "min_lot cannot be negative",
+		},
+		{
+			name:        "alias equal to ID",
+			asset:       models.Asset{ID: "reit", Name: "Real Estate", Class: "real_estate", Aliases: []string{"reit"}},
+			expectError: true,
+			errorMsg:    "alias reit is repeated or equal to the asset ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewAssetService(registeredAssets())
+
+			result, err := service.CreateAsset(context.Background(), tt.asset)
+
+			if tt.expectError {
+				if err == nil || err.Error() != tt.errorMsg {
+					t.Errorf("expected error '%s', got '%v'", tt.errorMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			if result.UpdatedAt == "" {
+				t.Errorf("expected updated_at to be set")
+			}
+		})
+	}
+}
+
+func TestUpdateAsset(t *testing.T) {
+	service := NewAssetService(registeredAssets())
+
+	updated, err := service.UpdateAsset(context.Background(), models.Asset{
+		ID: "stocks", Name: "Global Equity", Class: "equity", Tradable: false, Aliases: []string{"stock", "equities", "shares"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if len(updated.Aliases) != 3 {
+		t.Errorf("expected aliases to be replaced, got %v", updated.Aliases)
+	}
+
+	if _, err := service.UpdateAsset(context.Background(), models.Asset{ID: "crypto", Name: "Crypto", Class: "crypto"}); err == nil {
+		t.Errorf("expected error for unknown asset")
+	}
+}
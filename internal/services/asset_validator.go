@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"portfolio-rebalancer/internal/models"
+	"sort"
+	"strings"
+)
+
+// AssetUniverseError is returned when a reported allocation does not match the
+// portfolio's asset universe. It lists every offending asset so callers can report them.
+type AssetUniverseError struct {
+	Unknown []string
+	Missing []string
+}
+
+func (e *AssetUniverseError) Error() string {
+	var parts []string
+	if len(e.Unknown) > 0 {
+		parts = append(parts, "unknown assets: "+strings.Join(e.Unknown, ", "))
+	}
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing assets: "+strings.Join(e.Missing, ", "))
+	}
+	return "allocation does not match the portfolio's assets (" + strings.Join(parts, "; ") + ")"
+}
+
+type AssetValidator interface {
+	ValidateAllocation(ctx context.Context, allocation map[string]float64, portfolio models.Portfolio) (*models.AssetValidation, error)
+}
+
+// AssetConfig is the validation mode and the assets allowed on top of the asset registry
+type AssetConfig struct {
+	Mode    string
+	Assets  []string          // assets every portfolio may hold, on top of its own
+	Aliases map[string]string // alias -> canonical asset
+}
+
+// AssetConfigFromEnv reads ASSET_VALIDATION_MODE (strict, warn or alias; default strict),
+// ASSET_UNIVERSE as a comma separated list and ASSET_ALIASES as comma separated alias=asset pairs
+func AssetConfigFromEnv() (AssetConfig, error) {
+	config := AssetConfig{
+		Mode:    os.Getenv("ASSET_VALIDATION_MODE"),
+		Aliases: make(map[string]string),
+	}
+	if config.Mode == "" {
+		config.Mode = models.AssetValidationStrict
+	}
+
+	for _, asset := range strings.Split(os.Getenv("ASSET_UNIVERSE"), ",") {
+		if asset = strings.TrimSpace(asset); asset != "" {
+			config.Assets = append(config.Assets, asset)
+		}
+	}
+
+	for _, pair := range strings.Split(os.Getenv("ASSET_ALIASES"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		alias, asset, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(alias) == "" || strings.TrimSpace(asset) == "" {
+			return config, fmt.Errorf("invalid ASSET_ALIASES entry %q, expected alias=asset", pair)
+		}
+		config.Aliases[strings.TrimSpace(alias)] = strings.TrimSpace(asset)
+	}
+
+	switch config.Mode {
+	case models.AssetValidationStrict, models.AssetValidationWarn, models.AssetValidationAlias:
+		return config, nil
+	default:
+		return config, fmt.Errorf("invalid ASSET_VALIDATION_MODE %q, expected strict, warn or alias", config.Mode)
+	}
+}
+
+type AssetValidatorImpl struct {
+	config       AssetConfig
+	known        map[string]bool
+	assetService AssetService
+}
+
+// NewAssetValidator creates a validator for the configured assets and the asset registry
+func NewAssetValidator(config AssetConfig, assetService AssetService) AssetValidator {
+	known := make(map[string]bool, len(config.Assets))
+	for _, asset := range config.Assets {
+		known[asset] = true
+	}
+	return &AssetValidatorImpl{
+		config:       config,
+		known:        known,
+		assetService: assetService,
+	}
+}
+
+// ValidateAllocation compares a reported allocation with the assets the portfolio holds
+// or targets. Assets outside that universe, the configured one and the registry are unknown, while
+// held assets the allocation leaves out are missing; a typo shows up as both. Targeted assets
+// not held yet may be left out, at 0%, and are bought. Cash is always known and never missing:
+// it is what unfilled orders leave behind, which providers do not track. Strict and alias modes
+// reject unknown or missing assets, warn mode only reports them.
+func (v *AssetValidatorImpl) ValidateAllocation(ctx context.Context, allocation map[string]float64, portfolio models.Portfolio) (*models.AssetValidation, error) {
+	registry, err := v.assetService.Registry(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.AssetValidation{
+		Mode:       v.config.Mode,
+		Allocation: allocation,
+	}
+
+	if v.config.Mode == models.AssetValidationAlias {
+		result.Allocation = make(map[string]float64, len(allocation))
+		for asset, percent := range allocation {
+			canonical, ok := v.config.Aliases[asset]
+			if registered, found := registry.Lookup(asset); !ok && found && registered.ID != asset {
+				canonical, ok = registered.ID, true
+			}
+			if ok {
+				if result.Aliased == nil {
+					result.Aliased = make(map[string]string)
+				}
+				result.Aliased[asset] = canonical
+				asset = canonical
+			}
+			result.Allocation[asset] += percent
+		}
+	}
+
+	expected := make(map[string]bool)
+	held := make(map[string]bool)
+	for asset, percent := range portfolio.OriginalAllocation {
+		if percent > 0 {
+			expected[asset] = true
+		}
+	}
+	for asset, percent := range portfolio.Allocation {
+		if percent > 0 {
+			expected[asset] = true
+			held[asset] = true
+		}
+	}
+	expected[models.CashAsset] = true
+	delete(held, models.CashAsset)
+
+	for asset := range result.Allocation {
+		registered, found := registry.Lookup(asset)
+		if !expected[asset] && !v.known[asset] && !(found && registered.ID == asset) {
+			result.Unknown = append(result.Unknown, asset)
+		}
+	}
+	for asset := range held {
+		if _, exists := result.Allocation[asset]; !exists {
+			result.Missing = append(result.Missing, asset)
+		}
+	}
+	sort.Strings(result.Unknown)
+	sort.Strings(result.Missing)
+
+	if result.HasIssues() && v.config.Mode != models.AssetValidationWarn {
+		return result, &AssetUniverseError{Unknown: result.Unknown, Missing: result.Missing}
+	}
+
+	return result, nil
+}
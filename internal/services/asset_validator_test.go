@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+)
+
+func TestValidateAllocationAssets(t *testing.T) {
+	portfolio := models.Portfolio{
+		UserID:             "user1",
+		Allocation:         map[string]float64{"stocks": 60.0, "bonds": 30.0, "crypto": 10.0},
+		OriginalAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+	}
+
+	tests := []struct {
+		name               string
+		config             AssetConfig
+		registry           *mockAssetRepository
+		allocation         map[string]float64
+		expectRejected     bool
+		expectedUnknown    []string
+		expectedMissing    []string
+		expectedAllocation map[string]float64
+	}{
+		{
+			name:               "matching assets",
+			config:             AssetConfig{Mode: models.AssetValidationStrict},
+			allocation:         map[string]float64{"stocks": 65.0, "bonds": 25.0, "crypto": 10.0},
+			expectedAllocation: map[string]float64{"stocks": 65.0, "bonds": 25.0, "crypto": 10.0},
+		},
+		{
+			name:            "strict rejects a typo",
+			config:          AssetConfig{Mode: models.AssetValidationStrict},
+			allocation:      map[string]float64{"stock": 65.0, "bonds": 25.0, "crypto": 10.0},
+			expectRejected:  true,
+			expectedUnknown: []string{"stock"},
+			expectedMissing: []string{"stocks"},
+		},
+		{
+			name:               "configured universe allows new assets",
+			config:             AssetConfig{Mode: models.AssetValidationStrict, Assets: []string{"gold"}},
+			allocation:         map[string]float64{"stocks": 60.0, "bonds": 25.0, "crypto": 10.0, "gold": 5.0},
+			expectedAllocation: map[string]float64{"stocks": 60.0, "bonds": 25.0, "crypto": 10.0, "gold": 5.0},
+		},
+		{
+			name:               "warn accepts and reports",
+			config:             AssetConfig{Mode: models.AssetValidationWarn},
+			allocation:         map[string]float64{"stock": 65.0, "bonds": 25.0, "crypto": 10.0},
+			expectedUnknown:    []string{"stock"},
+			expectedMissing:    []string{"stocks"},
+			expectedAllocation: map[string]float64{"stock": 65.0, "bonds": 25.0, "crypto": 10.0},
+		},
+		{
+			name:               "alias maps to the canonical asset",
+			config:             AssetConfig{Mode: models.AssetValidationAlias, Aliases: map[string]string{"stock": "stocks"}},
+			allocation:         map[string]float64{"stock": 65.0, "bonds": 25.0, "crypto": 10.0},
+			expectedAllocation: map[string]float64{"stocks": 65.0, "bonds": 25.0, "crypto": 10.0},
+		},
+		{
+			name:            "alias still rejects unmapped assets",
+			config:          AssetConfig{Mode: models.AssetValidationAlias, Aliases: map[string]string{"stock": "stocks"}},
+			allocation:      map[string]float64{"stocks": 65.0, "bond": 25.0, "crypto": 10.0},
+			expectRejected:  true,
+			expectedUnknown: []string{"bond"},
+			expectedMissing: []string{"bonds"},
+		},
+		{
+			name:            "aliases are ignored in strict mode",
+			config:          AssetConfig{Mode: models.AssetValidationStrict, Aliases: map[string]string{"stock": "stocks"}},
+			allocation:      map[string]float64{"stock": 65.0, "bonds": 25.0, "crypto": 10.0},
+			expectRejected:  true,
+			expectedUnknown: []string{"stock"},
+			expectedMissing: []string{"stocks"},
+		},
+		{
+			name:               "registered assets are known",
+			config:             AssetConfig{Mode: models.AssetValidationStrict},
+			registry:           registeredAssets(),
+			allocation:         map[string]float64{"stocks": 60.0, "bonds": 25.0, "crypto": 10.0, "gold": 5.0},
+			expectedAllocation: map[string]float64{"stocks": 60.0, "bonds": 25.0, "crypto": 10.0, "gold": 5.0},
+		},
+		{
+			name:               "alias mode resolves registry aliases",
+			config:             AssetConfig{Mode: models.AssetValidationAlias},
+			registry:           registeredAssets(),
+			allocation:         map[string]float64{"equities": 65.0, "bond": 25.0, "crypto": 10.0},
+			expectedAllocation: map[string]float64{"stocks": 65.0, "bonds": 25.0, "crypto": 10.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := tt.registry
+			if registry == nil {
+				registry = &mockAssetRepository{}
+			}
+			validator := NewAssetValidator(tt.config, NewAssetService(registry))
+
+			result, err := validator.ValidateAllocation(context.Background(), tt.allocation, portfolio)
+
+			var universeErr *AssetUniverseError
+			if tt.expectRejected != errors.As(err, &universeErr) {
+				t.Fatalf("expected rejected=%v, got %v", tt.expectRejected, err)
+			}
+			if !tt.expectRejected && err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+
+			if !reflect.DeepEqual(result.Unknown, tt.expectedUnknown) {
+				t.Errorf("expected unknown %v, got %v", tt.expectedUnknown, result.Unknown)
+			}
+			if !reflect.DeepEqual(result.Missing, tt.expectedMissing) {
+				t.Errorf("expected missing %v, got %v", tt.expectedMissing, result.Missing)
+			}
+			if tt.expectedAllocation != nil && !reflect.DeepEqual(result.Allocation, tt.expectedAllocation) {
+				t.Errorf("expected allocation %v, got %v", tt.expectedAllocation, result.Allocation)
+			}
+		})
+	}
+}
+
+func TestValidateAllocationUnheldTarget(t *testing.T) {
+	portfolio := models.Portfolio{
+		UserID:             "user1",
+		Allocation:         map[string]float64{"stocks": 70.0, "bonds": 30.0},
+		OriginalAllocation: map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+	}
+	validator := NewAssetValidator(AssetConfig{Mode: models.AssetValidationStrict}, NewAssetService(&mockAssetRepository{}))
+
+	result, err := validator.ValidateAllocation(context.Background(), map[string]float64{"stocks": 70.0, "bonds": 30.0}, portfolio)
+	if err != nil || result.HasIssues() {
+		t.Errorf("expected a targeted asset not held yet to be left out, got %+v, %v", result, err)
+	}
+
+	_, err = validator.ValidateAllocation(context.Background(), map[string]float64{"stocks": 100.0, "gold": 0.0}, portfolio)
+	var universeErr *AssetUniverseError
+	if !errors.As(err, &universeErr) || !reflect.DeepEqual(universeErr.Missing, []string{"bonds"}) {
+		t.Errorf("expected held bonds to be missing, got %v", err)
+	}
+}
+
+func TestAssetConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		expected    AssetConfig
+		expectError bool
+	}{
+		{
+			name:     "defaults to strict",
+			expected: AssetConfig{Mode: models.AssetValidationStrict, Aliases: map[string]string{}},
+		},
+		{
+			name: "universe and aliases",
+			env: map[string]string{
+				"ASSET_VALIDATION_MODE": "alias",
+				"ASSET_UNIVERSE":        "stocks, bonds,gold",
+				"ASSET_ALIASES":         "stock=stocks, bond=bonds",
+			},
+			expected: AssetConfig{
+				Mode:    models.AssetValidationAlias,
+				Assets:  []string{"stocks", "bonds", "gold"},
+				Aliases: map[string]string{"stock": "stocks", "bond": "bonds"},
+			},
+		},
+		{
+			name:        "unknown mode",
+			env:         map[string]string{"ASSET_VALIDATION_MODE": "lenient"},
+			expectError: true,
+		},
+		{
+			name:        "malformed alias",
+			env:         map[string]string{"ASSET_ALIASES": "stock"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"ASSET_VALIDATION_MODE", "ASSET_UNIVERSE", "ASSET_ALIASES"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			config, err := AssetConfigFromEnv()
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			if !reflect.DeepEqual(config, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, config)
+			}
+		})
+	}
+}
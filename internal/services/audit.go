@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"log"
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/internal/requestinfo"
+	"portfolio-rebalancer/pkg/idgen"
+	"time"
+)
+
+type AuditService interface {
+	Record(ctx context.Context, entry models.AuditEntry)
+	ListEntries(ctx context.Context, userID string, from, to time.Time) ([]models.AuditEntry, error)
+}
+
+// AuditServiceImpl keeps the audit trail of portfolio changes and rebalance submissions
+type AuditServiceImpl struct {
+	auditRepository repository.AuditRepository
+}
+
+// NewAuditService creates a new audit service instance
+func NewAuditService(auditRepository repository.AuditRepository) AuditService {
+	return &AuditServiceImpl{
+		auditRepository: auditRepository,
+	}
+}
+
+// Record appends entry to the audit trail, filling in who made the change and the request it
+// was made in from ctx. entry.Actor is kept for changes made without a caller, e.g. by the
+// scheduler. The change being audited has already been stored, so a failed write is logged
+// rather than returned; failing the request would only invite the caller to repeat it.
+func (s *AuditServiceImpl) Record(ctx context.Context, entry models.AuditEntry) {
+	entry.ID = idgen.New()
+	entry.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+
+	if key, ok := auth.APIKeyFromContext(ctx); ok {
+		entry.Actor = key.Name
+		entry.Client = "api-key:" + key.ID
+	} else if user, ok := auth.UserFromContext(ctx); ok {
+		entry.Actor = user.ID
+		entry.Client = "token"
+	}
+	if entry.Actor == "" {
+		entry.Actor = models.ActorSystem
+	}
+
+	if info, ok := requestinfo.FromContext(ctx); ok {
+		entry.Method = info.Method
+		entry.Route = info.Route
+		entry.RequestID = info.ID
+	}
+
+	if err := s.auditRepository.Append(ctx, entry); err != nil {
+		log.Printf("Failed to audit %s of user %s by %s (request %s): %v", entry.Action, entry.UserID, entry.Actor, entry.RequestID, err)
+	}
+}
+
+// ListEntries lists a user's audit entries between from and to, newest first
+func (s *AuditServiceImpl) ListEntries(ctx context.Context, userID string, from, to time.Time) ([]models.AuditEntry, error) {
+	if userID == "" {
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+
+	entries, err := s.auditRepository.ListByUserID(ctx, userID, from, to)
+	if err != nil {
+		return nil, storageError("failed to list audit entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// snapshot copies a portfolio for an audit entry, so later changes to its maps do not leak in
+func snapshot(p models.Portfolio) *models.Portfolio {
+	p.Allocation = copyAllocation(p.Allocation)
+	p.OriginalAllocation = copyAllocation(p.OriginalAllocation)
+	return &p
+}
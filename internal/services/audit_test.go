@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"portfolio-rebalancer/internal/auth"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/requestinfo"
+	"portfolio-rebalancer/pkg/fx"
+)
+
+// Mock audit repository, keeping appended entries in memory
+type mockAuditRepository struct {
+	entries   []models.AuditEntry
+	appendErr error
+}
+
+func (m *mockAuditRepository) Append(ctx context.Context, entry models.AuditEntry) error {
+	if m.appendErr != nil {
+		return m.appendErr
+	}
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *mockAuditRepository) ListByUserID(ctx context.Context, userID string, from, to time.Time) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	for _, entry := range m.entries {
+		if entry.UserID == userID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func TestRecordAuditEntry(t *testing.T) {
+	request := requestinfo.WithInfo(context.Background(), requestinfo.Info{ID: "req-1", Method: "POST", Route: "/portfolio"})
+
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		actor          string
+		expectedActor  string
+		expectedClient string
+		expectedReqID  string
+	}{
+		{
+			name:           "api key",
+			ctx:            auth.WithAPIKey(request, &models.APIKey{ID: "k1", Name: "provider"}),
+			actor:          models.ActorProvider,
+			expectedActor:  "provider",
+			expectedClient: "api-key:k1",
+			expectedReqID:  "req-1",
+		},
+		{
+			name:           "end user",
+			ctx:            auth.WithUser(request, &auth.User{ID: "1"}),
+			expectedActor:  "1",
+			expectedClient: "token",
+			expectedReqID:  "req-1",
+		},
+		{
+			name:          "unauthenticated request keeps the actor",
+			ctx:           request,
+			actor:         models.ActorUser,
+			expectedActor: models.ActorUser,
+			expectedReqID: "req-1",
+		},
+		{
+			name:          "background job",
+			ctx:           context.Background(),
+			expectedActor: models.ActorSystem,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockAuditRepository{}
+			service := NewAuditService(repo)
+
+			service.Record(tt.ctx, models.AuditEntry{UserID: "1", Action: models.AuditPortfolioUpdate, Actor: tt.actor})
+
+			if len(repo.entries) != 1 {
+				t.Fatalf("expected 1 entry, got %d", len(repo.entries))
+			}
+			entry := repo.entries[0]
+			if entry.ID == "" || entry.Timestamp == "" {
+				t.Errorf("expected an ID and timestamp, got %+v", entry)
+			}
+			if entry.Actor != tt.expectedActor {
+				t.Errorf("expected actor %q, got %q", tt.expectedActor, entry.Actor)
+			}
+			if entry.Client != tt.expectedClient {
+				t.Errorf("expected client %q, got %q", tt.expectedClient, entry.Client)
+			}
+			if entry.RequestID != tt.expectedReqID {
+				t.Errorf("expected request ID %q, got %q", tt.expectedReqID, entry.RequestID)
+			}
+			if tt.expectedReqID != "" && entry.Route != "/portfolio" {
+				t.Errorf("expected route /portfolio, got %q", entry.Route)
+			}
+		})
+	}
+}
+
+func TestRecordAuditEntryFailureIsNotFatal(t *testing.T) {
+	service := NewAuditService(&mockAuditRepository{appendErr: errors.New("es down")})
+
+	// The change is already stored, so a failed write is only logged
+	service.Record(context.Background(), models.AuditEntry{UserID: "1", Action: models.AuditPortfolioCreate})
+}
+
+func TestListAuditEntries(t *testing.T) {
+	repo := &mockAuditRepository{entries: []models.AuditEntry{
+		{ID: "a1", UserID: "1"},
+		{ID: "a2", UserID: "2"},
+	}}
+	service := NewAuditService(repo)
+
+	if _, err := service.ListEntries(context.Background(), "", time.Time{}, time.Time{}); err == nil {
+		t.Errorf("expected an error for a missing user_id")
+	}
+
+	entries, err := service.ListEntries(context.Background(), "1", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != "a1" {
+		t.Errorf("expected only user 1's entry, got %+v", entries)
+	}
+}
+
+func TestPortfolioChangesAreAudited(t *testing.T) {
+	stored := models.Portfolio{
+		UserID:             "1",
+		Allocation:         map[string]float64{"stocks": 60, "bonds": 40},
+		OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40},
+	}
+	portfolioRepo := &mockPortfolioRepository{
+		saveFunc: func(ctx context.Context, portfolio models.Portfolio) error {
+			stored = portfolio
+			return nil
+		},
+		getByUserIDFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+			p := stored
+			return &p, nil
+		},
+	}
+	auditRepo := &mockAuditRepository{}
+	service := NewPortfolioService(portfolioRepo, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), NewAuditService(auditRepo))
+	ctx := context.Background()
+
+	if _, err := service.CreatePortfolio(ctx, stored); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	update := stored
+	update.Allocation = map[string]float64{"stocks": 70, "bonds": 30}
+	if err := service.UpdatePortfolio(ctx, update); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := service.SetTargetAllocation(ctx, stored.UserID, map[string]float64{"stocks": 50, "bonds": 50}, models.AllocationSourceUser); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(auditRepo.entries) != 3 {
+		t.Fatalf("expected 3 audit entries, got %d", len(auditRepo.entries))
+	}
+
+	created := auditRepo.entries[0]
+	if created.Action != models.AuditPortfolioCreate || created.Before != nil || created.After == nil {
+		t.Errorf("expected a create with only an after snapshot, got %+v", created)
+	}
+
+	updated := auditRepo.entries[1]
+	if updated.Action != models.AuditPortfolioUpdate {
+		t.Errorf("expected %s, got %s", models.AuditPortfolioUpdate, updated.Action)
+	}
+	if updated.Before == nil || updated.Before.Allocation["stocks"] != 60 || updated.After.Allocation["stocks"] != 70 {
+		t.Errorf("expected stocks to move from 60 to 70, got before %+v after %+v", updated.Before, updated.After)
+	}
+
+	target := auditRepo.entries[2]
+	if target.Before.OriginalAllocation["stocks"] != 60 || target.After.OriginalAllocation["stocks"] != 50 {
+		t.Errorf("expected the stocks target to move from 60 to 50, got before %+v after %+v", target.Before, target.After)
+	}
+}
+
+func TestSubmitRebalanceIsAudited(t *testing.T) {
+	tests := []struct {
+		name            string
+		requireApproval bool
+		expectedStatus  string
+	}{
+		{name: "published", expectedStatus: models.RebalanceStatusApproved},
+		{name: "awaiting approval", requireApproval: true, expectedStatus: models.RebalanceStatusProposed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auditRepo := &mockAuditRepository{}
+			service := NewRebalanceService(&mockTransactionRepository{}, &mockRebalanceRepository{}, &mockPublisher{}, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, NewAuditService(auditRepo))
+
+			portfolio := models.Portfolio{
+				UserID:          "1",
+				Allocation:      map[string]float64{"stocks": 70, "bonds": 30},
+				RequireApproval: tt.requireApproval,
+			}
+			target := map[string]float64{"stocks": 60, "bonds": 40}
+			transactions := service.CalculateRebalance(portfolio.Allocation, target, portfolio.UserID)
+
+			rebalance, err := service.SubmitRebalance(context.Background(), portfolio, transactions, target, models.ActorProvider)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rebalance.Status != tt.expectedStatus {
+				t.Errorf("expected status %s, got %s", tt.expectedStatus, rebalance.Status)
+			}
+
+			if len(auditRepo.entries) != 1 {
+				t.Fatalf("expected 1 audit entry, got %d", len(auditRepo.entries))
+			}
+			entry := auditRepo.entries[0]
+			if entry.Action != models.AuditRebalanceSubmit || entry.RebalanceID != rebalance.ID || entry.Actor != models.ActorProvider {
+				t.Errorf("unexpected audit entry %+v", entry)
+			}
+			if entry.Before.Allocation["stocks"] != 70 || entry.After.Allocation["stocks"] != 60 {
+				t.Errorf("expected stocks to move from 70 to 60, got before %+v after %+v", entry.Before, entry.After)
+			}
+		})
+	}
+}
+
+func TestRebalanceDecisionsAreAudited(t *testing.T) {
+	tests := []struct {
+		name           string
+		decide         string
+		publishErr     error
+		expectedAction string
+	}{
+		{name: "approved", decide: "approve", expectedAction: models.AuditRebalanceApprove},
+		{name: "rejected", decide: "reject", expectedAction: models.AuditRebalanceReject},
+		{name: "publish failure", decide: "approve", publishErr: errors.New("kafka down")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := &mockPublisher{
+				publishFunc: func(ctx context.Context, message []byte) error {
+					return tt.publishErr
+				},
+			}
+			auditRepo := &mockAuditRepository{}
+			service := NewRebalanceService(&mockTransactionRepository{}, &mockRebalanceRepository{}, publisher, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, NewAuditService(auditRepo))
+
+			portfolio := models.Portfolio{UserID: "1", Allocation: map[string]float64{"stocks": 70, "bonds": 30}, RequireApproval: true}
+			transactions := service.CalculateRebalance(portfolio.Allocation, map[string]float64{"stocks": 60, "bonds": 40}, portfolio.UserID)
+			rebalance, err := service.SubmitRebalance(context.Background(), portfolio, transactions, nil, models.ActorProvider)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			decision := models.RebalanceDecision{RebalanceID: rebalance.ID, Actor: "jane"}
+			if tt.decide == "approve" {
+				_, err = service.ApproveRebalance(context.Background(), decision)
+			} else {
+				_, err = service.RejectRebalance(context.Background(), decision)
+			}
+			if (err != nil) != (tt.publishErr != nil) {
+				t.Fatalf("expected error %v, got %v", tt.publishErr, err)
+			}
+
+			if tt.expectedAction == "" {
+				if len(auditRepo.entries) != 1 {
+					t.Errorf("expected only the submission to be audited, got %d entries", len(auditRepo.entries))
+				}
+				return
+			}
+			if len(auditRepo.entries) != 2 {
+				t.Fatalf("expected 2 audit entries, got %d", len(auditRepo.entries))
+			}
+			entry := auditRepo.entries[1]
+			if entry.Action != tt.expectedAction || entry.Actor != "jane" || entry.RebalanceID != rebalance.ID || entry.UserID != "1" {
+				t.Errorf("unexpected audit entry %+v", entry)
+			}
+		})
+	}
+}
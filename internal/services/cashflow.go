@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"portfolio-rebalancer/internal/models"
+	"strings"
+)
+
+// minCashFlowTrade skips trades too small to execute (below one cent)
+const minCashFlowTrade = 0.005
+
+// CalculateCashFlow directs a deposit into underweight assets, or takes a withdrawal
+// from overweight assets, so that drift shrinks without trading against the flow.
+// Once every asset is at its target the rest of the flow follows the target weights
+// for deposits, and the post-flow holdings for withdrawals. Constrained assets are
+// left out or kept within their bounds: deposits never buy locked or no-buy assets nor
+// lift an asset over its maximum, withdrawals never sell locked or no-sell assets nor
+// take an asset under its minimum. Their share goes to the remaining assets, and a
+// flow they cannot absorb fails with a ConstraintError.
+func (s *RebalanceServiceImpl) CalculateCashFlow(currentAllocation, targetAllocation map[string]float64, constraints map[string]models.AssetConstraint, flow models.CashFlow) (*models.CashFlowResult, error) {
+	if flow.Amount <= 0 {
+		return nil, invalid("amount", "amount must be greater than zero")
+	}
+
+	value := flow.PortfolioValue
+	var signed float64
+	switch flow.Type {
+	case models.CashFlowDeposit:
+		signed = flow.Amount
+	case models.CashFlowWithdrawal:
+		if flow.Amount > value {
+			return nil, invalid("amount", "withdrawal of %.2f exceeds portfolio value of %.2f", flow.Amount, value)
+		}
+		signed = -flow.Amount
+	default:
+		return nil, invalid("type", "type must be %s or %s", models.CashFlowDeposit, models.CashFlowWithdrawal)
+	}
+	newValue := value + signed
+
+	assets := constrainedAssets(currentAllocation, targetAllocation, nil)
+	holdings := make(map[string]float64, len(assets))
+	gaps := make(map[string]float64, len(assets))
+	room := make(map[string]float64, len(assets))
+	for _, asset := range assets {
+		holdings[asset] = currentAllocation[asset] * value / 100
+		room[asset] = cashFlowRoom(flow.Type, holdings[asset], newValue, constraints[asset])
+		// Positive gap: underweight for deposits, overweight for withdrawals
+		gap := targetAllocation[asset]*newValue/100 - holdings[asset]
+		if flow.Type == models.CashFlowWithdrawal {
+			gap = -gap
+		}
+		gaps[asset] = math.Min(math.Max(gap, 0), room[asset])
+	}
+
+	// Close the gaps first, then spread the rest
+	trades := make(map[string]float64, len(assets))
+	rest := spreadCashFlow(trades, gaps, gaps, assets, flow.Amount)
+	weights := make(map[string]float64, len(assets))
+	for _, asset := range assets {
+		if flow.Type == models.CashFlowDeposit {
+			weights[asset] = targetAllocation[asset]
+		} else {
+			// Spread over what is left so no holding goes negative
+			weights[asset] = holdings[asset] - trades[asset]
+		}
+	}
+	if rest = spreadCashFlow(trades, room, weights, assets, rest); rest >= minCashFlowTrade {
+		return nil, infeasible("%.2f of the %s cannot be traded without breaching a constraint", rest, strings.ToLower(flow.Type))
+	}
+
+	action := "BUY"
+	if flow.Type == models.CashFlowWithdrawal {
+		action = "SELL"
+	}
+
+	rebalanceID := s.nextID()
+	timestamp := s.timestamp()
+	result := &models.CashFlowResult{
+		NewAllocation: make(map[string]float64, len(assets)),
+		NewValue:      newValue,
+	}
+	for _, asset := range assets {
+		amount := trades[asset]
+		if flow.Type == models.CashFlowWithdrawal {
+			holdings[asset] -= amount
+		} else {
+			holdings[asset] += amount
+		}
+
+		if newValue > 0 {
+			result.NewAllocation[asset] = holdings[asset] / newValue * 100
+		}
+
+		if amount < minCashFlowTrade {
+			continue
+		}
+
+		var percent float64
+		if newValue > 0 {
+			percent = amount / newValue * 100
+		}
+		var rates []models.FXRate
+		if flow.FXRate != nil {
+			rates = []models.FXRate{*flow.FXRate}
+		}
+		result.Transactions = append(result.Transactions, models.RebalanceTransaction{
+			RebalanceID:      rebalanceID,
+			UserID:           flow.UserID,
+			Action:           action,
+			Asset:            asset,
+			RebalancePercent: percent,
+			Amount:           amount,
+			Currency:         flow.Currency,
+			FXRates:          rates,
+			Timestamp:        timestamp,
+		})
+	}
+
+	sortTransactions(result.Transactions)
+	return result, nil
+}
+
+// cashFlowRoom returns how much of an asset a flow of type may trade: buy for deposits, sell
+// for withdrawals, given the asset's holding and the portfolio's value after the flow
+func cashFlowRoom(flowType string, holding, newValue float64, c models.AssetConstraint) float64 {
+	if flowType == models.CashFlowDeposit {
+		switch {
+		case c.Locked || c.NoBuy:
+			return 0
+		case c.Max != nil:
+			return math.Max(*c.Max*newValue/100-holding, 0)
+		}
+		return math.Inf(1)
+	}
+
+	switch {
+	case c.Locked || c.NoSell:
+		return 0
+	case c.Min != nil:
+		return math.Max(holding-*c.Min*newValue/100, 0)
+	}
+	return holding
+}
+
+// spreadCashFlow adds amount to trades in proportion to weights, or evenly without any, never
+// past an asset's room. Assets that run out of room drop out and the rest is spread again over
+// the others. It returns what no asset had room for.
+func spreadCashFlow(trades, room, weights map[string]float64, assets []string, amount float64) float64 {
+	for amount > constraintEpsilon {
+		var free []string
+		var weight float64
+		for _, asset := range assets {
+			if room[asset]-trades[asset] > constraintEpsilon {
+				free = append(free, asset)
+				weight += weights[asset]
+			}
+		}
+		if len(free) == 0 {
+			return amount
+		}
+
+		remaining := amount
+		for _, asset := range free {
+			share := amount / float64(len(free))
+			if weight > constraintEpsilon {
+				share = amount * weights[asset] / weight
+			}
+			share = math.Min(share, room[asset]-trades[asset])
+			trades[asset] += share
+			remaining -= share
+		}
+		amount = remaining
+	}
+	return 0
+}
+
+// ConvertCashFlow expresses the flow in the given portfolio currency, recording the
+// rate used when the flow arrived in another currency. A missing rate is an FXError.
+func (s *RebalanceServiceImpl) ConvertCashFlow(ctx context.Context, flow models.CashFlow, currency string) (models.CashFlow, error) {
+	if flow.Currency == "" || flow.Currency == currency {
+		flow.Currency = currency
+		return flow, nil
+	}
+
+	rate, err := s.rates.Rate(ctx, flow.Currency, currency)
+	if err != nil {
+		return flow, &FXError{From: flow.Currency, To: currency, Err: fmt.Errorf("failed to convert %s cash flow into %s: %w", flow.Currency, currency, err)}
+	}
+
+	applied := fxRate(rate)
+	flow.Amount *= rate.Rate
+	flow.Currency = currency
+	flow.FXRate = &applied
+	return flow, nil
+}
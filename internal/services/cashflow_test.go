@@ -0,0 +1,164 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+)
+
+func TestCalculateCashFlow(t *testing.T) {
+	tests := []struct {
+		name               string
+		currentAllocation  map[string]float64
+		targetAllocation   map[string]float64
+		constraints        map[string]models.AssetConstraint
+		flow               models.CashFlow
+		expectError        bool
+		expectedAction     string
+		expectedAmounts    map[string]float64 // asset -> traded amount
+		expectedAllocation map[string]float64
+	}{
+		{
+			name:               "deposit goes to the underweight asset only",
+			currentAllocation:  map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			targetAllocation:   map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			flow:               models.CashFlow{UserID: "user1", Type: models.CashFlowDeposit, Amount: 1000, PortfolioValue: 10000},
+			expectedAction:     "BUY",
+			expectedAmounts:    map[string]float64{"bonds": 1000},
+			expectedAllocation: map[string]float64{"stocks": 63.6364, "bonds": 36.3636},
+		},
+		{
+			name:               "deposit large enough reaches the target",
+			currentAllocation:  map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			targetAllocation:   map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			flow:               models.CashFlow{UserID: "user1", Type: models.CashFlowDeposit, Amount: 5000, PortfolioValue: 10000},
+			expectedAction:     "BUY",
+			expectedAmounts:    map[string]float64{"stocks": 2000, "bonds": 3000},
+			expectedAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		},
+		{
+			name:               "first deposit follows the target",
+			currentAllocation:  map[string]float64{},
+			targetAllocation:   map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			flow:               models.CashFlow{UserID: "user1", Type: models.CashFlowDeposit, Amount: 1000},
+			expectedAction:     "BUY",
+			expectedAmounts:    map[string]float64{"stocks": 600, "bonds": 400},
+			expectedAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		},
+		{
+			name:               "withdrawal comes from the overweight asset only",
+			currentAllocation:  map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			targetAllocation:   map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			flow:               models.CashFlow{UserID: "user1", Type: models.CashFlowWithdrawal, Amount: 1000, PortfolioValue: 10000},
+			expectedAction:     "SELL",
+			expectedAmounts:    map[string]float64{"stocks": 1000},
+			expectedAllocation: map[string]float64{"stocks": 66.6667, "bonds": 33.3333},
+		},
+		{
+			name:               "deposit skips a no-buy asset",
+			currentAllocation:  map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			targetAllocation:   map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			constraints:        map[string]models.AssetConstraint{"bonds": {NoBuy: true}},
+			flow:               models.CashFlow{UserID: "user1", Type: models.CashFlowDeposit, Amount: 1000, PortfolioValue: 10000},
+			expectedAction:     "BUY",
+			expectedAmounts:    map[string]float64{"stocks": 1000},
+			expectedAllocation: map[string]float64{"stocks": 72.7273, "bonds": 27.2727},
+		},
+		{
+			name:               "deposit stops at an asset's maximum",
+			currentAllocation:  map[string]float64{"stocks": 50.0, "bonds": 30.0, "gold": 20.0},
+			targetAllocation:   map[string]float64{"stocks": 40.0, "bonds": 40.0, "gold": 20.0},
+			constraints:        map[string]models.AssetConstraint{"bonds": {Max: percent(32.0)}},
+			flow:               models.CashFlow{UserID: "user1", Type: models.CashFlowDeposit, Amount: 2000, PortfolioValue: 10000},
+			expectedAction:     "BUY",
+			expectedAmounts:    map[string]float64{"stocks": 506.67, "bonds": 840, "gold": 653.33},
+			expectedAllocation: map[string]float64{"stocks": 45.8889, "bonds": 32.0, "gold": 22.1111},
+		},
+		{
+			name:               "withdrawal skips a locked asset",
+			currentAllocation:  map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			targetAllocation:   map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			constraints:        map[string]models.AssetConstraint{"stocks": {Locked: true}},
+			flow:               models.CashFlow{UserID: "user1", Type: models.CashFlowWithdrawal, Amount: 1000, PortfolioValue: 10000},
+			expectedAction:     "SELL",
+			expectedAmounts:    map[string]float64{"bonds": 1000},
+			expectedAllocation: map[string]float64{"stocks": 77.7778, "bonds": 22.2222},
+		},
+		{
+			name:               "withdrawal stops at an asset's minimum",
+			currentAllocation:  map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			targetAllocation:   map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			constraints:        map[string]models.AssetConstraint{"stocks": {Min: percent(68.0)}},
+			flow:               models.CashFlow{UserID: "user1", Type: models.CashFlowWithdrawal, Amount: 1000, PortfolioValue: 10000},
+			expectedAction:     "SELL",
+			expectedAmounts:    map[string]float64{"stocks": 880, "bonds": 120},
+			expectedAllocation: map[string]float64{"stocks": 68.0, "bonds": 32.0},
+		},
+		{
+			name:              "deposit no asset may take",
+			currentAllocation: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			constraints:       map[string]models.AssetConstraint{"stocks": {NoBuy: true}, "bonds": {Locked: true}},
+			flow:              models.CashFlow{UserID: "user1", Type: models.CashFlowDeposit, Amount: 1000, PortfolioValue: 10000},
+			expectError:       true,
+		},
+		{
+			name:              "withdrawal above portfolio value",
+			currentAllocation: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			flow:              models.CashFlow{UserID: "user1", Type: models.CashFlowWithdrawal, Amount: 20000, PortfolioValue: 10000},
+			expectError:       true,
+		},
+		{
+			name:              "zero amount",
+			currentAllocation: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			flow:              models.CashFlow{UserID: "user1", Type: models.CashFlowDeposit, PortfolioValue: 10000},
+			expectError:       true,
+		},
+		{
+			name:              "unknown type",
+			currentAllocation: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			flow:              models.CashFlow{UserID: "user1", Type: "TRANSFER", Amount: 1000, PortfolioValue: 10000},
+			expectError:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &RebalanceServiceImpl{}
+
+			result, err := service.CalculateCashFlow(tt.currentAllocation, tt.targetAllocation, tt.constraints, tt.flow)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+
+			if len(result.Transactions) != len(tt.expectedAmounts) {
+				t.Fatalf("expected %d transactions, got %d", len(tt.expectedAmounts), len(result.Transactions))
+			}
+			for _, tx := range result.Transactions {
+				if tx.Action != tt.expectedAction {
+					t.Errorf("expected %s for %s, got %s", tt.expectedAction, tx.Asset, tx.Action)
+				}
+				if math.Abs(tx.Amount-tt.expectedAmounts[tx.Asset]) > 0.01 {
+					t.Errorf("expected %s amount %.2f, got %.2f", tx.Asset, tt.expectedAmounts[tx.Asset], tx.Amount)
+				}
+			}
+
+			for asset, expected := range tt.expectedAllocation {
+				if math.Abs(result.NewAllocation[asset]-expected) > 0.0001 {
+					t.Errorf("expected %s=%.4f, got %.4f", asset, expected, result.NewAllocation[asset])
+				}
+			}
+		})
+	}
+}
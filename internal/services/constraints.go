@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"portfolio-rebalancer/internal/models"
+	"sort"
+)
+
+// ErrInfeasibleConstraints is matched by every ConstraintError, returned when no allocation
+// summing to 100% satisfies the constraints
+var ErrInfeasibleConstraints = errors.New("constraints cannot be satisfied")
+
+// constraintEpsilon absorbs floating point noise when comparing against bounds
+const constraintEpsilon = 1e-9
+
+// assetBounds is the feasible range of one asset after applying its constraint
+type assetBounds struct {
+	lo, hi float64
+}
+
+// ApplyConstraints returns the allocation closest to the target that respects the
+// constraints. Assets are clamped into their bounds and the excess or shortfall is
+// redistributed across the remaining assets in proportion to their target weights.
+// Locked, no-buy and no-sell constraints are evaluated against the current allocation.
+func (s *RebalanceServiceImpl) ApplyConstraints(currentAllocation, targetAllocation map[string]float64, constraints map[string]models.AssetConstraint) (*models.ConstraintResult, error) {
+	assets := constrainedAssets(currentAllocation, targetAllocation, constraints)
+
+	bounds := make(map[string]assetBounds, len(assets))
+	var sumLo, sumHi float64
+	for _, asset := range assets {
+		b, err := boundsFor(asset, currentAllocation[asset], constraints[asset])
+		if err != nil {
+			return nil, err
+		}
+		bounds[asset] = b
+		sumLo += b.lo
+		sumHi += b.hi
+	}
+
+	if sumLo > 100+0.01 {
+		return nil, infeasible("floors and held positions add up to %.2f%%", sumLo)
+	}
+	if sumHi < 100-0.01 {
+		return nil, infeasible("ceilings and buy restrictions allow at most %.2f%%", sumHi)
+	}
+
+	result := &models.ConstraintResult{Target: make(map[string]float64, len(assets))}
+
+	// Clamp the requested target into each asset's bounds
+	pinned := make(map[string]bool, len(assets))
+	var total float64
+	for _, asset := range assets {
+		target := targetAllocation[asset]
+		b := bounds[asset]
+
+		switch {
+		case target < b.lo-constraintEpsilon:
+			result.Violations = append(result.Violations, fmt.Sprintf("%s target %.2f%% is below its minimum of %.2f%%", asset, target, b.lo))
+			target = b.lo
+			pinned[asset] = true
+		case target > b.hi+constraintEpsilon:
+			result.Violations = append(result.Violations, fmt.Sprintf("%s target %.2f%% is above its maximum of %.2f%%", asset, target, b.hi))
+			target = b.hi
+			pinned[asset] = true
+		}
+
+		result.Target[asset] = target
+		total += target
+	}
+
+	if err := redistribute(result.Target, targetAllocation, bounds, pinned, assets, 100-total); err != nil {
+		return nil, err
+	}
+
+	result.Adjusted = len(result.Violations) > 0
+	return result, nil
+}
+
+// redistribute spreads the residual over the assets that are not pinned, proportionally
+// to their requested targets. Assets that hit a bound are pinned and the loop repeats
+// with whatever residual is left.
+func redistribute(allocation, targetAllocation map[string]float64, bounds map[string]assetBounds, pinned map[string]bool, assets []string, residual float64) error {
+	for math.Abs(residual) > constraintEpsilon {
+		var free []string
+		var weight float64
+		for _, asset := range assets {
+			if pinned[asset] {
+				continue
+			}
+			b := bounds[asset]
+			if (residual > 0 && allocation[asset] >= b.hi-constraintEpsilon) || (residual < 0 && allocation[asset] <= b.lo+constraintEpsilon) {
+				continue
+			}
+			free = append(free, asset)
+			weight += targetAllocation[asset]
+		}
+
+		if len(free) == 0 {
+			return infeasible("%.2f%% cannot be redistributed", math.Abs(residual))
+		}
+
+		remaining := residual
+		for _, asset := range free {
+			share := residual / float64(len(free))
+			if weight > constraintEpsilon {
+				share = residual * targetAllocation[asset] / weight
+			}
+
+			b := bounds[asset]
+			next := math.Min(math.Max(allocation[asset]+share, b.lo), b.hi)
+			if (residual > 0 && next >= b.hi) || (residual < 0 && next <= b.lo) {
+				pinned[asset] = true
+			}
+			remaining -= next - allocation[asset]
+			allocation[asset] = next
+		}
+
+		residual = remaining
+	}
+
+	return nil
+}
+
+func boundsFor(asset string, current float64, c models.AssetConstraint) (assetBounds, error) {
+	b := assetBounds{lo: 0, hi: 100}
+	if c.Min != nil {
+		b.lo = math.Max(b.lo, *c.Min)
+	}
+	if c.Max != nil {
+		b.hi = math.Min(b.hi, *c.Max)
+	}
+	if c.Locked {
+		b.lo, b.hi = math.Max(b.lo, current), math.Min(b.hi, current)
+	}
+	if c.NoSell {
+		b.lo = math.Max(b.lo, current)
+	}
+	if c.NoBuy {
+		b.hi = math.Min(b.hi, current)
+	}
+
+	if b.lo > b.hi+constraintEpsilon {
+		return b, infeasible("%s must stay between %.2f%% and %.2f%% but currently holds %.2f%%",
+			asset, b.lo, b.hi, current)
+	}
+
+	return b, nil
+}
+
+// constrainedAssets returns every asset in the current or target allocation, sorted
+// so that redistribution is deterministic
+func constrainedAssets(currentAllocation, targetAllocation map[string]float64, constraints map[string]models.AssetConstraint) []string {
+	seen := make(map[string]bool)
+	var assets []string
+	for _, allocation := range []map[string]float64{currentAllocation, targetAllocation} {
+		for asset := range allocation {
+			if !seen[asset] {
+				seen[asset] = true
+				assets = append(assets, asset)
+			}
+		}
+	}
+	for asset := range constraints {
+		if !seen[asset] {
+			seen[asset] = true
+			assets = append(assets, asset)
+		}
+	}
+
+	sort.Strings(assets)
+	return assets
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+)
+
+func percent(v float64) *float64 {
+	return &v
+}
+
+func TestApplyConstraints(t *testing.T) {
+	tests := []struct {
+		name              string
+		currentAllocation map[string]float64
+		targetAllocation  map[string]float64
+		constraints       map[string]models.AssetConstraint
+		expectedTarget    map[string]float64
+		expectedAdjusted  bool
+		expectInfeasible  bool
+	}{
+		{
+			name:              "non-binding constraints keep the target",
+			currentAllocation: map[string]float64{"stocks": 70.0, "bonds": 20.0, "gold": 10.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+			constraints: map[string]models.AssetConstraint{
+				"stocks": {Max: percent(80.0)},
+			},
+			expectedTarget:   map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+			expectedAdjusted: false,
+		},
+		{
+			name:              "ceiling excess goes proportionally to other assets",
+			currentAllocation: map[string]float64{"stocks": 70.0, "bonds": 20.0, "gold": 10.0},
+			targetAllocation:  map[string]float64{"stocks": 70.0, "bonds": 20.0, "gold": 10.0},
+			constraints: map[string]models.AssetConstraint{
+				"stocks": {Max: percent(60.0)},
+			},
+			expectedTarget:   map[string]float64{"stocks": 60.0, "bonds": 26.6667, "gold": 13.3333},
+			expectedAdjusted: true,
+		},
+		{
+			name:              "floor shortfall comes proportionally from other assets",
+			currentAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+			constraints: map[string]models.AssetConstraint{
+				"gold": {Min: percent(19.0)},
+			},
+			expectedTarget:   map[string]float64{"stocks": 54.0, "bonds": 27.0, "gold": 19.0},
+			expectedAdjusted: true,
+		},
+		{
+			name:              "locked employer stock is held",
+			currentAllocation: map[string]float64{"acme": 15.0, "stocks": 55.0, "bonds": 30.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			constraints: map[string]models.AssetConstraint{
+				"acme": {Locked: true},
+			},
+			expectedTarget:   map[string]float64{"acme": 15.0, "stocks": 51.0, "bonds": 34.0},
+			expectedAdjusted: true,
+		},
+		{
+			name:              "no-sell keeps an overweight position",
+			currentAllocation: map[string]float64{"stocks": 70.0, "bonds": 20.0, "gold": 10.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+			constraints: map[string]models.AssetConstraint{
+				"stocks": {NoSell: true},
+			},
+			expectedTarget:   map[string]float64{"stocks": 70.0, "bonds": 22.5, "gold": 7.5},
+			expectedAdjusted: true,
+		},
+		{
+			name:              "no-buy caps an underweight position",
+			currentAllocation: map[string]float64{"stocks": 65.0, "bonds": 30.0, "gold": 5.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+			constraints: map[string]models.AssetConstraint{
+				"gold": {NoBuy: true},
+			},
+			expectedTarget:   map[string]float64{"stocks": 63.3333, "bonds": 31.6667, "gold": 5.0},
+			expectedAdjusted: true,
+		},
+		{
+			name:              "redistribution respects other ceilings",
+			currentAllocation: map[string]float64{"stocks": 50.0, "bonds": 30.0, "gold": 20.0},
+			targetAllocation:  map[string]float64{"stocks": 50.0, "bonds": 30.0, "gold": 20.0},
+			constraints: map[string]models.AssetConstraint{
+				"stocks": {Max: percent(40.0)},
+				"gold":   {Max: percent(21.0)},
+			},
+			expectedTarget:   map[string]float64{"stocks": 40.0, "bonds": 39.0, "gold": 21.0},
+			expectedAdjusted: true,
+		},
+		{
+			name:              "floors above 100 are infeasible",
+			currentAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			constraints: map[string]models.AssetConstraint{
+				"stocks": {Min: percent(70.0)},
+				"bonds":  {Min: percent(40.0)},
+			},
+			expectInfeasible: true,
+		},
+		{
+			name:              "ceilings below 100 are infeasible",
+			currentAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			constraints: map[string]models.AssetConstraint{
+				"stocks": {Max: percent(50.0)},
+				"bonds":  {Max: percent(40.0)},
+			},
+			expectInfeasible: true,
+		},
+		{
+			name:              "locked above its ceiling is infeasible",
+			currentAllocation: map[string]float64{"acme": 30.0, "bonds": 70.0},
+			targetAllocation:  map[string]float64{"acme": 10.0, "bonds": 90.0},
+			constraints: map[string]models.AssetConstraint{
+				"acme": {Locked: true, Max: percent(20.0)},
+			},
+			expectInfeasible: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &RebalanceServiceImpl{}
+
+			result, err := service.ApplyConstraints(tt.currentAllocation, tt.targetAllocation, tt.constraints)
+
+			if tt.expectInfeasible {
+				if !errors.Is(err, ErrInfeasibleConstraints) {
+					t.Errorf("expected ErrInfeasibleConstraints, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+
+			if result.Adjusted != tt.expectedAdjusted {
+				t.Errorf("expected adjusted=%v, got %v (violations %v)", tt.expectedAdjusted, result.Adjusted, result.Violations)
+			}
+			if tt.expectedAdjusted && len(result.Violations) == 0 {
+				t.Errorf("expected violations to explain the adjustment")
+			}
+
+			var total float64
+			for asset, percent := range result.Target {
+				total += percent
+				if math.Abs(percent-tt.expectedTarget[asset]) > 0.0001 {
+					t.Errorf("expected %s=%.4f, got %.4f", asset, tt.expectedTarget[asset], percent)
+				}
+			}
+			if math.Abs(total-100.0) > 0.0001 {
+				t.Errorf("expected feasible target to sum to 100, got %.4f", total)
+			}
+		})
+	}
+}
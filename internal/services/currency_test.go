@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/pkg/fx"
+)
+
+// testRates quotes EUR and IDR against USD
+func testRates() *fx.MemoryProvider {
+	rates := fx.NewMemoryProvider("test")
+	rates.Set("USD", "EUR", 0.9, "2024-01-01T00:00:00Z")
+	rates.Set("USD", "IDR", 15000, "2024-01-02T00:00:00Z")
+	return rates
+}
+
+func TestConvertCashFlow(t *testing.T) {
+	tests := []struct {
+		name           string
+		flow           models.CashFlow
+		currency       string
+		expectError    bool
+		expectedAmount float64
+		expectedRate   float64
+	}{
+		{
+			name:           "flow without currency is in the portfolio currency",
+			flow:           models.CashFlow{Type: models.CashFlowDeposit, Amount: 1000},
+			currency:       "USD",
+			expectedAmount: 1000,
+		},
+		{
+			name:           "direct quote",
+			flow:           models.CashFlow{Type: models.CashFlowDeposit, Amount: 1000, Currency: "USD"},
+			currency:       "EUR",
+			expectedAmount: 900,
+			expectedRate:   0.9,
+		},
+		{
+			name:           "inverse quote",
+			flow:           models.CashFlow{Type: models.CashFlowDeposit, Amount: 900, Currency: "EUR"},
+			currency:       "USD",
+			expectedAmount: 1000,
+			expectedRate:   1 / 0.9,
+		},
+		{
+			name:           "cross rate through USD",
+			flow:           models.CashFlow{Type: models.CashFlowDeposit, Amount: 1500000, Currency: "IDR"},
+			currency:       "EUR",
+			expectedAmount: 90,
+			expectedRate:   0.9 / 15000,
+		},
+		{
+			name:        "unknown currency",
+			flow:        models.CashFlow{Type: models.CashFlowDeposit, Amount: 1000, Currency: "JPY"},
+			currency:    "USD",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &RebalanceServiceImpl{rates: testRates()}
+
+			flow, err := service.ConvertCashFlow(context.Background(), tt.flow, tt.currency)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+
+			if flow.Currency != tt.currency {
+				t.Errorf("expected currency %s, got %s", tt.currency, flow.Currency)
+			}
+			if math.Abs(flow.Amount-tt.expectedAmount) > 0.0001 {
+				t.Errorf("expected amount %.4f, got %.4f", tt.expectedAmount, flow.Amount)
+			}
+			if tt.expectedRate == 0 {
+				if flow.FXRate != nil {
+					t.Errorf("expected no rate to be recorded, got %+v", flow.FXRate)
+				}
+				return
+			}
+			if flow.FXRate == nil || math.Abs(flow.FXRate.Rate-tt.expectedRate) > 1e-12 {
+				t.Errorf("expected rate %v to be recorded, got %+v", tt.expectedRate, flow.FXRate)
+			}
+		})
+	}
+}
+
+func TestCashFlowTransactionsRecordRates(t *testing.T) {
+	assets := &mockAssetRepository{
+		assets: []models.Asset{
+			{ID: "us_stocks", Name: "US Equity", Class: "equity", Currency: "USD", Tradable: true},
+			{ID: "eu_stocks", Name: "EU Equity", Class: "equity", Currency: "EUR", Tradable: true},
+			{ID: "id_bonds", Name: "Indonesia Govt Bonds", Class: "fixed_income", Currency: "IDR", Tradable: true},
+		},
+	}
+
+	var published []models.RebalanceTransaction
+	service := NewRebalanceService(&mockTransactionRepository{}, &mockRebalanceRepository{}, &mockPublisher{
+		publishFunc: func(ctx context.Context, message []byte) error {
+			return json.Unmarshal(message, &published)
+		},
+	}, NewAssetService(assets), testRates(), nil, nil)
+
+	// A EUR deposit into a USD portfolio split across three currencies
+	flow, err := service.ConvertCashFlow(context.Background(), models.CashFlow{
+		UserID: "user1", Type: models.CashFlowDeposit, Amount: 900, Currency: "EUR",
+	}, "USD")
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	result, err := service.CalculateCashFlow(nil, map[string]float64{"us_stocks": 50.0, "eu_stocks": 30.0, "id_bonds": 20.0}, nil, flow)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if err := service.PublishRebalanceTransactions(context.Background(), result.Transactions); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	expected := map[string]struct {
+		currency    string
+		assetAmount float64
+		rates       int
+	}{
+		"us_stocks": {"USD", 500, 1},
+		"eu_stocks": {"EUR", 270, 2},
+		"id_bonds":  {"IDR", 3000000, 2},
+	}
+	if len(published) != len(expected) {
+		t.Fatalf("expected %d transactions, got %d", len(expected), len(published))
+	}
+	for _, tx := range published {
+		want := expected[tx.Asset]
+		if tx.Currency != "USD" || tx.AssetCurrency != want.currency {
+			t.Errorf("%s: expected USD amount traded in %s, got %s in %s", tx.Asset, want.currency, tx.Currency, tx.AssetCurrency)
+		}
+		if math.Abs(tx.AssetAmount-want.assetAmount) > 0.0001 {
+			t.Errorf("%s: expected asset amount %.4f, got %.4f", tx.Asset, want.assetAmount, tx.AssetAmount)
+		}
+		if len(tx.FXRates) != want.rates || tx.FXRates[0].From != "EUR" || tx.FXRates[0].To != "USD" {
+			t.Errorf("%s: expected %d rates starting with the EUR deposit, got %+v", tx.Asset, want.rates, tx.FXRates)
+		}
+	}
+}
+
+func TestCreatePortfolioCurrency(t *testing.T) {
+	service := NewPortfolioService(&mockPortfolioRepository{}, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
+
+	result, err := service.CreatePortfolio(context.Background(), models.Portfolio{
+		UserID:     "user1",
+		Allocation: map[string]float64{"stocks": 100.0},
+	})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if result.Currency != models.DefaultCurrency {
+		t.Errorf("expected default currency %s, got %s", models.DefaultCurrency, result.Currency)
+	}
+
+	if _, err := service.CreatePortfolio(context.Background(), models.Portfolio{
+		UserID:     "user1",
+		Allocation: map[string]float64{"stocks": 100.0},
+		Currency:   "Rupiah",
+	}); err == nil || err.Error() != "currency must be a 3-letter ISO 4217 code, got Rupiah" {
+		t.Errorf("expected invalid currency error, got %v", err)
+	}
+}
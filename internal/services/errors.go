@@ -0,0 +1,143 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"portfolio-rebalancer/internal/repository"
+)
+
+// Dependencies reported by DependencyUnavailableError
+const (
+	DependencyElasticsearch = "Elasticsearch"
+	DependencyKafka         = "Kafka"
+)
+
+// FieldError describes a problem with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when the input is missing or malformed. Fields lists the
+// offending fields when they are known.
+type ValidationError struct {
+	Message string
+	Fields  []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// NotFoundError is returned when the requested resource does not exist. A NotFoundError
+// without an ID matches every missing resource of its kind with errors.Is.
+type NotFoundError struct {
+	Resource string
+	ID       string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.ID == "" {
+		return e.Resource + " not found"
+	}
+	return e.Resource + " not found: " + e.ID
+}
+
+// Is reports whether target is a NotFoundError for the same kind of resource
+func (e *NotFoundError) Is(target error) bool {
+	t, ok := target.(*NotFoundError)
+	return ok && t.Resource == e.Resource && (t.ID == "" || t.ID == e.ID)
+}
+
+// ConflictError is returned when a change clashes with the current state of a resource
+type ConflictError struct {
+	Err error
+}
+
+func (e *ConflictError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// ConstraintError is returned when a portfolio's constraints leave no allocation, or no way to
+// trade a cash flow, that satisfies them. It matches ErrInfeasibleConstraints with errors.Is.
+type ConstraintError struct {
+	Err error
+}
+
+func (e *ConstraintError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// FXError is returned when an amount cannot be converted because no rate from From to To is known
+type FXError struct {
+	From string
+	To   string
+	Err  error
+}
+
+func (e *FXError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FXError) Unwrap() error {
+	return e.Err
+}
+
+// DependencyUnavailableError is returned when a backing service failed to answer, so
+// the same request may succeed once it recovers
+type DependencyUnavailableError struct {
+	Dependency string
+	Err        error
+}
+
+func (e *DependencyUnavailableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DependencyUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// invalid returns a ValidationError for a single field
+func invalid(field, format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...)
+	return &ValidationError{
+		Message: message,
+		Fields:  []FieldError{{Field: field, Message: message}},
+	}
+}
+
+// invalidField reports a failed check of field, e.g. from the models package, as a ValidationError
+func invalidField(field string, err error) error {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return err
+	}
+	return invalid(field, "%s", err.Error())
+}
+
+// storageError reports a failed Elasticsearch call, formatted like fmt.Errorf
+func storageError(format string, args ...interface{}) error {
+	return &DependencyUnavailableError{Dependency: DependencyElasticsearch, Err: fmt.Errorf(format, args...)}
+}
+
+// infeasible returns a ConstraintError wrapping ErrInfeasibleConstraints, formatted like fmt.Errorf
+func infeasible(format string, args ...interface{}) error {
+	return &ConstraintError{Err: fmt.Errorf("%w: "+format, append([]interface{}{ErrInfeasibleConstraints}, args...)...)}
+}
+
+// lookupError tells a missing resource from a store that could not be read
+func lookupError(resource, id string, err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return &NotFoundError{Resource: resource, ID: id}
+	}
+	return storageError("failed to get %s %s: %w", resource, id, err)
+}
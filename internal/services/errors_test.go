@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/pkg/fx"
+)
+
+func TestLookupError(t *testing.T) {
+	var notFoundErr *NotFoundError
+	err := lookupError("rebalance", "r1", repository.ErrNotFound)
+	if !errors.As(err, &notFoundErr) || notFoundErr.ID != "r1" {
+		t.Errorf("expected a NotFoundError for r1, got %v", err)
+	}
+	if !errors.Is(err, ErrRebalanceNotFound) {
+		t.Errorf("expected %v to match ErrRebalanceNotFound", err)
+	}
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("expected %v not to match ErrAPIKeyNotFound", err)
+	}
+
+	var unavailableErr *DependencyUnavailableError
+	cause := errors.New("connection refused")
+	err = lookupError("rebalance", "r1", cause)
+	if !errors.As(err, &unavailableErr) || unavailableErr.Dependency != DependencyElasticsearch {
+		t.Errorf("expected Elasticsearch to be unavailable, got %v", err)
+	}
+	if !errors.Is(err, cause) || errors.Is(err, ErrRebalanceNotFound) {
+		t.Errorf("expected %v to wrap only the cause", err)
+	}
+}
+
+func TestServiceErrorsAreTyped(t *testing.T) {
+	esDown := errors.New("es down")
+	ctx := context.Background()
+
+	t.Run("validation error names the field", func(t *testing.T) {
+		service := NewPortfolioService(&mockPortfolioRepository{}, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
+
+		_, err := service.CreatePortfolio(ctx, models.Portfolio{UserID: "1", Allocation: map[string]float64{"stocks": 60}})
+
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("expected a ValidationError, got %v", err)
+		}
+		expected := []FieldError{{Field: "allocation", Message: validationErr.Message}}
+		if !reflect.DeepEqual(validationErr.Fields, expected) {
+			t.Errorf("expected fields %+v, got %+v", expected, validationErr.Fields)
+		}
+	})
+
+	t.Run("missing portfolio", func(t *testing.T) {
+		service := NewPortfolioService(&mockPortfolioRepository{}, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
+
+		_, err := service.GetPortfolio(ctx, "1")
+
+		var notFoundErr *NotFoundError
+		if !errors.As(err, &notFoundErr) || notFoundErr.Resource != "portfolio" {
+			t.Errorf("expected a missing portfolio, got %v", err)
+		}
+	})
+
+	t.Run("store failure", func(t *testing.T) {
+		repo := &mockPortfolioRepository{
+			saveFunc: func(ctx context.Context, portfolio models.Portfolio) error {
+				return esDown
+			},
+		}
+		service := NewPortfolioService(repo, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
+
+		_, err := service.CreatePortfolio(ctx, models.Portfolio{UserID: "1", Allocation: map[string]float64{"stocks": 100}})
+
+		var unavailableErr *DependencyUnavailableError
+		if !errors.As(err, &unavailableErr) || unavailableErr.Dependency != DependencyElasticsearch || !errors.Is(err, esDown) {
+			t.Errorf("expected Elasticsearch to be unavailable, got %v", err)
+		}
+	})
+
+	t.Run("publish failure", func(t *testing.T) {
+		publisher := &mockPublisher{
+			publishFunc: func(ctx context.Context, message []byte) error {
+				return errors.New("broker down")
+			},
+		}
+		service := NewRebalanceService(&mockTransactionRepository{}, &mockRebalanceRepository{}, publisher, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
+		transactions := service.CalculateRebalance(map[string]float64{"stocks": 70, "bonds": 30}, map[string]float64{"stocks": 60, "bonds": 40}, "1")
+
+		_, err := service.SubmitRebalance(ctx, models.Portfolio{UserID: "1"}, transactions, map[string]float64{"stocks": 60, "bonds": 40}, models.ActorProvider)
+
+		var unavailableErr *DependencyUnavailableError
+		if !errors.As(err, &unavailableErr) || unavailableErr.Dependency != DependencyKafka {
+			t.Errorf("expected Kafka to be unavailable, got %v", err)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		var published [][]byte
+		modelRepo := &mockModelPortfolioRepository{
+			getFunc: func(ctx context.Context, id string) (*models.ModelPortfolio, error) {
+				return &models.ModelPortfolio{ID: id, Name: "Balanced", Allocation: map[string]float64{"stocks": 60, "bonds": 40}}, nil
+			},
+		}
+		service := newTestModelService(modelRepo, []models.Portfolio{{UserID: "1", ModelID: "balanced"}}, &published)
+
+		err := service.DeleteModel(ctx, "balanced")
+
+		var conflictErr *ConflictError
+		if !errors.As(err, &conflictErr) || !errors.Is(err, ErrModelInUse) {
+			t.Errorf("expected a conflict with ErrModelInUse, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"portfolio-rebalancer/internal/execution"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/pkg/idgen"
+	"time"
+)
+
+// executionEpsilon absorbs floating point noise in corrected allocations
+const executionEpsilon = 1e-9
+
+type ExecutionService interface {
+	ExecuteRebalance(ctx context.Context, rebalance *models.Rebalance, transactions []models.RebalanceTransaction) []models.Execution
+	ListExecutions(ctx context.Context, rebalanceID string) ([]models.Execution, error)
+}
+
+// ExecutionServiceImpl hands approved transactions to the broker and feeds the fills
+// back into the portfolio's allocation
+type ExecutionServiceImpl struct {
+	executor            execution.Executor
+	executionRepository repository.ExecutionRepository
+	portfolioService    PortfolioService
+}
+
+// NewExecutionService creates a new execution service instance
+func NewExecutionService(
+	executor execution.Executor,
+	executionRepository repository.ExecutionRepository,
+	portfolioService PortfolioService,
+) ExecutionService {
+	return &ExecutionServiceImpl{
+		executor:            executor,
+		executionRepository: executionRepository,
+		portfolioService:    portfolioService,
+	}
+}
+
+// ExecuteRebalance executes each transaction and stores the result. A broker that cannot
+// be reached counts as a rejection, so every transaction has exactly one execution.
+// When the rebalance recorded the allocation it was expected to reach, the portfolio's
+// allocation is corrected for whatever did not fill.
+func (s *ExecutionServiceImpl) ExecuteRebalance(ctx context.Context, rebalance *models.Rebalance, transactions []models.RebalanceTransaction) []models.Execution {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	portfolio, err := s.portfolioService.GetPortfolio(ctx, transactions[0].UserID)
+	if err != nil {
+		log.Printf("Executing transactions for user %s without a portfolio: %v", transactions[0].UserID, err)
+	}
+
+	executions := make([]models.Execution, 0, len(transactions))
+	for _, tx := range transactions {
+		// Percentage rebalances carry no amount; the broker needs one to size the order
+		if tx.Amount == 0 && portfolio != nil && portfolio.TotalValue > 0 {
+			tx.Amount = math.Round(portfolio.TotalValue*tx.RebalancePercent) / 100
+		}
+
+		result, err := s.executor.Execute(ctx, tx)
+		if err != nil {
+			log.Printf("Failed to execute %s %s for user %s: %v", tx.Action, tx.Asset, tx.UserID, err)
+			result = models.Execution{
+				RebalanceID:      tx.RebalanceID,
+				UserID:           tx.UserID,
+				Action:           tx.Action,
+				Asset:            tx.Asset,
+				RequestedPercent: tx.RebalancePercent,
+				Status:           models.ExecutionRejected,
+				Reason:           err.Error(),
+				ExecutedAt:       time.Now().UTC().Format(time.RFC3339),
+			}
+		}
+		result.ID = idgen.New()
+
+		if err := s.executionRepository.Save(ctx, result); err != nil {
+			log.Printf("Failed to save execution of %s %s for user %s: %v", tx.Action, tx.Asset, tx.UserID, err)
+		}
+		executions = append(executions, result)
+	}
+
+	// The portfolio may have changed while the broker worked, so only its allocation is written
+	if rebalance != nil && len(rebalance.ExpectedAllocation) > 0 && portfolio != nil {
+		actual := actualAllocation(rebalance.ExpectedAllocation, executions)
+		if _, err := s.portfolioService.ReplaceAllocation(ctx, portfolio.UserID, actual, models.AllocationSourceExecution, rebalance.ID); err != nil {
+			log.Printf("Failed to update allocation of user %s after execution: %v", portfolio.UserID, err)
+		}
+	}
+
+	return executions
+}
+
+// ListExecutions returns the broker results of one rebalance, oldest first
+func (s *ExecutionServiceImpl) ListExecutions(ctx context.Context, rebalanceID string) ([]models.Execution, error) {
+	if rebalanceID == "" {
+		return nil, invalid("rebalance_id", "rebalance_id is required and cannot be empty")
+	}
+
+	executions, err := s.executionRepository.ListByRebalanceID(ctx, rebalanceID)
+	if err != nil {
+		return nil, storageError("failed to list executions: %w", err)
+	}
+
+	return executions, nil
+}
+
+// actualAllocation corrects the allocation a rebalance was expected to reach for the part
+// of each order that did not fill. An unfilled buy leaves its money in cash; an unfilled
+// sell keeps the asset and takes the cash it would have raised back out.
+func actualAllocation(expected map[string]float64, executions []models.Execution) map[string]float64 {
+	actual := copyAllocation(expected)
+	for _, e := range executions {
+		unfilled := e.RequestedPercent - e.FilledPercent
+		if unfilled <= 0 {
+			continue
+		}
+		switch e.Action {
+		case "BUY":
+			actual[e.Asset] -= unfilled
+			actual[models.CashAsset] += unfilled
+		case "SELL":
+			actual[e.Asset] += unfilled
+			actual[models.CashAsset] -= unfilled
+		}
+	}
+
+	// Sells that did not fill may have funded buys that did; the portfolio then holds
+	// more than 100%, so scale it back down instead of reporting negative cash
+	return normalizeAllocation(actual)
+}
+
+// normalizeAllocation drops assets at or below zero and scales the rest back to 100%
+func normalizeAllocation(allocation map[string]float64) map[string]float64 {
+	for asset, percent := range allocation {
+		if percent < executionEpsilon {
+			delete(allocation, asset)
+		}
+	}
+	total := 0.0
+	for _, percent := range allocation {
+		total += percent
+	}
+	if total > 0 && math.Abs(total-100) > executionEpsilon {
+		for asset, percent := range allocation {
+			allocation[asset] = percent * 100 / total
+		}
+	}
+
+	return allocation
+}
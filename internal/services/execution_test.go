@@ -0,0 +1,401 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/pkg/fx"
+)
+
+// Mock executor, filling each order according to fills (asset -> share filled, 1 when missing)
+type mockExecutor struct {
+	fills       map[string]float64
+	executeFunc func(ctx context.Context, tx models.RebalanceTransaction) (models.Execution, error)
+}
+
+func (m *mockExecutor) Execute(ctx context.Context, tx models.RebalanceTransaction) (models.Execution, error) {
+	if m.executeFunc != nil {
+		return m.executeFunc(ctx, tx)
+	}
+
+	ratio, ok := m.fills[tx.Asset]
+	if !ok {
+		ratio = 1
+	}
+	execution := models.Execution{
+		RebalanceID:      tx.RebalanceID,
+		UserID:           tx.UserID,
+		Action:           tx.Action,
+		Asset:            tx.Asset,
+		RequestedPercent: tx.RebalancePercent,
+		FilledPercent:    tx.RebalancePercent * ratio,
+		Quantity:         tx.Amount * ratio,
+		Status:           models.ExecutionFilled,
+	}
+	switch {
+	case ratio == 0:
+		execution.Status, execution.Reason = models.ExecutionRejected, "rejected"
+	case ratio < 1:
+		execution.Status, execution.Reason = models.ExecutionPartiallyFilled, "partial"
+	}
+	return execution, nil
+}
+
+// Mock execution repository, keeping saved executions in memory
+type mockExecutionRepository struct {
+	executions []models.Execution
+}
+
+func (m *mockExecutionRepository) Save(ctx context.Context, execution models.Execution) error {
+	m.executions = append(m.executions, execution)
+	return nil
+}
+
+func (m *mockExecutionRepository) ListByRebalanceID(ctx context.Context, rebalanceID string) ([]models.Execution, error) {
+	var executions []models.Execution
+	for _, execution := range m.executions {
+		if execution.RebalanceID == rebalanceID {
+			executions = append(executions, execution)
+		}
+	}
+	return executions, nil
+}
+
+func (m *mockExecutionRepository) ListByUserID(ctx context.Context, userID string, since time.Time) ([]models.Execution, error) {
+	var executions []models.Execution
+	for _, execution := range m.executions {
+		executedAt, _ := time.Parse(time.RFC3339, execution.ExecutedAt)
+		if execution.UserID == userID && !executedAt.Before(since) {
+			executions = append(executions, execution)
+		}
+	}
+	return executions, nil
+}
+
+func TestActualAllocation(t *testing.T) {
+	tests := []struct {
+		name       string
+		expected   map[string]float64
+		executions []models.Execution
+		want       map[string]float64
+	}{
+		{
+			name:     "everything filled",
+			expected: map[string]float64{"stocks": 60, "bonds": 40},
+			executions: []models.Execution{
+				{Action: "SELL", Asset: "stocks", RequestedPercent: 10, FilledPercent: 10},
+				{Action: "BUY", Asset: "bonds", RequestedPercent: 10, FilledPercent: 10},
+			},
+			want: map[string]float64{"stocks": 60, "bonds": 40},
+		},
+		{
+			name:     "buy half filled leaves cash",
+			expected: map[string]float64{"stocks": 60, "bonds": 40},
+			executions: []models.Execution{
+				{Action: "SELL", Asset: "stocks", RequestedPercent: 10, FilledPercent: 10},
+				{Action: "BUY", Asset: "bonds", RequestedPercent: 10, FilledPercent: 5},
+			},
+			want: map[string]float64{"stocks": 60, "bonds": 35, "cash": 5},
+		},
+		{
+			name:     "sell rejected keeps the asset",
+			expected: map[string]float64{"stocks": 60, "bonds": 40},
+			executions: []models.Execution{
+				{Action: "SELL", Asset: "stocks", RequestedPercent: 10, FilledPercent: 0},
+				{Action: "BUY", Asset: "bonds", RequestedPercent: 10, FilledPercent: 0},
+			},
+			want: map[string]float64{"stocks": 70, "bonds": 30},
+		},
+		{
+			name:     "rejected sell funding a filled buy is scaled back",
+			expected: map[string]float64{"stocks": 60, "bonds": 40},
+			executions: []models.Execution{
+				{Action: "SELL", Asset: "stocks", RequestedPercent: 10, FilledPercent: 0},
+				{Action: "BUY", Asset: "bonds", RequestedPercent: 10, FilledPercent: 10},
+			},
+			want: map[string]float64{"stocks": 70 * 100 / 110.0, "bonds": 40 * 100 / 110.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := actualAllocation(tt.expected, tt.executions)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for asset, percent := range tt.want {
+				if math.Abs(got[asset]-percent) > 1e-9 {
+					t.Errorf("expected %s at %.4f, got %.4f", asset, percent, got[asset])
+				}
+			}
+		})
+	}
+}
+
+func TestProcessTransactionsExecutes(t *testing.T) {
+	tests := []struct {
+		name               string
+		fills              map[string]float64
+		brokerErr          error
+		expectedStatus     string
+		expectedTxStatus   map[string]string
+		expectedAllocation map[string]float64
+	}{
+		{
+			name:               "filled",
+			expectedStatus:     models.RebalanceStatusExecuted,
+			expectedTxStatus:   map[string]string{"stocks": models.RebalanceStatusExecuted, "bonds": models.RebalanceStatusExecuted},
+			expectedAllocation: map[string]float64{"stocks": 60, "bonds": 40},
+		},
+		{
+			name:               "partially filled",
+			fills:              map[string]float64{"bonds": 0.5},
+			expectedStatus:     models.RebalanceStatusExecuted,
+			expectedTxStatus:   map[string]string{"stocks": models.RebalanceStatusExecuted, "bonds": models.RebalanceStatusExecuted},
+			expectedAllocation: map[string]float64{"stocks": 60, "bonds": 35, "cash": 5},
+		},
+		{
+			name:               "rejected",
+			fills:              map[string]float64{"stocks": 0, "bonds": 0},
+			expectedStatus:     models.RebalanceStatusFailed,
+			expectedTxStatus:   map[string]string{"stocks": models.RebalanceStatusFailed, "bonds": models.RebalanceStatusFailed},
+			expectedAllocation: map[string]float64{"stocks": 70, "bonds": 30},
+		},
+		{
+			name:               "broker unreachable",
+			brokerErr:          errors.New("connection refused"),
+			expectedStatus:     models.RebalanceStatusFailed,
+			expectedTxStatus:   map[string]string{"stocks": models.RebalanceStatusFailed, "bonds": models.RebalanceStatusFailed},
+			expectedAllocation: map[string]float64{"stocks": 70, "bonds": 30},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := models.Portfolio{
+				UserID:             "user1",
+				Allocation:         map[string]float64{"stocks": 70, "bonds": 30},
+				OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40},
+				TotalValue:         10000,
+			}
+			portfolioRepo := &mockPortfolioRepository{
+				getByUserIDFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+					p := stored
+					return &p, nil
+				},
+				saveFunc: func(ctx context.Context, portfolio models.Portfolio) error {
+					stored = portfolio
+					return nil
+				},
+			}
+			portfolioService := NewPortfolioService(portfolioRepo, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
+
+			executor := &mockExecutor{fills: tt.fills}
+			if tt.brokerErr != nil {
+				executor.executeFunc = func(ctx context.Context, tx models.RebalanceTransaction) (models.Execution, error) {
+					return models.Execution{}, tt.brokerErr
+				}
+			}
+			executionRepo := &mockExecutionRepository{}
+			executionService := NewExecutionService(executor, executionRepo, portfolioService)
+
+			var message []byte
+			publisher := &mockPublisher{
+				publishFunc: func(ctx context.Context, m []byte) error {
+					message = m
+					return nil
+				},
+			}
+			txStatus := make(map[string]string)
+			txRepo := &mockTransactionRepository{
+				saveFunc: func(ctx context.Context, tx models.RebalanceTransaction) error {
+					txStatus[tx.Asset] = tx.Status
+					return nil
+				},
+			}
+			service := NewRebalanceService(txRepo, &mockRebalanceRepository{}, publisher, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), executionService, nil)
+
+			transactions := service.CalculateRebalance(stored.Allocation, stored.OriginalAllocation, "user1")
+			if _, err := service.SubmitRebalance(context.Background(), stored, transactions, stored.OriginalAllocation, models.ActorProvider); err != nil {
+				t.Fatalf("unexpected submit error: %v", err)
+			}
+			if err := service.ProcessTransactions(context.Background(), message); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// A redelivered message must not reach the broker again
+			if err := service.ProcessTransactions(context.Background(), message); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(executionRepo.executions) != len(transactions) {
+				t.Fatalf("expected %d executions, got %d", len(transactions), len(executionRepo.executions))
+			}
+			for _, execution := range executionRepo.executions {
+				if execution.ID == "" {
+					t.Errorf("expected executions to be stored with an ID")
+				}
+			}
+			// Percentage legs are sized from the portfolio value: 10% of 10000
+			for _, execution := range executionRepo.executions {
+				if execution.Asset == "stocks" && execution.Status == models.ExecutionFilled && execution.Quantity != 1000 {
+					t.Errorf("expected the order to be sized from the portfolio value, got %+v", execution)
+				}
+			}
+
+			for asset, status := range tt.expectedTxStatus {
+				if txStatus[asset] != status {
+					t.Errorf("expected %s transaction saved as %s, got %s", asset, status, txStatus[asset])
+				}
+			}
+
+			rebalance, _ := service.GetRebalance(context.Background(), transactions[0].RebalanceID)
+			if rebalance.Status != tt.expectedStatus {
+				t.Errorf("expected status %s, got %s (%v)", tt.expectedStatus, rebalance.Status, statuses(*rebalance))
+			}
+
+			if len(stored.Allocation) != len(tt.expectedAllocation) {
+				t.Fatalf("expected allocation %v, got %v", tt.expectedAllocation, stored.Allocation)
+			}
+			for asset, percent := range tt.expectedAllocation {
+				if math.Abs(stored.Allocation[asset]-percent) > 1e-9 {
+					t.Errorf("expected %s at %.2f, got %.2f", asset, percent, stored.Allocation[asset])
+				}
+			}
+		})
+	}
+}
+
+func TestRebalanceAfterPartialFill(t *testing.T) {
+	stored := models.Portfolio{
+		UserID:             "user1",
+		Allocation:         map[string]float64{"stocks": 70, "bonds": 30},
+		OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40},
+		TotalValue:         10000,
+	}
+	portfolioRepo := &mockPortfolioRepository{
+		getByUserIDFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+			p := stored
+			return &p, nil
+		},
+		saveFunc: func(ctx context.Context, portfolio models.Portfolio) error {
+			stored = portfolio
+			return nil
+		},
+	}
+	portfolioService := NewPortfolioService(portfolioRepo, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
+	executionService := NewExecutionService(&mockExecutor{fills: map[string]float64{"bonds": 0.5}}, &mockExecutionRepository{}, portfolioService)
+
+	var message []byte
+	publisher := &mockPublisher{
+		publishFunc: func(ctx context.Context, m []byte) error {
+			message = m
+			return nil
+		},
+	}
+	service := NewRebalanceService(&mockTransactionRepository{}, &mockRebalanceRepository{}, publisher, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), executionService, nil)
+
+	transactions := service.CalculateRebalance(stored.Allocation, stored.OriginalAllocation, "user1")
+	if _, err := service.SubmitRebalance(context.Background(), stored, transactions, stored.OriginalAllocation, models.ActorProvider); err != nil {
+		t.Fatalf("unexpected submit error: %v", err)
+	}
+	if err := service.ProcessTransactions(context.Background(), message); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.Allocation[models.CashAsset] == 0 {
+		t.Fatalf("expected the unfilled buy to be held in cash, got %v", stored.Allocation)
+	}
+
+	// The provider does not know about the cash and reports the invested assets only
+	validator := NewAssetValidator(AssetConfig{Mode: models.AssetValidationStrict}, NewAssetService(&mockAssetRepository{}))
+	portfolio, err := portfolioService.GetPortfolio(context.Background(), "user1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := validator.ValidateAllocation(context.Background(), map[string]float64{"stocks": 65, "bonds": 35}, *portfolio); err != nil {
+		t.Errorf("expected the next report to be accepted, got %v", err)
+	}
+	if _, err := validator.ValidateAllocation(context.Background(), map[string]float64{"stocks": 60, "bonds": 35, "cash": 5}, *portfolio); err != nil {
+		t.Errorf("expected a report including the cash to be accepted, got %v", err)
+	}
+}
+
+func TestExecuteRebalanceKeepsConcurrentChanges(t *testing.T) {
+	stored := models.Portfolio{
+		UserID:             "user1",
+		Allocation:         map[string]float64{"stocks": 70, "bonds": 30},
+		OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40},
+		TotalValue:         10000,
+	}
+	seqNo := 1
+	write := func(p models.Portfolio) {
+		stored = p
+		seqNo++
+	}
+	raced := false
+	portfolioRepo := &mockPortfolioRepository{
+		getByUserIDFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+			p := stored
+			return &p, nil
+		},
+		getVersionedFunc: func(ctx context.Context, userID string) (*models.Portfolio, repository.Version, error) {
+			p := stored
+			return &p, repository.Version{SeqNo: seqNo, PrimaryTerm: 1}, nil
+		},
+		saveIfVersionFunc: func(ctx context.Context, portfolio models.Portfolio, version repository.Version) error {
+			if !raced {
+				// Another write lands between the read and this one
+				raced = true
+				changed := stored
+				changed.Schedule = "0 9 * * 1"
+				write(changed)
+			}
+			if version.SeqNo != seqNo {
+				return repository.ErrConflict
+			}
+			write(portfolio)
+			return nil
+		},
+	}
+	auditRepo := &mockAuditRepository{}
+	portfolioService := NewPortfolioService(portfolioRepo, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), NewAuditService(auditRepo))
+
+	executor := &mockExecutor{}
+	executor.executeFunc = func(ctx context.Context, tx models.RebalanceTransaction) (models.Execution, error) {
+		// The target changes while the broker works
+		changed := stored
+		changed.OriginalAllocation = map[string]float64{"stocks": 50, "bonds": 50}
+		write(changed)
+		executor.executeFunc = nil
+		return executor.Execute(ctx, tx)
+	}
+	executionService := NewExecutionService(executor, &mockExecutionRepository{}, portfolioService)
+
+	rebalance := &models.Rebalance{ID: "rb1", UserID: "user1", ExpectedAllocation: map[string]float64{"stocks": 60, "bonds": 40}}
+	transactions := []models.RebalanceTransaction{
+		{RebalanceID: "rb1", UserID: "user1", Action: "SELL", Asset: "stocks", RebalancePercent: 10},
+		{RebalanceID: "rb1", UserID: "user1", Action: "BUY", Asset: "bonds", RebalancePercent: 10},
+	}
+	executionService.ExecuteRebalance(context.Background(), rebalance, transactions)
+
+	if stored.Allocation["stocks"] != 60 || stored.Allocation["bonds"] != 40 {
+		t.Errorf("expected the executed allocation, got %v", stored.Allocation)
+	}
+	if stored.OriginalAllocation["stocks"] != 50 {
+		t.Errorf("expected the target changed during execution to be kept, got %v", stored.OriginalAllocation)
+	}
+	if stored.Schedule != "0 9 * * 1" {
+		t.Errorf("expected the schedule written concurrently to be kept, got %q", stored.Schedule)
+	}
+
+	if len(auditRepo.entries) != 1 {
+		t.Fatalf("expected 1 audit entry, got %d", len(auditRepo.entries))
+	}
+	if entry := auditRepo.entries[0]; entry.RebalanceID != "rb1" || entry.Actor != models.ActorSystem {
+		t.Errorf("expected a system entry for rebalance rb1, got %+v", entry)
+	}
+}
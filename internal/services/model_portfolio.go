@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/pkg/idgen"
+	"time"
+)
+
+// ErrModelInUse is returned when deleting a model portfolio that still has subscribers
+var ErrModelInUse = errors.New("model portfolio still has subscribed users")
+
+type ModelPortfolioService interface {
+	CreateModel(ctx context.Context, model models.ModelPortfolio) (*models.ModelPortfolio, error)
+	GetModel(ctx context.Context, id string) (*models.ModelPortfolio, error)
+	ListModels(ctx context.Context) ([]models.ModelPortfolio, error)
+	UpdateModel(ctx context.Context, model models.ModelPortfolio) ([]models.ModelImpact, error)
+	DeleteModel(ctx context.Context, id string) error
+	PreviewModelUpdate(ctx context.Context, id string, allocation map[string]float64) ([]models.ModelImpact, error)
+}
+
+// ModelPortfolioServiceImpl manages model portfolios and propagates changes to subscribers
+type ModelPortfolioServiceImpl struct {
+	modelRepository  repository.ModelPortfolioRepository
+	portfolioService PortfolioService
+	rebalanceService RebalanceService
+}
+
+// NewModelPortfolioService creates a new model portfolio service instance
+func NewModelPortfolioService(
+	modelRepository repository.ModelPortfolioRepository,
+	portfolioService PortfolioService,
+	rebalanceService RebalanceService,
+) ModelPortfolioService {
+	return &ModelPortfolioServiceImpl{
+		modelRepository:  modelRepository,
+		portfolioService: portfolioService,
+		rebalanceService: rebalanceService,
+	}
+}
+
+// CreateModel creates a new model portfolio, generating an ID when none is given
+func (s *ModelPortfolioServiceImpl) CreateModel(ctx context.Context, model models.ModelPortfolio) (*models.ModelPortfolio, error) {
+	if err := validateModel(model); err != nil {
+		return nil, err
+	}
+
+	if model.ID == "" {
+		model.ID = idgen.New()
+	}
+
+	model.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	err := s.modelRepository.Create(ctx, model)
+	if errors.Is(err, repository.ErrConflict) {
+		return nil, &ConflictError{Err: fmt.Errorf("model portfolio %s already exists", model.ID)}
+	}
+	if err != nil {
+		return nil, storageError("failed to save model portfolio: %w", err)
+	}
+
+	return &model, nil
+}
+
+// GetModel retrieves a model portfolio by ID
+func (s *ModelPortfolioServiceImpl) GetModel(ctx context.Context, id string) (*models.ModelPortfolio, error) {
+	if id == "" {
+		return nil, invalid("id", "id is required and cannot be empty")
+	}
+
+	model, err := s.modelRepository.GetByID(ctx, id)
+	if err != nil {
+		return nil, lookupError("model portfolio", id, err)
+	}
+
+	return model, nil
+}
+
+// ListModels retrieves all model portfolios
+func (s *ModelPortfolioServiceImpl) ListModels(ctx context.Context) ([]models.ModelPortfolio, error) {
+	modelPortfolios, err := s.modelRepository.List(ctx)
+	if err != nil {
+		return nil, storageError("failed to list model portfolios: %w", err)
+	}
+
+	return modelPortfolios, nil
+}
+
+// UpdateModel saves the new model allocation, moves every subscriber's target to it
+// and publishes the rebalance transactions each subscriber now needs within its constraints
+func (s *ModelPortfolioServiceImpl) UpdateModel(ctx context.Context, model models.ModelPortfolio) ([]models.ModelImpact, error) {
+	if err := validateModel(model); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.GetModel(ctx, model.ID); err != nil {
+		return nil, err
+	}
+
+	model.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := s.modelRepository.Save(ctx, model); err != nil {
+		return nil, storageError("failed to save model portfolio: %w", err)
+	}
+
+	subscribers, err := s.portfolioService.ListByModel(ctx, model.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A failure for one subscriber must not block the others; it is reported per user
+	impacts := make([]models.ModelImpact, 0, len(subscribers))
+	for _, portfolio := range subscribers {
+		impact, target, constraintErr := s.impact(portfolio, model.Allocation)
+
+		if _, err := s.portfolioService.SetTargetAllocation(ctx, portfolio.UserID, model.Allocation, models.AllocationSourceModel); err != nil {
+			log.Printf("Failed to apply model %s to user %s: %v", model.ID, portfolio.UserID, err)
+			impact.Error = err.Error()
+		} else if constraintErr != nil {
+			log.Printf("Not rebalancing user %s after model %s change: %v", portfolio.UserID, model.ID, constraintErr)
+		} else if _, err := s.rebalanceService.SubmitRebalance(ctx, portfolio, impact.Transactions, target, models.ActorModel); err != nil {
+			log.Printf("Failed to publish transactions for user %s after model %s change: %v", portfolio.UserID, model.ID, err)
+			impact.Error = err.Error()
+		}
+
+		impacts = append(impacts, impact)
+	}
+
+	log.Printf("Model portfolio %s updated, %d subscribers rebalanced", model.ID, len(subscribers))
+	return impacts, nil
+}
+
+// DeleteModel removes a model portfolio that no user subscribes to
+func (s *ModelPortfolioServiceImpl) DeleteModel(ctx context.Context, id string) error {
+	if _, err := s.GetModel(ctx, id); err != nil {
+		return err
+	}
+
+	subscribers, err := s.portfolioService.ListByModel(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(subscribers) > 0 {
+		return &ConflictError{Err: fmt.Errorf("%w: %d users", ErrModelInUse, len(subscribers))}
+	}
+
+	if err := s.modelRepository.Delete(ctx, id); err != nil {
+		return storageError("failed to delete model portfolio: %w", err)
+	}
+
+	return nil
+}
+
+// PreviewModelUpdate calculates the transactions every subscriber would need if the
+// model moved to the given allocation, without saving or publishing anything
+func (s *ModelPortfolioServiceImpl) PreviewModelUpdate(ctx context.Context, id string, allocation map[string]float64) ([]models.ModelImpact, error) {
+	if err := models.ValidateAllocation(allocation); err != nil {
+		return nil, invalidField("allocation", err)
+	}
+
+	if _, err := s.GetModel(ctx, id); err != nil {
+		return nil, err
+	}
+
+	subscribers, err := s.portfolioService.ListByModel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	impacts := make([]models.ModelImpact, 0, len(subscribers))
+	for _, portfolio := range subscribers {
+		impact, _, _ := s.impact(portfolio, allocation)
+		impacts = append(impacts, impact)
+	}
+
+	return impacts, nil
+}
+
+// impact calculates the transactions that move a subscriber to the model allocation within
+// the subscriber's own constraints, and returns the constrained target they reach. When the
+// constraints cannot be met, the impact carries the error and no transactions.
+func (s *ModelPortfolioServiceImpl) impact(portfolio models.Portfolio, allocation map[string]float64) (models.ModelImpact, map[string]float64, error) {
+	impact := models.ModelImpact{UserID: portfolio.UserID}
+
+	target := allocation
+	if len(portfolio.Constraints) > 0 {
+		result, err := s.rebalanceService.ApplyConstraints(portfolio.Allocation, allocation, portfolio.Constraints)
+		if err != nil {
+			impact.Error = err.Error()
+			return impact, nil, err
+		}
+		target = result.Target
+	}
+
+	impact.Transactions = s.rebalanceService.CalculateRebalance(portfolio.Allocation, target, portfolio.UserID)
+	return impact, target, nil
+}
+
+func validateModel(model models.ModelPortfolio) error {
+	if model.Name == "" {
+		return invalid("name", "name is required and cannot be empty")
+	}
+
+	if len(model.Allocation) == 0 {
+		return invalid("allocation", "allocation is required and cannot be empty")
+	}
+
+	if err := models.ValidateAllocation(model.Allocation); err != nil {
+		return invalidField("allocation", err)
+	}
+
+	return nil
+}
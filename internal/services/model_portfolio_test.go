@@ -0,0 +1,384 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/pkg/fx"
+)
+
+// Mock model portfolio repository
+type mockModelPortfolioRepository struct {
+	saveFunc   func(ctx context.Context, model models.ModelPortfolio) error
+	createFunc func(ctx context.Context, model models.ModelPortfolio) error
+	getFunc    func(ctx context.Context, id string) (*models.ModelPortfolio, error)
+	listFunc   func(ctx context.Context) ([]models.ModelPortfolio, error)
+	deleteFunc func(ctx context.Context, id string) error
+}
+
+func (m *mockModelPortfolioRepository) Save(ctx context.Context, model models.ModelPortfolio) error {
+	if m.saveFunc != nil {
+		return m.saveFunc(ctx, model)
+	}
+	return nil
+}
+
+func (m *mockModelPortfolioRepository) Create(ctx context.Context, model models.ModelPortfolio) error {
+	if m.createFunc != nil {
+		return m.createFunc(ctx, model)
+	}
+	if _, err := m.GetByID(ctx, model.ID); err == nil {
+		return repository.ErrConflict
+	}
+	return m.Save(ctx, model)
+}
+
+func (m *mockModelPortfolioRepository) GetByID(ctx context.Context, id string) (*models.ModelPortfolio, error) {
+	if m.getFunc != nil {
+		return m.getFunc(ctx, id)
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (m *mockModelPortfolioRepository) List(ctx context.Context) ([]models.ModelPortfolio, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (m *mockModelPortfolioRepository) Delete(ctx context.Context, id string) error {
+	if m.deleteFunc != nil {
+		return m.deleteFunc(ctx, id)
+	}
+	return nil
+}
+
+func newTestModelService(modelRepo *mockModelPortfolioRepository, subscribers []models.Portfolio, published *[][]byte) ModelPortfolioService {
+	portfolioRepo := &mockPortfolioRepository{
+		listByModelFunc: func(ctx context.Context, modelID string) ([]models.Portfolio, error) {
+			return subscribers, nil
+		},
+		getByUserIDFunc: subscriber(subscribers),
+	}
+	portfolioService := NewPortfolioService(portfolioRepo, &mockAllocationHistoryRepository{}, modelRepo, NewAssetService(&mockAssetRepository{}), nil)
+	rebalanceService := NewRebalanceService(&mockTransactionRepository{}, &mockRebalanceRepository{}, &mockPublisher{
+		publishFunc: func(ctx context.Context, message []byte) error {
+			*published = append(*published, message)
+			return nil
+		},
+	}, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
+	return NewModelPortfolioService(modelRepo, portfolioService, rebalanceService)
+}
+
+// subscriber looks a portfolio up among the subscribers by user ID
+func subscriber(subscribers []models.Portfolio) func(ctx context.Context, userID string) (*models.Portfolio, error) {
+	return func(ctx context.Context, userID string) (*models.Portfolio, error) {
+		for _, portfolio := range subscribers {
+			if portfolio.UserID == userID {
+				p := portfolio
+				return &p, nil
+			}
+		}
+		return nil, repository.ErrNotFound
+	}
+}
+
+func existingModelRepository() *mockModelPortfolioRepository {
+	return &mockModelPortfolioRepository{
+		getFunc: func(ctx context.Context, id string) (*models.ModelPortfolio, error) {
+			if id != "balanced" {
+				return nil, repository.ErrNotFound
+			}
+			return &models.ModelPortfolio{
+				ID:         "balanced",
+				Name:       "Balanced 60/40",
+				Allocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			}, nil
+		},
+	}
+}
+
+func TestCreateModel(t *testing.T) {
+	tests := []struct {
+		name        string
+		model       models.ModelPortfolio
+		createErr   error
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name: "generates ID",
+			model: models.ModelPortfolio{
+				Name:       "Growth",
+				Allocation: map[string]float64{"stocks": 80.0, "bonds": 20.0},
+			},
+		},
+		{
+			name: "duplicate ID",
+			model: models.ModelPortfolio{
+				ID:         "balanced",
+				Name:       "Balanced",
+				Allocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			},
+			expectError: true,
+			errorMsg:    "model portfolio balanced already exists",
+		},
+		{
+			name: "created concurrently",
+			model: models.ModelPortfolio{
+				ID:         "growth",
+				Name:       "Growth",
+				Allocation: map[string]float64{"stocks": 80.0, "bonds": 20.0},
+			},
+			createErr:   repository.ErrConflict,
+			expectError: true,
+			errorMsg:    "model portfolio growth already exists",
+		},
+		{
+			name: "storage error is not taken as absent",
+			model: models.ModelPortfolio{
+				ID:         "growth",
+				Name:       "Growth",
+				Allocation: map[string]float64{"stocks": 80.0, "bonds": 20.0},
+			},
+			createErr:   errors.New("es down"),
+			expectError: true,
+			errorMsg:    "failed to save model portfolio: es down",
+		},
+		{
+			name: "missing name",
+			model: models.ModelPortfolio{
+				Allocation: map[string]float64{"stocks": 100.0},
+			},
+			expectError: true,
+			errorMsg:    "name is required and cannot be empty",
+		},
+		{
+			name: "invalid allocation",
+			model: models.ModelPortfolio{
+				Name:       "Broken",
+				Allocation: map[string]float64{"stocks": 90.0},
+			},
+			expectError: true,
+			errorMsg:    "allocation must sum to 100%, got 90.00%",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var published [][]byte
+			modelRepo := existingModelRepository()
+			if tt.createErr != nil {
+				modelRepo.createFunc = func(ctx context.Context, model models.ModelPortfolio) error {
+					return tt.createErr
+				}
+			}
+			service := newTestModelService(modelRepo, nil, &published)
+
+			result, err := service.CreateModel(context.Background(), tt.model)
+
+			if tt.expectError {
+				if err == nil || err.Error() != tt.errorMsg {
+					t.Errorf("expected error '%s', got '%v'", tt.errorMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			if result.ID == "" || result.UpdatedAt == "" {
+				t.Errorf("expected ID and updated_at to be set, got %+v", result)
+			}
+		})
+	}
+}
+
+func TestUpdateModel(t *testing.T) {
+	subscribers := []models.Portfolio{
+		{
+			UserID:             "user1",
+			ModelID:            "balanced",
+			Allocation:         map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			OriginalAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		},
+		{
+			UserID:             "user2",
+			ModelID:            "balanced",
+			Allocation:         map[string]float64{"stocks": 50.0, "bonds": 50.0},
+			OriginalAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		},
+	}
+
+	var published [][]byte
+	service := newTestModelService(existingModelRepository(), subscribers, &published)
+
+	impacts, err := service.UpdateModel(context.Background(), models.ModelPortfolio{
+		ID:         "balanced",
+		Name:       "Balanced 50/50",
+		Allocation: map[string]float64{"stocks": 50.0, "bonds": 50.0},
+	})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	if len(impacts) != 2 {
+		t.Fatalf("expected 2 impacts, got %d", len(impacts))
+	}
+	if len(impacts[0].Transactions) != 2 {
+		t.Errorf("expected 2 transactions for user1, got %d", len(impacts[0].Transactions))
+	}
+	if len(impacts[1].Transactions) != 0 {
+		t.Errorf("expected no transactions for user2, got %d", len(impacts[1].Transactions))
+	}
+	// Only user1 needed trades, empty batches are not published
+	if len(published) != 1 {
+		t.Errorf("expected 1 published batch, got %d", len(published))
+	}
+
+	if _, err := service.UpdateModel(context.Background(), models.ModelPortfolio{
+		ID:         "missing",
+		Name:       "Missing",
+		Allocation: map[string]float64{"stocks": 100.0},
+	}); err == nil {
+		t.Errorf("expected error for unknown model")
+	}
+}
+
+func TestUpdateModelRespectsSubscriberConstraints(t *testing.T) {
+	subscribers := []models.Portfolio{
+		{
+			UserID:             "user1",
+			ModelID:            "balanced",
+			Allocation:         map[string]float64{"stocks": 50.0, "bonds": 30.0, "gold": 20.0},
+			OriginalAllocation: map[string]float64{"stocks": 50.0, "bonds": 30.0, "gold": 20.0},
+			Constraints:        map[string]models.AssetConstraint{"gold": {Locked: true}},
+		},
+	}
+	portfolioRepo := &mockPortfolioRepository{
+		listByModelFunc: func(ctx context.Context, modelID string) ([]models.Portfolio, error) {
+			return subscribers, nil
+		},
+		getByUserIDFunc: subscriber(subscribers),
+	}
+	rebalanceRepo := &mockRebalanceRepository{}
+	portfolioService := NewPortfolioService(portfolioRepo, &mockAllocationHistoryRepository{}, existingModelRepository(), NewAssetService(&mockAssetRepository{}), nil)
+	rebalanceService := NewRebalanceService(&mockTransactionRepository{}, rebalanceRepo, &mockPublisher{}, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
+	service := NewModelPortfolioService(existingModelRepository(), portfolioService, rebalanceService)
+
+	allocation := map[string]float64{"stocks": 40.0, "bonds": 30.0, "gold": 30.0}
+	impacts, err := service.UpdateModel(context.Background(), models.ModelPortfolio{ID: "balanced", Name: "Gold heavy", Allocation: allocation})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	if len(impacts) != 1 || impacts[0].Error != "" {
+		t.Fatalf("expected one successful impact, got %+v", impacts)
+	}
+	for _, tx := range impacts[0].Transactions {
+		if tx.Asset == "gold" {
+			t.Errorf("expected the locked gold position not to trade, got %+v", tx)
+		}
+	}
+
+	if len(rebalanceRepo.rebalances) != 1 {
+		t.Fatalf("expected 1 rebalance, got %d", len(rebalanceRepo.rebalances))
+	}
+	for _, rebalance := range rebalanceRepo.rebalances {
+		if rebalance.ExpectedAllocation["gold"] != 20.0 {
+			t.Errorf("expected the constrained target to be reconciled against, got %v", rebalance.ExpectedAllocation)
+		}
+	}
+
+	previews, err := service.PreviewModelUpdate(context.Background(), "balanced", allocation)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	for _, tx := range previews[0].Transactions {
+		if tx.Asset == "gold" {
+			t.Errorf("expected the preview not to trade the locked gold position, got %+v", tx)
+		}
+	}
+}
+
+func TestPreviewModelUpdate(t *testing.T) {
+	subscribers := []models.Portfolio{
+		{
+			UserID:     "user1",
+			ModelID:    "balanced",
+			Allocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		},
+	}
+
+	saved := false
+	modelRepo := existingModelRepository()
+	modelRepo.saveFunc = func(ctx context.Context, model models.ModelPortfolio) error {
+		saved = true
+		return nil
+	}
+
+	var published [][]byte
+	service := newTestModelService(modelRepo, subscribers, &published)
+
+	impacts, err := service.PreviewModelUpdate(context.Background(), "balanced", map[string]float64{"stocks": 70.0, "bonds": 30.0})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	if len(impacts) != 1 || len(impacts[0].Transactions) != 2 {
+		t.Errorf("expected 2 transactions for user1, got %+v", impacts)
+	}
+	if saved || len(published) != 0 {
+		t.Errorf("preview must not save or publish anything")
+	}
+
+	if _, err := service.PreviewModelUpdate(context.Background(), "balanced", map[string]float64{"stocks": 70.0}); err == nil {
+		t.Errorf("expected error for invalid allocation")
+	}
+}
+
+func TestDeleteModel(t *testing.T) {
+	tests := []struct {
+		name        string
+		id          string
+		subscribers []models.Portfolio
+		expectInUse bool
+		expectError bool
+	}{
+		{
+			name: "no subscribers",
+			id:   "balanced",
+		},
+		{
+			name:        "has subscribers",
+			id:          "balanced",
+			subscribers: []models.Portfolio{{UserID: "user1", ModelID: "balanced"}},
+			expectError: true,
+			expectInUse: true,
+		},
+		{
+			name:        "unknown model",
+			id:          "missing",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var published [][]byte
+			service := newTestModelService(existingModelRepository(), tt.subscribers, &published)
+
+			err := service.DeleteModel(context.Background(), tt.id)
+
+			if tt.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			if tt.expectInUse != errors.Is(err, ErrModelInUse) {
+				t.Errorf("expected ErrModelInUse %v, got %v", tt.expectInUse, err)
+			}
+		})
+	}
+}
@@ -2,56 +2,144 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"portfolio-rebalancer/internal/models"
 	"portfolio-rebalancer/internal/repository"
+	"time"
 )
 
+// portfolioWriteAttempts bounds how often a conditional portfolio write is read and tried
+// again after losing to a concurrent one
+const portfolioWriteAttempts = 3
+
 type PortfolioService interface {
 	CreatePortfolio(ctx context.Context, p models.Portfolio) (*models.Portfolio, error)
 	GetPortfolio(ctx context.Context, userID string) (*models.Portfolio, error)
 	UpdatePortfolio(ctx context.Context, portfolio models.Portfolio) error
+	UpdateAllocation(ctx context.Context, portfolio models.Portfolio, source string) error
+	ReplaceAllocation(ctx context.Context, userID string, allocation map[string]float64, source, rebalanceID string) (*models.Portfolio, error)
+	GetPortfolioAsOf(ctx context.Context, userID string, asOf time.Time) (*models.Portfolio, error)
+	GetAllocationHistory(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error)
+	ListByModel(ctx context.Context, modelID string) ([]models.Portfolio, error)
+	SetTargetAllocation(ctx context.Context, userID string, target map[string]float64, source string) (*models.Portfolio, error)
+	SetSchedule(ctx context.Context, userID string, schedule string) (*models.Portfolio, error)
 }
 
 // PortfolioServiceImpl handles portfolio business logic
 type PortfolioServiceImpl struct {
 	portfolioRepository repository.PortfolioRepository
+	historyRepository   repository.AllocationHistoryRepository
+	modelRepository     repository.ModelPortfolioRepository
+	assetService        AssetService
+	auditService        AuditService
 }
 
 // NewPortfolioService creates a new portfolio service instance
-func NewPortfolioService(portfolioRepository repository.PortfolioRepository) PortfolioService {
+func NewPortfolioService(
+	portfolioRepository repository.PortfolioRepository,
+	historyRepository repository.AllocationHistoryRepository,
+	modelRepository repository.ModelPortfolioRepository,
+	assetService AssetService,
+	auditService AuditService,
+) PortfolioService {
 	return &PortfolioServiceImpl{
 		portfolioRepository,
+		historyRepository,
+		modelRepository,
+		assetService,
+		auditService,
 	}
 }
 
-// CreatePortfolio creates a new portfolio with validation
+// CreatePortfolio creates a new portfolio with validation.
+// When ModelID or AllocationTree is set the target comes from it, and the current
+// allocation defaults to that target when not provided.
 func (s *PortfolioServiceImpl) CreatePortfolio(ctx context.Context, p models.Portfolio) (*models.Portfolio, error) {
 	// Validate user ID
 	if p.UserID == "" {
-		return nil, fmt.Errorf("user_id is required and cannot be empty")
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+
+	// A hierarchical target is stored as-is and its leaves become the flat allocation
+	if len(p.AllocationTree) > 0 {
+		if p.ModelID != "" {
+			return nil, invalid("allocation_tree", "allocation_tree cannot be combined with model_id")
+		}
+		if err := models.ValidateAllocationTree(p.AllocationTree); err != nil {
+			return nil, invalidField("allocation_tree", err)
+		}
+		if len(p.Allocation) == 0 {
+			p.Allocation = models.FlattenAllocation(p.AllocationTree)
+		}
+	}
+
+	var model *models.ModelPortfolio
+	if p.ModelID != "" {
+		var err error
+		model, err = s.modelRepository.GetByID(ctx, p.ModelID)
+		if err != nil {
+			return nil, lookupError("model portfolio", p.ModelID, err)
+		}
+		if len(p.Allocation) == 0 {
+			p.Allocation = copyAllocation(model.Allocation)
+		}
 	}
 
 	// Validate allocation exists
 	if len(p.Allocation) == 0 {
-		return nil, fmt.Errorf("allocation is required and cannot be empty")
+		return nil, invalid("allocation", "allocation is required and cannot be empty")
 	}
 
 	// Validate allocation percentages
 	if err := models.ValidateAllocation(p.Allocation); err != nil {
+		return nil, invalidField("allocation", err)
+	}
+
+	if err := models.ValidateConstraints(p.Constraints); err != nil {
+		return nil, invalidField("constraints", err)
+	}
+
+	if p.Currency == "" {
+		p.Currency = models.DefaultCurrency
+	}
+	if !currencyPattern.MatchString(p.Currency) {
+		return nil, invalid("currency", "currency must be a 3-letter ISO 4217 code, got %s", p.Currency)
+	}
+
+	// Set original allocation (this is the target to maintain) from the model, the
+	// leaves of the allocation tree, or else the current allocation.
+	// Subscribers keep a copy of the model's target, refreshed whenever the model changes
+	switch {
+	case model != nil:
+		p.OriginalAllocation = copyAllocation(model.Allocation)
+	case len(p.AllocationTree) > 0:
+		p.OriginalAllocation = models.FlattenAllocation(p.AllocationTree)
+	default:
+		p.OriginalAllocation = copyAllocation(p.Allocation)
+	}
+
+	if err := s.canonicalizeAssets(ctx, &p); err != nil {
 		return nil, err
 	}
 
-	// Set original allocation to current allocation (this is the target to maintain)
-	p.OriginalAllocation = make(map[string]float64)
-	for k, v := range p.Allocation {
-		p.OriginalAllocation[k] = v
+	next, err := nextScheduledRun(p.Schedule, time.Now())
+	if err != nil {
+		return nil, invalidField("schedule", err)
 	}
+	p.NextRebalanceAt = next
 
 	// Save to storage
 	if err := s.portfolioRepository.Save(ctx, p); err != nil {
-		return nil, fmt.Errorf("failed to save portfolio: %w", err)
+		return nil, storageError("failed to save portfolio: %w", err)
+	}
+
+	// The portfolio is saved, so a missing history record must not make the caller retry the create
+	if err := s.recordHistory(ctx, p, models.AllocationSourceUser); err != nil {
+		log.Printf("Failed to record history of new portfolio for user %s: %v", p.UserID, err)
 	}
+	s.audit(ctx, models.AuditPortfolioCreate, nil, p)
 
 	return &p, nil
 }
@@ -59,33 +147,317 @@ func (s *PortfolioServiceImpl) CreatePortfolio(ctx context.Context, p models.Por
 // GetPortfolio retrieves a portfolio by user ID
 func (s *PortfolioServiceImpl) GetPortfolio(ctx context.Context, userID string) (*models.Portfolio, error) {
 	if userID == "" {
-		return nil, fmt.Errorf("user_id is required and cannot be empty")
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
 	}
 
 	portfolio, err := s.portfolioRepository.GetByUserID(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("portfolio not found for user %s: %w", userID, err)
+		return nil, lookupError("portfolio", userID, err)
 	}
 
 	return portfolio, nil
 }
 
-// UpdatePortfolio updates an existing portfolio's current allocation
+// UpdatePortfolio updates an existing portfolio's current allocation as reported by the provider
 func (s *PortfolioServiceImpl) UpdatePortfolio(ctx context.Context, portfolio models.Portfolio) error {
+	return s.UpdateAllocation(ctx, portfolio, models.AllocationSourceProvider)
+}
+
+// UpdateAllocation updates an existing portfolio's current allocation and, when given, its total
+// value, and records where the change came from. Every other field is kept as stored.
+func (s *PortfolioServiceImpl) UpdateAllocation(ctx context.Context, portfolio models.Portfolio, source string) error {
 	if portfolio.UserID == "" {
-		return fmt.Errorf("user_id is required and cannot be empty")
+		return invalid("user_id", "user_id is required and cannot be empty")
 	}
 
 	// Validate allocation if provided
 	if len(portfolio.Allocation) > 0 {
 		if err := models.ValidateAllocation(portfolio.Allocation); err != nil {
-			return err
+			return invalidField("allocation", err)
+		}
+	}
+
+	before, after, err := s.modify(ctx, portfolio.UserID, func(p *models.Portfolio) {
+		if len(portfolio.Allocation) > 0 {
+			p.Allocation = copyAllocation(portfolio.Allocation)
+		}
+		if portfolio.TotalValue > 0 {
+			p.TotalValue = portfolio.TotalValue
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.recordHistory(ctx, *after, source); err != nil {
+		return err
+	}
+	s.audit(ctx, models.AuditPortfolioUpdate, before, *after)
+
+	return nil
+}
+
+// ReplaceAllocation re-reads the user's portfolio and replaces only its current allocation,
+// so changes saved meanwhile, e.g. to its target or schedule, are kept. The audit entry is
+// tied to rebalanceID when the allocation comes from executing a rebalance.
+func (s *PortfolioServiceImpl) ReplaceAllocation(ctx context.Context, userID string, allocation map[string]float64, source, rebalanceID string) (*models.Portfolio, error) {
+	if userID == "" {
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+	if err := models.ValidateAllocation(allocation); err != nil {
+		return nil, invalidField("allocation", err)
+	}
+
+	before, after, err := s.modify(ctx, userID, func(p *models.Portfolio) {
+		p.Allocation = copyAllocation(allocation)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.recordHistory(ctx, *after, source); err != nil {
+		return nil, err
+	}
+	s.auditRebalance(ctx, models.AuditPortfolioUpdate, rebalanceID, before, *after)
+
+	return after, nil
+}
+
+// ListByModel retrieves every portfolio subscribed to a model portfolio
+func (s *PortfolioServiceImpl) ListByModel(ctx context.Context, modelID string) ([]models.Portfolio, error) {
+	portfolios, err := s.portfolioRepository.ListByModelID(ctx, modelID)
+	if err != nil {
+		return nil, storageError("failed to list portfolios for model %s: %w", modelID, err)
+	}
+
+	return portfolios, nil
+}
+
+// SetTargetAllocation replaces the user's target allocation and records the change
+func (s *PortfolioServiceImpl) SetTargetAllocation(ctx context.Context, userID string, target map[string]float64, source string) (*models.Portfolio, error) {
+	if userID == "" {
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+
+	if err := models.ValidateAllocation(target); err != nil {
+		return nil, invalidField("allocation", err)
+	}
+
+	before, after, err := s.modify(ctx, userID, func(p *models.Portfolio) {
+		p.OriginalAllocation = copyAllocation(target)
+		// A flat target replaces any hierarchical one, which would otherwise go stale
+		p.AllocationTree = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.recordHistory(ctx, *after, source); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, models.AuditPortfolioUpdate, before, *after)
+
+	return after, nil
+}
+
+// SetSchedule changes when the scheduler rebalances the user's portfolio. An empty
+// schedule turns scheduled rebalancing off.
+func (s *PortfolioServiceImpl) SetSchedule(ctx context.Context, userID string, schedule string) (*models.Portfolio, error) {
+	if userID == "" {
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+
+	next, err := nextScheduledRun(schedule, time.Now())
+	if err != nil {
+		return nil, invalidField("schedule", err)
+	}
+
+	before, after, err := s.modify(ctx, userID, func(p *models.Portfolio) {
+		p.Schedule = schedule
+		p.NextRebalanceAt = next
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The allocation is unchanged, so no history is recorded
+	s.audit(ctx, models.AuditPortfolioUpdate, before, *after)
+
+	return after, nil
+}
+
+// GetPortfolioAsOf reconstructs a portfolio from the latest history record at or before asOf.
+// Records written before the history kept the whole portfolio only restore its allocations.
+func (s *PortfolioServiceImpl) GetPortfolioAsOf(ctx context.Context, userID string, asOf time.Time) (*models.Portfolio, error) {
+	if userID == "" {
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+
+	record, err := s.historyRepository.GetAsOf(ctx, userID, asOf)
+	if err != nil {
+		return nil, lookupError("portfolio", userID, err)
+	}
+
+	return &models.Portfolio{
+		UserID:             record.UserID,
+		Allocation:         record.Allocation,
+		OriginalAllocation: record.OriginalAllocation,
+		ModelID:            record.ModelID,
+		AllocationTree:     record.AllocationTree,
+		Constraints:        record.Constraints,
+		TotalValue:         record.TotalValue,
+		Currency:           record.Currency,
+	}, nil
+}
+
+// GetAllocationHistory lists a user's allocation changes between from and to, oldest first
+func (s *PortfolioServiceImpl) GetAllocationHistory(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error) {
+	if userID == "" {
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+
+	history, err := s.historyRepository.ListByUserID(ctx, userID, from, to)
+	if err != nil {
+		return nil, storageError("failed to get allocation history: %w", err)
+	}
+
+	return history, nil
+}
+
+// recordHistory appends a snapshot of the portfolio to its allocation history
+func (s *PortfolioServiceImpl) recordHistory(ctx context.Context, p models.Portfolio, source string) error {
+	record := models.AllocationHistory{
+		UserID:             p.UserID,
+		Allocation:         p.Allocation,
+		OriginalAllocation: p.OriginalAllocation,
+		ModelID:            p.ModelID,
+		AllocationTree:     p.AllocationTree,
+		Constraints:        p.Constraints,
+		TotalValue:         p.TotalValue,
+		Currency:           p.Currency,
+		Source:             source,
+		Timestamp:          time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	if err := s.historyRepository.Append(ctx, record); err != nil {
+		return storageError("failed to record allocation history: %w", err)
+	}
+
+	return nil
+}
+
+// modify applies change to the stored portfolio and writes it back only if no other write
+// landed since it was read, reading it again otherwise. It returns snapshots of the
+// portfolio before and after the change.
+func (s *PortfolioServiceImpl) modify(ctx context.Context, userID string, change func(*models.Portfolio)) (*models.Portfolio, *models.Portfolio, error) {
+	var err error
+	for attempt := 0; attempt < portfolioWriteAttempts; attempt++ {
+		portfolio, version, getErr := s.portfolioRepository.GetVersioned(ctx, userID)
+		if getErr != nil {
+			return nil, nil, lookupError("portfolio", userID, getErr)
+		}
+
+		before := snapshot(*portfolio)
+		change(portfolio)
+		if err = s.portfolioRepository.SaveIfVersion(ctx, *portfolio, version); err == nil {
+			return before, portfolio, nil
 		}
+		if !errors.Is(err, repository.ErrConflict) {
+			return nil, nil, storageError("failed to update portfolio: %w", err)
+		}
+	}
+
+	return nil, nil, &ConflictError{Err: fmt.Errorf("portfolio %s kept changing while it was updated: %w", userID, err)}
+}
+
+// audit records a change to a portfolio in the audit trail, when one is kept
+func (s *PortfolioServiceImpl) audit(ctx context.Context, action string, before *models.Portfolio, after models.Portfolio) {
+	s.auditRebalance(ctx, action, "", before, after)
+}
+
+// auditRebalance records a change made on behalf of a rebalance, if rebalanceID is set
+func (s *PortfolioServiceImpl) auditRebalance(ctx context.Context, action, rebalanceID string, before *models.Portfolio, after models.Portfolio) {
+	if s.auditService == nil {
+		return
+	}
+
+	s.auditService.Record(ctx, models.AuditEntry{
+		UserID:      after.UserID,
+		Action:      action,
+		RebalanceID: rebalanceID,
+		Before:      before,
+		After:       snapshot(after),
+	})
+}
+
+func copyAllocation(allocation map[string]float64) map[string]float64 {
+	result := make(map[string]float64, len(allocation))
+	for k, v := range allocation {
+		result[k] = v
+	}
+	return result
+}
+
+// canonicalizeAssets replaces asset names and aliases in the portfolio with the IDs
+// from the asset registry. Names are kept as-is while the registry is empty.
+func (s *PortfolioServiceImpl) canonicalizeAssets(ctx context.Context, p *models.Portfolio) error {
+	registry, err := s.assetService.Registry(ctx)
+	if err != nil {
+		return err
+	}
+	if registry.Empty() {
+		return nil
 	}
 
-	if err := s.portfolioRepository.Save(ctx, portfolio); err != nil {
-		return fmt.Errorf("failed to update portfolio: %w", err)
+	if p.Allocation, err = registry.Canonical(p.Allocation); err != nil {
+		return invalidField("allocation", err)
+	}
+	if p.OriginalAllocation, err = registry.Canonical(p.OriginalAllocation); err != nil {
+		return invalidField("allocation", err)
+	}
+	if p.AllocationTree, err = canonicalTree(registry, p.AllocationTree); err != nil {
+		return invalidField("allocation_tree", err)
+	}
+
+	if len(p.Constraints) > 0 {
+		constraints := make(map[string]models.AssetConstraint, len(p.Constraints))
+		for name, constraint := range p.Constraints {
+			asset, ok := registry.Lookup(name)
+			if !ok {
+				return invalid("constraints."+name, "unknown asset %s", name)
+			}
+			constraints[asset.ID] = constraint
+		}
+		p.Constraints = constraints
 	}
 
 	return nil
 }
+
+// canonicalTree copies the tree with every leaf renamed to its asset ID. Inner nodes
+// are asset classes rather than assets, so they keep their names.
+func canonicalTree(registry *models.AssetRegistry, nodes []models.AllocationNode) ([]models.AllocationNode, error) {
+	if nodes == nil {
+		return nil, nil
+	}
+
+	result := make([]models.AllocationNode, len(nodes))
+	for i, node := range nodes {
+		result[i] = node
+		if len(node.Children) > 0 {
+			children, err := canonicalTree(registry, node.Children)
+			if err != nil {
+				return nil, err
+			}
+			result[i].Children = children
+			continue
+		}
+
+		asset, ok := registry.Lookup(node.Asset)
+		if !ok {
+			return nil, fmt.Errorf("unknown asset %s", node.Asset)
+		}
+		result[i].Asset = asset.ID
+	}
+	return result, nil
+}
@@ -4,14 +4,21 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
 )
 
 // Mock repository
 type mockPortfolioRepository struct {
 	saveFunc      func(ctx context.Context, portfolio models.Portfolio) error
 	getByUserIDFunc func(ctx context.Context, userID string) (*models.Portfolio, error)
+	listByModelFunc func(ctx context.Context, modelID string) ([]models.Portfolio, error)
+	listDueFunc     func(ctx context.Context, now time.Time) ([]models.Portfolio, error)
+	listAllFunc     func(ctx context.Context) ([]models.Portfolio, error)
+	getVersionedFunc  func(ctx context.Context, userID string) (*models.Portfolio, repository.Version, error)
+	saveIfVersionFunc func(ctx context.Context, portfolio models.Portfolio, version repository.Version) error
 }
 
 func (m *mockPortfolioRepository) Save(ctx context.Context, portfolio models.Portfolio) error {
@@ -25,7 +32,88 @@ func (m *mockPortfolioRepository) GetByUserID(ctx context.Context, userID string
 	if m.getByUserIDFunc != nil {
 		return m.getByUserIDFunc(ctx, userID)
 	}
-	return nil, errors.New("not found")
+	return nil, repository.ErrNotFound
+}
+
+func (m *mockPortfolioRepository) GetVersioned(ctx context.Context, userID string) (*models.Portfolio, repository.Version, error) {
+	if m.getVersionedFunc != nil {
+		return m.getVersionedFunc(ctx, userID)
+	}
+	portfolio, err := m.GetByUserID(ctx, userID)
+	return portfolio, repository.Version{}, err
+}
+
+func (m *mockPortfolioRepository) SaveIfVersion(ctx context.Context, portfolio models.Portfolio, version repository.Version) error {
+	if m.saveIfVersionFunc != nil {
+		return m.saveIfVersionFunc(ctx, portfolio, version)
+	}
+	return m.Save(ctx, portfolio)
+}
+
+// existingPortfolio is a stored 60/40 portfolio for any user
+func existingPortfolio(ctx context.Context, userID string) (*models.Portfolio, error) {
+	return &models.Portfolio{
+		UserID:             userID,
+		Allocation:         map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		OriginalAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+	}, nil
+}
+
+func (m *mockPortfolioRepository) ListByModelID(ctx context.Context, modelID string) ([]models.Portfolio, error) {
+	if m.listByModelFunc != nil {
+		return m.listByModelFunc(ctx, modelID)
+	}
+	return nil, nil
+}
+
+func (m *mockPortfolioRepository) ListDue(ctx context.Context, now time.Time) ([]models.Portfolio, error) {
+	if m.listDueFunc != nil {
+		return m.listDueFunc(ctx, now)
+	}
+	return nil, nil
+}
+
+func (m *mockPortfolioRepository) ListAll(ctx context.Context) ([]models.Portfolio, error) {
+	if m.listAllFunc != nil {
+		return m.listAllFunc(ctx)
+	}
+	return nil, nil
+}
+
+// Mock allocation history repository
+type mockAllocationHistoryRepository struct {
+	appendFunc  func(ctx context.Context, record models.AllocationHistory) error
+	listFunc    func(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error)
+	getAsOfFunc func(ctx context.Context, userID string, asOf time.Time) (*models.AllocationHistory, error)
+	latestFunc  func(ctx context.Context, userID string, sources []string) (*models.AllocationHistory, error)
+}
+
+func (m *mockAllocationHistoryRepository) Append(ctx context.Context, record models.AllocationHistory) error {
+	if m.appendFunc != nil {
+		return m.appendFunc(ctx, record)
+	}
+	return nil
+}
+
+func (m *mockAllocationHistoryRepository) ListByUserID(ctx context.Context, userID string, from, to time.Time) ([]models.AllocationHistory, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx, userID, from, to)
+	}
+	return nil, nil
+}
+
+func (m *mockAllocationHistoryRepository) GetAsOf(ctx context.Context, userID string, asOf time.Time) (*models.AllocationHistory, error) {
+	if m.getAsOfFunc != nil {
+		return m.getAsOfFunc(ctx, userID, asOf)
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (m *mockAllocationHistoryRepository) GetLatestBySource(ctx context.Context, userID string, sources []string) (*models.AllocationHistory, error) {
+	if m.latestFunc != nil {
+		return m.latestFunc(ctx, userID, sources)
+	}
+	return nil, repository.ErrNotFound
 }
 
 func TestCreatePortfolio(t *testing.T) {
@@ -102,9 +190,10 @@ func TestCreatePortfolio(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockRepo := &mockPortfolioRepository{
-				saveFunc: tt.mockSave,
+				saveFunc:        tt.mockSave,
+				getByUserIDFunc: existingPortfolio,
 			}
-			service := NewPortfolioService(mockRepo)
+			service := NewPortfolioService(mockRepo, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
 
 			result, err := service.CreatePortfolio(context.Background(), tt.portfolio)
 
@@ -166,10 +255,10 @@ func TestGetPortfolio(t *testing.T) {
 			name:   "portfolio not found",
 			userID: "user1",
 			mockGet: func(ctx context.Context, userID string) (*models.Portfolio, error) {
-				return nil, errors.New("not found")
+				return nil, repository.ErrNotFound
 			},
 			expectError: true,
-			errorMsg:    "portfolio not found for user user1: not found",
+			errorMsg:    "portfolio not found: user1",
 		},
 	}
 
@@ -178,7 +267,7 @@ func TestGetPortfolio(t *testing.T) {
 			mockRepo := &mockPortfolioRepository{
 				getByUserIDFunc: tt.mockGet,
 			}
-			service := NewPortfolioService(mockRepo)
+			service := NewPortfolioService(mockRepo, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
 
 			result, err := service.GetPortfolio(context.Background(), tt.userID)
 
@@ -261,9 +350,10 @@ func TestUpdatePortfolio(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockRepo := &mockPortfolioRepository{
-				saveFunc: tt.mockSave,
+				saveFunc:        tt.mockSave,
+				getByUserIDFunc: existingPortfolio,
 			}
-			service := NewPortfolioService(mockRepo)
+			service := NewPortfolioService(mockRepo, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
 
 			err := service.UpdatePortfolio(context.Background(), tt.portfolio)
 
@@ -283,3 +373,404 @@ func TestUpdatePortfolio(t *testing.T) {
 		})
 	}
 }
+
+func TestPortfolioUpdatesKeepOtherFields(t *testing.T) {
+	stored := models.Portfolio{
+		UserID:             "user1",
+		Allocation:         map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		OriginalAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		TotalValue:         10000,
+		Schedule:           "monthly",
+		NextRebalanceAt:    "2026-11-01T00:00:00Z",
+	}
+	// What a caller read before the scheduler claimed the current period
+	stale := stored
+	stale.NextRebalanceAt = "2026-10-01T00:00:00Z"
+
+	repo := &mockPortfolioRepository{
+		getByUserIDFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+			p := stored
+			return &p, nil
+		},
+		saveFunc: func(ctx context.Context, portfolio models.Portfolio) error {
+			stored = portfolio
+			return nil
+		},
+	}
+	service := NewPortfolioService(repo, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
+	ctx := context.Background()
+
+	stale.Allocation = map[string]float64{"stocks": 70.0, "bonds": 30.0}
+	if err := service.UpdatePortfolio(ctx, stale); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.NextRebalanceAt != "2026-11-01T00:00:00Z" || stored.Allocation["stocks"] != 70.0 {
+		t.Errorf("expected only the allocation to change, got %+v", stored)
+	}
+
+	if _, err := service.SetTargetAllocation(ctx, "user1", map[string]float64{"stocks": 50.0, "bonds": 50.0}, models.AllocationSourceUser); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.Allocation["stocks"] != 70.0 || stored.OriginalAllocation["stocks"] != 50.0 || stored.NextRebalanceAt != "2026-11-01T00:00:00Z" {
+		t.Errorf("expected only the target to change, got %+v", stored)
+	}
+
+	if _, err := service.SetSchedule(ctx, "user1", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.Schedule != "" || stored.NextRebalanceAt != "" || stored.Allocation["stocks"] != 70.0 || stored.OriginalAllocation["stocks"] != 50.0 || stored.TotalValue != 10000 {
+		t.Errorf("expected only the schedule to change, got %+v", stored)
+	}
+}
+
+func TestPortfolioHistoryRecording(t *testing.T) {
+	tests := []struct {
+		name           string
+		action         func(service PortfolioService) error
+		mockAppend     func(ctx context.Context, record models.AllocationHistory) error
+		expectedSource string
+		expectError    bool
+		errorMsg       string
+	}{
+		{
+			name: "create records user source",
+			action: func(service PortfolioService) error {
+				_, err := service.CreatePortfolio(context.Background(), models.Portfolio{
+					UserID:     "user1",
+					Allocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+				})
+				return err
+			},
+			expectedSource: models.AllocationSourceUser,
+		},
+		{
+			name: "update records provider source",
+			action: func(service PortfolioService) error {
+				return service.UpdatePortfolio(context.Background(), models.Portfolio{
+					UserID:     "user1",
+					Allocation: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+				})
+			},
+			expectedSource: models.AllocationSourceProvider,
+		},
+		{
+			name: "cash flow update records cashflow source",
+			action: func(service PortfolioService) error {
+				return service.UpdateAllocation(context.Background(), models.Portfolio{
+					UserID:     "user1",
+					Allocation: map[string]float64{"stocks": 65.0, "bonds": 35.0},
+					TotalValue: 11000,
+				}, models.AllocationSourceCashFlow)
+			},
+			expectedSource: models.AllocationSourceCashFlow,
+		},
+		{
+			name: "history error does not fail the create",
+			action: func(service PortfolioService) error {
+				_, err := service.CreatePortfolio(context.Background(), models.Portfolio{
+					UserID:     "user1",
+					Allocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+				})
+				return err
+			},
+			mockAppend: func(ctx context.Context, record models.AllocationHistory) error {
+				return errors.New("es down")
+			},
+		},
+		{
+			name: "history error fails the update",
+			action: func(service PortfolioService) error {
+				return service.UpdatePortfolio(context.Background(), models.Portfolio{
+					UserID:     "user1",
+					Allocation: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+				})
+			},
+			mockAppend: func(ctx context.Context, record models.AllocationHistory) error {
+				return errors.New("es down")
+			},
+			expectError: true,
+			errorMsg:    "failed to record allocation history: es down",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recorded []models.AllocationHistory
+			historyRepo := &mockAllocationHistoryRepository{
+				appendFunc: func(ctx context.Context, record models.AllocationHistory) error {
+					if tt.mockAppend != nil {
+						return tt.mockAppend(ctx, record)
+					}
+					recorded = append(recorded, record)
+					return nil
+				},
+			}
+			service := NewPortfolioService(&mockPortfolioRepository{getByUserIDFunc: existingPortfolio}, historyRepo, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
+
+			err := tt.action(service)
+
+			if tt.expectError {
+				if err == nil || err.Error() != tt.errorMsg {
+					t.Errorf("expected error '%s', got '%v'", tt.errorMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			if tt.mockAppend != nil {
+				return
+			}
+			if len(recorded) != 1 {
+				t.Fatalf("expected 1 history record, got %d", len(recorded))
+			}
+			if recorded[0].Source != tt.expectedSource {
+				t.Errorf("expected source %s, got %s", tt.expectedSource, recorded[0].Source)
+			}
+			if _, err := time.Parse(time.RFC3339Nano, recorded[0].Timestamp); err != nil {
+				t.Errorf("expected RFC3339 timestamp, got %s", recorded[0].Timestamp)
+			}
+		})
+	}
+}
+
+func TestGetPortfolioAsOf(t *testing.T) {
+	asOf := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		userID      string
+		mockGetAsOf func(ctx context.Context, userID string, asOf time.Time) (*models.AllocationHistory, error)
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:   "reconstructs portfolio from history",
+			userID: "user1",
+			mockGetAsOf: func(ctx context.Context, userID string, asOf time.Time) (*models.AllocationHistory, error) {
+				return &models.AllocationHistory{
+					UserID:             userID,
+					Allocation:         map[string]float64{"stocks": 70.0, "bonds": 30.0},
+					OriginalAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+					ModelID:            "balanced",
+					Constraints:        map[string]models.AssetConstraint{"stocks": {NoSell: true}},
+					TotalValue:         10000,
+					Currency:           "EUR",
+					Source:             models.AllocationSourceProvider,
+				}, nil
+			},
+		},
+		{
+			name:        "empty user ID",
+			userID:      "",
+			expectError: true,
+			errorMsg:    "user_id is required and cannot be empty",
+		},
+		{
+			name:        "no history before as_of",
+			userID:      "user1",
+			expectError: true,
+			errorMsg:    "portfolio not found: user1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			historyRepo := &mockAllocationHistoryRepository{
+				getAsOfFunc: tt.mockGetAsOf,
+			}
+			service := NewPortfolioService(&mockPortfolioRepository{}, historyRepo, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
+
+			result, err := service.GetPortfolioAsOf(context.Background(), tt.userID, asOf)
+
+			if tt.expectError {
+				if err == nil || err.Error() != tt.errorMsg {
+					t.Errorf("expected error '%s', got '%v'", tt.errorMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			if result.Allocation["stocks"] != 70.0 || result.OriginalAllocation["stocks"] != 60.0 {
+				t.Errorf("unexpected portfolio reconstructed: %+v", result)
+			}
+			if result.ModelID != "balanced" || !result.Constraints["stocks"].NoSell || result.TotalValue != 10000 || result.Currency != "EUR" {
+				t.Errorf("expected the rest of the portfolio to be reconstructed, got %+v", result)
+			}
+		})
+	}
+}
+
+func TestCreatePortfolioFromModel(t *testing.T) {
+	model := &models.ModelPortfolio{
+		ID:         "balanced",
+		Name:       "Balanced 60/40",
+		Allocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+	}
+
+	tests := []struct {
+		name               string
+		portfolio          models.Portfolio
+		expectError        bool
+		errorMsg           string
+		expectedAllocation map[string]float64
+	}{
+		{
+			name:               "current allocation defaults to model",
+			portfolio:          models.Portfolio{UserID: "user1", ModelID: "balanced"},
+			expectedAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		},
+		{
+			name: "current allocation kept, target from model",
+			portfolio: models.Portfolio{
+				UserID:     "user1",
+				ModelID:    "balanced",
+				Allocation: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			},
+			expectedAllocation: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+		},
+		{
+			name:        "unknown model",
+			portfolio:   models.Portfolio{UserID: "user1", ModelID: "missing"},
+			expectError: true,
+			errorMsg:    "model portfolio not found: missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modelRepo := &mockModelPortfolioRepository{
+				getFunc: func(ctx context.Context, id string) (*models.ModelPortfolio, error) {
+					if id != model.ID {
+						return nil, repository.ErrNotFound
+					}
+					return model, nil
+				},
+			}
+			service := NewPortfolioService(&mockPortfolioRepository{}, &mockAllocationHistoryRepository{}, modelRepo, NewAssetService(&mockAssetRepository{}), nil)
+
+			result, err := service.CreatePortfolio(context.Background(), tt.portfolio)
+
+			if tt.expectError {
+				if err == nil || err.Error() != tt.errorMsg {
+					t.Errorf("expected error '%s', got '%v'", tt.errorMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			for asset, percent := range tt.expectedAllocation {
+				if result.Allocation[asset] != percent {
+					t.Errorf("expected allocation %s=%.2f, got %.2f", asset, percent, result.Allocation[asset])
+				}
+			}
+			for asset, percent := range model.Allocation {
+				if result.OriginalAllocation[asset] != percent {
+					t.Errorf("expected target %s=%.2f, got %.2f", asset, percent, result.OriginalAllocation[asset])
+				}
+			}
+		})
+	}
+}
+
+func TestCreatePortfolioWithAllocationTree(t *testing.T) {
+	tree := []models.AllocationNode{
+		{Asset: "equities", Percent: 60.0, Children: []models.AllocationNode{
+			{Asset: "us", Percent: 45.0},
+			{Asset: "em", Percent: 15.0},
+		}},
+		{Asset: "bonds", Percent: 40.0},
+	}
+
+	service := NewPortfolioService(&mockPortfolioRepository{}, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
+
+	result, err := service.CreatePortfolio(context.Background(), models.Portfolio{
+		UserID:         "user1",
+		AllocationTree: tree,
+	})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	expected := map[string]float64{"us": 45.0, "em": 15.0, "bonds": 40.0}
+	for asset, percent := range expected {
+		if result.Allocation[asset] != percent || result.OriginalAllocation[asset] != percent {
+			t.Errorf("expected %s=%.2f in allocation and target, got %.2f and %.2f",
+				asset, percent, result.Allocation[asset], result.OriginalAllocation[asset])
+		}
+	}
+	if _, exists := result.OriginalAllocation["equities"]; exists {
+		t.Errorf("expected only leaf assets in the flat target")
+	}
+
+	tree[0].Children[0].Percent = 40.0
+	if _, err := service.CreatePortfolio(context.Background(), models.Portfolio{
+		UserID:         "user1",
+		AllocationTree: tree,
+	}); err == nil || err.Error() != "children of equities must sum to 60.00%, got 55.00%" {
+		t.Errorf("expected children sum error, got %v", err)
+	}
+}
+
+func TestCreatePortfolioResolvesAssets(t *testing.T) {
+	service := NewPortfolioService(&mockPortfolioRepository{}, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(registeredAssets()), nil)
+
+	maxStocks := 70.0
+	result, err := service.CreatePortfolio(context.Background(), models.Portfolio{
+		UserID:      "user1",
+		Allocation:  map[string]float64{"stock": 60.0, "bond": 30.0, "gold": 10.0},
+		Constraints: map[string]models.AssetConstraint{"equities": {Max: &maxStocks}},
+	})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	expected := map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0}
+	for asset, percent := range expected {
+		if result.Allocation[asset] != percent || result.OriginalAllocation[asset] != percent {
+			t.Errorf("expected canonical %s=%.2f in allocation and target, got %.2f and %.2f",
+				asset, percent, result.Allocation[asset], result.OriginalAllocation[asset])
+		}
+	}
+	if _, exists := result.Constraints["stocks"]; !exists || len(result.Constraints) != 1 {
+		t.Errorf("expected constraint keyed by canonical asset, got %v", result.Constraints)
+	}
+
+	if _, err := service.CreatePortfolio(context.Background(), models.Portfolio{
+		UserID:     "user1",
+		Allocation: map[string]float64{"stocks": 60.0, "crypto": 40.0},
+	}); err == nil || err.Error() != "unknown asset crypto" {
+		t.Errorf("expected unknown asset error, got %v", err)
+	}
+}
+
+func TestCreatePortfolioWithSchedule(t *testing.T) {
+	service := NewPortfolioService(&mockPortfolioRepository{}, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, NewAssetService(&mockAssetRepository{}), nil)
+
+	result, err := service.CreatePortfolio(context.Background(), models.Portfolio{
+		UserID:     "user1",
+		Allocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		Schedule:   "quarterly",
+	})
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	next, err := time.Parse(time.RFC3339, result.NextRebalanceAt)
+	if err != nil {
+		t.Fatalf("expected next_rebalance_at to be set, got %q", result.NextRebalanceAt)
+	}
+	if next.Day() != 1 || next.Month()%3 != 1 || !next.After(time.Now()) {
+		t.Errorf("expected the first day of the next quarter, got %s", next)
+	}
+
+	if _, err := service.CreatePortfolio(context.Background(), models.Portfolio{
+		UserID:     "user1",
+		Allocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		Schedule:   "every now and then",
+	}); err == nil {
+		t.Errorf("expected invalid schedule error")
+	}
+}
@@ -9,31 +9,88 @@ import (
 	"portfolio-rebalancer/internal/messaging"
 	"portfolio-rebalancer/internal/models"
 	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/pkg/fx"
+	"portfolio-rebalancer/pkg/idgen"
+	"sort"
 	"time"
 )
 
 type RebalanceService interface {
 	CalculateRebalance(currentAllocation, targetAllocation map[string]float64, userID string) []models.RebalanceTransaction
+	CalculateDrift(currentAllocation map[string]float64, targetTree []models.AllocationNode) []models.AllocationDrift
+	ApplyConstraints(currentAllocation, targetAllocation map[string]float64, constraints map[string]models.AssetConstraint) (*models.ConstraintResult, error)
+	CalculateCashFlow(currentAllocation, targetAllocation map[string]float64, constraints map[string]models.AssetConstraint, flow models.CashFlow) (*models.CashFlowResult, error)
+	ConvertCashFlow(ctx context.Context, flow models.CashFlow, currency string) (models.CashFlow, error)
+	PairTransactions(transactions []models.RebalanceTransaction) []models.RebalanceSwitch
 	PublishRebalanceTransactions(ctx context.Context, transactions []models.RebalanceTransaction) error
+	SubmitRebalance(ctx context.Context, portfolio models.Portfolio, transactions []models.RebalanceTransaction, expected map[string]float64, actor string) (*models.Rebalance, error)
+	ApproveRebalance(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, error)
+	RejectRebalance(ctx context.Context, decision models.RebalanceDecision) (*models.Rebalance, error)
+	GetRebalance(ctx context.Context, id string) (*models.Rebalance, error)
+	ListRebalances(ctx context.Context, userID, status string) ([]models.Rebalance, error)
+	ListTransactions(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error)
 	ProcessTransactions(ctx context.Context, message []byte) error
 }
 
 type RebalanceServiceImpl struct {
-	transactionRepo repository.TransactionRepository
-	publisher       messaging.Publisher
+	transactionRepo  repository.TransactionRepository
+	rebalanceRepo    repository.RebalanceRepository
+	publisher        messaging.Publisher
+	assetService     AssetService
+	rates            fx.RateProvider
+	executionService ExecutionService
+	auditService     AuditService
+	newID            func() string
+	now              func() time.Time
 }
 
 // NewRebalanceService creates a new rebalance service instance
-func NewRebalanceService(transactionRepo repository.TransactionRepository, publisher messaging.Publisher) RebalanceService {
+func NewRebalanceService(
+	transactionRepo repository.TransactionRepository,
+	rebalanceRepo repository.RebalanceRepository,
+	publisher messaging.Publisher,
+	assetService AssetService,
+	rates fx.RateProvider,
+	executionService ExecutionService,
+	auditService AuditService,
+) RebalanceService {
 	return &RebalanceServiceImpl{
-		transactionRepo: transactionRepo,
-		publisher:       publisher,
+		transactionRepo:  transactionRepo,
+		rebalanceRepo:    rebalanceRepo,
+		publisher:        publisher,
+		assetService:     assetService,
+		rates:            rates,
+		executionService: executionService,
+		auditService:     auditService,
+		newID:            idgen.New,
+		now:              time.Now,
 	}
 }
 
+// nextID returns the ID shared by every leg of one rebalance
+func (s *RebalanceServiceImpl) nextID() string {
+	if s.newID == nil {
+		return idgen.New()
+	}
+	return s.newID()
+}
+
+// timestamp returns the current time in the format stored on transactions
+func (s *RebalanceServiceImpl) timestamp() string {
+	now := s.now
+	if now == nil {
+		now = time.Now
+	}
+	return now().UTC().Format(time.RFC3339)
+}
+
+// CalculateRebalance returns the BUY and SELL legs that move the current allocation to
+// the target. Legs are ordered sells first, then by size descending, then by asset name,
+// so the same allocations always produce the same output.
 func (s *RebalanceServiceImpl) CalculateRebalance(currentAllocation, targetAllocation map[string]float64, userID string) []models.RebalanceTransaction {
 	var transactions []models.RebalanceTransaction
-	timestamp := time.Now().UTC().Format(time.RFC3339)
+	rebalanceID := s.nextID()
+	timestamp := s.timestamp()
 
 	// Calculate the difference between current and target for each asset
 	for asset, targetPercent := range targetAllocation {
@@ -59,6 +116,7 @@ func (s *RebalanceServiceImpl) CalculateRebalance(currentAllocation, targetAlloc
 		}
 
 		transactions = append(transactions, models.RebalanceTransaction{
+			RebalanceID:      rebalanceID,
 			UserID:           userID,
 			Action:           action,
 			Asset:            asset,
@@ -71,6 +129,7 @@ func (s *RebalanceServiceImpl) CalculateRebalance(currentAllocation, targetAlloc
 	for asset, currentPercent := range currentAllocation {
 		if _, exists := targetAllocation[asset]; !exists && currentPercent > 0.01 {
 			transactions = append(transactions, models.RebalanceTransaction{
+				RebalanceID:      rebalanceID,
 				UserID:           userID,
 				Action:           "SELL",
 				Asset:            asset,
@@ -80,26 +139,147 @@ func (s *RebalanceServiceImpl) CalculateRebalance(currentAllocation, targetAlloc
 		}
 	}
 
+	sortTransactions(transactions)
 	return transactions
 }
 
+// sortTransactions orders legs sells before buys, then by size descending, then by asset name
+func sortTransactions(transactions []models.RebalanceTransaction) {
+	sort.SliceStable(transactions, func(i, j int) bool {
+		a, b := transactions[i], transactions[j]
+		if a.Action != b.Action {
+			return a.Action == "SELL"
+		}
+		if a.RebalancePercent != b.RebalancePercent {
+			return a.RebalancePercent > b.RebalancePercent
+		}
+		return a.Asset < b.Asset
+	})
+}
+
+// CalculateDrift aggregates the leaf-level current allocation up a hierarchical target
+// and reports the drift of every asset class. Current assets missing from the target
+// are reported as top-level classes with a zero target.
+func (s *RebalanceServiceImpl) CalculateDrift(currentAllocation map[string]float64, targetTree []models.AllocationNode) []models.AllocationDrift {
+	drift := driftNodes(currentAllocation, targetTree)
+
+	leaves := models.FlattenAllocation(targetTree)
+	var unknown []string
+	for asset := range currentAllocation {
+		if _, exists := leaves[asset]; !exists {
+			unknown = append(unknown, asset)
+		}
+	}
+	sort.Strings(unknown)
+
+	for _, asset := range unknown {
+		drift = append(drift, models.AllocationDrift{
+			Asset:   asset,
+			Current: currentAllocation[asset],
+			Drift:   currentAllocation[asset],
+		})
+	}
+
+	return drift
+}
+
+func driftNodes(currentAllocation map[string]float64, nodes []models.AllocationNode) []models.AllocationDrift {
+	drift := make([]models.AllocationDrift, 0, len(nodes))
+	for _, node := range nodes {
+		d := models.AllocationDrift{
+			Asset:  node.Asset,
+			Target: node.Percent,
+		}
+
+		if len(node.Children) == 0 {
+			d.Current = currentAllocation[node.Asset]
+		} else {
+			d.Children = driftNodes(currentAllocation, node.Children)
+			for _, child := range d.Children {
+				d.Current += child.Current
+			}
+		}
+
+		d.Drift = d.Current - d.Target
+		drift = append(drift, d)
+	}
+	return drift
+}
+
+// PublishRebalanceTransactions enriches the transactions from the asset registry and
+// queues them for processing
 func (s *RebalanceServiceImpl) PublishRebalanceTransactions(ctx context.Context, transactions []models.RebalanceTransaction) error {
 	if len(transactions) == 0 {
 		return nil
 	}
 
+	transactions, err := s.enrichTransactions(ctx, transactions)
+	if err != nil {
+		return err
+	}
+
 	payload, err := json.Marshal(transactions)
 	if err != nil {
 		return fmt.Errorf("failed to marshal transactions: %w", err)
 	}
 
 	if err := s.publisher.Publish(ctx, payload); err != nil {
-		return fmt.Errorf("failed to publish transactions: %w", err)
+		return &DependencyUnavailableError{Dependency: DependencyKafka, Err: fmt.Errorf("failed to publish transactions: %w", err)}
 	}
 
 	return nil
 }
 
+// enrichTransactions returns copies of the transactions carrying the canonical asset ID,
+// display name and class. Amounts are converted into the asset's currency and the rate
+// used is recorded, and a missing rate is an FXError. Assets missing from the registry,
+// such as cash, are left as-is.
+func (s *RebalanceServiceImpl) enrichTransactions(ctx context.Context, transactions []models.RebalanceTransaction) ([]models.RebalanceTransaction, error) {
+	registry, err := s.assetService.Registry(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load asset registry: %w", err)
+	}
+
+	enriched := make([]models.RebalanceTransaction, len(transactions))
+	for i, tx := range transactions {
+		assetCurrency := tx.Currency
+		if asset, ok := registry.Lookup(tx.Asset); ok {
+			tx.Asset = asset.ID
+			tx.AssetName = asset.Name
+			tx.AssetClass = asset.Class
+			if asset.Currency != "" {
+				assetCurrency = asset.Currency
+			}
+		}
+
+		if tx.Amount > 0 && tx.Currency != "" {
+			tx.AssetCurrency = assetCurrency
+			tx.AssetAmount = tx.Amount
+			if assetCurrency != tx.Currency {
+				rate, err := s.rates.Rate(ctx, tx.Currency, assetCurrency)
+				if err != nil {
+					return nil, &FXError{From: tx.Currency, To: assetCurrency, Err: fmt.Errorf("failed to convert %s amount for %s: %w", tx.Currency, tx.Asset, err)}
+				}
+				tx.AssetAmount = tx.Amount * rate.Rate
+				tx.FXRates = append(append([]models.FXRate(nil), tx.FXRates...), fxRate(rate))
+			}
+		}
+		enriched[i] = tx
+	}
+	return enriched, nil
+}
+
+// fxRate records a provider rate on a transaction
+func fxRate(rate fx.Rate) models.FXRate {
+	return models.FXRate{
+		From:   rate.From,
+		To:     rate.To,
+		Rate:   rate.Rate,
+		AsOf:   rate.AsOf,
+		Source: rate.Source,
+	}
+}
+
 // ProcessTransactions processes rebalance transactions from Kafka message
 func (s *RebalanceServiceImpl) ProcessTransactions(ctx context.Context, message []byte) error {
 	// Skip empty or invalid messages (e.g., health check pings)
@@ -121,13 +301,18 @@ func (s *RebalanceServiceImpl) ProcessTransactions(ctx context.Context, message
 
 	log.Printf("Processing %d rebalance transactions from consumer kafka", len(transactions))
 
-	// Save each transaction to Elasticsearch
+	// Group the legs by rebalance so each rebalance is executed and recorded as a whole
+	var order []string
+	groups := make(map[string][]models.RebalanceTransaction)
 	for _, tx := range transactions {
-		if err := s.transactionRepo.SaveTransaction(ctx, tx); err != nil {
-			log.Printf("Failed to save transaction for user %s, asset %s: %v", tx.UserID, tx.Asset, err)
-			// Continue processing other transactions even if one fails
-			continue
+		if _, seen := groups[tx.RebalanceID]; !seen {
+			order = append(order, tx.RebalanceID)
 		}
+		groups[tx.RebalanceID] = append(groups[tx.RebalanceID], tx)
+	}
+
+	for _, id := range order {
+		s.processRebalance(ctx, id, groups[id])
 	}
 
 	log.Printf("Successfully processed %d transactions", len(transactions))
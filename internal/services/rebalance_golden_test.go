@@ -0,0 +1,140 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// TestCalculateRebalanceGolden pins the exact output of the calculator, including
+// transaction order. Run with -update after an intended change and review the diff.
+func TestCalculateRebalanceGolden(t *testing.T) {
+	tests := []struct {
+		name              string
+		currentAllocation map[string]float64
+		targetAllocation  map[string]float64
+	}{
+		{
+			name:              "buy_and_sell",
+			currentAllocation: map[string]float64{"stocks": 70.0, "bonds": 20.0, "gold": 10.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+		},
+		{
+			name:              "already_balanced",
+			currentAllocation: map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+		},
+		{
+			name:              "within_tolerance",
+			currentAllocation: map[string]float64{"stocks": 60.005, "bonds": 29.995, "gold": 10.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+		},
+		{
+			name:              "mixed_tolerance",
+			currentAllocation: map[string]float64{"stocks": 60.005, "bonds": 25.0, "gold": 14.995},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+		},
+		{
+			name:              "sell_asset_missing_from_target",
+			currentAllocation: map[string]float64{"stocks": 50.0, "bonds": 30.0, "crypto": 20.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 40.0},
+		},
+		{
+			name:              "buy_asset_missing_from_current",
+			currentAllocation: map[string]float64{"stocks": 70.0, "bonds": 30.0},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+		},
+		{
+			name:              "equal_legs_ordered_by_name",
+			currentAllocation: map[string]float64{"a": 30.0, "b": 30.0, "c": 20.0, "d": 20.0},
+			targetAllocation:  map[string]float64{"a": 25.0, "b": 25.0, "c": 25.0, "d": 25.0},
+		},
+		{
+			name: "many_assets",
+			currentAllocation: map[string]float64{
+				"us": 32.5, "intl": 12.25, "em": 8.0, "bonds": 22.0, "tips": 4.25, "gold": 6.0, "reit": 9.0, "cash": 6.0,
+			},
+			targetAllocation: map[string]float64{
+				"us": 30.0, "intl": 15.0, "em": 5.0, "bonds": 25.0, "tips": 5.0, "gold": 5.0, "reit": 10.0, "cash": 5.0,
+			},
+		},
+		{
+			name:              "liquidate_everything",
+			currentAllocation: map[string]float64{"stocks": 60.0, "bonds": 40.0},
+			targetAllocation:  map[string]float64{},
+		},
+		{
+			name:              "invest_from_nothing",
+			currentAllocation: map[string]float64{},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+		},
+		{
+			name:              "swap_entire_holdings",
+			currentAllocation: map[string]float64{"stocks": 100.0},
+			targetAllocation:  map[string]float64{"bonds": 100.0},
+		},
+		{
+			name:              "fractional_drift",
+			currentAllocation: map[string]float64{"stocks": 61.37, "bonds": 28.91, "gold": 9.72},
+			targetAllocation:  map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+		},
+	}
+
+	service := &RebalanceServiceImpl{
+		newID: func() string { return "rebalance-1" },
+		now:   func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) },
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transactions := service.CalculateRebalance(tt.currentAllocation, tt.targetAllocation, "user1")
+
+			got, err := json.MarshalIndent(transactions, "", "  ")
+			if err != nil {
+				t.Fatalf("failed to marshal transactions: %v", err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", "calculate_rebalance", tt.name+".golden.json")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatalf("failed to create testdata: %v", err)
+				}
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatalf("failed to write golden file: %v", err)
+				}
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read golden file (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s\ngot:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+func TestCalculateRebalanceIsStable(t *testing.T) {
+	service := &RebalanceServiceImpl{
+		newID: func() string { return "rebalance-1" },
+		now:   func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) },
+	}
+	current := map[string]float64{"a": 30.0, "b": 30.0, "c": 20.0, "d": 20.0}
+	target := map[string]float64{"a": 25.0, "b": 25.0, "c": 25.0, "d": 25.0}
+
+	first, _ := json.Marshal(service.CalculateRebalance(current, target, "user1"))
+	for i := 0; i < 50; i++ {
+		next, _ := json.Marshal(service.CalculateRebalance(current, target, "user1"))
+		if !bytes.Equal(first, next) {
+			t.Fatalf("expected identical output on every call, got\n%s\n%s", first, next)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"portfolio-rebalancer/internal/models"
+)
+
+// RebalancePlan is what an allocation reported by the provider takes a portfolio to
+type RebalancePlan struct {
+	Portfolio    *models.Portfolio
+	Allocation   map[string]float64 // reported allocation, with aliases resolved
+	Target       map[string]float64 // target, moved to satisfy the portfolio's constraints
+	Transactions []models.RebalanceTransaction
+	Constraints  *models.ConstraintResult
+	Validation   *models.AssetValidation
+	Drift        []models.AllocationDrift // only for hierarchical targets
+	Rebalance    *models.Rebalance        // set once submitted, nil when nothing needs trading
+}
+
+// RebalancePlanner turns an allocation reported by the provider into a rebalance, so the
+// HTTP and gRPC APIs check, calculate and record it the same way
+type RebalancePlanner interface {
+	Plan(ctx context.Context, userID string, allocation map[string]float64) (*RebalancePlan, error)
+	Rebalance(ctx context.Context, userID string, allocation map[string]float64) (*RebalancePlan, error)
+}
+
+type RebalancePlannerImpl struct {
+	portfolioService PortfolioService
+	rebalanceService RebalanceService
+	assetValidator   AssetValidator
+}
+
+// NewRebalancePlanner creates a new rebalance planner instance
+func NewRebalancePlanner(
+	portfolioService PortfolioService,
+	rebalanceService RebalanceService,
+	assetValidator AssetValidator,
+) RebalancePlanner {
+	return &RebalancePlannerImpl{
+		portfolioService: portfolioService,
+		rebalanceService: rebalanceService,
+		assetValidator:   assetValidator,
+	}
+}
+
+// Plan checks a reported allocation against the portfolio and calculates the transactions
+// taking it back to its target, without submitting them
+func (s *RebalancePlannerImpl) Plan(ctx context.Context, userID string, allocation map[string]float64) (*RebalancePlan, error) {
+	if userID == "" {
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+	if len(allocation) == 0 {
+		return nil, invalid("new_allocation", "new_allocation is required and cannot be empty")
+	}
+	if err := models.ValidateAllocation(allocation); err != nil {
+		return nil, invalidField("new_allocation", err)
+	}
+
+	portfolio, err := s.portfolioService.GetPortfolio(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A typo in an asset name would otherwise sell the typo and buy the real asset in full
+	validation, err := s.assetValidator.ValidateAllocation(ctx, allocation, *portfolio)
+	var universeErr *AssetUniverseError
+	if errors.As(err, &universeErr) {
+		return nil, &ValidationError{
+			Message: "new_allocation does not match the portfolio's assets",
+			Fields:  assetFieldErrors(universeErr),
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if validation.HasIssues() {
+		log.Printf("Accepted allocation for user %s with unknown %v and missing %v assets", userID, validation.Unknown, validation.Missing)
+	}
+
+	plan := &RebalancePlan{
+		Portfolio:  portfolio,
+		Allocation: validation.Allocation,
+		Target:     portfolio.OriginalAllocation,
+		Validation: validation,
+	}
+
+	// Constraints may move the target to the closest allocation the portfolio is allowed to hold
+	if len(portfolio.Constraints) > 0 {
+		plan.Constraints, err = s.rebalanceService.ApplyConstraints(plan.Allocation, plan.Target, portfolio.Constraints)
+		if err != nil {
+			return nil, err
+		}
+		plan.Target = plan.Constraints.Target
+	}
+
+	// Compare the reported allocation against the target to know what to BUY/SELL
+	plan.Transactions = s.rebalanceService.CalculateRebalance(plan.Allocation, plan.Target, userID)
+
+	// Hierarchical targets also get drift reported for every asset class level
+	if len(portfolio.AllocationTree) > 0 {
+		plan.Drift = s.rebalanceService.CalculateDrift(plan.Allocation, portfolio.AllocationTree)
+	}
+
+	return plan, nil
+}
+
+// Rebalance plans a reported allocation, publishes the transactions or holds them for
+// approval, then records the reported allocation on the portfolio
+func (s *RebalancePlannerImpl) Rebalance(ctx context.Context, userID string, allocation map[string]float64) (*RebalancePlan, error) {
+	plan, err := s.Plan(ctx, userID, allocation)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.Rebalance, err = s.rebalanceService.SubmitRebalance(ctx, *plan.Portfolio, plan.Transactions, plan.Target, models.ActorProvider)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Rebalance != nil {
+		plan.Transactions = plan.Rebalance.Transactions
+		log.Printf("Submitted %d transactions for user %s as %s", len(plan.Transactions), userID, plan.Rebalance.Status)
+	} else {
+		log.Printf("No rebalancing needed for user %s - portfolio already at target allocation", userID)
+	}
+
+	// Only the reported allocation is written, so fields changed since the read, like a
+	// claimed schedule period, are kept
+	reported := models.Portfolio{UserID: userID, Allocation: plan.Allocation}
+	if err := s.portfolioService.UpdatePortfolio(ctx, reported); err != nil {
+		log.Printf("Failed to update portfolio for user %s: %v", userID, err)
+		// Don't fail the rebalance, transactions are already queued
+	}
+
+	return plan, nil
+}
+
+// assetFieldErrors lists every unknown and missing asset as a field of new_allocation
+func assetFieldErrors(err *AssetUniverseError) []FieldError {
+	fields := make([]FieldError, 0, len(err.Unknown)+len(err.Missing))
+	for _, asset := range err.Unknown {
+		fields = append(fields, FieldError{Field: "new_allocation." + asset, Message: "unknown asset"})
+	}
+	for _, asset := range err.Missing {
+		fields = append(fields, FieldError{Field: "new_allocation." + asset, Message: "missing asset held or targeted by the portfolio"})
+	}
+	return fields
+}
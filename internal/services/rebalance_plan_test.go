@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/pkg/fx"
+)
+
+// newTestPlanner plans against portfolioRepo, publishing every rebalance
+func newTestPlanner(portfolioRepo *mockPortfolioRepository) RebalancePlanner {
+	assetService := NewAssetService(&mockAssetRepository{})
+	portfolioService := NewPortfolioService(portfolioRepo, &mockAllocationHistoryRepository{}, &mockModelPortfolioRepository{}, assetService, nil)
+	rebalanceService := NewRebalanceService(&mockTransactionRepository{}, &mockRebalanceRepository{}, &mockPublisher{}, assetService, fx.NewMemoryProvider("test"), nil, nil)
+	validator := NewAssetValidator(AssetConfig{Mode: models.AssetValidationStrict}, assetService)
+	return NewRebalancePlanner(portfolioService, rebalanceService, validator)
+}
+
+func TestRebalancePlannerRecordsReportedAllocation(t *testing.T) {
+	// The schedule is claimed by the scheduler between the planner's read and its write
+	reads := 0
+	var saved *models.Portfolio
+	portfolioRepo := &mockPortfolioRepository{
+		getByUserIDFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+			reads++
+			portfolio, _ := existingPortfolio(ctx, userID)
+			portfolio.Schedule = "monthly"
+			portfolio.NextRebalanceAt = "2024-06-01T00:00:00Z"
+			if reads > 1 {
+				portfolio.NextRebalanceAt = "2024-07-01T00:00:00Z"
+			}
+			return portfolio, nil
+		},
+		saveFunc: func(ctx context.Context, portfolio models.Portfolio) error {
+			saved = &portfolio
+			return nil
+		},
+	}
+
+	plan, err := newTestPlanner(portfolioRepo).Rebalance(context.Background(), "user1", map[string]float64{"stocks": 70.0, "bonds": 30.0})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.Rebalance == nil || len(plan.Transactions) != 2 {
+		t.Errorf("expected a submitted rebalance with 2 transactions, got %+v", plan)
+	}
+
+	if saved == nil {
+		t.Fatal("expected the reported allocation to be recorded")
+	}
+	if !reflect.DeepEqual(saved.Allocation, map[string]float64{"stocks": 70.0, "bonds": 30.0}) {
+		t.Errorf("expected the reported allocation to be recorded, got %v", saved.Allocation)
+	}
+	if saved.NextRebalanceAt != "2024-07-01T00:00:00Z" {
+		t.Errorf("expected the claimed schedule period to be kept, got %s", saved.NextRebalanceAt)
+	}
+}
+
+func TestRebalancePlannerRejectsUnknownAssets(t *testing.T) {
+	saves := 0
+	portfolioRepo := &mockPortfolioRepository{
+		getByUserIDFunc: existingPortfolio,
+		saveFunc: func(ctx context.Context, portfolio models.Portfolio) error {
+			saves++
+			return nil
+		},
+	}
+
+	_, err := newTestPlanner(portfolioRepo).Rebalance(context.Background(), "user1", map[string]float64{"stonks": 60.0, "bonds": 40.0})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	expected := []FieldError{
+		{Field: "new_allocation.stonks", Message: "unknown asset"},
+		{Field: "new_allocation.stocks", Message: "missing asset held or targeted by the portfolio"},
+	}
+	if !reflect.DeepEqual(validationErr.Fields, expected) {
+		t.Errorf("expected fields %v, got %v", expected, validationErr.Fields)
+	}
+	if saves != 0 {
+		t.Errorf("expected nothing to be recorded, got %d saves", saves)
+	}
+}
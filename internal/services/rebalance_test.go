@@ -5,13 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"testing"
+	"time"
 
 	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/pkg/fx"
 )
 
 // Mock transaction repository
 type mockTransactionRepository struct {
 	saveFunc func(ctx context.Context, tx models.RebalanceTransaction) error
+	listFunc func(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error)
 }
 
 func (m *mockTransactionRepository) SaveTransaction(ctx context.Context, tx models.RebalanceTransaction) error {
@@ -21,6 +24,13 @@ func (m *mockTransactionRepository) SaveTransaction(ctx context.Context, tx mode
 	return nil
 }
 
+func (m *mockTransactionRepository) ListByUserID(ctx context.Context, userID string, from, to time.Time) ([]models.RebalanceTransaction, error) {
+	if m.listFunc != nil {
+		return m.listFunc(ctx, userID, from, to)
+	}
+	return nil, nil
+}
+
 // Mock publisher
 type mockPublisher struct {
 	publishFunc func(ctx context.Context, message []byte) error
@@ -135,7 +145,7 @@ func TestCalculateRebalance(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockRepo := &mockTransactionRepository{}
 			mockPub := &mockPublisher{}
-			service := NewRebalanceService(mockRepo, mockPub)
+			service := NewRebalanceService(mockRepo, &mockRebalanceRepository{}, mockPub, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
 
 			transactions := service.CalculateRebalance(tt.currentAllocation, tt.targetAllocation, tt.userID)
 
@@ -206,7 +216,7 @@ func TestPublishRebalanceTransactions(t *testing.T) {
 			mockPub := &mockPublisher{
 				publishFunc: tt.mockPublish,
 			}
-			service := NewRebalanceService(mockRepo, mockPub)
+			service := NewRebalanceService(mockRepo, &mockRebalanceRepository{}, mockPub, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
 
 			err := service.PublishRebalanceTransactions(context.Background(), tt.transactions)
 
@@ -227,6 +237,37 @@ func TestPublishRebalanceTransactions(t *testing.T) {
 	}
 }
 
+func TestPublishEnrichesTransactions(t *testing.T) {
+	var published []models.RebalanceTransaction
+	mockPub := &mockPublisher{
+		publishFunc: func(ctx context.Context, message []byte) error {
+			return json.Unmarshal(message, &published)
+		},
+	}
+	service := NewRebalanceService(&mockTransactionRepository{}, &mockRebalanceRepository{}, mockPub, NewAssetService(registeredAssets()), fx.NewMemoryProvider("test"), nil, nil)
+
+	transactions := []models.RebalanceTransaction{
+		{UserID: "user1", Action: "SELL", Asset: "stock", RebalancePercent: 10.0},
+		{UserID: "user1", Action: "BUY", Asset: "cash", RebalancePercent: 10.0},
+	}
+	if err := service.PublishRebalanceTransactions(context.Background(), transactions); err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+
+	if len(published) != 2 {
+		t.Fatalf("expected 2 published transactions, got %d", len(published))
+	}
+	if published[0].Asset != "stocks" || published[0].AssetName != "Global Equity" || published[0].AssetClass != "equity" {
+		t.Errorf("expected registry metadata on the stocks leg, got %+v", published[0])
+	}
+	if published[1].Asset != "cash" || published[1].AssetClass != "" {
+		t.Errorf("expected unregistered asset to be left as-is, got %+v", published[1])
+	}
+	if transactions[0].Asset != "stock" {
+		t.Errorf("expected the caller's transactions to be left untouched")
+	}
+}
+
 func TestProcessTransactions(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -298,7 +339,7 @@ func TestProcessTransactions(t *testing.T) {
 				saveFunc: tt.mockSave,
 			}
 			mockPub := &mockPublisher{}
-			service := NewRebalanceService(mockRepo, mockPub)
+			service := NewRebalanceService(mockRepo, &mockRebalanceRepository{}, mockPub, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
 
 			err := service.ProcessTransactions(context.Background(), tt.message)
 
@@ -314,3 +355,50 @@ func TestProcessTransactions(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculateDrift(t *testing.T) {
+	tree := []models.AllocationNode{
+		{Asset: "equities", Percent: 60.0, Children: []models.AllocationNode{
+			{Asset: "us", Percent: 40.0},
+			{Asset: "em", Percent: 20.0},
+		}},
+		{Asset: "bonds", Percent: 40.0, Children: []models.AllocationNode{
+			{Asset: "govt", Percent: 25.0},
+			{Asset: "corp", Percent: 15.0},
+		}},
+	}
+	current := map[string]float64{
+		"us":   50.0,
+		"em":   15.0,
+		"govt": 20.0,
+		"corp": 10.0,
+		"gold": 5.0,
+	}
+
+	service := NewRebalanceService(&mockTransactionRepository{}, &mockRebalanceRepository{}, &mockPublisher{}, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
+	drift := service.CalculateDrift(current, tree)
+
+	if len(drift) != 3 {
+		t.Fatalf("expected 3 top-level classes, got %d", len(drift))
+	}
+
+	expected := []struct {
+		asset   string
+		current float64
+		drift   float64
+	}{
+		{"equities", 65.0, 5.0},
+		{"bonds", 30.0, -10.0},
+		{"gold", 5.0, 5.0},
+	}
+	for i, e := range expected {
+		if drift[i].Asset != e.asset || drift[i].Current != e.current || drift[i].Drift != e.drift {
+			t.Errorf("expected %s current=%.2f drift=%.2f, got %+v", e.asset, e.current, e.drift, drift[i])
+		}
+	}
+
+	us := drift[0].Children[0]
+	if us.Asset != "us" || us.Drift != 10.0 {
+		t.Errorf("expected leaf us drift 10.00, got %+v", us)
+	}
+}
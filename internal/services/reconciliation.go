@@ -0,0 +1,350 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/pkg/idgen"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// reconciliationLock is the lock a replica must hold to run the reconciliation job
+const reconciliationLock = "reconciliation"
+
+// reportedSources are the history sources that record an allocation someone observed,
+// as opposed to one the service expects after queuing trades
+var reportedSources = []string{models.AllocationSourceUser, models.AllocationSourceProvider}
+
+type ReconciliationService interface {
+	Reconcile(ctx context.Context, userID string) (*models.ReconciliationReport, error)
+	RunAll(ctx context.Context) (int, error)
+	Start(ctx context.Context)
+	ListReports(ctx context.Context, userID string) ([]models.ReconciliationReport, error)
+	Summary(ctx context.Context, since time.Time) (*models.ReconciliationSummary, error)
+}
+
+// ReconciliationConfig controls how often portfolios are reconciled and how far an
+// asset may be off target before it is flagged
+type ReconciliationConfig struct {
+	Interval  time.Duration
+	Tolerance float64       // percentage points
+	LockTTL   time.Duration // must exceed the longest run, or another replica may take over
+	Owner     string        // identifies this replica in the lock
+}
+
+// ReconciliationConfigFromEnv reads RECONCILIATION_INTERVAL (default 1h) and
+// RECONCILIATION_LOCK_TTL (default 10m) as Go durations, and RECONCILIATION_TOLERANCE
+// in percentage points (default 1). The owner is the hostname plus a random suffix.
+func ReconciliationConfigFromEnv() (ReconciliationConfig, error) {
+	config := ReconciliationConfig{
+		Interval:  time.Hour,
+		Tolerance: 1,
+		LockTTL:   10 * time.Minute,
+	}
+
+	for key, target := range map[string]*time.Duration{
+		"RECONCILIATION_INTERVAL": &config.Interval,
+		"RECONCILIATION_LOCK_TTL": &config.LockTTL,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return config, fmt.Errorf("invalid %s %q, expected a positive duration such as 1h", key, value)
+		}
+		*target = d
+	}
+
+	if value := os.Getenv("RECONCILIATION_TOLERANCE"); value != "" {
+		tolerance, err := strconv.ParseFloat(value, 64)
+		if err != nil || tolerance < 0 || tolerance > 100 {
+			return config, fmt.Errorf("invalid RECONCILIATION_TOLERANCE %q, expected percentage points between 0 and 100", value)
+		}
+		config.Tolerance = tolerance
+	}
+
+	hostname, _ := os.Hostname()
+	config.Owner = hostname + "-" + idgen.New()
+
+	return config, nil
+}
+
+// ReconciliationServiceImpl checks that executed rebalances took portfolios to their target
+type ReconciliationServiceImpl struct {
+	portfolioRepository      repository.PortfolioRepository
+	historyRepository        repository.AllocationHistoryRepository
+	executionRepository      repository.ExecutionRepository
+	rebalanceRepository      repository.RebalanceRepository
+	reconciliationRepository repository.ReconciliationRepository
+	lockRepository           repository.LockRepository
+	config                   ReconciliationConfig
+	now                      func() time.Time
+}
+
+// NewReconciliationService creates a new reconciliation service instance
+func NewReconciliationService(
+	portfolioRepository repository.PortfolioRepository,
+	historyRepository repository.AllocationHistoryRepository,
+	executionRepository repository.ExecutionRepository,
+	rebalanceRepository repository.RebalanceRepository,
+	reconciliationRepository repository.ReconciliationRepository,
+	lockRepository repository.LockRepository,
+	config ReconciliationConfig,
+) ReconciliationService {
+	return &ReconciliationServiceImpl{
+		portfolioRepository:      portfolioRepository,
+		historyRepository:        historyRepository,
+		executionRepository:      executionRepository,
+		rebalanceRepository:      rebalanceRepository,
+		reconciliationRepository: reconciliationRepository,
+		lockRepository:           lockRepository,
+		config:                   config,
+		now:                      time.Now,
+	}
+}
+
+// Start reconciles every portfolio every config.Interval in a background goroutine until ctx is done
+func (s *ReconciliationServiceImpl) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		log.Printf("Reconciliation started, running every %s", s.config.Interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Reconciliation shutting down")
+				return
+			case <-ticker.C:
+				if n, err := s.RunAll(ctx); err != nil {
+					log.Printf("Reconciliation run failed: %v", err)
+				} else if n > 0 {
+					log.Printf("Reconciled %d portfolios", n)
+				}
+			}
+		}
+	}()
+}
+
+// RunAll reconciles every portfolio and returns how many reports were stored. Only the
+// replica holding the reconciliation lock runs; the others return immediately.
+func (s *ReconciliationServiceImpl) RunAll(ctx context.Context) (int, error) {
+	acquired, err := s.lockRepository.Acquire(ctx, reconciliationLock, s.config.Owner, s.config.LockTTL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire reconciliation lock: %w", err)
+	}
+	if !acquired {
+		return 0, nil
+	}
+	defer func() {
+		if err := s.lockRepository.Release(ctx, reconciliationLock, s.config.Owner); err != nil {
+			log.Printf("Failed to release reconciliation lock: %v", err)
+		}
+	}()
+
+	portfolios, err := s.portfolioRepository.ListAll(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list portfolios: %w", err)
+	}
+
+	reconciled := 0
+	for _, portfolio := range portfolios {
+		if _, err := s.reconcile(ctx, portfolio); err != nil {
+			log.Printf("Reconciliation failed for user %s: %v", portfolio.UserID, err)
+			continue
+		}
+		reconciled++
+	}
+
+	return reconciled, nil
+}
+
+// Reconcile reconciles one portfolio now and stores the report
+func (s *ReconciliationServiceImpl) Reconcile(ctx context.Context, userID string) (*models.ReconciliationReport, error) {
+	if userID == "" {
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+
+	portfolio, err := s.portfolioRepository.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, lookupError("portfolio", userID, err)
+	}
+
+	return s.reconcile(ctx, *portfolio)
+}
+
+// ListReports lists a user's reconciliation reports, newest first
+func (s *ReconciliationServiceImpl) ListReports(ctx context.Context, userID string) ([]models.ReconciliationReport, error) {
+	if userID == "" {
+		return nil, invalid("user_id", "user_id is required and cannot be empty")
+	}
+
+	reports, err := s.reconciliationRepository.ListByUserID(ctx, userID)
+	if err != nil {
+		return nil, storageError("failed to list reconciliation reports: %w", err)
+	}
+
+	return reports, nil
+}
+
+// Summary counts the latest report of every portfolio reconciled since the given time
+func (s *ReconciliationServiceImpl) Summary(ctx context.Context, since time.Time) (*models.ReconciliationSummary, error) {
+	reports, err := s.reconciliationRepository.ListLatest(ctx, since)
+	if err != nil {
+		return nil, storageError("failed to list reconciliation reports: %w", err)
+	}
+
+	summary := &models.ReconciliationSummary{
+		Since:      since.UTC().Format(time.RFC3339),
+		Portfolios: len(reports),
+		BreakUsers: []string{},
+	}
+	for _, report := range reports {
+		switch report.Status {
+		case models.ReconciliationStatusMatched:
+			summary.Matched++
+		case models.ReconciliationStatusBreak:
+			summary.Breaks++
+			summary.BreakUsers = append(summary.BreakUsers, report.UserID)
+		case models.ReconciliationStatusPending:
+			summary.Pending++
+		}
+	}
+	sort.Strings(summary.BreakUsers)
+
+	return summary, nil
+}
+
+// reconcile compares the portfolio's target with its last reported allocation plus
+// every execution since that report. Portfolios without a reported allocation in their
+// history are compared using their stored allocation as-is.
+func (s *ReconciliationServiceImpl) reconcile(ctx context.Context, portfolio models.Portfolio) (*models.ReconciliationReport, error) {
+	now := s.now().UTC()
+	report := models.ReconciliationReport{
+		ID:        idgen.New(),
+		UserID:    portfolio.UserID,
+		Target:    portfolio.OriginalAllocation,
+		Reported:  portfolio.Allocation,
+		Tolerance: s.config.Tolerance,
+		CreatedAt: now.Format(time.RFC3339),
+	}
+
+	var executions []models.Execution
+	if record, err := s.historyRepository.GetLatestBySource(ctx, portfolio.UserID, reportedSources); err == nil {
+		report.Reported = record.Allocation
+		report.ReportedAt = record.Timestamp
+
+		reportedAt, err := time.Parse(time.RFC3339Nano, record.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid history timestamp %q: %w", record.Timestamp, err)
+		}
+		// Executions are stored to the second, so one in the same second as the report counts
+		executions, err = s.executionRepository.ListByUserID(ctx, portfolio.UserID, reportedAt.Truncate(time.Second))
+		if err != nil {
+			return nil, storageError("failed to list executions: %w", err)
+		}
+	}
+
+	report.Actual = applyExecutions(report.Reported, executions)
+	report.Executions = len(executions)
+	report.Breaks = allocationBreaks(report.Target, report.Actual, s.config.Tolerance)
+
+	report.Status = models.ReconciliationStatusMatched
+	if len(report.Breaks) > 0 {
+		report.Status = models.ReconciliationStatusBreak
+		// Trades still waiting for approval or the broker explain the gap for now
+		pending, err := s.hasPendingRebalance(ctx, portfolio.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if pending {
+			report.Status = models.ReconciliationStatusPending
+		}
+	}
+
+	if err := s.reconciliationRepository.Save(ctx, report); err != nil {
+		return nil, storageError("failed to save reconciliation report: %w", err)
+	}
+	if report.Status == models.ReconciliationStatusBreak {
+		log.Printf("Reconciliation break for user %s: %d assets off target by more than %.2f%%", report.UserID, len(report.Breaks), report.Tolerance)
+	}
+
+	return &report, nil
+}
+
+// hasPendingRebalance reports whether the user has a rebalance that is proposed or approved but not yet executed
+func (s *ReconciliationServiceImpl) hasPendingRebalance(ctx context.Context, userID string) (bool, error) {
+	for _, status := range []string{models.RebalanceStatusProposed, models.RebalanceStatusApproved} {
+		rebalances, err := s.rebalanceRepository.ListByUserID(ctx, userID, status)
+		if err != nil {
+			return false, storageError("failed to list rebalances: %w", err)
+		}
+		if len(rebalances) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// applyExecutions moves the filled part of each execution into or out of its asset,
+// settling against cash. Cash spent beyond what the sells raised came from outside the
+// portfolio, e.g. a deposit, so the result is scaled back to 100% instead.
+func applyExecutions(reported map[string]float64, executions []models.Execution) map[string]float64 {
+	actual := copyAllocation(reported)
+	for _, e := range executions {
+		switch e.Action {
+		case "BUY":
+			actual[e.Asset] += e.FilledPercent
+			actual[models.CashAsset] -= e.FilledPercent
+		case "SELL":
+			actual[e.Asset] -= e.FilledPercent
+			actual[models.CashAsset] += e.FilledPercent
+		}
+	}
+
+	return normalizeAllocation(actual)
+}
+
+// allocationBreaks lists the assets whose actual allocation differs from the target by
+// more than tolerance, largest difference first. Assets missing on either side count as 0.
+func allocationBreaks(target, actual map[string]float64, tolerance float64) []models.ReconciliationBreak {
+	assets := make(map[string]bool, len(target)+len(actual))
+	for asset := range target {
+		assets[asset] = true
+	}
+	for asset := range actual {
+		assets[asset] = true
+	}
+
+	var breaks []models.ReconciliationBreak
+	for asset := range assets {
+		difference := actual[asset] - target[asset]
+		if math.Abs(difference) <= tolerance+executionEpsilon {
+			continue
+		}
+		breaks = append(breaks, models.ReconciliationBreak{
+			Asset:      asset,
+			Target:     target[asset],
+			Actual:     actual[asset],
+			Difference: difference,
+		})
+	}
+
+	sort.Slice(breaks, func(i, j int) bool {
+		di, dj := math.Abs(breaks[i].Difference), math.Abs(breaks[j].Difference)
+		if di != dj {
+			return di > dj
+		}
+		return breaks[i].Asset < breaks[j].Asset
+	})
+
+	return breaks
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+)
+
+// Mock reconciliation repository, keeping saved reports in memory
+type mockReconciliationRepository struct {
+	reports []models.ReconciliationReport
+}
+
+func (m *mockReconciliationRepository) Save(ctx context.Context, report models.ReconciliationReport) error {
+	m.reports = append(m.reports, report)
+	return nil
+}
+
+func (m *mockReconciliationRepository) ListByUserID(ctx context.Context, userID string) ([]models.ReconciliationReport, error) {
+	var reports []models.ReconciliationReport
+	for i := len(m.reports) - 1; i >= 0; i-- {
+		if m.reports[i].UserID == userID {
+			reports = append(reports, m.reports[i])
+		}
+	}
+	return reports, nil
+}
+
+func (m *mockReconciliationRepository) ListLatest(ctx context.Context, since time.Time) ([]models.ReconciliationReport, error) {
+	latest := make(map[string]models.ReconciliationReport)
+	var order []string
+	for _, report := range m.reports {
+		if _, seen := latest[report.UserID]; !seen {
+			order = append(order, report.UserID)
+		}
+		latest[report.UserID] = report
+	}
+	reports := make([]models.ReconciliationReport, 0, len(order))
+	for _, userID := range order {
+		reports = append(reports, latest[userID])
+	}
+	return reports, nil
+}
+
+func TestReconcile(t *testing.T) {
+	reportedAt := time.Date(2024, 5, 1, 9, 0, 0, 500, time.UTC)
+	execution := func(action, asset string, requested, filled float64, at time.Time) models.Execution {
+		return models.Execution{
+			UserID:           "user1",
+			Action:           action,
+			Asset:            asset,
+			RequestedPercent: requested,
+			FilledPercent:    filled,
+			ExecutedAt:       at.Format(time.RFC3339),
+		}
+	}
+
+	tests := []struct {
+		name           string
+		history        *models.AllocationHistory
+		executions     []models.Execution
+		pending        bool
+		expectedStatus string
+		expectedActual map[string]float64
+		expectedBreaks []string
+	}{
+		{
+			name:    "target reached",
+			history: &models.AllocationHistory{Allocation: map[string]float64{"stocks": 70, "bonds": 30}},
+			executions: []models.Execution{
+				execution("SELL", "stocks", 10, 10, reportedAt),
+				execution("BUY", "bonds", 10, 10, reportedAt.Add(time.Second)),
+			},
+			expectedStatus: models.ReconciliationStatusMatched,
+			expectedActual: map[string]float64{"stocks": 60, "bonds": 40},
+		},
+		{
+			name:    "partial fill leaves cash",
+			history: &models.AllocationHistory{Allocation: map[string]float64{"stocks": 70, "bonds": 30}},
+			executions: []models.Execution{
+				execution("SELL", "stocks", 10, 10, reportedAt),
+				execution("BUY", "bonds", 10, 4, reportedAt),
+			},
+			expectedStatus: models.ReconciliationStatusBreak,
+			expectedActual: map[string]float64{"stocks": 60, "bonds": 34, "cash": 6},
+			expectedBreaks: []string{"bonds", "cash"},
+		},
+		{
+			name:    "executions before the report are ignored",
+			history: &models.AllocationHistory{Allocation: map[string]float64{"stocks": 70, "bonds": 30}},
+			executions: []models.Execution{
+				execution("SELL", "stocks", 10, 10, reportedAt.Add(-time.Hour)),
+				execution("BUY", "bonds", 10, 10, reportedAt.Add(-time.Hour)),
+			},
+			expectedStatus: models.ReconciliationStatusBreak,
+			expectedActual: map[string]float64{"stocks": 70, "bonds": 30},
+			expectedBreaks: []string{"bonds", "stocks"},
+		},
+		{
+			name:           "off target while a rebalance is pending",
+			history:        &models.AllocationHistory{Allocation: map[string]float64{"stocks": 70, "bonds": 30}},
+			pending:        true,
+			expectedStatus: models.ReconciliationStatusPending,
+			expectedActual: map[string]float64{"stocks": 70, "bonds": 30},
+			expectedBreaks: []string{"bonds", "stocks"},
+		},
+		{
+			name:           "within tolerance",
+			history:        &models.AllocationHistory{Allocation: map[string]float64{"stocks": 60.5, "bonds": 39.5}},
+			expectedStatus: models.ReconciliationStatusMatched,
+			expectedActual: map[string]float64{"stocks": 60.5, "bonds": 39.5},
+		},
+		{
+			name:           "no reported allocation uses the stored one",
+			executions:     []models.Execution{execution("BUY", "bonds", 10, 10, reportedAt)},
+			expectedStatus: models.ReconciliationStatusMatched,
+			expectedActual: map[string]float64{"stocks": 60, "bonds": 40},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			portfolioRepo := &mockPortfolioRepository{
+				getByUserIDFunc: func(ctx context.Context, userID string) (*models.Portfolio, error) {
+					return &models.Portfolio{
+						UserID:             userID,
+						Allocation:         map[string]float64{"stocks": 60, "bonds": 40},
+						OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40},
+					}, nil
+				},
+			}
+			historyRepo := &mockAllocationHistoryRepository{
+				latestFunc: func(ctx context.Context, userID string, sources []string) (*models.AllocationHistory, error) {
+					if tt.history == nil {
+						return nil, repository.ErrNotFound
+					}
+					record := *tt.history
+					record.Timestamp = reportedAt.Format(time.RFC3339Nano)
+					return &record, nil
+				},
+			}
+			rebalanceRepo := &mockRebalanceRepository{}
+			if tt.pending {
+				_ = rebalanceRepo.Save(context.Background(), models.Rebalance{ID: "r1", UserID: "user1", Status: models.RebalanceStatusProposed})
+			}
+			reconciliationRepo := &mockReconciliationRepository{}
+			service := NewReconciliationService(portfolioRepo, historyRepo, &mockExecutionRepository{executions: tt.executions},
+				rebalanceRepo, reconciliationRepo, &mockLockRepository{}, ReconciliationConfig{Tolerance: 1})
+
+			report, err := service.Reconcile(context.Background(), "user1")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if report.Status != tt.expectedStatus {
+				t.Errorf("expected status %s, got %s (breaks %+v)", tt.expectedStatus, report.Status, report.Breaks)
+			}
+			if len(report.Actual) != len(tt.expectedActual) {
+				t.Fatalf("expected actual %v, got %v", tt.expectedActual, report.Actual)
+			}
+			for asset, percent := range tt.expectedActual {
+				if math.Abs(report.Actual[asset]-percent) > 1e-9 {
+					t.Errorf("expected actual %s at %.2f, got %.2f", asset, percent, report.Actual[asset])
+				}
+			}
+			if len(report.Breaks) != len(tt.expectedBreaks) {
+				t.Fatalf("expected breaks %v, got %+v", tt.expectedBreaks, report.Breaks)
+			}
+			for i, asset := range tt.expectedBreaks {
+				if report.Breaks[i].Asset != asset {
+					t.Errorf("break %d: expected %s, got %s", i, asset, report.Breaks[i].Asset)
+				}
+			}
+			if len(reconciliationRepo.reports) != 1 {
+				t.Errorf("expected the report to be stored, got %d reports", len(reconciliationRepo.reports))
+			}
+		})
+	}
+}
+
+func TestReconciliationRunAllAndSummary(t *testing.T) {
+	portfolios := []models.Portfolio{
+		{UserID: "user1", Allocation: map[string]float64{"stocks": 60, "bonds": 40}, OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40}},
+		{UserID: "user2", Allocation: map[string]float64{"stocks": 80, "bonds": 20}, OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40}},
+		{UserID: "user3", Allocation: map[string]float64{"stocks": 50, "bonds": 50}, OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40}},
+	}
+	portfolioRepo := &mockPortfolioRepository{
+		listAllFunc: func(ctx context.Context) ([]models.Portfolio, error) {
+			return portfolios, nil
+		},
+	}
+
+	lock := &mockLockRepository{held: true}
+	reconciliationRepo := &mockReconciliationRepository{}
+	service := NewReconciliationService(portfolioRepo, &mockAllocationHistoryRepository{}, &mockExecutionRepository{},
+		&mockRebalanceRepository{}, reconciliationRepo, lock, ReconciliationConfig{Tolerance: 1})
+
+	if n, err := service.RunAll(context.Background()); err != nil || n != 0 {
+		t.Fatalf("expected nothing to run while another replica holds the lock, got %d, %v", n, err)
+	}
+
+	lock.held = false
+	n, err := service.RunAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 portfolios reconciled, got %d", n)
+	}
+	if !lock.released {
+		t.Errorf("expected the reconciliation lock to be released")
+	}
+
+	summary, err := service.Summary(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.Portfolios != 3 || summary.Matched != 1 || summary.Breaks != 2 {
+		t.Errorf("expected 3 portfolios with 1 matched and 2 breaks, got %+v", summary)
+	}
+	if len(summary.BreakUsers) != 2 || summary.BreakUsers[0] != "user2" || summary.BreakUsers[1] != "user3" {
+		t.Errorf("expected break users [user2 user3], got %v", summary.BreakUsers)
+	}
+}
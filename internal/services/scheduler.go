@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/pkg/cron"
+	"portfolio-rebalancer/pkg/idgen"
+	"time"
+)
+
+// schedulerLock is the lock a replica must hold to run scheduled rebalances
+const schedulerLock = "scheduler"
+
+type SchedulerService interface {
+	RunDue(ctx context.Context) (int, error)
+	Start(ctx context.Context)
+}
+
+// SchedulerConfig controls how often due portfolios are evaluated and how long a
+// replica may hold the scheduler lock
+type SchedulerConfig struct {
+	Interval time.Duration
+	LockTTL  time.Duration // must exceed the longest run, or another replica may take over
+	Owner    string        // identifies this replica in the lock
+}
+
+// SchedulerConfigFromEnv reads SCHEDULER_INTERVAL (default 1m) and SCHEDULER_LOCK_TTL
+// (default 5m) as Go durations. The owner is the hostname plus a random suffix.
+func SchedulerConfigFromEnv() (SchedulerConfig, error) {
+	config := SchedulerConfig{
+		Interval: time.Minute,
+		LockTTL:  5 * time.Minute,
+	}
+
+	for key, target := range map[string]*time.Duration{
+		"SCHEDULER_INTERVAL": &config.Interval,
+		"SCHEDULER_LOCK_TTL": &config.LockTTL,
+	} {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return config, fmt.Errorf("invalid %s %q, expected a positive duration such as 1m", key, value)
+		}
+		*target = d
+	}
+
+	hostname, _ := os.Hostname()
+	config.Owner = hostname + "-" + idgen.New()
+
+	return config, nil
+}
+
+// SchedulerServiceImpl rebalances portfolios whose schedule is due against their
+// last known allocation, through the same pipeline as provider driven rebalances
+type SchedulerServiceImpl struct {
+	portfolioRepository repository.PortfolioRepository
+	lockRepository      repository.LockRepository
+	rebalanceService    RebalanceService
+	config              SchedulerConfig
+	now                 func() time.Time
+}
+
+// NewSchedulerService creates a new scheduler service instance
+func NewSchedulerService(
+	portfolioRepository repository.PortfolioRepository,
+	lockRepository repository.LockRepository,
+	rebalanceService RebalanceService,
+	config SchedulerConfig,
+) SchedulerService {
+	return &SchedulerServiceImpl{
+		portfolioRepository: portfolioRepository,
+		lockRepository:      lockRepository,
+		rebalanceService:    rebalanceService,
+		config:              config,
+		now:                 time.Now,
+	}
+}
+
+// Start runs due schedules every config.Interval in a background goroutine until ctx is done
+func (s *SchedulerServiceImpl) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		log.Printf("Scheduler started, checking every %s", s.config.Interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Scheduler shutting down")
+				return
+			case <-ticker.C:
+				if n, err := s.RunDue(ctx); err != nil {
+					log.Printf("Scheduled rebalance run failed: %v", err)
+				} else if n > 0 {
+					log.Printf("Ran %d scheduled rebalances", n)
+				}
+			}
+		}
+	}()
+}
+
+// RunDue rebalances every portfolio whose next scheduled run has passed and returns
+// how many were processed. Only the replica holding the scheduler lock runs; the others
+// return immediately. Each portfolio's next run is claimed, by moving it forward with a
+// conditional write, before its transactions are published, so a schedule never fires
+// twice for the same period.
+func (s *SchedulerServiceImpl) RunDue(ctx context.Context) (int, error) {
+	acquired, err := s.lockRepository.Acquire(ctx, schedulerLock, s.config.Owner, s.config.LockTTL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire scheduler lock: %w", err)
+	}
+	if !acquired {
+		return 0, nil
+	}
+	defer func() {
+		if err := s.lockRepository.Release(ctx, schedulerLock, s.config.Owner); err != nil {
+			log.Printf("Failed to release scheduler lock: %v", err)
+		}
+	}()
+
+	now := s.now().UTC()
+	portfolios, err := s.portfolioRepository.ListDue(ctx, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list due portfolios: %w", err)
+	}
+
+	processed := 0
+	for _, portfolio := range portfolios {
+		claimed, err := s.rebalance(ctx, portfolio, now)
+		if err != nil {
+			log.Printf("Scheduled rebalance failed for user %s: %v", portfolio.UserID, err)
+			continue
+		}
+		if claimed {
+			processed++
+		}
+	}
+
+	return processed, nil
+}
+
+// rebalance claims the portfolio's current period and trades it back to its target. The
+// allocation is left to the execution feedback, which knows what actually filled.
+func (s *SchedulerServiceImpl) rebalance(ctx context.Context, listed models.Portfolio, now time.Time) (bool, error) {
+	next, err := nextScheduledRun(listed.Schedule, now)
+	if err != nil {
+		return false, err
+	}
+
+	portfolio, claimed, err := s.moveNextRun(ctx, listed.UserID, listed.NextRebalanceAt, next)
+	if err != nil {
+		return false, fmt.Errorf("failed to advance schedule: %w", err)
+	}
+	if !claimed {
+		log.Printf("Skipping scheduled rebalance for user %s, its period was already claimed or rescheduled", listed.UserID)
+		return false, nil
+	}
+
+	target := portfolio.OriginalAllocation
+	if len(portfolio.Constraints) > 0 {
+		result, err := s.rebalanceService.ApplyConstraints(portfolio.Allocation, target, portfolio.Constraints)
+		if err != nil {
+			return true, err
+		}
+		target = result.Target
+	}
+
+	transactions := s.rebalanceService.CalculateRebalance(portfolio.Allocation, target, portfolio.UserID)
+	if len(transactions) == 0 {
+		return true, nil
+	}
+
+	rebalance, err := s.rebalanceService.SubmitRebalance(ctx, *portfolio, transactions, target, models.ActorScheduler)
+	if err != nil {
+		// Give the period back so the next run retries it
+		if _, _, restoreErr := s.moveNextRun(ctx, portfolio.UserID, next, listed.NextRebalanceAt); restoreErr != nil {
+			log.Printf("Failed to restore schedule for user %s: %v", portfolio.UserID, restoreErr)
+		}
+		return true, fmt.Errorf("failed to submit rebalance: %w", err)
+	}
+	if rebalance.Status == models.RebalanceStatusProposed {
+		// Nothing trades until the rebalance is approved
+		return true, nil
+	}
+	log.Printf("Published %d scheduled transactions for user %s", len(transactions), portfolio.UserID)
+
+	return true, nil
+}
+
+// moveNextRun moves the portfolio's next run from one time to another. Only the stored
+// portfolio's schedule changes, with a conditional write, so concurrent changes are kept and
+// two runs cannot both claim a period. It returns the stored portfolio, and false when its
+// next run is no longer from.
+func (s *SchedulerServiceImpl) moveNextRun(ctx context.Context, userID, from, to string) (*models.Portfolio, bool, error) {
+	for attempt := 0; attempt < portfolioWriteAttempts; attempt++ {
+		portfolio, version, err := s.portfolioRepository.GetVersioned(ctx, userID)
+		if err != nil {
+			return nil, false, err
+		}
+		if portfolio.NextRebalanceAt != from {
+			return portfolio, false, nil
+		}
+
+		portfolio.NextRebalanceAt = to
+		err = s.portfolioRepository.SaveIfVersion(ctx, *portfolio, version)
+		if err == nil {
+			return portfolio, true, nil
+		}
+		if !errors.Is(err, repository.ErrConflict) {
+			return nil, false, err
+		}
+	}
+
+	return nil, false, fmt.Errorf("portfolio %s kept changing while its schedule was moved", userID)
+}
+
+// nextScheduledRun returns the first run of schedule after now in RFC3339, or an empty
+// string when the portfolio is not scheduled
+func nextScheduledRun(schedule string, now time.Time) (string, error) {
+	if schedule == "" {
+		return "", nil
+	}
+
+	parsed, err := cron.Parse(schedule)
+	if err != nil {
+		return "", err
+	}
+
+	next := parsed.Next(now)
+	if next.IsZero() {
+		return "", fmt.Errorf("schedule %q never runs", schedule)
+	}
+	return next.Format(time.RFC3339), nil
+}
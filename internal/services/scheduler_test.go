@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"portfolio-rebalancer/internal/models"
+	"portfolio-rebalancer/internal/repository"
+	"portfolio-rebalancer/pkg/fx"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Mock lock repository
+type mockLockRepository struct {
+	held     bool
+	released bool
+}
+
+func (m *mockLockRepository) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	return !m.held, nil
+}
+
+func (m *mockLockRepository) Release(ctx context.Context, name, owner string) error {
+	m.released = true
+	return nil
+}
+
+func TestNextScheduledRun(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		schedule string
+		expected string
+		wantErr  bool
+	}{
+		{name: "unscheduled", schedule: "", expected: ""},
+		{name: "monthly", schedule: "monthly", expected: "2024-06-01T00:00:00Z"},
+		{name: "quarterly", schedule: "@quarterly", expected: "2024-07-01T00:00:00Z"},
+		{name: "every monday at 9", schedule: "0 9 * * 1", expected: "2024-05-20T09:00:00Z"},
+		{name: "every 15 minutes", schedule: "*/15 * * * *", expected: "2024-05-15T10:45:00Z"},
+		{name: "sunday as 7", schedule: "0 0 * * 7", expected: "2024-05-19T00:00:00Z"},
+		{name: "day of month or weekday", schedule: "0 0 20 * 5", expected: "2024-05-17T00:00:00Z"},
+		{name: "stepped range", schedule: "0 0 1-31/10 * *", expected: "2024-05-21T00:00:00Z"},
+		{name: "list of months", schedule: "0 12 1 3,9 *", expected: "2024-09-01T12:00:00Z"},
+		{name: "never runs", schedule: "0 0 30 2 *", wantErr: true},
+		{name: "too few fields", schedule: "0 0 1 *", wantErr: true},
+		{name: "out of range", schedule: "60 0 1 * *", wantErr: true},
+		{name: "names not supported", schedule: "0 0 * * MON", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, err := nextScheduledRun(tt.schedule, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", next)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if next != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, next)
+			}
+		})
+	}
+}
+
+func TestSchedulerRunDue(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 5, 0, 0, time.UTC)
+	due := func(allocation map[string]float64) models.Portfolio {
+		return models.Portfolio{
+			UserID:             "user1",
+			Allocation:         allocation,
+			OriginalAllocation: map[string]float64{"stocks": 60, "bonds": 40},
+			Schedule:           "monthly",
+			NextRebalanceAt:    "2024-05-01T00:00:00Z",
+		}
+	}
+
+	tests := []struct {
+		name              string
+		lockHeld          bool
+		portfolios        []models.Portfolio
+		stored            map[string]string // next_rebalance_at stored when it differs from the listed one
+		publishErr        error
+		expectedProcessed int
+		expectedPublished int
+		expectedSaved     []string // next_rebalance_at of each save, in order
+	}{
+		{
+			name:              "drifted portfolio is rebalanced",
+			portfolios:        []models.Portfolio{due(map[string]float64{"stocks": 70, "bonds": 30})},
+			expectedProcessed: 1,
+			expectedPublished: 1,
+			expectedSaved:     []string{"2024-06-01T00:00:00Z"},
+		},
+		{
+			name:              "portfolio on target only advances",
+			portfolios:        []models.Portfolio{due(map[string]float64{"stocks": 60, "bonds": 40})},
+			expectedProcessed: 1,
+			expectedSaved:     []string{"2024-06-01T00:00:00Z"},
+		},
+		{
+			name:       "lock held by another replica",
+			lockHeld:   true,
+			portfolios: []models.Portfolio{due(map[string]float64{"stocks": 70, "bonds": 30})},
+		},
+		{
+			name:       "period already claimed is skipped",
+			portfolios: []models.Portfolio{due(map[string]float64{"stocks": 70, "bonds": 30})},
+			stored:     map[string]string{"user1": "2024-06-01T00:00:00Z"},
+		},
+		{
+			name:              "publish failure gives the period back",
+			portfolios:        []models.Portfolio{due(map[string]float64{"stocks": 70, "bonds": 30})},
+			publishErr:        errors.New("kafka down"),
+			expectedPublished: 1,
+			expectedSaved:     []string{"2024-06-01T00:00:00Z", "2024-05-01T00:00:00Z"},
+		},
+		{
+			name: "rebalance awaiting approval",
+			portfolios: []models.Portfolio{func() models.Portfolio {
+				p := due(map[string]float64{"stocks": 70, "bonds": 30})
+				p.RequireApproval = true
+				return p
+			}()},
+			expectedProcessed: 1,
+			expectedSaved:     []string{"2024-06-01T00:00:00Z"},
+		},
+		{
+			name: "invalid schedule is skipped",
+			portfolios: []models.Portfolio{
+				{UserID: "user2", Schedule: "sometimes", NextRebalanceAt: "2024-05-01T00:00:00Z"},
+				due(map[string]float64{"stocks": 70, "bonds": 30}),
+			},
+			expectedProcessed: 1,
+			expectedPublished: 1,
+			expectedSaved:     []string{"2024-06-01T00:00:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := make(map[string]models.Portfolio)
+			for _, p := range tt.portfolios {
+				if next, ok := tt.stored[p.UserID]; ok {
+					p.NextRebalanceAt = next
+				}
+				stored[p.UserID] = p
+			}
+
+			var saved []string
+			portfolioRepo := &mockPortfolioRepository{
+				getVersionedFunc: func(ctx context.Context, userID string) (*models.Portfolio, repository.Version, error) {
+					p := stored[userID]
+					return &p, repository.Version{SeqNo: len(saved), PrimaryTerm: 1}, nil
+				},
+				saveIfVersionFunc: func(ctx context.Context, p models.Portfolio, version repository.Version) error {
+					if version.SeqNo != len(saved) {
+						return repository.ErrConflict
+					}
+					saved = append(saved, p.NextRebalanceAt)
+					stored[p.UserID] = p
+					return nil
+				},
+				listDueFunc: func(ctx context.Context, at time.Time) ([]models.Portfolio, error) {
+					return tt.portfolios, nil
+				},
+			}
+
+			published := 0
+			publisher := &mockPublisher{
+				publishFunc: func(ctx context.Context, message []byte) error {
+					published++
+					return tt.publishErr
+				},
+			}
+
+			lockRepo := &mockLockRepository{held: tt.lockHeld}
+			rebalanceService := NewRebalanceService(&mockTransactionRepository{}, &mockRebalanceRepository{}, publisher, NewAssetService(&mockAssetRepository{}), fx.NewMemoryProvider("test"), nil, nil)
+			scheduler := &SchedulerServiceImpl{
+				portfolioRepository: portfolioRepo,
+				lockRepository:      lockRepo,
+				rebalanceService:    rebalanceService,
+				config:              SchedulerConfig{Owner: "test", LockTTL: time.Minute},
+				now:                 func() time.Time { return now },
+			}
+
+			processed, err := scheduler.RunDue(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if processed != tt.expectedProcessed {
+				t.Errorf("expected %d processed, got %d", tt.expectedProcessed, processed)
+			}
+			if published != tt.expectedPublished {
+				t.Errorf("expected %d publishes, got %d", tt.expectedPublished, published)
+			}
+			if len(saved) != len(tt.expectedSaved) {
+				t.Fatalf("expected saves %v, got %v", tt.expectedSaved, saved)
+			}
+			for i := range saved {
+				if saved[i] != tt.expectedSaved[i] {
+					t.Errorf("save %d: expected next_rebalance_at %s, got %s", i, tt.expectedSaved[i], saved[i])
+				}
+			}
+			// The allocation is left to the execution feedback
+			for _, p := range tt.portfolios {
+				if !reflect.DeepEqual(stored[p.UserID].Allocation, p.Allocation) {
+					t.Errorf("expected allocation of %s to stay %v, got %v", p.UserID, p.Allocation, stored[p.UserID].Allocation)
+				}
+			}
+			if lockRepo.released == tt.lockHeld {
+				t.Errorf("expected lock released %v, got %v", !tt.lockHeld, lockRepo.released)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"math"
+	"portfolio-rebalancer/internal/models"
+	"sort"
+)
+
+// switchEpsilon is the leftover below which a leg counts as fully matched
+const switchEpsilon = 1e-9
+
+// switchUnits scales percentages to integers so legs that net to zero are found exactly,
+// without floating point noise from the leg calculation
+const switchUnits = 1e8
+
+// maxExactSwitchLegs bounds the legs searched exhaustively for the fewest switches; the
+// search takes 2^legs steps
+const maxExactSwitchLegs = 16
+
+// PairTransactions expresses BUY and SELL legs as from→to switches, using as few switches
+// as possible. Any imbalance between the two sides becomes a leg into or out of cash, so
+// the switches out of and into each asset always add up to its legs exactly.
+//
+// n legs always pair into n-1 switches or fewer; every group of legs that nets to zero on
+// its own saves one more. The legs are therefore split into as many zero-sum groups as
+// possible, and within a group the largest sell funds the largest buy first. Above
+// maxExactSwitchLegs legs only equal-sized sells and buys are split off, so the result may
+// then not be minimal. Every switch carries the legs' rebalance ID, or a new one if the
+// legs have none.
+func (s *RebalanceServiceImpl) PairTransactions(transactions []models.RebalanceTransaction) []models.RebalanceSwitch {
+	var sells, buys []models.RebalanceTransaction
+	var balance float64
+	for _, tx := range transactions {
+		if tx.Action == "SELL" {
+			sells = append(sells, tx)
+			balance += tx.RebalancePercent
+		} else {
+			buys = append(buys, tx)
+			balance -= tx.RebalancePercent
+		}
+	}
+	if len(sells) == 0 && len(buys) == 0 {
+		return nil
+	}
+
+	// Whatever one side has left is funded from or parked in cash
+	switch {
+	case balance > switchEpsilon:
+		buys = append(buys, models.RebalanceTransaction{Action: "BUY", Asset: models.CashAsset, RebalancePercent: balance})
+	case balance < -switchEpsilon:
+		sells = append(sells, models.RebalanceTransaction{Action: "SELL", Asset: models.CashAsset, RebalancePercent: -balance})
+	}
+	sortLegs(sells)
+	sortLegs(buys)
+
+	first := transactions[0]
+	rebalanceID := first.RebalanceID
+	if rebalanceID == "" {
+		rebalanceID = s.nextID()
+	}
+
+	var switches []models.RebalanceSwitch
+	add := func(from, to string, percent float64) {
+		switches = append(switches, models.RebalanceSwitch{
+			RebalanceID:      rebalanceID,
+			UserID:           first.UserID,
+			From:             from,
+			To:               to,
+			RebalancePercent: percent,
+			Timestamp:        first.Timestamp,
+		})
+	}
+
+	for _, group := range zeroSumGroups(sells, buys) {
+		var groupSells, groupBuys []models.RebalanceTransaction
+		for _, i := range group {
+			if i < len(sells) {
+				groupSells = append(groupSells, sells[i])
+			} else {
+				groupBuys = append(groupBuys, buys[i-len(sells)])
+			}
+		}
+		pairLegs(groupSells, groupBuys, add)
+	}
+
+	return switches
+}
+
+// pairLegs matches the largest sell against the largest buy until one side is used up. For
+// legs that net to zero this takes at most sells+buys-1 switches; a leftover from rounding
+// is switched from or into cash so nothing is dropped.
+func pairLegs(sells, buys []models.RebalanceTransaction, add func(from, to string, percent float64)) {
+	i, j := 0, 0
+	var sold, bought float64
+	for i < len(sells) && j < len(buys) {
+		sell := sells[i].RebalancePercent - sold
+		buy := buys[j].RebalancePercent - bought
+		moved := math.Min(sell, buy)
+		add(sells[i].Asset, buys[j].Asset, moved)
+
+		sold += moved
+		bought += moved
+		if sell-moved <= switchEpsilon {
+			i, sold = i+1, 0
+		}
+		if buy-moved <= switchEpsilon {
+			j, bought = j+1, 0
+		}
+	}
+
+	for ; i < len(sells); i, sold = i+1, 0 {
+		add(sells[i].Asset, models.CashAsset, sells[i].RebalancePercent-sold)
+	}
+	for ; j < len(buys); j, bought = j+1, 0 {
+		add(models.CashAsset, buys[j].Asset, buys[j].RebalancePercent-bought)
+	}
+}
+
+// zeroSumGroups splits balanced legs into groups that each net to zero, as many as possible.
+// Legs are numbered sells first, then buys, and both groups and their legs are returned in
+// that order so the pairing is deterministic.
+func zeroSumGroups(sells, buys []models.RebalanceTransaction) [][]int {
+	units := make([]int64, 0, len(sells)+len(buys))
+	for _, tx := range sells {
+		units = append(units, int64(math.Round(tx.RebalancePercent*switchUnits)))
+	}
+	for _, tx := range buys {
+		units = append(units, -int64(math.Round(tx.RebalancePercent*switchUnits)))
+	}
+
+	var groups [][]int
+	if len(units) > maxExactSwitchLegs {
+		groups = equalSizedGroups(units)
+	} else {
+		groups = maxZeroSumGroups(units)
+	}
+
+	for _, group := range groups {
+		sort.Ints(group)
+	}
+	sort.Slice(groups, func(a, b int) bool { return groups[a][0] < groups[b][0] })
+	return groups
+}
+
+// maxZeroSumGroups partitions the legs into the most groups that net to zero. best[mask] is
+// the most zero-sum groups the legs in mask can be split into; adding legs one at a time,
+// a group closes whenever the legs added so far net to zero.
+func maxZeroSumGroups(units []int64) [][]int {
+	n := len(units)
+	full := 1<<n - 1
+	sum := make([]int64, full+1)
+	best := make([]int, full+1)
+	last := make([]int, full+1)
+	for mask := 1; mask <= full; mask++ {
+		low := mask & -mask
+		sum[mask] = sum[mask^low] + units[bitIndex(low)]
+
+		best[mask], last[mask] = -1, -1
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) != 0 && best[mask^(1<<i)] > best[mask] {
+				best[mask], last[mask] = best[mask^(1<<i)], i
+			}
+		}
+		if sum[mask] == 0 {
+			best[mask]++
+		}
+	}
+
+	// Walk back from all legs, closing a group at every subset that nets to zero
+	var groups [][]int
+	var group []int
+	for mask := full; mask != 0; {
+		i := last[mask]
+		group = append(group, i)
+		mask ^= 1 << i
+		if mask == 0 || sum[mask] == 0 {
+			groups = append(groups, group)
+			group = nil
+		}
+	}
+	return groups
+}
+
+// equalSizedGroups pairs each sell with a buy of the same size and leaves the remaining
+// legs in one group
+func equalSizedGroups(units []int64) [][]int {
+	var groups [][]int
+	var rest []int
+	paired := make([]bool, len(units))
+	for i, u := range units {
+		if paired[i] {
+			continue
+		}
+		for j := i + 1; j < len(units) && u > 0; j++ {
+			if !paired[j] && units[j] == -u {
+				paired[i], paired[j] = true, true
+				groups = append(groups, []int{i, j})
+				break
+			}
+		}
+		if !paired[i] {
+			rest = append(rest, i)
+		}
+	}
+	if len(rest) > 0 {
+		groups = append(groups, rest)
+	}
+	return groups
+}
+
+// bitIndex returns the position of the single bit set in bit
+func bitIndex(bit int) int {
+	i := 0
+	for bit > 1 {
+		bit >>= 1
+		i++
+	}
+	return i
+}
+
+// sortLegs orders legs by size, largest first, breaking ties by asset name
+func sortLegs(legs []models.RebalanceTransaction) {
+	sort.SliceStable(legs, func(a, b int) bool {
+		if legs[a].RebalancePercent != legs[b].RebalancePercent {
+			return legs[a].RebalancePercent > legs[b].RebalancePercent
+		}
+		return legs[a].Asset < legs[b].Asset
+	})
+}
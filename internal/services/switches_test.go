@@ -0,0 +1,180 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"portfolio-rebalancer/internal/models"
+)
+
+func TestPairTransactions(t *testing.T) {
+	leg := func(action, asset string, percent float64) models.RebalanceTransaction {
+		return models.RebalanceTransaction{RebalanceID: "rb1", UserID: "user1", Action: action, Asset: asset, RebalancePercent: percent}
+	}
+
+	tests := []struct {
+		name             string
+		transactions     []models.RebalanceTransaction
+		expectedSwitches []models.RebalanceSwitch
+	}{
+		{
+			name: "one sell funds two buys",
+			transactions: []models.RebalanceTransaction{
+				leg("BUY", "gold", 4.0),
+				leg("SELL", "stocks", 10.0),
+				leg("BUY", "bonds", 6.0),
+			},
+			expectedSwitches: []models.RebalanceSwitch{
+				{From: "stocks", To: "bonds", RebalancePercent: 6.0},
+				{From: "stocks", To: "gold", RebalancePercent: 4.0},
+			},
+		},
+		{
+			name: "largest legs are matched first",
+			transactions: []models.RebalanceTransaction{
+				leg("SELL", "b", 3.0),
+				leg("SELL", "a", 7.0),
+				leg("BUY", "d", 5.0),
+				leg("BUY", "c", 5.0),
+			},
+			expectedSwitches: []models.RebalanceSwitch{
+				{From: "a", To: "c", RebalancePercent: 5.0},
+				{From: "a", To: "d", RebalancePercent: 2.0},
+				{From: "b", To: "d", RebalancePercent: 3.0},
+			},
+		},
+		{
+			// Largest-first pairing alone takes four switches: a→c, a→d, b→d and b→e
+			name: "legs that net to zero are paired on their own",
+			transactions: []models.RebalanceTransaction{
+				leg("SELL", "a", 5.0),
+				leg("SELL", "b", 3.0),
+				leg("BUY", "c", 4.0),
+				leg("BUY", "d", 3.0),
+				leg("BUY", "e", 1.0),
+			},
+			expectedSwitches: []models.RebalanceSwitch{
+				{From: "a", To: "c", RebalancePercent: 4.0},
+				{From: "a", To: "e", RebalancePercent: 1.0},
+				{From: "b", To: "d", RebalancePercent: 3.0},
+			},
+		},
+		{
+			name: "cash joins the group it balances",
+			transactions: []models.RebalanceTransaction{
+				leg("SELL", "a", 6.0),
+				leg("SELL", "b", 2.0),
+				leg("BUY", "c", 6.0),
+				leg("BUY", "d", 1.0),
+			},
+			expectedSwitches: []models.RebalanceSwitch{
+				{From: "a", To: "c", RebalancePercent: 6.0},
+				{From: "b", To: models.CashAsset, RebalancePercent: 1.0},
+				{From: "b", To: "d", RebalancePercent: 1.0},
+			},
+		},
+		{
+			name: "excess sells are parked in cash",
+			transactions: []models.RebalanceTransaction{
+				leg("SELL", "stocks", 10.0),
+				leg("BUY", "bonds", 8.0),
+			},
+			expectedSwitches: []models.RebalanceSwitch{
+				{From: "stocks", To: "bonds", RebalancePercent: 8.0},
+				{From: "stocks", To: models.CashAsset, RebalancePercent: 2.0},
+			},
+		},
+		{
+			name: "buys without sells are funded from cash",
+			transactions: []models.RebalanceTransaction{
+				leg("BUY", "bonds", 5.0),
+			},
+			expectedSwitches: []models.RebalanceSwitch{
+				{From: models.CashAsset, To: "bonds", RebalancePercent: 5.0},
+			},
+		},
+		{
+			name:         "no transactions",
+			transactions: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &RebalanceServiceImpl{}
+
+			switches := service.PairTransactions(tt.transactions)
+
+			if len(switches) != len(tt.expectedSwitches) {
+				t.Fatalf("expected %d switches, got %d: %+v", len(tt.expectedSwitches), len(switches), switches)
+			}
+			for i, expected := range tt.expectedSwitches {
+				got := switches[i]
+				if got.From != expected.From || got.To != expected.To || math.Abs(got.RebalancePercent-expected.RebalancePercent) > 0.0001 {
+					t.Errorf("switch %d: expected %s→%s %.2f, got %s→%s %.2f", i,
+						expected.From, expected.To, expected.RebalancePercent, got.From, got.To, got.RebalancePercent)
+				}
+				if got.RebalanceID != "rb1" || got.UserID != "user1" {
+					t.Errorf("switch %d: expected rebalance rb1 for user1, got %s for %s", i, got.RebalanceID, got.UserID)
+				}
+			}
+		})
+	}
+}
+
+func TestPairTransactionsReconcilesWithLegs(t *testing.T) {
+	service := &RebalanceServiceImpl{newID: func() string { return "rb1" }}
+
+	transactions := service.CalculateRebalance(
+		map[string]float64{"stocks": 52.3, "bonds": 21.4, "gold": 11.1, "crypto": 15.2},
+		map[string]float64{"stocks": 60.0, "bonds": 30.0, "gold": 10.0},
+		"user1",
+	)
+	switches := service.PairTransactions(transactions)
+
+	moved := make(map[string]float64)
+	for _, sw := range switches {
+		if sw.RebalanceID != "rb1" {
+			t.Errorf("expected switches to share the legs' rebalance ID, got %s", sw.RebalanceID)
+		}
+		moved[sw.From] -= sw.RebalancePercent
+		moved[sw.To] += sw.RebalancePercent
+	}
+	for _, tx := range transactions {
+		expected := tx.RebalancePercent
+		if tx.Action == "SELL" {
+			expected = -expected
+		}
+		if math.Abs(moved[tx.Asset]-expected) > 1e-9 {
+			t.Errorf("expected switches to move %.4f of %s, got %.4f", expected, tx.Asset, moved[tx.Asset])
+		}
+	}
+	if len(switches) > len(transactions)-1 {
+		t.Errorf("expected at most %d switches, got %d", len(transactions)-1, len(switches))
+	}
+}
+
+func TestPairTransactionsManyLegs(t *testing.T) {
+	service := &RebalanceServiceImpl{}
+
+	// Too many legs to search exhaustively, so only equal-sized legs are split off
+	var transactions []models.RebalanceTransaction
+	for i := 0; i < 10; i++ {
+		percent := float64(i + 1)
+		transactions = append(transactions,
+			models.RebalanceTransaction{RebalanceID: "rb1", Action: "SELL", Asset: fmt.Sprintf("sell%d", i), RebalancePercent: percent},
+			models.RebalanceTransaction{RebalanceID: "rb1", Action: "BUY", Asset: fmt.Sprintf("buy%d", i), RebalancePercent: percent})
+	}
+
+	switches := service.PairTransactions(transactions)
+
+	if len(switches) != 10 {
+		t.Fatalf("expected 10 switches, got %d: %+v", len(switches), switches)
+	}
+	for _, sw := range switches {
+		if sw.From[len("sell"):] != sw.To[len("buy"):] {
+			t.Errorf("expected each sell to fund the buy of its size, got %s→%s", sw.From, sw.To)
+		}
+	}
+}
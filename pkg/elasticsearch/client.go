@@ -3,7 +3,9 @@ package elasticsearch
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"portfolio-rebalancer/pkg/latency"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
@@ -11,6 +13,24 @@ import (
 
 var client *elasticsearch.Client
 
+// requestLatency tracks how long Elasticsearch takes to answer, for load shedding
+var requestLatency = latency.NewTracker()
+
+// Latency returns the tracker of Elasticsearch request latency
+func Latency() *latency.Tracker {
+	return requestLatency
+}
+
+// timedTransport records the latency of every request sent to Elasticsearch
+type timedTransport struct {
+	next http.RoundTripper
+}
+
+func (t timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	defer requestLatency.Since(time.Now())
+	return t.next.RoundTrip(req)
+}
+
 // GetClient returns the Elasticsearch client instance
 func GetClient() *elasticsearch.Client {
 	return client
@@ -22,6 +42,7 @@ func Init() error {
 		Addresses: []string{
 			os.Getenv("ELASTICSEARCH_URL"),
 		},
+		Transport: timedTransport{next: http.DefaultTransport},
 	}
 
 	var esClient *elasticsearch.Client
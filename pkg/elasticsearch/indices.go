@@ -0,0 +1,390 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// IndexSpec describes an index the application reads and writes through an alias.
+// Each entry in Versions is a complete mapping; the physical index for version N
+// is named "<alias>-v<N>" and the alias always points at the latest version.
+// Fields added to the latest version are put on its existing indices, so adding a
+// field needs no new version. A new version reindexes everything, which for a
+// lifecycle version starts the rollover over at a single generation.
+type IndexSpec struct {
+	Alias    string
+	Versions []IndexVersion // ordered oldest to newest, version numbers start at 1
+}
+
+// IndexVersion holds the settings and mappings of one index version
+type IndexVersion struct {
+	Settings map[string]interface{}
+	Mappings map[string]interface{}
+	// Script is an optional painless script applied to every document
+	// when reindexing from the previous version into this one
+	Script string
+	// Lifecycle turns the version into date-rolled indices named
+	// "<alias>-v<N>-000001", "<alias>-v<N>-000002", ... managed by ILM.
+	// Rolled indices are used rather than a data stream so documents keep
+	// their IDs and can still be updated in place.
+	Lifecycle *LifecyclePolicy
+}
+
+// IndexName returns the physical index name for the given version.
+// For versions with a lifecycle policy this is the prefix of the rolled indices.
+func (s IndexSpec) IndexName(version int) string {
+	return fmt.Sprintf("%s-v%d", s.Alias, version)
+}
+
+// writeIndex returns the index the alias writes to right after creating the version
+func (s IndexSpec) writeIndex(version int) string {
+	if s.Versions[version-1].Lifecycle != nil {
+		return s.IndexName(version) + "-000001"
+	}
+	return s.IndexName(version)
+}
+
+// indexPattern matches every physical index of the version
+func (s IndexSpec) indexPattern(version int) string {
+	if s.Versions[version-1].Lifecycle != nil {
+		return s.IndexName(version) + "-*"
+	}
+	return s.IndexName(version)
+}
+
+// settings merges the lifecycle settings into the version settings
+func (s IndexSpec) settings(version int) map[string]interface{} {
+	v := s.Versions[version-1]
+	settings := make(map[string]interface{}, len(v.Settings)+2)
+	for key, value := range v.Settings {
+		settings[key] = value
+	}
+	if v.Lifecycle != nil {
+		for key, value := range v.Lifecycle.indexSettings(s.Alias) {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+// LatestVersion returns the newest version number of the spec
+func (s IndexSpec) LatestVersion() int {
+	return len(s.Versions)
+}
+
+// DefaultSettings returns index settings for shards and replicas,
+// configurable through ES_NUMBER_OF_SHARDS and ES_NUMBER_OF_REPLICAS
+func DefaultSettings() map[string]interface{} {
+	return map[string]interface{}{
+		"number_of_shards":   envInt("ES_NUMBER_OF_SHARDS", 1),
+		"number_of_replicas": envInt("ES_NUMBER_OF_REPLICAS", 1),
+	}
+}
+
+// Migrate installs index templates for every spec and brings each alias up to
+// its latest version, reindexing data one version at a time. Indices created
+// implicitly by dynamic mapping under the alias name are treated as version 0.
+func Migrate(ctx context.Context, esClient *elasticsearch.Client, specs ...IndexSpec) error {
+	for _, spec := range specs {
+		if len(spec.Versions) == 0 {
+			return fmt.Errorf("index spec %s has no versions", spec.Alias)
+		}
+		if lifecycle := spec.Versions[spec.LatestVersion()-1].Lifecycle; lifecycle != nil {
+			if err := putLifecyclePolicy(ctx, esClient, *lifecycle); err != nil {
+				return err
+			}
+		}
+		if err := putIndexTemplate(ctx, esClient, spec); err != nil {
+			return err
+		}
+		if err := migrateIndex(ctx, esClient, spec); err != nil {
+			return fmt.Errorf("failed to migrate %s: %w", spec.Alias, err)
+		}
+	}
+	return nil
+}
+
+// putIndexTemplate installs a composable template so that indices matching the
+// alias (including ones recreated accidentally by a write) get explicit mappings
+func putIndexTemplate(ctx context.Context, esClient *elasticsearch.Client, spec IndexSpec) error {
+	latest := spec.LatestVersion()
+	body, err := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{spec.Alias, spec.Alias + "-v*"},
+		"priority":       100,
+		"template": map[string]interface{}{
+			"settings": spec.settings(latest),
+			"mappings": spec.Versions[latest-1].Mappings,
+		},
+		"_meta": map[string]interface{}{
+			"version": latest,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := esClient.Indices.PutIndexTemplate(spec.Alias, bytes.NewReader(body),
+		esClient.Indices.PutIndexTemplate.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error installing index template %s: %s", spec.Alias, res.String())
+	}
+
+	return nil
+}
+
+func migrateIndex(ctx context.Context, esClient *elasticsearch.Client, spec IndexSpec) error {
+	current, err := currentVersion(ctx, esClient, spec)
+	if err != nil {
+		return err
+	}
+
+	latest := spec.LatestVersion()
+	if current == latest {
+		return putMapping(ctx, esClient, spec, latest)
+	}
+	if current > latest {
+		return fmt.Errorf("alias points to version %d but the newest known version is %d", current, latest)
+	}
+
+	// Fresh install - nothing to reindex
+	if current < 0 {
+		if err := createIndex(ctx, esClient, spec.writeIndex(latest), spec, latest); err != nil {
+			return err
+		}
+		log.Printf("Created index %s", spec.writeIndex(latest))
+		return swapAlias(ctx, esClient, spec, "", latest, false)
+	}
+
+	source := spec.Alias
+	if current > 0 {
+		source = spec.indexPattern(current)
+	}
+
+	for version := current + 1; version <= latest; version++ {
+		dest := spec.writeIndex(version)
+		if err := createIndex(ctx, esClient, dest, spec, version); err != nil {
+			return err
+		}
+		if err := reindex(ctx, esClient, source, dest, spec.Versions[version-1].Script); err != nil {
+			return err
+		}
+		log.Printf("Reindexed %s into %s", source, dest)
+		source = spec.indexPattern(version)
+	}
+
+	// A legacy concrete index blocks the alias name, so it is dropped in the same atomic call
+	from := spec.Alias
+	if current > 0 {
+		from = spec.indexPattern(current)
+	}
+	return swapAlias(ctx, esClient, spec, from, latest, current == 0)
+}
+
+// putMapping adds fields introduced since the version's indices were created. Every
+// generation keeps its name and lifecycle age; documents written before a field was
+// mapped are only searchable on it once updated.
+func putMapping(ctx context.Context, esClient *elasticsearch.Client, spec IndexSpec, version int) error {
+	body, err := json.Marshal(spec.Versions[version-1].Mappings)
+	if err != nil {
+		return err
+	}
+
+	res, err := esClient.Indices.PutMapping([]string{spec.indexPattern(version)}, bytes.NewReader(body),
+		esClient.Indices.PutMapping.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error updating mappings of %s: %s", spec.indexPattern(version), res.String())
+	}
+
+	return nil
+}
+
+var versionSuffix = regexp.MustCompile(`-v(\d+)(?:-\d+)?$`)
+
+// currentVersion returns the version the alias points to, 0 for a legacy
+// concrete index named like the alias, and -1 when nothing exists yet
+func currentVersion(ctx context.Context, esClient *elasticsearch.Client, spec IndexSpec) (int, error) {
+	res, err := esClient.Indices.GetAlias(
+		esClient.Indices.GetAlias.WithName(spec.Alias),
+		esClient.Indices.GetAlias.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return legacyVersion(ctx, esClient, spec)
+	}
+	if res.IsError() {
+		return 0, fmt.Errorf("error reading alias %s: %s", spec.Alias, res.String())
+	}
+
+	var indices map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for index := range indices {
+		match := versionSuffix.FindStringSubmatch(index)
+		if match == nil {
+			continue
+		}
+		if v, _ := strconv.Atoi(match[1]); v > version {
+			version = v
+		}
+	}
+
+	return version, nil
+}
+
+func legacyVersion(ctx context.Context, esClient *elasticsearch.Client, spec IndexSpec) (int, error) {
+	res, err := esClient.Indices.Exists([]string{spec.Alias}, esClient.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 200 {
+		return 0, nil
+	}
+	return -1, nil
+}
+
+func createIndex(ctx context.Context, esClient *elasticsearch.Client, name string, spec IndexSpec, version int) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"settings": spec.settings(version),
+		"mappings": spec.Versions[version-1].Mappings,
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := esClient.Indices.Create(name,
+		esClient.Indices.Create.WithBody(bytes.NewReader(body)),
+		esClient.Indices.Create.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		msg, _ := io.ReadAll(res.Body)
+		// A previous run may have been interrupted after creating the index
+		if bytes.Contains(msg, []byte("resource_already_exists_exception")) {
+			return nil
+		}
+		return fmt.Errorf("error creating index %s: [%s] %s", name, res.Status(), msg)
+	}
+
+	return nil
+}
+
+func reindex(ctx context.Context, esClient *elasticsearch.Client, source, dest, script string) error {
+	request := map[string]interface{}{
+		"source": map[string]interface{}{"index": source},
+		"dest":   map[string]interface{}{"index": dest},
+	}
+	if script != "" {
+		request["script"] = map[string]interface{}{"source": script, "lang": "painless"}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	defer cancel()
+
+	res, err := esClient.Reindex(bytes.NewReader(body),
+		esClient.Reindex.WithWaitForCompletion(true),
+		esClient.Reindex.WithRefresh(true),
+		esClient.Reindex.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error reindexing %s into %s: %s", source, dest, res.String())
+	}
+
+	var result struct {
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+	if len(result.Failures) > 0 {
+		return fmt.Errorf("reindexing %s into %s had %d failures", source, dest, len(result.Failures))
+	}
+
+	return nil
+}
+
+// swapAlias atomically points the alias at the write index of the given version
+func swapAlias(ctx context.Context, esClient *elasticsearch.Client, spec IndexSpec, from string, version int, removeIndex bool) error {
+	to := spec.writeIndex(version)
+	add := map[string]interface{}{"index": to, "alias": spec.Alias}
+	if spec.Versions[version-1].Lifecycle != nil {
+		// Rollover moves the write flag to each new generation
+		add["is_write_index"] = true
+	}
+
+	var actions []map[string]interface{}
+	switch {
+	case from == "":
+	case removeIndex:
+		actions = append(actions, map[string]interface{}{"remove_index": map[string]interface{}{"index": from}})
+	default:
+		actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": from, "alias": spec.Alias}})
+	}
+	actions = append(actions, map[string]interface{}{"add": add})
+
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return err
+	}
+
+	res, err := esClient.Indices.UpdateAliases(bytes.NewReader(body),
+		esClient.Indices.UpdateAliases.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error pointing alias %s at %s: %s", spec.Alias, to, res.String())
+	}
+
+	log.Printf("Alias %s now points to %s", spec.Alias, to)
+	return nil
+}
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
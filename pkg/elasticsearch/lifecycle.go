@@ -0,0 +1,116 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// LifecyclePolicy describes an ILM policy for date-rolled indices.
+// Indices roll over in the hot phase, become read-only in the warm phase
+// and are deleted once DeleteAfter has passed since their rollover.
+type LifecyclePolicy struct {
+	Name            string
+	RolloverMaxAge  string // e.g. "30d"
+	RolloverMaxSize string // primary shard size, e.g. "50gb"
+	WarmAfter       string
+	DeleteAfter     string
+}
+
+// LifecyclePolicyFromEnv builds a policy using the given defaults, overridable through
+// <PREFIX>_ROLLOVER_MAX_AGE, <PREFIX>_ROLLOVER_MAX_SIZE, <PREFIX>_WARM_AFTER and
+// <PREFIX>_RETENTION_MONTHS
+func LifecyclePolicyFromEnv(name, prefix string, defaults LifecyclePolicy) LifecyclePolicy {
+	policy := defaults
+	policy.Name = name
+	if v := os.Getenv(prefix + "_ROLLOVER_MAX_AGE"); v != "" {
+		policy.RolloverMaxAge = v
+	}
+	if v := os.Getenv(prefix + "_ROLLOVER_MAX_SIZE"); v != "" {
+		policy.RolloverMaxSize = v
+	}
+	if v := os.Getenv(prefix + "_WARM_AFTER"); v != "" {
+		policy.WarmAfter = v
+	}
+	// Months are counted as 31 days so that retention never falls short
+	if months := envInt(prefix+"_RETENTION_MONTHS", 0); months > 0 {
+		policy.DeleteAfter = fmt.Sprintf("%dd", months*31)
+	}
+	return policy
+}
+
+func (p LifecyclePolicy) body() map[string]interface{} {
+	rollover := map[string]interface{}{}
+	if p.RolloverMaxAge != "" {
+		rollover["max_age"] = p.RolloverMaxAge
+	}
+	if p.RolloverMaxSize != "" {
+		rollover["max_primary_shard_size"] = p.RolloverMaxSize
+	}
+
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{
+			"actions": map[string]interface{}{
+				"rollover":     rollover,
+				"set_priority": map[string]interface{}{"priority": 100},
+			},
+		},
+	}
+	if p.WarmAfter != "" {
+		phases["warm"] = map[string]interface{}{
+			"min_age": p.WarmAfter,
+			"actions": map[string]interface{}{
+				"readonly":     map[string]interface{}{},
+				"forcemerge":   map[string]interface{}{"max_num_segments": 1},
+				"set_priority": map[string]interface{}{"priority": 50},
+			},
+		}
+	}
+	if p.DeleteAfter != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": p.DeleteAfter,
+			"actions": map[string]interface{}{
+				"delete": map[string]interface{}{},
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"policy": map[string]interface{}{"phases": phases},
+	}
+}
+
+// indexSettings returns the settings that attach an index to the policy
+func (p LifecyclePolicy) indexSettings(alias string) map[string]interface{} {
+	return map[string]interface{}{
+		"index.lifecycle.name":           p.Name,
+		"index.lifecycle.rollover_alias": alias,
+	}
+}
+
+func putLifecyclePolicy(ctx context.Context, esClient *elasticsearch.Client, policy LifecyclePolicy) error {
+	body, err := json.Marshal(policy.body())
+	if err != nil {
+		return err
+	}
+
+	res, err := esClient.ILM.PutLifecycle(policy.Name,
+		esClient.ILM.PutLifecycle.WithBody(bytes.NewReader(body)),
+		esClient.ILM.PutLifecycle.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error installing lifecycle policy %s: %s", policy.Name, res.String())
+	}
+
+	log.Printf("Lifecycle policy %s installed", policy.Name)
+	return nil
+}
@@ -0,0 +1,120 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// MigrationLock is the lock a replica must hold to migrate indices
+const MigrationLock = "index-migration"
+
+const (
+	// migrationLockTTL is kept short and renewed while migrating, so a crashed replica
+	// only holds up the others briefly
+	migrationLockTTL = time.Minute
+	// migrationPollInterval is how often a waiting replica checks the lock and the aliases
+	migrationPollInterval = 2 * time.Second
+)
+
+// errMigrationLockLost is returned when another replica took the migration lock mid-run
+var errMigrationLockLost = errors.New("migration lock was lost")
+
+// Locker holds named, expiring locks shared by every replica. Acquiring a lock already
+// held by the same owner renews it.
+type Locker interface {
+	Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, name, owner string) error
+}
+
+// MigrateLocked runs Migrate while holding the migration lock, so replicas starting together
+// do not reindex the same data or race on the alias swap. A replica that does not get the
+// lock waits until every alias points at its latest version, or until the lock frees up.
+// The index behind locker must already exist.
+func MigrateLocked(ctx context.Context, esClient *elasticsearch.Client, locker Locker, owner string, specs ...IndexSpec) error {
+	for {
+		acquired, err := locker.Acquire(ctx, MigrationLock, owner, migrationLockTTL)
+		if err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		if acquired {
+			return migrateHoldingLock(ctx, esClient, locker, owner, specs)
+		}
+
+		migrated, err := upToDate(ctx, esClient, specs)
+		if err != nil {
+			return err
+		}
+		if migrated {
+			log.Printf("Indices were migrated by another replica")
+			return nil
+		}
+
+		log.Printf("Waiting for another replica to migrate indices")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationPollInterval):
+		}
+	}
+}
+
+// migrateHoldingLock migrates while renewing the lock, and stops the migration if the lock
+// is lost so two replicas never migrate at once
+func migrateHoldingLock(ctx context.Context, esClient *elasticsearch.Client, locker Locker, owner string, specs []IndexSpec) error {
+	migrateCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(migrationLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-migrateCtx.Done():
+				return
+			case <-ticker.C:
+				held, err := locker.Acquire(migrateCtx, MigrationLock, owner, migrationLockTTL)
+				if err != nil {
+					log.Printf("Failed to renew migration lock: %v", err)
+					continue
+				}
+				if !held {
+					cancel(errMigrationLockLost)
+					return
+				}
+			}
+		}
+	}()
+
+	err := Migrate(migrateCtx, esClient, specs...)
+	if cause := context.Cause(migrateCtx); errors.Is(cause, errMigrationLockLost) {
+		err = cause
+	}
+	cancel(nil)
+	<-renewed
+
+	if releaseErr := locker.Release(ctx, MigrationLock, owner); releaseErr != nil {
+		log.Printf("Failed to release migration lock: %v", releaseErr)
+	}
+	return err
+}
+
+// upToDate reports whether every alias points at the latest version of its spec
+func upToDate(ctx context.Context, esClient *elasticsearch.Client, specs []IndexSpec) (bool, error) {
+	for _, spec := range specs {
+		current, err := currentVersion(ctx, esClient, spec)
+		if err != nil {
+			return false, err
+		}
+		if current != spec.LatestVersion() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,57 @@
+package fx
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// rateFile is the format of a static rates file:
+//
+//	{"base": "USD", "as_of": "2024-01-01T00:00:00Z", "rates": {"EUR": 0.92, "IDR": 15600}}
+//
+// Each rate is the number of units of the currency worth one unit of base.
+type rateFile struct {
+	Base  string             `json:"base"`
+	AsOf  string             `json:"as_of"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// NewFileProvider loads rates quoted against a single base currency from a JSON file
+func NewFileProvider(path string) (*MemoryProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FX rates file: %w", err)
+	}
+
+	var file rateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse FX rates file: %w", err)
+	}
+	if file.Base == "" {
+		return nil, fmt.Errorf("FX rates file %s has no base currency", path)
+	}
+
+	provider := NewMemoryProvider("file:" + path)
+	for currency, rate := range file.Rates {
+		if rate <= 0 {
+			return nil, fmt.Errorf("FX rate for %s must be positive, got %v", currency, rate)
+		}
+		provider.Set(file.Base, currency, rate, file.AsOf)
+	}
+
+	log.Printf("Loaded %d FX rates against %s from %s", len(file.Rates), file.Base, path)
+	return provider, nil
+}
+
+// NewProviderFromEnv loads FX_RATES_FILE when set. Otherwise it returns an empty
+// provider, which only converts between identical currencies.
+func NewProviderFromEnv() (RateProvider, error) {
+	path := os.Getenv("FX_RATES_FILE")
+	if path == "" {
+		log.Println("Warning: FX_RATES_FILE not set, only same-currency conversions are available")
+		return NewMemoryProvider("memory"), nil
+	}
+	return NewFileProvider(path)
+}
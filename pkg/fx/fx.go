@@ -0,0 +1,99 @@
+package fx
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Rate converts an amount in From into To: amount * Rate
+type Rate struct {
+	From   string
+	To     string
+	Rate   float64
+	AsOf   string // RFC3339 time the rate was quoted
+	Source string // provider the rate came from
+}
+
+// RateProvider is a source of FX rates
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (Rate, error)
+}
+
+// MemoryProvider serves rates held in memory. Missing pairs are derived from the
+// inverse quote or crossed through a currency quoted against both sides.
+type MemoryProvider struct {
+	mu     sync.RWMutex
+	source string
+	rates  map[string]map[string]Rate
+}
+
+// NewMemoryProvider creates an empty provider; rates are added with Set
+func NewMemoryProvider(source string) *MemoryProvider {
+	return &MemoryProvider{
+		source: source,
+		rates:  make(map[string]map[string]Rate),
+	}
+}
+
+// Set records that one unit of from is worth rate units of to
+func (p *MemoryProvider) Set(from, to string, rate float64, asOf string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rates[from] == nil {
+		p.rates[from] = make(map[string]Rate)
+	}
+	p.rates[from][to] = Rate{From: from, To: to, Rate: rate, AsOf: asOf, Source: p.source}
+}
+
+// Rate returns the rate from one currency to another
+func (p *MemoryProvider) Rate(ctx context.Context, from, to string) (Rate, error) {
+	if from == to {
+		return Rate{From: from, To: to, Rate: 1, Source: p.source}, nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if rate, ok := p.quote(from, to); ok {
+		return rate, nil
+	}
+
+	// Cross through a pivot, in a fixed order so the same pivot is always chosen
+	pivots := make([]string, 0, len(p.rates))
+	for currency := range p.rates {
+		pivots = append(pivots, currency)
+	}
+	sort.Strings(pivots)
+	for _, pivot := range pivots {
+		first, ok := p.quote(from, pivot)
+		if !ok {
+			continue
+		}
+		second, ok := p.quote(pivot, to)
+		if !ok {
+			continue
+		}
+
+		asOf := first.AsOf
+		if second.AsOf < asOf {
+			asOf = second.AsOf
+		}
+		return Rate{From: from, To: to, Rate: first.Rate * second.Rate, AsOf: asOf, Source: p.source}, nil
+	}
+
+	return Rate{}, fmt.Errorf("no FX rate from %s to %s", from, to)
+}
+
+// quote returns a direct or inverse rate
+func (p *MemoryProvider) quote(from, to string) (Rate, bool) {
+	if rate, ok := p.rates[from][to]; ok {
+		return rate, true
+	}
+	if rate, ok := p.rates[to][from]; ok && rate.Rate != 0 {
+		return Rate{From: from, To: to, Rate: 1 / rate.Rate, AsOf: rate.AsOf, Source: rate.Source}, true
+	}
+	return Rate{}, false
+}
@@ -2,10 +2,20 @@ package kafka
 
 import (
 	"context"
+	"portfolio-rebalancer/pkg/latency"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
+// publishLatency tracks how long Kafka takes to accept published messages, for load shedding
+var publishLatency = latency.NewTracker()
+
+// Latency returns the tracker of Kafka publish latency
+func Latency() *latency.Tracker {
+	return publishLatency
+}
+
 // Publisher implements messaging.Publisher interface using Kafka
 type Publisher struct {
 	writer *kafka.Writer
@@ -28,5 +38,6 @@ func (p *Publisher) Publish(ctx context.Context, message []byte) error {
 		Value: message,
 	}
 
+	defer publishLatency.Since(time.Now())
 	return p.writer.WriteMessages(ctx, msg)
 }
@@ -0,0 +1,55 @@
+package latency
+
+import (
+	"sync"
+	"time"
+)
+
+// smoothing is the weight of the newest observation in the moving average
+const smoothing = 0.2
+
+// staleAfter is how long without observations before a dependency is assumed to have
+// recovered, so shedding the traffic that would measure it cannot keep it marked slow forever
+const staleAfter = 30 * time.Second
+
+// Tracker keeps an exponentially weighted moving average of a dependency's call latency.
+// It is safe for concurrent use.
+type Tracker struct {
+	mu       sync.Mutex
+	average  time.Duration
+	observed time.Time
+}
+
+// NewTracker creates a tracker with no observations
+func NewTracker() *Tracker {
+	return &Tracker{}
+}
+
+// Observe records how long one call took
+func (t *Tracker) Observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.observed.IsZero() || time.Since(t.observed) > staleAfter {
+		t.average = d
+	} else {
+		t.average = time.Duration(smoothing*float64(d) + (1-smoothing)*float64(t.average))
+	}
+	t.observed = time.Now()
+}
+
+// Since records the latency of a call that started at start
+func (t *Tracker) Since(start time.Time) {
+	t.Observe(time.Since(start))
+}
+
+// Value returns the average latency, or zero when nothing was observed recently
+func (t *Tracker) Value() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.observed.IsZero() || time.Since(t.observed) > staleAfter {
+		return 0
+	}
+	return t.average
+}
@@ -0,0 +1,511 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Document is the part of an OpenAPI 3 document needed to validate requests and responses
+// against it. Schemas support type, format date-time, nullable, enum, properties, required,
+// additionalProperties, items, oneOf, minimum and local $refs.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Components holds the schemas and responses operations refer to
+type Components struct {
+	Schemas   map[string]*Schema   `json:"schemas"`
+	Responses map[string]*Response `json:"responses"`
+}
+
+// PathItem holds the operations of one path
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Put    *Operation `json:"put,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+}
+
+// Operation is one method of a path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Deprecated  bool                 `json:"deprecated,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is a query or path parameter of an operation. Path parameters are matched by
+// the router, so only query parameters are validated.
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody describes the JSON body an operation accepts
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes one status an operation responds with. Ref points to a shared
+// response under components.
+type Response struct {
+	Ref         string               `json:"$ref,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON schema as used by OpenAPI 3.0
+type Schema struct {
+	Ref                  string                `json:"$ref,omitempty"`
+	Type                 string                `json:"type,omitempty"`
+	Format               string                `json:"format,omitempty"`
+	Nullable             bool                  `json:"nullable,omitempty"`
+	Enum                 []interface{}         `json:"enum,omitempty"`
+	Properties           map[string]*Schema    `json:"properties,omitempty"`
+	Required             []string              `json:"required,omitempty"`
+	AdditionalProperties *AdditionalProperties `json:"additionalProperties,omitempty"`
+	Items                *Schema               `json:"items,omitempty"`
+	OneOf                []*Schema             `json:"oneOf,omitempty"`
+	Minimum              *float64              `json:"minimum,omitempty"`
+}
+
+// AdditionalProperties is either false, forbidding properties that are not listed, or the
+// schema every unlisted property must match
+type AdditionalProperties struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+// UnmarshalJSON accepts a boolean or a schema
+func (a *AdditionalProperties) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(data, &a.Schema)
+}
+
+// MarshalJSON writes the boolean or the schema back
+func (a AdditionalProperties) MarshalJSON() ([]byte, error) {
+	if a.Schema != nil {
+		return json.Marshal(a.Schema)
+	}
+	return json.Marshal(a.Allowed)
+}
+
+// ValidationError is one way a value does not match its schema. Field is the dotted path
+// of the offending value, e.g. allocation.stocks or allocation_tree[0].percent.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Load parses a document and checks that every $ref in it resolves
+func Load(data []byte) (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q, expected 3.x", doc.OpenAPI)
+	}
+
+	for path, item := range doc.Paths {
+		for method, op := range item.operations() {
+			if err := doc.checkOperation(op); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", method, path, err)
+			}
+		}
+	}
+	for name, schema := range doc.Components.Schemas {
+		if err := doc.checkSchema(schema); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+
+	return &doc, nil
+}
+
+// operations returns the item's operations keyed by HTTP method
+func (p *PathItem) operations() map[string]*Operation {
+	ops := make(map[string]*Operation)
+	for method, op := range map[string]*Operation{"GET": p.Get, "POST": p.Post, "PUT": p.Put, "DELETE": p.Delete} {
+		if op != nil {
+			ops[method] = op
+		}
+	}
+	return ops
+}
+
+// Operations lists every documented method and path, sorted by path then method
+func (d *Document) Operations() [][2]string {
+	var result [][2]string
+	for path, item := range d.Paths {
+		for method := range item.operations() {
+			result = append(result, [2]string{method, path})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i][1] != result[j][1] {
+			return result[i][1] < result[j][1]
+		}
+		return result[i][0] < result[j][0]
+	})
+	return result
+}
+
+// Operation returns the operation documented for method and path, a path template such as
+// /v1/portfolios/{user_id} for routes with parameters. HEAD requests use the GET operation.
+func (d *Document) Operation(method, path string) (*Operation, bool) {
+	item, ok := d.Paths[path]
+	if !ok {
+		return nil, false
+	}
+	if method == "HEAD" {
+		method = "GET"
+	}
+	op, ok := item.operations()[strings.ToUpper(method)]
+	return op, ok
+}
+
+// HasPath reports whether the document describes path
+func (d *Document) HasPath(path string) bool {
+	_, ok := d.Paths[path]
+	return ok
+}
+
+// ValidateRequest checks the query parameters and JSON body of a request to op
+func (d *Document) ValidateRequest(op *Operation, query url.Values, body []byte) []ValidationError {
+	var errs []ValidationError
+	for _, param := range op.Parameters {
+		if param.In != "query" {
+			continue
+		}
+		value := query.Get(param.Name)
+		if value == "" {
+			if param.Required {
+				errs = append(errs, ValidationError{Field: param.Name, Message: "is required"})
+			}
+			continue
+		}
+		errs = append(errs, d.validateParameter(param, value)...)
+	}
+
+	if op.RequestBody == nil {
+		return errs
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			errs = append(errs, ValidationError{Message: "request body is required"})
+		}
+		return errs
+	}
+	media, ok := op.RequestBody.Content["application/json"]
+	if !ok {
+		return errs
+	}
+
+	value, err := decode(body)
+	if err != nil {
+		return append(errs, ValidationError{Message: "request body must be valid JSON"})
+	}
+	return append(errs, d.Validate(media.Schema, value, "")...)
+}
+
+// ValidateResponse checks that status is documented for op and that body matches its schema
+func (d *Document) ValidateResponse(op *Operation, status int, contentType string, body []byte) []ValidationError {
+	response, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		return []ValidationError{{Message: fmt.Sprintf("status %d is not documented", status)}}
+	}
+	response = d.resolveResponse(response)
+
+	if len(response.Content) == 0 {
+		if len(bytes.TrimSpace(body)) > 0 {
+			return []ValidationError{{Message: fmt.Sprintf("status %d is documented without a body", status)}}
+		}
+		return nil
+	}
+
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	media, ok := response.Content[mediaType]
+	if !ok {
+		return []ValidationError{{Message: fmt.Sprintf("content type %q is not documented for status %d", mediaType, status)}}
+	}
+
+	value, err := decode(body)
+	if err != nil {
+		return []ValidationError{{Message: "response body must be valid JSON"}}
+	}
+	return d.Validate(media.Schema, value, "")
+}
+
+// Validate checks a decoded JSON value against schema. Numbers must be decoded as json.Number.
+func (d *Document) Validate(schema *Schema, value interface{}, field string) []ValidationError {
+	schema = d.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+
+	if value == nil {
+		if schema.Nullable || (schema.Type == "" && len(schema.OneOf) == 0) {
+			return nil
+		}
+		return []ValidationError{{Field: field, Message: "must not be null"}}
+	}
+
+	if len(schema.OneOf) > 0 {
+		for _, option := range schema.OneOf {
+			if len(d.Validate(option, value, field)) == 0 {
+				return nil
+			}
+		}
+		return []ValidationError{{Field: field, Message: "does not match any of the allowed shapes"}}
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		return []ValidationError{{Field: field, Message: "must be one of " + formatEnum(schema.Enum)}}
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return typeError(field, schema.Type)
+		}
+		return d.validateObject(schema, object, field)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return typeError(field, schema.Type)
+		}
+		var errs []ValidationError
+		for i, item := range items {
+			errs = append(errs, d.Validate(schema.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+		return errs
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return typeError(field, schema.Type)
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return []ValidationError{{Field: field, Message: "must be an RFC3339 timestamp"}}
+			}
+		}
+	case "number", "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return typeError(field, schema.Type)
+		}
+		if schema.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				return typeError(field, schema.Type)
+			}
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return typeError(field, schema.Type)
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			return []ValidationError{{Field: field, Message: "must be at least " + strconv.FormatFloat(*schema.Minimum, 'f', -1, 64)}}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return typeError(field, schema.Type)
+		}
+	}
+
+	return nil
+}
+
+// validateObject checks required, listed and unlisted properties, in property name order
+func (d *Document) validateObject(schema *Schema, object map[string]interface{}, field string) []ValidationError {
+	var errs []ValidationError
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			errs = append(errs, ValidationError{Field: join(field, name), Message: "is required"})
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if property, ok := schema.Properties[name]; ok {
+			errs = append(errs, d.Validate(property, object[name], join(field, name))...)
+			continue
+		}
+
+		additional := schema.AdditionalProperties
+		switch {
+		case additional == nil:
+		case !additional.Allowed:
+			errs = append(errs, ValidationError{Field: join(field, name), Message: "is not a known field"})
+		case additional.Schema != nil:
+			errs = append(errs, d.Validate(additional.Schema, object[name], join(field, name))...)
+		}
+	}
+	return errs
+}
+
+// validateParameter parses a query parameter according to its schema and validates it
+func (d *Document) validateParameter(param Parameter, raw string) []ValidationError {
+	schema := d.resolve(param.Schema)
+	if schema == nil {
+		return nil
+	}
+
+	var value interface{} = raw
+	switch schema.Type {
+	case "number", "integer":
+		value = json.Number(raw)
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			return typeError(param.Name, schema.Type)
+		}
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return typeError(param.Name, schema.Type)
+		}
+		value = b
+	}
+	return d.Validate(schema, value, param.Name)
+}
+
+// resolve follows a schema's $ref to the component it names
+func (d *Document) resolve(schema *Schema) *Schema {
+	for schema != nil && schema.Ref != "" {
+		schema = d.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// resolveResponse follows a response's $ref to the component it names
+func (d *Document) resolveResponse(response *Response) *Response {
+	for response != nil && response.Ref != "" {
+		response = d.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	}
+	return response
+}
+
+func (d *Document) checkOperation(op *Operation) error {
+	for _, param := range op.Parameters {
+		if err := d.checkSchema(param.Schema); err != nil {
+			return fmt.Errorf("parameter %s: %w", param.Name, err)
+		}
+	}
+	if op.RequestBody != nil {
+		for _, media := range op.RequestBody.Content {
+			if err := d.checkSchema(media.Schema); err != nil {
+				return fmt.Errorf("request body: %w", err)
+			}
+		}
+	}
+	if len(op.Responses) == 0 {
+		return fmt.Errorf("no responses documented")
+	}
+	for status, response := range op.Responses {
+		if response.Ref != "" && d.resolveResponse(response) == nil {
+			return fmt.Errorf("response %s: unresolved $ref %s", status, response.Ref)
+		}
+		for _, media := range d.resolveResponse(response).Content {
+			if err := d.checkSchema(media.Schema); err != nil {
+				return fmt.Errorf("response %s: %w", status, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSchema reports the first $ref within schema that does not resolve
+func (d *Document) checkSchema(schema *Schema) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		if d.resolve(schema) == nil {
+			return fmt.Errorf("unresolved $ref %s", schema.Ref)
+		}
+		return nil
+	}
+
+	children := append([]*Schema{schema.Items}, schema.OneOf...)
+	for _, property := range schema.Properties {
+		children = append(children, property)
+	}
+	if schema.AdditionalProperties != nil {
+		children = append(children, schema.AdditionalProperties.Schema)
+	}
+	for _, child := range children {
+		if err := d.checkSchema(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decode parses JSON keeping numbers as json.Number, so integers can be told from floats
+func decode(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		values[i] = fmt.Sprint(value)
+	}
+	return strings.Join(values, ", ")
+}
+
+func typeError(field, typ string) []ValidationError {
+	article := "a"
+	if typ == "object" || typ == "array" || typ == "integer" {
+		article = "an"
+	}
+	return []ValidationError{{Field: field, Message: "must be " + article + " " + typ}}
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
@@ -0,0 +1,282 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// AnyMethod registers a handler for every method of a pattern, for handlers that route by
+// method themselves
+const AnyMethod = ""
+
+// Middleware wraps a handler, e.g. to authenticate or rate limit the requests it serves
+type Middleware func(http.Handler) http.Handler
+
+// Router routes requests by method and path. Patterns are paths whose segments are literal
+// or a {name} parameter matching any one non-empty segment, e.g. /v1/portfolios/{user_id}.
+// Literal segments take precedence over parameters. Middleware sees the matched pattern and
+// parameters, and also runs for requests no route matches.
+type Router struct {
+	entries    []*entry
+	middleware []Middleware
+	handler    http.Handler
+
+	// NotFound serves requests whose path matches no pattern
+	NotFound http.Handler
+	// MethodNotAllowed serves requests whose path matches but method does not. The Allow
+	// header is set before it is called.
+	MethodNotAllowed http.Handler
+}
+
+// entry is a pattern with its handlers keyed by method
+type entry struct {
+	pattern  string
+	segments []string
+	handlers map[string]http.Handler
+}
+
+// match is what a request was routed to, stored in its context
+type match struct {
+	pattern string
+	params  map[string]string
+	handler http.Handler
+}
+
+type contextKey struct{}
+
+// New creates a router with plain text 404 and 405 responses and no middleware
+func New() *Router {
+	r := &Router{
+		NotFound: http.NotFoundHandler(),
+		MethodNotAllowed: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}),
+	}
+	r.handler = http.HandlerFunc(r.dispatch)
+	return r
+}
+
+// Use appends middleware to the chain every request passes through, the first added
+// outermost. It must be called before the router serves requests.
+func (r *Router) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+
+	r.handler = http.HandlerFunc(r.dispatch)
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		r.handler = r.middleware[i](r.handler)
+	}
+}
+
+// Handle registers handler for method and pattern. HEAD requests use the GET handler.
+// It panics on an invalid pattern or a method registered twice, like http.ServeMux.
+func (r *Router) Handle(method, pattern string, handler http.Handler) {
+	segments, err := parse(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, e := range r.entries {
+		if e.pattern != pattern {
+			continue
+		}
+		if _, ok := e.handlers[method]; ok {
+			panic(fmt.Sprintf("router: %s %s is already registered", method, pattern))
+		}
+		e.handlers[method] = handler
+		return
+	}
+
+	r.entries = append(r.entries, &entry{
+		pattern:  pattern,
+		segments: segments,
+		handlers: map[string]http.Handler{method: handler},
+	})
+}
+
+// HandleFunc registers a handler function for method and pattern
+func (r *Router) HandleFunc(method, pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	r.Handle(method, pattern, http.HandlerFunc(handler))
+}
+
+// Routes lists every registered method and pattern, sorted by pattern then method.
+// Patterns registered for AnyMethod are listed with an empty method.
+func (r *Router) Routes() [][2]string {
+	var routes [][2]string
+	for _, e := range r.entries {
+		for method := range e.handlers {
+			routes = append(routes, [2]string{method, e.pattern})
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i][1] != routes[j][1] {
+			return routes[i][1] < routes[j][1]
+		}
+		return routes[i][0] < routes[j][0]
+	})
+	return routes
+}
+
+// ServeHTTP routes the request and passes it through the middleware to its handler
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	m := r.match(req)
+	r.handler.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, m)))
+}
+
+// dispatch runs the handler the request was routed to, at the end of the middleware chain
+func (r *Router) dispatch(w http.ResponseWriter, req *http.Request) {
+	m, ok := req.Context().Value(contextKey{}).(*match)
+	if !ok {
+		m = r.match(req)
+	}
+	m.handler.ServeHTTP(w, req)
+}
+
+// match finds the most specific pattern matching the request's path and its handler for the
+// request's method
+func (r *Router) match(req *http.Request) *match {
+	segments := strings.Split(req.URL.EscapedPath(), "/")
+
+	var best *entry
+	var params map[string]string
+	for _, e := range r.entries {
+		p, ok := e.match(segments)
+		if !ok || (best != nil && !e.moreSpecific(best)) {
+			continue
+		}
+		best, params = e, p
+	}
+	if best == nil {
+		return &match{handler: r.NotFound}
+	}
+
+	m := &match{pattern: best.pattern, params: params}
+	if handler, ok := best.handler(req.Method); ok {
+		m.handler = handler
+		return m
+	}
+
+	allow := best.allowed()
+	m.handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		r.MethodNotAllowed.ServeHTTP(w, req)
+	})
+	return m
+}
+
+// handler returns the entry's handler for method
+func (e *entry) handler(method string) (http.Handler, bool) {
+	if handler, ok := e.handlers[method]; ok {
+		return handler, true
+	}
+	if handler, ok := e.handlers[http.MethodGet]; ok && method == http.MethodHead {
+		return handler, true
+	}
+	handler, ok := e.handlers[AnyMethod]
+	return handler, ok
+}
+
+// allowed lists the methods the entry has handlers for
+func (e *entry) allowed() []string {
+	var methods []string
+	for method := range e.handlers {
+		methods = append(methods, method)
+		if method == http.MethodGet {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// match reports whether the path segments match the entry, with the parameters they set
+func (e *entry) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(e.segments) {
+		return nil, false
+	}
+
+	var params map[string]string
+	for i, segment := range e.segments {
+		name, isParam := paramName(segment)
+		if !isParam {
+			if segments[i] != segment {
+				return nil, false
+			}
+			continue
+		}
+
+		value, err := url.PathUnescape(segments[i])
+		if err != nil || value == "" {
+			return nil, false
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[name] = value
+	}
+	return params, true
+}
+
+// moreSpecific reports whether e takes precedence over other, which matches the same path:
+// at the first segment where one has a literal and the other a parameter, the literal wins
+func (e *entry) moreSpecific(other *entry) bool {
+	for i := range e.segments {
+		_, eParam := paramName(e.segments[i])
+		_, otherParam := paramName(other.segments[i])
+		if eParam != otherParam {
+			return !eParam
+		}
+	}
+	return false
+}
+
+// parse splits a pattern into its segments and checks them
+func parse(pattern string) ([]string, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("router: pattern %q must start with /", pattern)
+	}
+
+	segments := strings.Split(pattern, "/")
+	seen := make(map[string]bool)
+	for _, segment := range segments[1:] {
+		name, isParam := paramName(segment)
+		if !isParam {
+			if strings.ContainsAny(segment, "{}") {
+				return nil, fmt.Errorf("router: segment %q of %q must be literal or a whole {name}", segment, pattern)
+			}
+			continue
+		}
+		if name == "" || seen[name] {
+			return nil, fmt.Errorf("router: pattern %q has an empty or repeated parameter", pattern)
+		}
+		seen[name] = true
+	}
+	return segments, nil
+}
+
+// paramName returns the name of a {name} segment
+func paramName(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// Pattern returns the pattern the request was routed to, or "" when no route matched its path
+func Pattern(r *http.Request) string {
+	if m, ok := r.Context().Value(contextKey{}).(*match); ok {
+		return m.pattern
+	}
+	return ""
+}
+
+// Param returns the value of the request's path parameter name, or "" when its route has none
+func Param(r *http.Request, name string) string {
+	if m, ok := r.Context().Value(contextKey{}).(*match); ok {
+		return m.params[name]
+	}
+	return ""
+}